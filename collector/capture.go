@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"github.com/segmentio/kafka-go"
+)
+
+// flushInterval is how often captures end idle TCP sessions and drop
+// incomplete datagrams.
+const flushInterval = 10 * time.Second
+
+// captureSettings is the part of the dashboard settings the collector
+// acts on. It arrives on the control topic published by plutos-space.
+type captureSettings struct {
+	MaxPacketSize int      `json:"maxPacketSize"`
+	InterfaceMode string   `json:"interfaceMode"`
+	Interfaces    []string `json:"interfaces"`
+}
+
+type controlMessage struct {
+	Type     string `json:"type"`
+	Version  int64  `json:"version"`
+	Settings struct {
+		Network captureSettings `json:"network"`
+	} `json:"settings"`
+}
+
+// CaptureManager runs one capture per selected interface and restarts
+// captures when the interface selection or snap length changes.
+type CaptureManager struct {
+	devices  []pcap.Interface
+	writer   *kafka.Writer
+	ring     *Ring
+	sessions *kafka.Writer
+	// defaultSnaplen is -snaplen, used while settings leave MaxPacketSize
+	// at 0.
+	defaultSnaplen int
+
+	mu       sync.Mutex
+	ctx      context.Context
+	settings captureSettings
+	snaplen  int
+	running  map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewCaptureManager creates a manager publishing packet metadata to
+// writer. If ring is not nil, raw packets are also written to it, and if
+// sessions is not nil, TCP connections are reassembled and published to
+// it.
+func NewCaptureManager(devices []pcap.Interface, writer *kafka.Writer, ring *Ring, sessions *kafka.Writer, snaplen int) *CaptureManager {
+	return &CaptureManager{
+		devices:        devices,
+		writer:         writer,
+		ring:           ring,
+		sessions:       sessions,
+		defaultSnaplen: snaplen,
+		settings:       captureSettings{InterfaceMode: "auto"},
+		snaplen:        snaplen,
+		running:        make(map[string]context.CancelFunc),
+	}
+}
+
+// Run captures on the selected interfaces, every one until settings say
+// otherwise, and returns once ctx is cancelled and all captures have
+// stopped.
+func (m *CaptureManager) Run(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.reconcile()
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.wg.Wait()
+}
+
+// Apply changes the captured interfaces and snap length. Interfaces named
+// in s that do not exist on this host are ignored.
+func (m *CaptureManager) Apply(s captureSettings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.settings = s
+	if m.ctx != nil && m.ctx.Err() == nil {
+		m.reconcile()
+	}
+}
+
+// reconcile starts and stops captures to match m.settings. It must be
+// called with m.mu held.
+func (m *CaptureManager) reconcile() {
+	s := m.settings
+
+	snaplen := s.MaxPacketSize
+	if snaplen == 0 {
+		snaplen = m.defaultSnaplen
+	}
+	if snaplen != m.snaplen {
+		log.Printf("Snapshot length changed from %d to %d, restarting captures", m.snaplen, snaplen)
+		m.snaplen = snaplen
+		for name, stop := range m.running {
+			stop()
+			delete(m.running, name)
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range s.Interfaces {
+		wanted[name] = true
+	}
+
+	for _, device := range m.devices {
+		selected := s.InterfaceMode != "specific" || wanted[device.Name]
+		stop, running := m.running[device.Name]
+		switch {
+		case selected && !running:
+			m.start(device)
+		case !selected && running:
+			log.Printf("Stopping packet capture on %s", device.Name)
+			stop()
+			delete(m.running, device.Name)
+		}
+	}
+}
+
+// start must be called with m.mu held.
+func (m *CaptureManager) start(device pcap.Interface) {
+	if device.Name == "lo" || device.Name == "log" {
+		log.Printf("Skipping loopback interface %s", device.Name)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.running[device.Name] = cancel
+	m.wg.Add(1)
+	go captureDevice(ctx, device, m.snaplen, m.writer, m.ring, m.sessions, &m.wg)
+}
+
+func captureDevice(ctx context.Context, device pcap.Interface, snaplen int, writer *kafka.Writer, ring *Ring, sessionWriter *kafka.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	handle, err := pcap.OpenLive(device.Name, int32(snaplen), *promiscuous, pcap.BlockForever)
+	if err != nil {
+		log.Printf("Error opening device %s: %v", device.Name, err)
+		return
+	}
+
+	// Closing the handle ends the packet source below.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		handle.Close()
+	}()
+
+	log.Printf("Started packet capture on %s (snaplen %d)", device.Name, snaplen)
+	if ring != nil {
+		ring.AddInterface(device.Name, handle.LinkType(), snaplen)
+	}
+
+	var sessions *SessionTracker
+	if sessionWriter != nil {
+		sessions = NewSessionTracker(device.Name, sessionWriter)
+		defer sessions.Close()
+	}
+	var defrag *Defragmenter
+	if *defragment {
+		defrag = NewDefragmenter(*defragMemMB << 20)
+	}
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			if ring != nil {
+				ring.Write(device.Name, packet)
+			}
+
+			meta := packetMetadata(device.Name, packet)
+			var frag FragmentResult
+			if defrag != nil && meta.IsFragment {
+				frag = defrag.Add(packet)
+				meta.FragAnomaly = frag.Anomaly
+			}
+			publishPacket(&meta, writer)
+			if sessions != nil {
+				sessions.Add(packet)
+			}
+
+			// A reassembled datagram is published after its last fragment,
+			// with the transport and application fields its fragments lack.
+			if frag.Datagram != nil {
+				whole := packetMetadata(device.Name, frag.Datagram)
+				whole.Fragments = frag.Fragments
+				publishPacket(&whole, writer)
+				if sessions != nil {
+					sessions.Add(frag.Datagram)
+				}
+			}
+		case now := <-flush.C:
+			if sessions != nil {
+				sessions.Flush(now)
+			}
+			if defrag != nil {
+				defrag.Flush(now)
+			}
+		}
+	}
+}
+
+// watchControlTopic applies capture settings published on the control
+// topic. The topic is read from the start on every launch, so the latest
+// retained settings are applied before new ones arrive.
+func watchControlTopic(ctx context.Context, manager *CaptureManager, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{*kafkaAddr},
+		Topic:     *controlTopic,
+		Partition: 0,
+		MinBytes:  1,
+		MaxBytes:  1e6,
+		MaxWait:   time.Second,
+	})
+	defer reader.Close()
+
+	// Version 0 is the server's defaults, never saved; older servers
+	// publish it, and it must not override the command line.
+	applied := int64(0)
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error reading control topic: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		var msg controlMessage
+		if err := json.Unmarshal(m.Value, &msg); err != nil {
+			log.Printf("Error decoding control message: %v", err)
+			continue
+		}
+		if msg.Type != "settings" || msg.Version <= applied {
+			continue
+		}
+
+		manager.Apply(msg.Settings.Network)
+		applied = msg.Version
+		log.Printf("Applied settings version %d", msg.Version)
+	}
+}
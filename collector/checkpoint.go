@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint persists how far each tailed file has been read so that a
+// restarted collector resumes where it left off instead of re-sending or
+// skipping log lines.
+type Checkpoint struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]checkpointEntry
+	dirty   bool
+}
+
+type checkpointEntry struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path:    path,
+		entries: make(map[string]checkpointEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return c, nil
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Lookup returns the saved offset for a file. An entry stored under a
+// different path with the same inode wins over a stale entry for the path
+// itself, which is what happens when a file is renamed during rotation
+// while the collector is down.
+func (c *Checkpoint) Lookup(path string, inode uint64) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok && e.Inode == inode {
+		return e.Offset, true
+	}
+	for _, e := range c.entries {
+		if e.Inode == inode {
+			return e.Offset, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Checkpoint) Set(path string, inode uint64, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok && e.Inode == inode && e.Offset == offset {
+		return
+	}
+	c.entries[path] = checkpointEntry{Inode: inode, Offset: offset}
+	c.dirty = true
+}
+
+func (c *Checkpoint) Delete(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[path]; ok {
+		delete(c.entries, path)
+		c.dirty = true
+	}
+}
+
+// Save writes the checkpoint atomically via a temp file and rename. It is a
+// no-op when nothing changed since the last save.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
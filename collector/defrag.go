@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// fragmentTimeout is how long the fragments of a datagram are kept
+	// waiting for the rest, as in the Linux kernel.
+	fragmentTimeout = 30 * time.Second
+	// maxFragments bounds the fragments of one datagram; more is only seen
+	// in attacks.
+	maxFragments = 256
+	// maxDatagramSize bounds the length of an IPv4 datagram and the
+	// payload of an IPv6 one; jumbograms are not reassembled.
+	maxDatagramSize = 65535
+	// fragmentOverhead is charged against the memory limit for every
+	// fragment on top of its payload.
+	fragmentOverhead = 64
+)
+
+// Fragment anomalies, reported on the fragment they were found in.
+const (
+	// Part of the fragment overlaps data already received with a different
+	// offset, length or content. Hosts resolve overlaps differently, so a
+	// sensor can see other data than the target.
+	fragmentOverlap = "overlap"
+	// The first fragment is too short to hold the transport header, which
+	// splits the ports and flags from the rest of the header.
+	fragmentTiny = "tiny-first"
+	// The fragments reach past the largest possible datagram, as in the
+	// ping of death.
+	fragmentOversized = "oversized"
+)
+
+// minTransportHeader is the header length a first fragment must hold.
+var minTransportHeader = map[layers.IPProtocol]int{
+	layers.IPProtocolTCP:    20,
+	layers.IPProtocolUDP:    8,
+	layers.IPProtocolICMPv4: 8,
+	layers.IPProtocolICMPv6: 8,
+}
+
+type fragmentKey struct {
+	src, dst [16]byte
+	protocol layers.IPProtocol
+	id       uint32
+	v6       bool
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// datagram collects the fragments of one IP datagram, sorted by offset.
+type datagram struct {
+	fragments []fragment
+	// total is the payload length, known once the last fragment arrived.
+	total   int
+	started time.Time
+	size    int
+
+	// From the first fragment: the link-layer headers in front of the IP
+	// header, the IP header and the first layer's type to decode with.
+	link      []byte
+	header    []byte
+	linkType  gopacket.LayerType
+	haveFirst bool
+}
+
+// FragmentResult is what Defragmenter.Add found out about a packet.
+type FragmentResult struct {
+	// Datagram is the reassembled packet if this fragment completed it.
+	Datagram gopacket.Packet
+	// Fragments is the number of fragments Datagram was built from.
+	Fragments int
+	// Anomaly is set if the fragment was suspicious.
+	Anomaly string
+}
+
+// Defragmenter reassembles fragmented IPv4 and IPv6 datagrams, keeping at
+// most maxBytes of fragments. Datagrams that would exceed it are not
+// reassembled. It is not safe for concurrent use; each capture owns one.
+type Defragmenter struct {
+	maxBytes  int
+	bytes     int
+	datagrams map[fragmentKey]*datagram
+}
+
+func NewDefragmenter(maxBytes int) *Defragmenter {
+	return &Defragmenter{maxBytes: maxBytes, datagrams: make(map[fragmentKey]*datagram)}
+}
+
+// Add passes a captured packet to the defragmenter. Packets that are not
+// fragments give an empty result.
+func (d *Defragmenter) Add(packet gopacket.Packet) FragmentResult {
+	var (
+		key    fragmentKey
+		offset int
+		more   bool
+		data   []byte
+		header []byte
+		ipType gopacket.LayerType
+	)
+
+	if ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		more = ip4.Flags&layers.IPv4MoreFragments != 0
+		if !more && ip4.FragOffset == 0 {
+			return FragmentResult{}
+		}
+		copy(key.src[:], ip4.SrcIP.To16())
+		copy(key.dst[:], ip4.DstIP.To16())
+		key.protocol, key.id = ip4.Protocol, uint32(ip4.Id)
+		offset, data = int(ip4.FragOffset)*8, ip4.Payload
+		header, ipType = ip4.Contents, layers.LayerTypeIPv4
+	} else if frag, ok := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment); ok {
+		// Atomic fragments (RFC 6946) are whole datagrams.
+		more = frag.MoreFragments
+		if !more && frag.FragmentOffset == 0 {
+			return FragmentResult{}
+		}
+		ip6, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if !ok {
+			return FragmentResult{}
+		}
+		copy(key.src[:], ip6.SrcIP.To16())
+		copy(key.dst[:], ip6.DstIP.To16())
+		key.protocol, key.id, key.v6 = frag.NextHeader, frag.Identification, true
+		offset, data = int(frag.FragmentOffset)*8, frag.Payload
+		// The rebuilt header carries the fragment's next header; extension
+		// headers in front of the fragment header are dropped.
+		header = ipv6Header(ip6, frag.NextHeader)
+		ipType = layers.LayerTypeIPv6
+	} else {
+		return FragmentResult{}
+	}
+
+	var result FragmentResult
+	end := offset + len(data)
+	limit := maxDatagramSize
+	if !key.v6 {
+		limit -= len(header)
+	}
+	if end > limit {
+		result.Anomaly = fragmentOversized
+		d.drop(key)
+		return result
+	}
+	if offset == 0 && more && len(data) < minTransportHeader[key.protocol] {
+		result.Anomaly = fragmentTiny
+	}
+	// Only the last fragment may have a length that is not a multiple of
+	// eight; others cannot be placed and are ignored.
+	if more && len(data)%8 != 0 {
+		return result
+	}
+
+	dg := d.datagrams[key]
+	if dg == nil {
+		if d.bytes+len(data)+fragmentOverhead > d.maxBytes {
+			return result
+		}
+		dg = &datagram{total: -1, started: packet.Metadata().Timestamp}
+		d.datagrams[key] = dg
+	}
+
+	if overlaps(dg, offset, data) {
+		result.Anomaly = fragmentOverlap
+		// The first copy of the data is kept.
+		return result
+	}
+	if !more {
+		if dg.total >= 0 && dg.total != end {
+			result.Anomaly = fragmentOverlap
+			return result
+		}
+		dg.total = end
+	}
+	if dg.total >= 0 && end > dg.total {
+		result.Anomaly = fragmentOverlap
+		return result
+	}
+	if len(dg.fragments) >= maxFragments || d.bytes+len(data)+fragmentOverhead > d.maxBytes {
+		d.drop(key)
+		return result
+	}
+
+	if offset == 0 {
+		dg.link = linkHeaders(packet, ipType)
+		dg.header = append([]byte(nil), header...)
+		dg.linkType = packet.Layers()[0].LayerType()
+		dg.haveFirst = true
+	}
+	if dg.insert(fragment{offset: offset, data: append([]byte(nil), data...)}) {
+		dg.size += len(data) + fragmentOverhead
+		d.bytes += len(data) + fragmentOverhead
+	}
+
+	if payload := dg.assemble(); payload != nil {
+		result.Datagram = dg.packet(payload, packet.Metadata().CaptureInfo)
+		result.Fragments = len(dg.fragments)
+		d.drop(key)
+	}
+	return result
+}
+
+// Flush discards datagrams still incomplete fragmentTimeout after their
+// first fragment.
+func (d *Defragmenter) Flush(now time.Time) {
+	for key, dg := range d.datagrams {
+		if now.Sub(dg.started) > fragmentTimeout {
+			d.drop(key)
+		}
+	}
+}
+
+func (d *Defragmenter) drop(key fragmentKey) {
+	if dg := d.datagrams[key]; dg != nil {
+		d.bytes -= dg.size
+		delete(d.datagrams, key)
+	}
+}
+
+// overlaps reports whether data at offset overlaps a fragment of dg other
+// than an identical copy of it, which captures can see twice.
+func overlaps(dg *datagram, offset int, data []byte) bool {
+	end := offset + len(data)
+	for _, f := range dg.fragments {
+		fEnd := f.offset + len(f.data)
+		if offset >= fEnd || end <= f.offset {
+			continue
+		}
+		if offset == f.offset && bytes.Equal(data, f.data) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// insert adds f to dg unless it holds an identical copy already.
+func (dg *datagram) insert(f fragment) bool {
+	i := len(dg.fragments)
+	for i > 0 && dg.fragments[i-1].offset > f.offset {
+		i--
+	}
+	if i > 0 && dg.fragments[i-1].offset == f.offset {
+		return false
+	}
+	dg.fragments = append(dg.fragments, fragment{})
+	copy(dg.fragments[i+1:], dg.fragments[i:])
+	dg.fragments[i] = f
+	return true
+}
+
+// assemble returns the payload of dg if every byte of it has arrived.
+func (dg *datagram) assemble() []byte {
+	if dg.total < 0 || !dg.haveFirst {
+		return nil
+	}
+	next := 0
+	for _, f := range dg.fragments {
+		if f.offset != next {
+			return nil
+		}
+		next += len(f.data)
+	}
+	if next != dg.total {
+		return nil
+	}
+
+	payload := make([]byte, 0, dg.total)
+	for _, f := range dg.fragments {
+		payload = append(payload, f.data...)
+	}
+	return payload
+}
+
+// packet rebuilds the datagram as an unfragmented packet behind the first
+// fragment's link-layer headers.
+func (dg *datagram) packet(payload []byte, ci gopacket.CaptureInfo) gopacket.Packet {
+	header := dg.header
+	if len(header) == 40 && header[0]>>4 == 6 {
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(payload)))
+	} else {
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(header)+len(payload)))
+		// Keep the don't-fragment flag, clear more fragments and the offset.
+		header[6] &= 0x40
+		header[7] = 0
+		header[10], header[11] = 0, 0
+		binary.BigEndian.PutUint16(header[10:12], ipv4Checksum(header))
+	}
+
+	data := make([]byte, 0, len(dg.link)+len(header)+len(payload))
+	data = append(data, dg.link...)
+	data = append(data, header...)
+	data = append(data, payload...)
+
+	p := gopacket.NewPacket(data, dg.linkType, gopacket.Default)
+	ci.CaptureLength, ci.Length = len(data), len(data)
+	p.Metadata().CaptureInfo = ci
+	return p
+}
+
+// linkHeaders returns the headers of the layers in front of the IP layer,
+// e.g. Ethernet and 802.1Q.
+func linkHeaders(packet gopacket.Packet, ipType gopacket.LayerType) []byte {
+	var link []byte
+	for _, l := range packet.Layers() {
+		if l.LayerType() == ipType {
+			break
+		}
+		link = append(link, l.LayerContents()...)
+	}
+	return link
+}
+
+// ipv6Header builds a fixed IPv6 header for a reassembled datagram.
+func ipv6Header(ip6 *layers.IPv6, next layers.IPProtocol) []byte {
+	h := make([]byte, 40)
+	binary.BigEndian.PutUint32(h[0:4], 6<<28|uint32(ip6.TrafficClass)<<20|ip6.FlowLabel&0xfffff)
+	h[6] = byte(next)
+	h[7] = ip6.HopLimit
+	copy(h[8:24], ip6.SrcIP.To16())
+	copy(h[24:40], ip6.DstIP.To16())
+	return h
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// isFragment reports whether the packet is part of a fragmented datagram.
+func isFragment(packet gopacket.Packet) bool {
+	if ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		return ip4.Flags&layers.IPv4MoreFragments != 0 || ip4.FragOffset != 0
+	}
+	if frag, ok := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment); ok {
+		return frag.MoreFragments || frag.FragmentOffset != 0
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testFragment builds an IPv4 fragment of datagram id at offset (in eight
+// byte units).
+func testFragment(t testing.TB, id uint16, offset uint16, more bool, data []byte) []byte {
+	ip := &layers.IPv4{
+		Version:    4,
+		TTL:        64,
+		Id:         id,
+		FragOffset: offset,
+		SrcIP:      net.IPv4(10, 0, 0, 1),
+		DstIP:      net.IPv4(10, 0, 0, 2),
+		Protocol:   layers.IPProtocolUDP,
+	}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, gopacket.Payload(data)); err != nil {
+		t.Fatalf("serializing fragment: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzDefragmenter(f *testing.F) {
+	udp := []byte("\x9c\x40\x00\x35\x00\x18\x00\x00abcdefghijklmnop")
+	f.Add(testFragment(f, 1, 0, true, udp[:16]), testFragment(f, 1, 2, false, udp[16:]), testFragment(f, 1, 1, true, udp[8:16]))
+	f.Add(testFragment(f, 2, 0, true, udp[:8]), testFragment(f, 2, 0, true, udp[8:16]), testFragment(f, 2, 8191, false, udp))
+
+	f.Fuzz(func(t *testing.T, a, b, c []byte) {
+		d := NewDefragmenter(1 << 20)
+		now := time.Unix(1700000000, 0)
+		for _, data := range [][]byte{a, b, c} {
+			packet := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default)
+			packet.Metadata().Timestamp = now
+			if result := d.Add(packet); result.Datagram != nil {
+				var meta PacketData
+				dissect(result.Datagram, &meta)
+			}
+		}
+		d.Flush(now.Add(time.Hour))
+	})
+}
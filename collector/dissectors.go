@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Dissector reads the fields of one protocol from a packet. The fields are
+// published under the dissector's name in the packet's app section.
+type Dissector interface {
+	Name() string
+	// Dissect returns the protocol's fields, or nil if the packet does not
+	// carry the protocol.
+	Dissect(packet gopacket.Packet) interface{}
+}
+
+var dissectors []Dissector
+
+func registerDissector(d Dissector) {
+	dissectors = append(dissectors, d)
+}
+
+func init() {
+	registerDissector(arpDissector{})
+	registerDissector(dhcpDissector{})
+	registerDissector(ndpDissector{})
+	registerDissector(ntpDissector{})
+	registerDissector(sshDissector{})
+	registerDissector(smbDissector{})
+	registerDissector(quicDissector{})
+	registerDissector(nameServiceDissector{name: "mdns", port: 5353})
+	registerDissector(nameServiceDissector{name: "llmnr", port: 5355})
+	registerDissector(nameServiceDissector{name: "dns", port: 53, answersOnly: true})
+	registerDissector(snmpDissector{})
+}
+
+// dissect runs every dissector over the packet and collects the results in
+// meta.App.
+func dissect(packet gopacket.Packet, meta *PacketData) {
+	for _, d := range dissectors {
+		if fields := safeDissect(d, packet); fields != nil {
+			if meta.App == nil {
+				meta.App = make(map[string]interface{})
+			}
+			meta.App[d.Name()] = fields
+		}
+	}
+}
+
+// safeDissect runs one dissector, so that a packet a parser does not
+// expect costs its fields rather than the capture.
+func safeDissect(d Dissector, packet gopacket.Packet) (fields interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Dissectors] %s dissector failed on a packet: %v", d.Name(), r)
+			fields = nil
+		}
+	}()
+	return d.Dissect(packet)
+}
+
+// udpPayload returns the payload of a UDP packet from or to port.
+func udpPayload(packet gopacket.Packet, port layers.UDPPort) (*layers.UDP, []byte) {
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || (udp.SrcPort != port && udp.DstPort != port) {
+		return nil, nil
+	}
+	return udp, udp.Payload
+}
+
+// tcpPayload returns the payload of a TCP segment from or to port.
+func tcpPayload(packet gopacket.Packet, port layers.TCPPort) (*layers.TCP, []byte) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 || (tcp.SrcPort != port && tcp.DstPort != port) {
+		return nil, nil
+	}
+	return tcp, tcp.Payload
+}
+
+// ARPInfo is an ARP request or reply. Gratuitous ARPs announce the
+// sender's own address.
+type ARPInfo struct {
+	Operation  string `json:"operation"`
+	SenderMAC  string `json:"sender_mac"`
+	SenderIP   string `json:"sender_ip"`
+	TargetMAC  string `json:"target_mac"`
+	TargetIP   string `json:"target_ip"`
+	Gratuitous bool   `json:"gratuitous,omitempty"`
+}
+
+type arpDissector struct{}
+
+func (arpDissector) Name() string { return "arp" }
+
+func (arpDissector) Dissect(packet gopacket.Packet) interface{} {
+	arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || arp.Protocol != layers.EthernetTypeIPv4 {
+		return nil
+	}
+	info := &ARPInfo{
+		Operation:  "request",
+		SenderMAC:  net.HardwareAddr(arp.SourceHwAddress).String(),
+		SenderIP:   net.IP(arp.SourceProtAddress).String(),
+		TargetMAC:  net.HardwareAddr(arp.DstHwAddress).String(),
+		TargetIP:   net.IP(arp.DstProtAddress).String(),
+		Gratuitous: bytes.Equal(arp.SourceProtAddress, arp.DstProtAddress),
+	}
+	if arp.Operation == layers.ARPReply {
+		info.Operation = "reply"
+	}
+	return info
+}
+
+// DHCPInfo is a DHCPv4 message. ClientID is the client identifier option
+// in hex; servers answer with YourIP and identify themselves by ServerID.
+type DHCPInfo struct {
+	MessageType string `json:"message_type"`
+	ClientMAC   string `json:"client_mac"`
+	Hostname    string `json:"hostname,omitempty"`
+	ClientID    string `json:"client_id,omitempty"`
+	VendorClass string `json:"vendor_class,omitempty"`
+	RequestedIP string `json:"requested_ip,omitempty"`
+	YourIP      string `json:"your_ip,omitempty"`
+	ServerID    string `json:"server_id,omitempty"`
+	Router      string `json:"router,omitempty"`
+}
+
+type dhcpDissector struct{}
+
+func (dhcpDissector) Name() string { return "dhcp" }
+
+func (dhcpDissector) Dissect(packet gopacket.Packet) interface{} {
+	dhcp, ok := packet.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4)
+	if !ok {
+		return nil
+	}
+	info := &DHCPInfo{ClientMAC: dhcp.ClientHWAddr.String()}
+	if ip := dhcp.YourClientIP; ip != nil && !ip.IsUnspecified() {
+		info.YourIP = ip.String()
+	}
+	for _, opt := range dhcp.Options {
+		switch opt.Type {
+		case layers.DHCPOptMessageType:
+			if len(opt.Data) == 1 {
+				info.MessageType = strings.ToLower(layers.DHCPMsgType(opt.Data[0]).String())
+			}
+		case layers.DHCPOptHostname:
+			info.Hostname = string(opt.Data)
+		case layers.DHCPOptClientID:
+			info.ClientID = hex.EncodeToString(opt.Data)
+		case layers.DHCPOptClassID:
+			info.VendorClass = string(opt.Data)
+		case layers.DHCPOptRequestIP:
+			if len(opt.Data) == 4 {
+				info.RequestedIP = net.IP(opt.Data).String()
+			}
+		case layers.DHCPOptServerID:
+			if len(opt.Data) == 4 {
+				info.ServerID = net.IP(opt.Data).String()
+			}
+		case layers.DHCPOptRouter:
+			if len(opt.Data) >= 4 {
+				info.Router = net.IP(opt.Data[:4]).String()
+			}
+		}
+	}
+	return info
+}
+
+// NDPInfo is an IPv6 neighbor discovery message, the IPv6 counterpart of
+// ARP. LinkAddr is the source or target link-layer address option.
+type NDPInfo struct {
+	Type           string `json:"type"`
+	TargetIP       string `json:"target_ip,omitempty"`
+	LinkAddr       string `json:"link_addr,omitempty"`
+	Router         bool   `json:"router,omitempty"`
+	RouterLifetime uint16 `json:"router_lifetime,omitempty"`
+}
+
+type ndpDissector struct{}
+
+func (ndpDissector) Name() string { return "ndp" }
+
+func (ndpDissector) Dissect(packet gopacket.Packet) interface{} {
+	var (
+		info    NDPInfo
+		options layers.ICMPv6Options
+	)
+	if m, ok := packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation); ok {
+		info.Type, info.TargetIP, options = "neighbor-solicitation", m.TargetAddress.String(), m.Options
+	}
+	if m, ok := packet.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement); ok {
+		info.Type, info.TargetIP, options = "neighbor-advertisement", m.TargetAddress.String(), m.Options
+		info.Router = m.Router()
+	}
+	if m, ok := packet.Layer(layers.LayerTypeICMPv6RouterSolicitation).(*layers.ICMPv6RouterSolicitation); ok {
+		info.Type, options = "router-solicitation", m.Options
+	}
+	if m, ok := packet.Layer(layers.LayerTypeICMPv6RouterAdvertisement).(*layers.ICMPv6RouterAdvertisement); ok {
+		info.Type, options = "router-advertisement", m.Options
+		info.Router, info.RouterLifetime = true, m.RouterLifetime
+	}
+	if info.Type == "" {
+		return nil
+	}
+	for _, opt := range options {
+		if opt.Type == layers.ICMPv6OptSourceAddress || opt.Type == layers.ICMPv6OptTargetAddress {
+			info.LinkAddr = net.HardwareAddr(opt.Data).String()
+		}
+	}
+	return &info
+}
+
+// NTPInfo is an NTP message. Monlist marks a mode 7 MON_GETLIST request,
+// the query abused for NTP amplification.
+type NTPInfo struct {
+	Version int    `json:"version"`
+	Mode    string `json:"mode"`
+	Stratum int    `json:"stratum,omitempty"`
+	Monlist bool   `json:"monlist,omitempty"`
+}
+
+var ntpModes = []string{"reserved", "symmetric-active", "symmetric-passive", "client", "server", "broadcast", "control", "private"}
+
+// ntpMonlist is the MON_GETLIST_1 request code of mode 7.
+const ntpMonlist = 42
+
+type ntpDissector struct{}
+
+func (ntpDissector) Name() string { return "ntp" }
+
+// Dissect reads the header itself, since mode 6 and 7 packets are shorter
+// than gopacket's NTP layer accepts.
+func (ntpDissector) Dissect(packet gopacket.Packet) interface{} {
+	_, payload := udpPayload(packet, 123)
+	if len(payload) < 4 {
+		return nil
+	}
+	info := &NTPInfo{
+		Version: int(payload[0] >> 3 & 7),
+		Mode:    ntpModes[payload[0]&7],
+	}
+	if info.Version == 0 || info.Version > 4 {
+		return nil
+	}
+	switch payload[0] & 7 {
+	case 7:
+		info.Monlist = payload[3] == ntpMonlist
+	case 6:
+	default:
+		if len(payload) < 48 {
+			return nil
+		}
+		info.Stratum = int(payload[1])
+	}
+	return info
+}
+
+// SSHInfo holds an SSH banner or the HASSH fingerprint of a key exchange
+// init message: the MD5 of its key exchange, encryption, MAC and
+// compression algorithms for the client (HASSH) or server (HASSHServer).
+type SSHInfo struct {
+	Banner      string `json:"banner,omitempty"`
+	HASSH       string `json:"hassh,omitempty"`
+	HASSHServer string `json:"hassh_server,omitempty"`
+}
+
+const sshMsgKexInit = 20
+
+type sshDissector struct{}
+
+func (sshDissector) Name() string { return "ssh" }
+
+func (sshDissector) Dissect(packet gopacket.Packet) interface{} {
+	tcp, payload := tcpPayload(packet, 22)
+	if tcp == nil {
+		return nil
+	}
+	// The server is normally the side with the lower port.
+	server := tcp.SrcPort < tcp.DstPort
+
+	var info SSHInfo
+	if bytes.HasPrefix(payload, []byte("SSH-")) {
+		line := payload
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, payload = line[:i], line[i+1:]
+		} else {
+			payload = nil
+		}
+		info.Banner = strings.TrimRight(string(line[:min(len(line), 255)]), "\r")
+	}
+	if algorithms := sshKexInit(payload); algorithms != nil {
+		// kex;enc;mac;compression in the packet's direction.
+		dir := 0
+		if server {
+			dir = 1
+		}
+		fingerprint := strings.Join([]string{algorithms[0], algorithms[2+dir], algorithms[4+dir], algorithms[6+dir]}, ";")
+		sum := md5.Sum([]byte(fingerprint))
+		if server {
+			info.HASSHServer = hex.EncodeToString(sum[:])
+		} else {
+			info.HASSH = hex.EncodeToString(sum[:])
+		}
+	}
+	if info == (SSHInfo{}) {
+		return nil
+	}
+	return &info
+}
+
+// sshKexInit returns the first eight name-lists of an unencrypted
+// SSH_MSG_KEXINIT packet: key exchange, host key, then encryption, MAC and
+// compression algorithms, each client to server and server to client.
+func sshKexInit(b []byte) []string {
+	if len(b) < 6+16 || b[5] != sshMsgKexInit {
+		return nil
+	}
+	t := &tlsReader{b: b[6+16:], ok: true}
+	lists := make([]string, 8)
+	for i := range lists {
+		lists[i] = string(t.vector(4))
+	}
+	if !t.ok {
+		return nil
+	}
+	return lists
+}
+
+// SMBInfo is the first SMB message of a segment. Path is the share of a
+// tree connect or the file of a create request.
+type SMBInfo struct {
+	Version  string `json:"version"`
+	Command  string `json:"command,omitempty"`
+	Response bool   `json:"response,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+var smb2Commands = []string{
+	"negotiate", "session-setup", "logoff", "tree-connect", "tree-disconnect",
+	"create", "close", "flush", "read", "write", "lock", "ioctl", "cancel",
+	"echo", "query-directory", "change-notify", "query-info", "set-info",
+	"oplock-break",
+}
+
+const (
+	smb2HeaderSize  = 64
+	smb2TreeConnect = 3
+	smb2Create      = 5
+)
+
+type smbDissector struct{}
+
+func (smbDissector) Name() string { return "smb" }
+
+func (smbDissector) Dissect(packet gopacket.Packet) interface{} {
+	_, payload := tcpPayload(packet, 445)
+	// Direct TCP transport prefixes each message with a 4 byte length.
+	if len(payload) < 8 || payload[0] != 0 {
+		return nil
+	}
+	msg := payload[4:]
+
+	switch {
+	case bytes.HasPrefix(msg, []byte("\xffSMB")):
+		// SMBv1, disabled on current systems and used by old malware.
+		return &SMBInfo{Version: "1"}
+	case bytes.HasPrefix(msg, []byte("\xfdSMB")):
+		return &SMBInfo{Version: "3", Command: "encrypted"}
+	case !bytes.HasPrefix(msg, []byte("\xfeSMB")) || len(msg) < smb2HeaderSize:
+		return nil
+	}
+
+	info := &SMBInfo{Version: "2"}
+	command := binary.LittleEndian.Uint16(msg[12:14])
+	if int(command) < len(smb2Commands) {
+		info.Command = smb2Commands[command]
+	} else {
+		info.Command = fmt.Sprint(command)
+	}
+	info.Response = binary.LittleEndian.Uint32(msg[16:20])&1 != 0
+	if status := binary.LittleEndian.Uint32(msg[8:12]); info.Response && status != 0 {
+		info.Status = fmt.Sprintf("0x%08x", status)
+	}
+
+	if !info.Response {
+		body := msg[smb2HeaderSize:]
+		switch {
+		case command == smb2TreeConnect && len(body) >= 8:
+			info.Path = smbString(msg, binary.LittleEndian.Uint16(body[4:6]), binary.LittleEndian.Uint16(body[6:8]))
+		case command == smb2Create && len(body) >= 48:
+			info.Path = smbString(msg, binary.LittleEndian.Uint16(body[44:46]), binary.LittleEndian.Uint16(body[46:48]))
+		}
+	}
+	return info
+}
+
+// smbString decodes the UTF-16 string at offset from the start of the SMB2
+// header, if the segment holds all of it.
+func smbString(msg []byte, offset, length uint16) string {
+	end := int(offset) + int(length)
+	if length == 0 || end > len(msg) {
+		return ""
+	}
+	b := msg[offset:end]
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// NameServiceInfo is a multicast DNS or LLMNR message, which hosts use to
+// announce and look up names on the local link.
+type NameServiceInfo struct {
+	Response  bool               `json:"response,omitempty"`
+	Questions []string           `json:"questions,omitempty"`
+	Answers   []NameServiceEntry `json:"answers,omitempty"`
+}
+
+// NameServiceEntry is one answer record; Value is the address, target
+// name or service of A, AAAA, PTR and SRV records.
+type NameServiceEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// maxNameServiceRecords bounds the records published per message.
+const maxNameServiceRecords = 32
+
+// nameServiceDissector handles the DNS-formatted link-local name
+// protocols, mDNS and LLMNR. For DNS, whose questions are in dns_query,
+// it publishes the answers of responses only.
+type nameServiceDissector struct {
+	name        string
+	port        layers.UDPPort
+	answersOnly bool
+}
+
+func (d nameServiceDissector) Name() string { return d.name }
+
+func (d nameServiceDissector) Dissect(packet gopacket.Packet) interface{} {
+	_, payload := udpPayload(packet, d.port)
+	if payload == nil {
+		return nil
+	}
+	// gopacket decodes DNS and mDNS itself; LLMNR is decoded through
+	// NewPacket too, which turns decoder panics on malformed records into
+	// decode failures.
+	dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok {
+		decoded := gopacket.NewPacket(payload, layers.LayerTypeDNS, gopacket.NoCopy)
+		if dns, ok = decoded.Layer(layers.LayerTypeDNS).(*layers.DNS); !ok {
+			return nil
+		}
+	}
+	if d.answersOnly && !dns.QR {
+		return nil
+	}
+
+	info := &NameServiceInfo{Response: dns.QR}
+	for _, q := range dns.Questions {
+		if !d.answersOnly && len(info.Questions) < maxNameServiceRecords {
+			info.Questions = append(info.Questions, string(q.Name))
+		}
+	}
+	for _, records := range [][]layers.DNSResourceRecord{dns.Answers, dns.Additionals} {
+		for _, rr := range records {
+			if len(info.Answers) == maxNameServiceRecords {
+				break
+			}
+			entry := NameServiceEntry{Name: string(rr.Name), Type: rr.Type.String()}
+			switch rr.Type {
+			case layers.DNSTypeA, layers.DNSTypeAAAA:
+				entry.Value = rr.IP.String()
+			case layers.DNSTypePTR:
+				entry.Value = string(rr.PTR)
+			case layers.DNSTypeSRV:
+				entry.Value = fmt.Sprintf("%s:%d", rr.SRV.Name, rr.SRV.Port)
+			default:
+				continue
+			}
+			info.Answers = append(info.Answers, entry)
+		}
+	}
+	if len(info.Questions) == 0 && len(info.Answers) == 0 {
+		return nil
+	}
+	return info
+}
+
+// SNMPInfo is an SNMP message. Versions 1 and 2c send the community, a
+// shared password, in clear text.
+type SNMPInfo struct {
+	Version   string `json:"version"`
+	Community string `json:"community,omitempty"`
+	PDU       string `json:"pdu,omitempty"`
+}
+
+var snmpPDUs = []string{"get", "get-next", "response", "set", "trap", "get-bulk", "inform", "trap", "report"}
+
+type snmpDissector struct{}
+
+func (snmpDissector) Name() string { return "snmp" }
+
+func (snmpDissector) Dissect(packet gopacket.Packet) interface{} {
+	_, payload := udpPayload(packet, 161)
+	if payload == nil {
+		if _, payload = udpPayload(packet, 162); payload == nil {
+			return nil
+		}
+	}
+
+	msg, _, ok := berElement(payload, 0x30)
+	if !ok {
+		return nil
+	}
+	version, rest, ok := berElement(msg, 0x02)
+	if !ok || len(version) != 1 {
+		return nil
+	}
+	info := &SNMPInfo{}
+	switch version[0] {
+	case 0:
+		info.Version = "1"
+	case 1:
+		info.Version = "2c"
+	case 3:
+		// Version 3 has user-based security instead of a community.
+		info.Version = "3"
+		return info
+	default:
+		return nil
+	}
+
+	community, rest, ok := berElement(rest, 0x04)
+	if !ok {
+		return nil
+	}
+	info.Community = string(community)
+	if len(rest) > 0 && rest[0]&0xe0 == 0xa0 && int(rest[0]&0x1f) < len(snmpPDUs) {
+		info.PDU = snmpPDUs[rest[0]&0x1f]
+	}
+	return info
+}
+
+// berElement reads a BER element with the given tag from the start of b
+// and returns its contents and what follows it.
+func berElement(b []byte, tag byte) (contents, rest []byte, ok bool) {
+	if len(b) < 2 || b[0] != tag {
+		return nil, nil, false
+	}
+	n, b := int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return nil, nil, false
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n > len(b) {
+		return nil, nil, false
+	}
+	return b[:n], b[n:], true
+}
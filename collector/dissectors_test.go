@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testPacket builds an Ethernet/IPv4 packet around a UDP or TCP payload.
+func testPacket(t testing.TB, udp bool, srcPort, dstPort uint16, payload []byte) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(10, 0, 0, 2),
+		Protocol: layers.IPProtocolTCP,
+	}
+	var transport gopacket.SerializableLayer
+	if udp {
+		ip.Protocol = layers.IPProtocolUDP
+		u := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		u.SetNetworkLayerForChecksum(ip)
+		transport = u
+	} else {
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), ACK: true, PSH: true, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip)
+		transport = tcp
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("serializing packet: %v", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func TestNameServiceTruncatedRecord(t *testing.T) {
+	// A response whose additional record runs past the end of the payload
+	// made gopacket's DNS decoder panic when called directly.
+	payload := []byte("\x00\x00\x00\x01\x00\x00\x00\xff\xf5\x00\x01a\x00")
+	for _, port := range []uint16{53, 5353, 5355} {
+		var meta PacketData
+		dissect(testPacket(t, true, port, port, payload), &meta)
+	}
+}
+
+func FuzzDissectUDP(f *testing.F) {
+	f.Add(uint16(5353), []byte("\x00\x00\x00\x01\x00\x00\x00\xff\xf5\x00\x01a\x00"))
+	f.Add(uint16(5355), []byte("\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x04host\x00\x00\x01\x00\x01"))
+	f.Add(uint16(53), []byte("\x00\x01\x81\x80\x00\x00\x00\x01\x00\x00\x00\x00\x01a\x00\x00\x01\x00\x01\x00\x00\x00\x3c\x00\x04\x0a\x00\x00\x01"))
+	f.Add(uint16(123), []byte("\x17\x00\x03\x2a\x00\x00\x00\x00"))
+	f.Add(uint16(161), []byte("\x30\x0e\x02\x01\x01\x04\x06public\xa0\x01\x00"))
+	f.Add(uint16(443), []byte("\xc0\x00\x00\x00\x01\x08\x01\x02\x03\x04\x05\x06\x07\x08\x00\x00\x40\x20"))
+	f.Add(uint16(67), []byte("\x01\x01\x06\x00"))
+
+	f.Fuzz(func(t *testing.T, port uint16, payload []byte) {
+		packet := testPacket(t, true, 40000, port, payload)
+		for _, d := range dissectors {
+			d.Dissect(packet)
+		}
+	})
+}
+
+func FuzzDissectTCP(f *testing.F) {
+	f.Add(uint16(22), []byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	f.Add(uint16(22), []byte("\x00\x00\x00\x2c\x04\x14abcdefghijklmnop\x00\x00\x00\x03abc"))
+	f.Add(uint16(445), []byte("\x00\x00\x00\x48\xfeSMB\x40\x00\x00\x00\x00\x00\x00\x00\x03\x00"))
+	f.Add(uint16(445), []byte("\x00\x00\x00\x20\xffSMB"))
+
+	f.Fuzz(func(t *testing.T, port uint16, payload []byte) {
+		packet := testPacket(t, false, 40000, port, payload)
+		for _, d := range dissectors {
+			d.Dissect(packet)
+		}
+	})
+}
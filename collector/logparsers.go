@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogParser turns a single line from a tailed file into a LogData record.
+// Source and host metadata are filled in by the tailer afterwards.
+type LogParser interface {
+	Name() string
+	Parse(line string) (LogData, error)
+}
+
+var logParsers = map[string]LogParser{}
+
+func registerLogParser(p LogParser, aliases ...string) {
+	logParsers[p.Name()] = p
+	for _, alias := range aliases {
+		logParsers[alias] = p
+	}
+}
+
+func init() {
+	registerLogParser(plainParser{})
+	registerLogParser(jsonParser{})
+	registerLogParser(combinedParser{}, "nginx", "apache")
+	registerLogParser(auditdParser{})
+}
+
+func lookupLogParser(name string) (LogParser, error) {
+	if name == "" {
+		name = "plain"
+	}
+	p, ok := logParsers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log parser %q", name)
+	}
+	return p, nil
+}
+
+// plainParser passes the line through untouched and guesses a level from
+// the usual keywords.
+type plainParser struct{}
+
+func (plainParser) Name() string { return "plain" }
+
+func (plainParser) Parse(line string) (LogData, error) {
+	return LogData{
+		LogLevel: guessLogLevel(line),
+		Message:  line,
+	}, nil
+}
+
+func guessLogLevel(line string) string {
+	upper := strings.ToUpper(line)
+	switch {
+	case strings.Contains(upper, "CRIT"), strings.Contains(upper, "FATAL"), strings.Contains(upper, "PANIC"):
+		return "critical"
+	case strings.Contains(upper, "ERROR"), strings.Contains(upper, "ERR "):
+		return "error"
+	case strings.Contains(upper, "WARN"):
+		return "warning"
+	case strings.Contains(upper, "DEBUG"):
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+func normalizeLogLevel(level string) string {
+	switch l := strings.ToLower(level); l {
+	case "warn":
+		return "warning"
+	case "err":
+		return "error"
+	case "crit", "fatal", "panic":
+		return "critical"
+	default:
+		return l
+	}
+}
+
+// jsonParser handles structured one-object-per-line logs. Well known keys
+// are lifted into the record, everything else ends up in Metadata.
+type jsonParser struct{}
+
+var (
+	jsonTimeKeys    = []string{"timestamp", "@timestamp", "time", "ts"}
+	jsonLevelKeys   = []string{"log_level", "level", "severity", "lvl"}
+	jsonMessageKeys = []string{"message", "msg", "log"}
+)
+
+func (jsonParser) Name() string { return "json" }
+
+func (jsonParser) Parse(line string) (LogData, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return LogData{}, err
+	}
+
+	entry := LogData{Metadata: map[string]string{}}
+
+	for _, key := range jsonTimeKeys {
+		if v, ok := fields[key]; ok {
+			if ts, ok := parseJSONTime(v); ok {
+				entry.Timestamp = ts
+				delete(fields, key)
+				break
+			}
+		}
+	}
+	for _, key := range jsonLevelKeys {
+		if v, ok := fields[key].(string); ok {
+			entry.LogLevel = normalizeLogLevel(v)
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range jsonMessageKeys {
+		if v, ok := fields[key].(string); ok {
+			entry.Message = v
+			delete(fields, key)
+			break
+		}
+	}
+
+	if entry.Message == "" {
+		entry.Message = line
+	}
+	if entry.LogLevel == "" {
+		entry.LogLevel = "info"
+	}
+
+	for k, v := range fields {
+		switch val := v.(type) {
+		case string:
+			entry.Metadata[k] = val
+		default:
+			b, err := json.Marshal(val)
+			if err != nil {
+				continue
+			}
+			entry.Metadata[k] = string(b)
+		}
+	}
+
+	return entry, nil
+}
+
+func parseJSONTime(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05"} {
+			if ts, err := time.Parse(layout, val); err == nil {
+				return ts, true
+			}
+		}
+	case float64:
+		sec := int64(val)
+		return time.Unix(sec, int64((val-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// combinedParser understands the nginx/apache "combined" access log format
+// (and "common", which is the same without referer and user agent).
+type combinedParser struct{}
+
+var combinedLogRe = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\S+)(?: "([^"]*)" "([^"]*)")?`)
+
+func (combinedParser) Name() string { return "combined" }
+
+func (combinedParser) Parse(line string) (LogData, error) {
+	m := combinedLogRe.FindStringSubmatch(line)
+	if m == nil {
+		return LogData{}, fmt.Errorf("line does not match combined log format")
+	}
+
+	ts, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[3])
+	if err != nil {
+		return LogData{}, err
+	}
+
+	status, _ := strconv.Atoi(m[5])
+	level := "info"
+	switch {
+	case status >= 500:
+		level = "error"
+	case status >= 400:
+		level = "warning"
+	}
+
+	meta := map[string]string{
+		"remote_addr": m[1],
+		"remote_user": m[2],
+		"request":     m[4],
+		"status":      m[5],
+		"bytes":       m[6],
+	}
+	if parts := strings.SplitN(m[4], " ", 3); len(parts) == 3 {
+		meta["method"] = parts[0]
+		meta["path"] = parts[1]
+		meta["protocol"] = parts[2]
+	}
+	if m[7] != "" && m[7] != "-" {
+		meta["referer"] = m[7]
+	}
+	if m[8] != "" && m[8] != "-" {
+		meta["user_agent"] = m[8]
+	}
+
+	return LogData{
+		Timestamp: ts,
+		LogLevel:  level,
+		Message:   line,
+		Metadata:  meta,
+	}, nil
+}
+
+// auditdParser parses Linux audit records such as
+// `type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e success=no ...`.
+type auditdParser struct{}
+
+var auditHeaderRe = regexp.MustCompile(`^type=(\S+) msg=audit\((\d+)\.(\d+):(\d+)\):\s*(.*)$`)
+
+func (auditdParser) Name() string { return "auditd" }
+
+func (auditdParser) Parse(line string) (LogData, error) {
+	m := auditHeaderRe.FindStringSubmatch(line)
+	if m == nil {
+		return LogData{}, fmt.Errorf("line does not match auditd format")
+	}
+
+	sec, _ := strconv.ParseInt(m[2], 10, 64)
+	msec, _ := strconv.ParseInt(m[3], 10, 64)
+
+	meta := parseKeyValues(m[5])
+	// User-space records carry their payload in a nested msg='...' field.
+	if nested, ok := meta["msg"]; ok && strings.Contains(nested, "=") {
+		for k, v := range parseKeyValues(nested) {
+			if _, exists := meta[k]; !exists {
+				meta[k] = v
+			}
+		}
+		delete(meta, "msg")
+	}
+	meta["audit_type"] = m[1]
+	meta["audit_serial"] = m[4]
+
+	level := "info"
+	if meta["success"] == "no" || meta["res"] == "failed" || meta["res"] == "0" {
+		level = "warning"
+	}
+
+	return LogData{
+		Timestamp: time.Unix(sec, msec*int64(time.Millisecond)),
+		LogLevel:  level,
+		Message:   line,
+		Metadata:  meta,
+	}, nil
+}
+
+// parseKeyValues splits `a=1 b="two words" c='x'` into a map, honouring
+// quoted values.
+func parseKeyValues(s string) map[string]string {
+	out := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			break
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		var val string
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			quote := s[0]
+			end := strings.IndexByte(s[1:], quote)
+			if end < 0 {
+				val, s = s[1:], ""
+			} else {
+				val, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				val, s = s, ""
+			} else {
+				val, s = s[:end], s[end:]
+			}
+		}
+		out[key] = val
+	}
+	return out
+}
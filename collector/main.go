@@ -25,6 +25,25 @@ var (
 	networkTopic = flag.String("network-topic", "network-pluto", "Kafka topic for network data")
 	promiscuous  = flag.Bool("promisc", true, "Set promiscuous mode on interface")
 	snapLen      = flag.Int("snaplen", 65535, "Snapshot length for packet capture")
+	capture      = flag.Bool("capture", true, "Capture network packets on all interfaces")
+	controlTopic = flag.String("control-topic", "pluto-control", "Kafka topic plutos-space publishes settings changes to")
+	logTopic     = flag.String("log-topic", "log-data", "Kafka topic for log data")
+	tailFiles    = flag.String("tail", "", "Comma-separated log files or glob patterns to follow, each optionally suffixed with =parser (plain, json, combined, nginx, apache, auditd)")
+	tailState    = flag.String("tail-checkpoint", "/var/lib/pluto/tail-checkpoint.json", "File used to persist log tailing offsets")
+	tailFromHead = flag.Bool("tail-from-start", false, "Read pre-existing files without a checkpoint from the beginning instead of the end")
+	sensorName   = flag.String("sensor", "", "Name this collector reports packets under, used to fetch raw packets from it (defaults to the hostname)")
+	pcapDir      = flag.String("pcap-dir", "", "Directory for the rolling full-packet capture; empty disables it")
+	pcapFileMB   = flag.Int("pcap-file-mb", 64, "Size in MB at which a capture file is closed and a new one started")
+	pcapMaxMB    = flag.Int("pcap-max-mb", 2048, "Total size in MB of capture files kept; the oldest are deleted beyond it")
+	pcapMaxAge   = flag.Duration("pcap-max-age", 24*time.Hour, "How long captured packets are kept")
+	pcapRotate   = flag.Duration("pcap-rotate", 5*time.Minute, "How long a capture file is written to before a new one is started")
+	pcapListen   = flag.String("pcap-listen", ":8087", "Address the packet retrieval API listens on when -pcap-dir is set")
+	pcapToken    = flag.String("pcap-token", os.Getenv("PLUTO_PCAP_TOKEN"), "Bearer token required by the packet retrieval API (default $PLUTO_PCAP_TOKEN)")
+	tcpSessions  = flag.Bool("tcp-sessions", false, "Reassemble TCP connections and publish a session record for each")
+	sessionTopic = flag.String("session-topic", "tcp-sessions", "Kafka topic for TCP session records")
+	sessionIdle  = flag.Duration("session-idle", 2*time.Minute, "A TCP session without packets for this long is ended")
+	defragment   = flag.Bool("defrag", true, "Reassemble fragmented IP datagrams and report fragmentation anomalies")
+	defragMemMB  = flag.Int("defrag-memory-mb", 32, "Memory in MB each interface may hold in fragments waiting for reassembly")
 	maxBatchSize = 100
 	batchTimeout = 1 * time.Second
 )
@@ -46,6 +65,12 @@ func main() {
 
 	flag.Parse()
 
+	if *sensorName == "" {
+		if *sensorName, err = os.Hostname(); err != nil {
+			log.Fatalf("Failed to get hostname, set -sensor: %v", err)
+		}
+	}
+
 	networkWriter := kafka.NewWriter(kafka.WriterConfig{
 		Brokers:      []string{*kafkaAddr},
 		Topic:        *networkTopic,
@@ -55,23 +80,99 @@ func main() {
 	})
 	defer networkWriter.Close()
 
-	devices, err := pcap.FindAllDevs()
-	if err != nil {
-		log.Fatalf("Failed to acquire devices: %v", err)
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	log.Printf("Found %d network interfaces", len(devices))
-	for _, device := range devices {
-		log.Printf("- Interfaces: %s", device.Name)
-		for _, addr := range device.Addresses {
-			log.Printf(" - IP: %s, Netmask: %s", addr.IP, addr.Netmask)
+	var wg sync.WaitGroup
+
+	if *tailFiles != "" {
+		specs, err := parseTailSpecs(*tailFiles)
+		if err != nil {
+			log.Fatalf("Invalid -tail value: %v", err)
 		}
+
+		checkpoint, err := LoadCheckpoint(*tailState)
+		if err != nil {
+			log.Fatalf("Failed to load tail checkpoint: %v", err)
+		}
+
+		// Log delivery is synchronous so that offsets are only checkpointed
+		// once Kafka has accepted the lines. Each write already holds all
+		// the lines of a read, so a partial batch is held only briefly.
+		logWriter := kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      []string{*kafkaAddr},
+			Topic:        *logTopic,
+			BatchSize:    maxBatchSize,
+			BatchTimeout: tailBatchTimeout,
+		})
+		defer logWriter.Close()
+
+		tailer := NewTailer(specs, logWriter, checkpoint, *tailFromHead)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tailer.Run(ctx)
+		}()
+		log.Printf("Started log tailer for %d pattern(s)", len(specs))
 	}
 
-	var wg sync.WaitGroup
-	for _, device := range devices {
+	if *capture {
+		devices, err := pcap.FindAllDevs()
+		if err != nil {
+			log.Fatalf("Failed to acquire devices: %v", err)
+		}
+
+		log.Printf("Found %d network interfaces", len(devices))
+		for _, device := range devices {
+			log.Printf("- Interfaces: %s", device.Name)
+			for _, addr := range device.Addresses {
+				log.Printf(" - IP: %s, Netmask: %s", addr.IP, addr.Netmask)
+			}
+		}
+
+		var ring *Ring
+		if *pcapDir != "" {
+			if *pcapToken == "" {
+				log.Fatalf("-pcap-token or PLUTO_PCAP_TOKEN is required with -pcap-dir")
+			}
+			ring, err = OpenRing(*pcapDir, int64(*pcapFileMB)<<20, int64(*pcapMaxMB)<<20, *pcapMaxAge, *pcapRotate)
+			if err != nil {
+				log.Fatalf("Failed to open packet capture directory: %v", err)
+			}
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				ring.Run(ctx)
+			}()
+			go func() {
+				defer wg.Done()
+				serveCaptureAPI(ctx, *pcapListen, *pcapToken, ring)
+			}()
+			log.Printf("Keeping full packets in %s (up to %d MB, %s)", *pcapDir, *pcapMaxMB, *pcapMaxAge)
+		}
+
+		var sessionWriter *kafka.Writer
+		if *tcpSessions {
+			sessionWriter = kafka.NewWriter(kafka.WriterConfig{
+				Brokers:      []string{*kafkaAddr},
+				Topic:        *sessionTopic,
+				BatchSize:    maxBatchSize,
+				BatchTimeout: batchTimeout,
+				Async:        true,
+			})
+			defer sessionWriter.Close()
+			log.Printf("Reassembling TCP sessions to topic %s", *sessionTopic)
+		}
+
+		manager := NewCaptureManager(devices, networkWriter, ring, sessionWriter, *snapLen)
 		wg.Add(1)
-		go captureDevice(device, networkWriter, &wg)
+		go func() {
+			defer wg.Done()
+			manager.Run(ctx)
+		}()
+
+		wg.Add(1)
+		go watchControlTopic(ctx, manager, &wg)
 	}
 
 	sigChan := make(chan os.Signal, 1)
@@ -79,6 +180,8 @@ func main() {
 	<-sigChan
 	log.Println("Shuttting down collector")
 
+	cancel()
+
 	if err := networkWriter.Close(); err != nil {
 		log.Printf("Error closing Kafka writer: %v", err)
 	}
@@ -88,6 +191,7 @@ func main() {
 
 type PacketData struct {
 	Timestamp  time.Time `json:"timestamp"`
+	Sensor     string    `json:"sensor,omitempty"`
 	DeviceName string    `json:"device_name"`
 	IfaceIndex int       `json:"interface_index,omitempty"`
 	Direction  string    `json:"direction,omitempty"`
@@ -111,6 +215,13 @@ type PacketData struct {
 	ICMPType   uint8  `json:"icmp_type,omitempty"`
 	ICMPCode   uint8  `json:"icmp_code,omitempty"`
 
+	// IsFragment marks a fragment of a larger datagram. Reassembled
+	// datagrams are published as well, with the number of fragments they
+	// were built from in Fragments.
+	IsFragment  bool   `json:"is_fragment,omitempty"`
+	Fragments   int    `json:"fragments,omitempty"`
+	FragAnomaly string `json:"fragment_anomaly,omitempty"`
+
 	// Transport
 	SrcPort       uint16 `json:"src_port,omitempty"`
 	DstPort       uint16 `json:"dst_port,omitempty"`
@@ -128,6 +239,10 @@ type PacketData struct {
 	TLSVrs     string   `json:"tls_version,omitempty"`
 	SNI        string   `json:"sni,omitempty"`
 
+	// App holds the fields the registered dissectors found, by protocol
+	// name, e.g. "dhcp" or "ssh".
+	App map[string]interface{} `json:"app,omitempty"`
+
 	// Payload
 	PayloadSize int    `json:"payload_size,omitempty"`
 	PayloadHash string `json:"payload_hash,omitempty"`
@@ -142,32 +257,11 @@ type PacketData struct {
 	} `json:"geoip,omitempty"`
 }
 
-func captureDevice(device pcap.Interface, writer *kafka.Writer, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	if device.Name == "lo" || device.Name == "log" {
-		log.Printf("Skipping loopback interface %s", device.Name)
-		return
-	}
-
-	handle, err := pcap.OpenLive(device.Name, int32(*snapLen), *promiscuous, pcap.BlockForever)
-	if err != nil {
-		log.Printf("Error opening device %s: %v", device.Name, err)
-		return
-	}
-	defer handle.Close()
-
-	log.Printf("Started packet capture on %s", device.Name)
-
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		processPacket(device.Name, packet, writer)
-	}
-}
-
-func processPacket(deviceName string, packet gopacket.Packet, writer *kafka.Writer) {
+// packetMetadata extracts the fields published for a packet.
+func packetMetadata(deviceName string, packet gopacket.Packet) PacketData {
 	meta := PacketData{
 		Timestamp:  packet.Metadata().Timestamp,
+		Sensor:     *sensorName,
 		DeviceName: deviceName,
 	}
 
@@ -196,6 +290,7 @@ func processPacket(deviceName string, packet gopacket.Packet, writer *kafka.Writ
 		meta.Protocol = ip4.Protocol.String()
 		meta.FragID = uint32(ip4.Id)
 		meta.FragOffset = ip4.FragOffset
+		meta.IsFragment = isFragment(packet)
 		meta.DSCP = ip4.TOS >> 2
 	} else if ip6Layer := packet.Layer(layers.LayerTypeIPv6); ip6Layer != nil {
 		ip6, _ := ip6Layer.(*layers.IPv6)
@@ -210,6 +305,7 @@ func processPacket(deviceName string, packet gopacket.Packet, writer *kafka.Writ
 			frag, _ := fragLayer.(*layers.IPv6Fragment)
 			meta.FragID = frag.Identification
 			meta.FragOffset = frag.FragmentOffset
+			meta.IsFragment = isFragment(packet)
 		}
 	}
 
@@ -235,6 +331,10 @@ func processPacket(deviceName string, packet gopacket.Packet, writer *kafka.Writ
 		icmp, _ := icmpLayer.(*layers.ICMPv4)
 		meta.ICMPType = uint8(icmp.TypeCode.Type())
 		meta.ICMPCode = uint8(icmp.TypeCode.Code())
+	} else if icmpLayer := packet.Layer(layers.LayerTypeICMPv6); icmpLayer != nil {
+		icmp, _ := icmpLayer.(*layers.ICMPv6)
+		meta.ICMPType = uint8(icmp.TypeCode.Type())
+		meta.ICMPCode = uint8(icmp.TypeCode.Code())
 	}
 
 	// DNS
@@ -250,7 +350,11 @@ func processPacket(deviceName string, packet gopacket.Packet, writer *kafka.Writ
 	if app := packet.ApplicationLayer(); app != nil {
 		meta.PayloadSize = len(app.Payload())
 	}
+	dissect(packet, &meta)
+	return meta
+}
 
+func publishPacket(meta *PacketData, writer *kafka.Writer) {
 	jsonBytes, err := json.Marshal(meta)
 	if err != nil {
 		log.Printf("JSON marshaling failed: %v", err)
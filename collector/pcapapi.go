@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	defaultPcapLimit = 10000
+	maxPcapLimit     = 100000
+)
+
+// pcapQuery selects packets from the ring. Zero addresses and ports match
+// anything, as does a Protocol of -1. With Bidirectional, packets of the
+// reverse direction match too, so a query for a connection returns both
+// sides of it.
+type pcapQuery struct {
+	From, To         time.Time
+	SrcIP, DstIP     [16]byte
+	SrcPort, DstPort uint16
+	Protocol         int
+	Bidirectional    bool
+	Limit            int
+}
+
+func (q *pcapQuery) matchDirection(srcIP, dstIP [16]byte, srcPort, dstPort uint16) bool {
+	var any [16]byte
+	return (q.SrcIP == any || q.SrcIP == srcIP) &&
+		(q.DstIP == any || q.DstIP == dstIP) &&
+		(q.SrcPort == 0 || q.SrcPort == srcPort) &&
+		(q.DstPort == 0 || q.DstPort == dstPort)
+}
+
+func (q *pcapQuery) match(e *indexEntry) bool {
+	if e.Timestamp < q.From.UnixNano() || e.Timestamp >= q.To.UnixNano() {
+		return false
+	}
+	if q.Protocol >= 0 && int(e.Protocol) != q.Protocol {
+		return false
+	}
+	return q.matchDirection(e.SrcIP, e.DstIP, e.SrcPort, e.DstPort) ||
+		(q.Bidirectional && q.matchDirection(e.DstIP, e.SrcIP, e.DstPort, e.SrcPort))
+}
+
+// pcapMatch is a packet found by a search.
+type pcapMatch struct {
+	file  int
+	entry indexEntry
+}
+
+var errNoPackets = errors.New("no matching packets in the capture buffer")
+
+// search returns the packets matching q in capture order, at most q.Limit.
+// Only the indexes of files overlapping the time range are read.
+func (r *Ring) search(snap ringSnapshot, q pcapQuery) ([]pcapMatch, error) {
+	var matches []pcapMatch
+	buf := make([]byte, indexEntrySize)
+	for i, f := range snap.files {
+		if f.Last.Before(q.From) || !f.First.Before(q.To) {
+			continue
+		}
+		index, err := os.Open(f.indexPath())
+		if os.IsNotExist(err) {
+			continue // deleted by the ring since the snapshot
+		}
+		if err != nil {
+			return nil, err
+		}
+		rd := bufio.NewReaderSize(index, 256<<10)
+		for n := int64(0); n < snap.entries[i]; n++ {
+			if _, err := io.ReadFull(rd, buf); err != nil {
+				break
+			}
+			e := decodeIndexEntry(buf)
+			if !q.match(&e) {
+				continue
+			}
+			matches = append(matches, pcapMatch{file: i, entry: e})
+			if len(matches) == q.Limit {
+				index.Close()
+				return matches, nil
+			}
+		}
+		index.Close()
+	}
+	if len(matches) == 0 {
+		return nil, errNoPackets
+	}
+	return matches, nil
+}
+
+// writePcap writes the matched packets to w, as pcapng or, with format
+// "pcap", as a classic pcap file, which requires every packet to have the
+// same link type.
+func writePcap(w io.Writer, snap ringSnapshot, matches []pcapMatch, format string) error {
+	files := make(map[int]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var (
+		classic  *pcapWriter
+		ngBuf    []byte
+		ngIfaces = make(map[[2]int]uint32)
+		data     []byte
+	)
+	if format == "pcapng" {
+		ngBuf = appendSectionHeader(ngBuf)
+	} else {
+		if !sameLinkType(snap, matches) {
+			return fmt.Errorf("matching packets have different link types")
+		}
+		first := snap.files[matches[0].file].Interfaces[matches[0].entry.Iface]
+		snapLen := 0
+		for _, m := range matches {
+			snapLen = max(snapLen, snap.files[m.file].Interfaces[m.entry.Iface].SnapLen)
+		}
+		var err error
+		if classic, err = newPcapWriter(w, first.LinkType, snapLen); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range matches {
+		f, ok := files[m.file]
+		if !ok {
+			var err error
+			if f, err = os.Open(snap.files[m.file].capturePath()); err != nil {
+				return err
+			}
+			files[m.file] = f
+		}
+		if cap(data) < int(m.entry.CapLen) {
+			data = make([]byte, m.entry.CapLen)
+		}
+		data = data[:m.entry.CapLen]
+		if _, err := f.ReadAt(data, m.entry.Offset); err != nil {
+			return err
+		}
+		ts := time.Unix(0, m.entry.Timestamp)
+
+		if classic != nil {
+			if err := classic.writePacket(ts, int(m.entry.Length), data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := [2]int{m.file, int(m.entry.Iface)}
+		id, ok := ngIfaces[key]
+		if !ok {
+			id = uint32(len(ngIfaces))
+			ngIfaces[key] = id
+			ngBuf = appendInterface(ngBuf, snap.files[m.file].Interfaces[m.entry.Iface])
+		}
+		ngBuf = appendPacket(ngBuf, id, ts, int(m.entry.Length), data)
+		if _, err := w.Write(ngBuf); err != nil {
+			return err
+		}
+		ngBuf = ngBuf[:0]
+	}
+	return nil
+}
+
+func sameLinkType(snap ringSnapshot, matches []pcapMatch) bool {
+	first := snap.files[matches[0].file].Interfaces[matches[0].entry.Iface].LinkType
+	for _, m := range matches {
+		if snap.files[m.file].Interfaces[m.entry.Iface].LinkType != first {
+			return false
+		}
+	}
+	return true
+}
+
+// parseProtocol accepts an IP protocol number or a name as stored with
+// packets, e.g. TCP, UDP or ICMPv4.
+func parseProtocol(v string) (int, error) {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 255 {
+		return n, nil
+	}
+	for i := 0; i < 256; i++ {
+		if strings.EqualFold(layers.IPProtocol(i).String(), v) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown protocol %q", v)
+}
+
+func parsePcapQuery(r *http.Request) (pcapQuery, error) {
+	params := r.URL.Query()
+	q := pcapQuery{
+		Protocol:      -1,
+		Bidirectional: params.Get("bidirectional") == "true",
+		Limit:         defaultPcapLimit,
+	}
+
+	var err error
+	if q.From, err = time.Parse(time.RFC3339Nano, params.Get("from")); err != nil {
+		return q, fmt.Errorf("from must be an RFC 3339 time")
+	}
+	if q.To, err = time.Parse(time.RFC3339Nano, params.Get("to")); err != nil {
+		return q, fmt.Errorf("to must be an RFC 3339 time")
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	for name, dst := range map[string]*[16]byte{"src_ip": &q.SrcIP, "dst_ip": &q.DstIP} {
+		if v := params.Get(name); v != "" {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return q, fmt.Errorf("%s is not an IP address", name)
+			}
+			*dst = ipKey(ip)
+		}
+	}
+	for name, dst := range map[string]*uint16{"src_port": &q.SrcPort, "dst_port": &q.DstPort} {
+		if v := params.Get(name); v != "" {
+			port, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a port number", name)
+			}
+			*dst = uint16(port)
+		}
+	}
+	if v := params.Get("protocol"); v != "" {
+		if q.Protocol, err = parseProtocol(v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return q, fmt.Errorf("limit must be a positive number")
+		}
+		q.Limit = min(l, maxPcapLimit)
+	}
+	return q, nil
+}
+
+// pcapHandler serves GET /pcap: the packets in the ring matching from, to
+// (RFC 3339, required), src_ip, dst_ip, src_port, dst_port, protocol and
+// bidirectional, at most limit of them, as a pcap (format=pcap, the
+// default) or pcapng (format=pcapng) download.
+func pcapHandler(ring *Ring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parsePcapQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "pcap"
+		}
+		if format != "pcap" && format != "pcapng" {
+			http.Error(w, "format must be pcap or pcapng", http.StatusBadRequest)
+			return
+		}
+
+		snap, err := ring.snapshot()
+		if err != nil {
+			log.Printf("[Ring] Error flushing capture for search: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		matches, err := ring.search(snap, q)
+		if err == errNoPackets {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("[Ring] Error searching capture index: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Classic pcap needs one link type; check before the response is
+		// committed.
+		if format == "pcap" && !sameLinkType(snap, matches) {
+			http.Error(w, "matching packets were captured on interfaces with different link types; use format=pcapng", http.StatusConflict)
+			return
+		}
+
+		contentType := "application/vnd.tcpdump.pcap"
+		if format == "pcapng" {
+			contentType = "application/x-pcapng"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Packet-Count", strconv.Itoa(len(matches)))
+		bw := bufio.NewWriterSize(w, 256<<10)
+		if err := writePcap(bw, snap, matches, format); err != nil {
+			log.Printf("[Ring] Error writing pcap: %v", err)
+			// The status is already sent; abort the connection so the
+			// client sees a failed download rather than a short file.
+			panic(http.ErrAbortHandler)
+		}
+		if err := bw.Flush(); err != nil && r.Context().Err() == nil {
+			log.Printf("[Ring] Error writing pcap: %v", err)
+		}
+	}
+}
+
+// pinHandler serves POST /pins, which pins the packets from from to to
+// until expires, all RFC 3339 times in a JSON body, and answers with the
+// pin.
+func pinHandler(ring *Ring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Pin
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		pin, err := ring.Pin(req.From, req.To, req.Expires)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pin)
+	}
+}
+
+// serveCaptureAPI serves packet retrieval from the ring on addr until ctx
+// is cancelled. Every request must carry token as a bearer token.
+func serveCaptureAPI(ctx context.Context, addr, token string, ring *Ring) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pcap", pcapHandler(ring))
+	mux.HandleFunc("POST /pins", pinHandler(ring))
+	mux.HandleFunc("DELETE /pins/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !ring.Unpin(r.PathValue("id")) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ring.Status())
+	})
+
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving packet capture API on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Packet capture API stopped: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// pcapng block types and the options the ring writes. Timestamps are
+// written with nanosecond resolution (if_tsresol 9).
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optionEnd      = 0
+	optionIfName   = 2
+	optionIfTsres  = 9
+	epbHeaderSize  = 28
+	blockTrailSize = 4
+)
+
+// captureInterface is an interface packets in a capture file were read
+// from.
+type captureInterface struct {
+	Name     string          `json:"name"`
+	LinkType layers.LinkType `json:"link_type"`
+	SnapLen  int             `json:"snaplen"`
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.LittleEndian.AppendUint16(b, v)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// appendSectionHeader appends a section header block of unknown length.
+func appendSectionHeader(b []byte) []byte {
+	b = appendUint32(b, blockSectionHeader)
+	b = appendUint32(b, 28)
+	b = appendUint32(b, byteOrderMagic)
+	b = appendUint16(b, 1) // major version
+	b = appendUint16(b, 0) // minor version
+	b = binary.LittleEndian.AppendUint64(b, ^uint64(0))
+	return appendUint32(b, 28)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = appendUint16(b, code)
+	b = appendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+// appendInterface appends an interface description block.
+func appendInterface(b []byte, iface captureInterface) []byte {
+	var opts []byte
+	if iface.Name != "" {
+		opts = appendOption(opts, optionIfName, []byte(iface.Name))
+	}
+	opts = appendOption(opts, optionIfTsres, []byte{9})
+	opts = appendOption(opts, optionEnd, nil)
+
+	length := uint32(20 + len(opts))
+	b = appendUint32(b, blockInterface)
+	b = appendUint32(b, length)
+	b = appendUint16(b, uint16(iface.LinkType))
+	b = appendUint16(b, 0)
+	b = appendUint32(b, uint32(iface.SnapLen))
+	b = append(b, opts...)
+	return appendUint32(b, length)
+}
+
+// appendPacket appends an enhanced packet block. The packet data starts
+// epbHeaderSize bytes into the block.
+func appendPacket(b []byte, ifaceID uint32, ts time.Time, length int, data []byte) []byte {
+	total := uint32(epbHeaderSize + len(data) + pad4(len(data)) + blockTrailSize)
+	nanos := uint64(ts.UnixNano())
+	b = appendUint32(b, blockEnhancedPacket)
+	b = appendUint32(b, total)
+	b = appendUint32(b, ifaceID)
+	b = appendUint32(b, uint32(nanos>>32))
+	b = appendUint32(b, uint32(nanos))
+	b = appendUint32(b, uint32(len(data)))
+	b = appendUint32(b, uint32(length))
+	b = append(b, data...)
+	b = append(b, make([]byte, pad4(len(data)))...)
+	return appendUint32(b, total)
+}
+
+// pcapWriter writes packets as a classic pcap file with nanosecond
+// timestamps, which tcpdump and Wireshark read without needing pcapng
+// support.
+type pcapWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPcapWriter(w io.Writer, linkType layers.LinkType, snapLen int) (*pcapWriter, error) {
+	var hdr []byte
+	hdr = appendUint32(hdr, 0xa1b23c4d) // nanosecond magic
+	hdr = appendUint16(hdr, 2)
+	hdr = appendUint16(hdr, 4)
+	hdr = appendUint32(hdr, 0) // thiszone
+	hdr = appendUint32(hdr, 0) // sigfigs
+	hdr = appendUint32(hdr, uint32(snapLen))
+	hdr = appendUint32(hdr, uint32(linkType))
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+func (p *pcapWriter) writePacket(ts time.Time, length int, data []byte) error {
+	b := p.buf[:0]
+	b = appendUint32(b, uint32(ts.Unix()))
+	b = appendUint32(b, uint32(ts.Nanosecond()))
+	b = appendUint32(b, uint32(len(data)))
+	b = appendUint32(b, uint32(length))
+	b = append(b, data...)
+	p.buf = b
+	_, err := p.w.Write(b)
+	return err
+}
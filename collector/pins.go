@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxPinDuration bounds how long a pin can hold capture files, so a client
+// that never releases its pins cannot keep the ring from rotating for
+// good.
+const maxPinDuration = 24 * time.Hour
+
+// Pin keeps the capture files holding packets from From to To from being
+// deleted by the ring's size and age limits until Expires, so they can be
+// copied out, e.g. as evidence for an alert. Pins are saved in the ring's
+// directory and survive restarts.
+type Pin struct {
+	ID      string    `json:"id"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Expires time.Time `json:"expires"`
+}
+
+func (r *Ring) pinsPath() string {
+	return filepath.Join(r.dir, "pins.json")
+}
+
+// loadPins reads the pins saved by a previous run, dropping expired ones.
+func (r *Ring) loadPins(now time.Time) error {
+	r.pins = make(map[string]Pin)
+	data, err := os.ReadFile(r.pinsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pins []Pin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return err
+	}
+	for _, p := range pins {
+		if p.Expires.After(now) {
+			r.pins[p.ID] = p
+		}
+	}
+	return nil
+}
+
+// savePins writes the current pins, replacing the file atomically.
+func (r *Ring) savePins() {
+	pins := make([]Pin, 0, len(r.pins))
+	for _, p := range r.pins {
+		pins = append(pins, p)
+	}
+	data, err := json.Marshal(pins)
+	if err == nil {
+		tmp := r.pinsPath() + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o640); err == nil {
+			err = os.Rename(tmp, r.pinsPath())
+		}
+	}
+	if err != nil {
+		log.Printf("[Ring] Error saving pins: %v", err)
+	}
+}
+
+// Pin holds the packets from from to to until expires.
+func (r *Ring) Pin(from, to, expires time.Time) (Pin, error) {
+	now := time.Now()
+	if !from.Before(to) {
+		return Pin{}, fmt.Errorf("from must be before to")
+	}
+	if !expires.After(now) || expires.Sub(now) > maxPinDuration {
+		return Pin{}, fmt.Errorf("expires must be in the next %s", maxPinDuration)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Pin{}, err
+	}
+	p := Pin{ID: hex.EncodeToString(id[:]), From: from.UTC(), To: to.UTC(), Expires: expires.UTC()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pins[p.ID] = p
+	r.savePins()
+	return p, nil
+}
+
+// Unpin releases a pin. It reports whether the pin existed.
+func (r *Ring) Unpin(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pins[id]; !ok {
+		return false
+	}
+	delete(r.pins, id)
+	r.savePins()
+	return true
+}
+
+// expirePins drops pins that have expired.
+func (r *Ring) expirePins(now time.Time) {
+	changed := false
+	for id, p := range r.pins {
+		if !p.Expires.After(now) {
+			delete(r.pins, id)
+			changed = true
+		}
+	}
+	if changed {
+		r.savePins()
+	}
+}
+
+// pinned reports whether a pin holds packets of f.
+func (r *Ring) pinned(f *ringFile) bool {
+	for _, p := range r.pins {
+		if !f.Last.Before(p.From) && !f.First.After(p.To) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/google/gopacket"
+)
+
+// QUICInfo is a QUIC version 1 Initial packet from a client. Its keys
+// derive from the packet's destination connection ID (RFC 9001 section
+// 5.2), so any observer can decrypt it and read the TLS ClientHello.
+type QUICInfo struct {
+	Version string   `json:"version"`
+	SNI     string   `json:"sni,omitempty"`
+	ALPN    []string `json:"alpn,omitempty"`
+}
+
+const quicVersion1 = 0x00000001
+
+// quicInitialSalt is the version 1 salt for Initial secrets.
+var quicInitialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+type quicDissector struct{}
+
+func (quicDissector) Name() string { return "quic" }
+
+func (quicDissector) Dissect(packet gopacket.Packet) interface{} {
+	udp, payload := udpPayload(packet, 443)
+	if udp == nil || udp.DstPort != 443 {
+		return nil
+	}
+	// Long header with the fixed bit set and packet type Initial.
+	if len(payload) < 7 || payload[0]&0xf0 != 0xc0 || binary.BigEndian.Uint32(payload[1:5]) != quicVersion1 {
+		return nil
+	}
+
+	info := &QUICInfo{Version: "1"}
+	if crypto := quicInitialCrypto(payload); crypto != nil {
+		if body := tlsHandshakeBody(crypto, tlsClientHello); body != nil {
+			hello := readClientHello(body)
+			info.SNI, info.ALPN = hello.serverName, hello.alpn
+		}
+	}
+	return info
+}
+
+// tlsHandshakeBody returns the body of a handshake message outside a TLS
+// record, as QUIC carries them, if it has type msgType.
+func tlsHandshakeBody(msg []byte, msgType byte) []byte {
+	if len(msg) < 4 || msg[0] != msgType {
+		return nil
+	}
+	n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	return msg[4:min(4+n, len(msg))]
+}
+
+// quicInitialCrypto decrypts a client Initial packet and returns the
+// CRYPTO frame data from offset 0 on, as far as it is contiguous.
+func quicInitialCrypto(p []byte) []byte {
+	r := &quicReader{b: p[5:], ok: true}
+	dcid := r.bytes(int(r.byte()))
+	r.bytes(int(r.byte()))        // source connection ID
+	r.bytes(int(r.varint()))      // token
+	length := int(r.varint())     // packet number and payload
+	pnOffset := len(p) - len(r.b) // start of the packet number
+	if !r.ok || length < 20 || pnOffset+length > len(p) {
+		return nil
+	}
+
+	key, iv, hp := quicClientKeys(dcid)
+	if key == nil {
+		return nil
+	}
+
+	// Remove header protection with a mask from a sample of the ciphertext,
+	// taken as if the packet number were four bytes long.
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, p[pnOffset+4:pnOffset+4+aes.BlockSize])
+
+	header := append([]byte(nil), p[:pnOffset+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	header = header[:pnOffset+pnLen]
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	plain, err := aead.Open(nil, nonce, p[pnOffset+pnLen:pnOffset+length], header)
+	if err != nil {
+		return nil
+	}
+	return quicCryptoFrames(plain)
+}
+
+// quicClientKeys derives the client's Initial packet protection keys.
+func quicClientKeys(dcid []byte) (key, iv, hp []byte) {
+	initial, err := hkdf.Extract(sha256.New, dcid, quicInitialSalt)
+	if err != nil {
+		return nil, nil, nil
+	}
+	client := hkdfExpandLabel(initial, "client in", sha256.Size)
+	key = hkdfExpandLabel(client, "quic key", 16)
+	iv = hkdfExpandLabel(client, "quic iv", 12)
+	hp = hkdfExpandLabel(client, "quic hp", 16)
+	if client == nil || key == nil || iv == nil || hp == nil {
+		return nil, nil, nil
+	}
+	return key, iv, hp
+}
+
+// hkdfExpandLabel is TLS 1.3's HKDF-Expand-Label with an empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	if secret == nil {
+		return nil
+	}
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out, err := hkdf.Expand(sha256.New, secret, string(info), length)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// quicCryptoFrames collects the CRYPTO frames of a decrypted Initial
+// payload, which clients may send out of order, and returns the data
+// contiguous from offset 0.
+func quicCryptoFrames(payload []byte) []byte {
+	type frame struct {
+		offset int
+		data   []byte
+	}
+	var frames []frame
+
+	r := &quicReader{b: payload, ok: true}
+loop:
+	for r.ok && len(r.b) > 0 {
+		switch typ := r.varint(); typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			r.varint() // largest acknowledged
+			r.varint() // delay
+			ranges := r.varint()
+			r.varint() // first range
+			for i := uint64(0); r.ok && i < ranges; i++ {
+				r.varint()
+				r.varint()
+			}
+			if typ == 0x03 {
+				r.varint()
+				r.varint()
+				r.varint()
+			}
+		case 0x06: // CRYPTO
+			offset := int(r.varint())
+			data := r.bytes(int(r.varint()))
+			if r.ok {
+				frames = append(frames, frame{offset, data})
+			}
+		default:
+			break loop
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool { return frames[i].offset < frames[j].offset })
+	var stream []byte
+	for _, f := range frames {
+		if f.offset > len(stream) {
+			break
+		}
+		if end := f.offset + len(f.data); end > len(stream) {
+			stream = append(stream, f.data[len(stream)-f.offset:]...)
+		}
+	}
+	return stream
+}
+
+// quicReader reads QUIC variable-length integers and byte strings,
+// turning truncation into an empty result like tlsReader.
+type quicReader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *quicReader) byte() byte {
+	if !r.ok || len(r.b) < 1 {
+		r.ok = false
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *quicReader) bytes(n int) []byte {
+	if !r.ok || n < 0 || n > len(r.b) {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *quicReader) varint() uint64 {
+	if !r.ok || len(r.b) < 1 {
+		r.ok = false
+		return 0
+	}
+	n := 1 << (r.b[0] >> 6)
+	b := r.bytes(n)
+	if b == nil {
+		return 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
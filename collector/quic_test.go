@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func FuzzQUICInitialCrypto(f *testing.F) {
+	f.Add([]byte("\xc0\x00\x00\x00\x01\x08\x83\x94\xc8\xf0\x3e\x51\x57\x08\x00\x00\x44\x9e"))
+	f.Add([]byte("\xc3\x00\x00\x00\x01\x00\x00\x00\x14\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"))
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		if len(p) < 7 {
+			return
+		}
+		if crypto := quicInitialCrypto(p); crypto != nil {
+			if body := tlsHandshakeBody(crypto, tlsClientHello); body != nil {
+				readClientHello(body)
+			}
+		}
+	})
+}
+
+func FuzzQUICCryptoFrames(f *testing.F) {
+	f.Add([]byte("\x06\x00\x04\x01\x00\x00\x00"))
+	f.Add([]byte("\x00\x01\x06\x40\x05\x02ab\x06\x00\x02cd"))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		quicCryptoFrames(payload)
+	})
+}
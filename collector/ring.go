@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Ring keeps the raw bytes of captured packets in a rolling set of pcapng
+// files so they can be retrieved for analysis after the fact. A file is
+// closed once it reaches the size or age limit for one file, and the
+// oldest files are deleted once the ring exceeds its total size or
+// packets get older than its maximum age.
+//
+// Every capture file has an index beside it (.idx) with a fixed-size
+// entry per packet holding its time, 5-tuple and position in the file, so
+// searches read the small index rather than the captures. Closed files
+// also have a .json summary of their time range and interfaces.
+//
+// Files holding packets covered by a Pin are kept past the limits until
+// the pin expires or is released.
+type Ring struct {
+	dir         string
+	fileSize    int64
+	maxSize     int64
+	maxAge      time.Duration
+	rotateEvery time.Duration
+
+	mu         sync.Mutex
+	files      []*ringFile // closed files, oldest first
+	cur        *ringFile
+	curOpened  time.Time
+	data       *os.File
+	dataBuf    *bufio.Writer
+	index      *os.File
+	indexBuf   *bufio.Writer
+	offset     int64
+	ifaceIDs   map[string]uint32
+	interfaces map[string]captureInterface
+	pins       map[string]Pin
+	scratch    []byte
+	failing    bool
+	stopped    bool
+}
+
+// ringFile describes one capture file of the ring.
+type ringFile struct {
+	Base       string             `json:"-"`
+	First      time.Time          `json:"first"`
+	Last       time.Time          `json:"last"`
+	Packets    int64              `json:"packets"`
+	Size       int64              `json:"size"`
+	Interfaces []captureInterface `json:"interfaces"`
+}
+
+func (f *ringFile) capturePath() string { return f.Base + ".pcapng" }
+func (f *ringFile) indexPath() string   { return f.Base + ".idx" }
+func (f *ringFile) summaryPath() string { return f.Base + ".json" }
+
+// indexEntry is one packet of a capture file's index. IPv4 addresses are
+// stored in their IPv6-mapped form and Protocol is 255 for packets
+// without an IP layer.
+type indexEntry struct {
+	Timestamp int64 // Unix nanoseconds
+	Offset    int64 // of the packet data in the capture file
+	CapLen    uint32
+	Length    uint32
+	Iface     uint16
+	Protocol  uint8
+	SrcIP     [16]byte
+	DstIP     [16]byte
+	SrcPort   uint16
+	DstPort   uint16
+}
+
+const indexEntrySize = 64
+
+func (e *indexEntry) encode(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint64(b, uint64(e.Timestamp))
+	b = binary.LittleEndian.AppendUint64(b, uint64(e.Offset))
+	b = appendUint32(b, e.CapLen)
+	b = appendUint32(b, e.Length)
+	b = appendUint16(b, e.Iface)
+	b = append(b, e.Protocol)
+	b = append(b, e.SrcIP[:]...)
+	b = append(b, e.DstIP[:]...)
+	b = appendUint16(b, e.SrcPort)
+	b = appendUint16(b, e.DstPort)
+	return append(b, 0) // pad to indexEntrySize
+}
+
+func decodeIndexEntry(b []byte) indexEntry {
+	var e indexEntry
+	e.Timestamp = int64(binary.LittleEndian.Uint64(b[0:]))
+	e.Offset = int64(binary.LittleEndian.Uint64(b[8:]))
+	e.CapLen = binary.LittleEndian.Uint32(b[16:])
+	e.Length = binary.LittleEndian.Uint32(b[20:])
+	e.Iface = binary.LittleEndian.Uint16(b[24:])
+	e.Protocol = b[26]
+	copy(e.SrcIP[:], b[27:43])
+	copy(e.DstIP[:], b[43:59])
+	e.SrcPort = binary.LittleEndian.Uint16(b[59:])
+	e.DstPort = binary.LittleEndian.Uint16(b[61:])
+	return e
+}
+
+// newIndexEntry fills the 5-tuple of an index entry from a decoded packet.
+func newIndexEntry(packet gopacket.Packet) indexEntry {
+	e := indexEntry{Protocol: 255}
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		copy(e.SrcIP[:], ip.SrcIP.To16())
+		copy(e.DstIP[:], ip.DstIP.To16())
+		e.Protocol = uint8(ip.Protocol)
+	case *layers.IPv6:
+		copy(e.SrcIP[:], ip.SrcIP.To16())
+		copy(e.DstIP[:], ip.DstIP.To16())
+		e.Protocol = uint8(ip.NextHeader)
+	}
+	switch t := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		e.SrcPort, e.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+		e.Protocol = uint8(layers.IPProtocolTCP)
+	case *layers.UDP:
+		e.SrcPort, e.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+		e.Protocol = uint8(layers.IPProtocolUDP)
+	case *layers.SCTP:
+		e.SrcPort, e.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+		e.Protocol = uint8(layers.IPProtocolSCTP)
+	}
+	return e
+}
+
+// OpenRing opens the ring in dir, creating it if needed. Files left by a
+// previous run are kept; one that was still being written when the
+// collector stopped has its index rebuilt.
+func OpenRing(dir string, fileSize, maxSize int64, maxAge, rotateEvery time.Duration) (*Ring, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	r := &Ring{
+		dir:         dir,
+		fileSize:    fileSize,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		rotateEvery: rotateEvery,
+		interfaces:  make(map[string]captureInterface),
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "capture-*.pcapng"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := &ringFile{Base: strings.TrimSuffix(path, ".pcapng")}
+		if data, err := os.ReadFile(f.summaryPath()); err == nil && json.Unmarshal(data, f) == nil {
+			r.files = append(r.files, f)
+			continue
+		}
+		if err := recoverCapture(f); err != nil {
+			log.Printf("[Ring] Discarding unreadable capture file %s: %v", path, err)
+			removeRingFile(f)
+			continue
+		}
+		log.Printf("[Ring] Recovered %s (%d packets)", path, f.Packets)
+		r.files = append(r.files, f)
+	}
+
+	if err := r.loadPins(time.Now()); err != nil {
+		log.Printf("[Ring] Discarding unreadable pins: %v", err)
+	}
+
+	r.mu.Lock()
+	r.enforceLimits(time.Now())
+	r.mu.Unlock()
+	return r, nil
+}
+
+// AddInterface registers an interface packets will be written for.
+func (r *Ring) AddInterface(name string, linkType layers.LinkType, snapLen int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interfaces[name] = captureInterface{Name: name, LinkType: linkType, SnapLen: snapLen}
+}
+
+// Write appends a packet captured on device to the ring.
+func (r *Ring) Write(device string, packet gopacket.Packet) {
+	ci := packet.Metadata().CaptureInfo
+	entry := newIndexEntry(packet)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	err := r.write(device, ci, packet.Data(), entry)
+	if err != nil {
+		if !r.failing {
+			log.Printf("[Ring] Error writing packet: %v", err)
+		}
+		r.failing = true
+		r.closeCurrent()
+		return
+	}
+	r.failing = false
+}
+
+func (r *Ring) write(device string, ci gopacket.CaptureInfo, data []byte, entry indexEntry) error {
+	now := time.Now()
+	if r.cur != nil && (r.offset >= r.fileSize || now.Sub(r.curOpened) >= r.rotateEvery) {
+		r.closeCurrent()
+		r.enforceLimits(now)
+	}
+	if r.cur == nil {
+		if err := r.openCurrent(now); err != nil {
+			return err
+		}
+	}
+
+	b := r.scratch[:0]
+	id, ok := r.ifaceIDs[device]
+	if !ok {
+		iface, registered := r.interfaces[device]
+		if !registered {
+			iface = captureInterface{Name: device, LinkType: layers.LinkTypeEthernet, SnapLen: len(data)}
+		}
+		id = uint32(len(r.cur.Interfaces))
+		r.ifaceIDs[device] = id
+		r.cur.Interfaces = append(r.cur.Interfaces, iface)
+		b = appendInterface(b, iface)
+	}
+
+	entry.Timestamp = ci.Timestamp.UnixNano()
+	entry.Offset = r.offset + int64(len(b)) + epbHeaderSize
+	entry.CapLen = uint32(len(data))
+	entry.Length = uint32(ci.Length)
+	entry.Iface = uint16(id)
+	b = appendPacket(b, id, ci.Timestamp, ci.Length, data)
+	r.scratch = b
+
+	if _, err := r.dataBuf.Write(b); err != nil {
+		return err
+	}
+	if _, err := r.indexBuf.Write(entry.encode(nil)); err != nil {
+		return err
+	}
+	r.offset += int64(len(b))
+
+	if r.cur.Packets == 0 || ci.Timestamp.Before(r.cur.First) {
+		r.cur.First = ci.Timestamp
+	}
+	if ci.Timestamp.After(r.cur.Last) {
+		r.cur.Last = ci.Timestamp
+	}
+	r.cur.Packets++
+	return nil
+}
+
+func (r *Ring) openCurrent(now time.Time) error {
+	f := &ringFile{Base: filepath.Join(r.dir, "capture-"+now.UTC().Format("20060102T150405.000000000Z"))}
+	data, err := os.OpenFile(f.capturePath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(f.indexPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		data.Close()
+		os.Remove(f.capturePath())
+		return err
+	}
+
+	r.cur, r.curOpened = f, now
+	r.data, r.dataBuf = data, bufio.NewWriterSize(data, 1<<20)
+	r.index, r.indexBuf = index, bufio.NewWriterSize(index, 64<<10)
+	r.ifaceIDs = make(map[string]uint32)
+
+	header := appendSectionHeader(nil)
+	if _, err := r.dataBuf.Write(header); err != nil {
+		r.closeCurrent()
+		return err
+	}
+	r.offset = int64(len(header))
+	return nil
+}
+
+// closeCurrent finishes the file being written, if any. An empty file is
+// removed.
+func (r *Ring) closeCurrent() {
+	if r.cur == nil {
+		return
+	}
+	f := r.cur
+	err := r.flush()
+	if closeErr := r.data.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := r.index.Close(); err == nil {
+		err = closeErr
+	}
+	r.cur, r.data, r.dataBuf, r.index, r.indexBuf = nil, nil, nil, nil, nil
+
+	if f.Packets == 0 {
+		removeRingFile(f)
+		return
+	}
+	if err != nil {
+		log.Printf("[Ring] Error closing %s: %v", f.capturePath(), err)
+	}
+	f.Size = r.offset + f.Packets*indexEntrySize
+	if data, err := json.Marshal(f); err == nil {
+		err = os.WriteFile(f.summaryPath(), data, 0o640)
+		if err != nil {
+			log.Printf("[Ring] Error writing summary of %s: %v", f.capturePath(), err)
+		}
+	}
+	r.files = append(r.files, f)
+}
+
+// flush writes buffered packets and index entries of the current file,
+// packets first so the index never points past the capture.
+func (r *Ring) flush() error {
+	if r.cur == nil {
+		return nil
+	}
+	if err := r.dataBuf.Flush(); err != nil {
+		return err
+	}
+	return r.indexBuf.Flush()
+}
+
+// enforceLimits deletes the oldest files while the ring is over its size
+// limit and files whose newest packet is older than the maximum age,
+// skipping pinned files.
+func (r *Ring) enforceLimits(now time.Time) {
+	r.expirePins(now)
+
+	total := int64(0)
+	if r.cur != nil {
+		total = r.offset + r.cur.Packets*indexEntrySize
+	}
+	for _, f := range r.files {
+		total += f.Size
+	}
+
+	kept := r.files[:0]
+	for _, f := range r.files {
+		expired := total > r.maxSize || (r.maxAge > 0 && now.Sub(f.Last) > r.maxAge)
+		if expired && !r.pinned(f) {
+			removeRingFile(f)
+			total -= f.Size
+			continue
+		}
+		kept = append(kept, f)
+	}
+	r.files = kept
+}
+
+func removeRingFile(f *ringFile) {
+	for _, path := range []string{f.capturePath(), f.indexPath(), f.summaryPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Ring] Error removing %s: %v", path, err)
+		}
+	}
+}
+
+// Run periodically flushes buffered packets, rotates files that have been
+// open longer than the rotation interval and applies the age limit. When
+// ctx is cancelled it closes the current file and stops accepting packets.
+func (r *Ring) Run(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.closeCurrent()
+			r.stopped = true
+			r.mu.Unlock()
+			return
+		case now := <-ticker.C:
+			r.mu.Lock()
+			if r.cur != nil && now.Sub(r.curOpened) >= r.rotateEvery {
+				r.closeCurrent()
+			} else if err := r.flush(); err != nil {
+				log.Printf("[Ring] Error flushing capture: %v", err)
+			}
+			r.enforceLimits(now)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// ringSnapshot is a consistent view of the ring's files for a search:
+// each file with the number of index entries written when it was taken.
+type ringSnapshot struct {
+	files   []*ringFile
+	entries []int64
+}
+
+func (r *Ring) snapshot() (ringSnapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var s ringSnapshot
+	for _, f := range r.files {
+		s.files = append(s.files, f)
+		s.entries = append(s.entries, f.Packets)
+	}
+	if r.cur != nil {
+		if err := r.flush(); err != nil {
+			return s, err
+		}
+		cur := *r.cur
+		cur.Interfaces = append([]captureInterface(nil), r.cur.Interfaces...)
+		s.files = append(s.files, &cur)
+		s.entries = append(s.entries, cur.Packets)
+	}
+	return s, nil
+}
+
+// RingStatus summarises the packets the ring holds.
+type RingStatus struct {
+	Files   int       `json:"files"`
+	Packets int64     `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+	MaxSize int64     `json:"max_bytes"`
+	Pins    int       `json:"pins"`
+	Pinned  int64     `json:"pinned_bytes"`
+	Oldest  time.Time `json:"oldest,omitempty"`
+	Newest  time.Time `json:"newest,omitempty"`
+}
+
+func (r *Ring) Status() RingStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := RingStatus{MaxSize: r.maxSize, Pins: len(r.pins)}
+	files := r.files
+	if r.cur != nil {
+		cur := *r.cur
+		cur.Size = r.offset + cur.Packets*indexEntrySize
+		files = append(files[:len(files):len(files)], &cur)
+	}
+	for _, f := range files {
+		s.Files++
+		s.Packets += f.Packets
+		s.Bytes += f.Size
+		if r.pinned(f) {
+			s.Pinned += f.Size
+		}
+		if s.Oldest.IsZero() || f.First.Before(s.Oldest) {
+			s.Oldest = f.First
+		}
+		if f.Last.After(s.Newest) {
+			s.Newest = f.Last
+		}
+	}
+	return s
+}
+
+// recoverCapture rebuilds the index and summary of a capture file that
+// was not closed cleanly, dropping a partly written last block.
+func recoverCapture(f *ringFile) error {
+	in, err := os.OpenFile(f.capturePath(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	index, err := os.Create(f.indexPath())
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	indexBuf := bufio.NewWriter(index)
+
+	rd := bufio.NewReaderSize(in, 1<<20)
+	var offset int64
+	header := make([]byte, 8)
+	var body []byte
+	for {
+		if _, err := io.ReadFull(rd, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			if err := in.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		blockType := binary.LittleEndian.Uint32(header)
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		if length < 12 || length%4 != 0 || length > 1<<26 {
+			if err := in.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if cap(body) < int(length-8) {
+			body = make([]byte, length-8)
+		}
+		body = body[:length-8]
+		if _, err := io.ReadFull(rd, body); err != nil {
+			if err := in.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		switch blockType {
+		case blockSectionHeader:
+			if offset != 0 || binary.LittleEndian.Uint32(body) != byteOrderMagic {
+				return errors.New("not a little-endian pcapng file written by the ring")
+			}
+		case blockInterface:
+			iface := captureInterface{
+				LinkType: layers.LinkType(binary.LittleEndian.Uint16(body)),
+				SnapLen:  int(binary.LittleEndian.Uint32(body[4:])),
+			}
+			opts := body[8 : len(body)-blockTrailSize]
+			for len(opts) >= 4 {
+				code := binary.LittleEndian.Uint16(opts)
+				n := int(binary.LittleEndian.Uint16(opts[2:]))
+				if code == optionEnd || 4+n > len(opts) {
+					break
+				}
+				if code == optionIfName {
+					iface.Name = string(opts[4 : 4+n])
+				}
+				opts = opts[4+n+pad4(n):]
+			}
+			f.Interfaces = append(f.Interfaces, iface)
+		case blockEnhancedPacket:
+			id := binary.LittleEndian.Uint32(body)
+			if int(id) >= len(f.Interfaces) {
+				return fmt.Errorf("packet at offset %d references unknown interface %d", offset, id)
+			}
+			nanos := int64(binary.LittleEndian.Uint32(body[4:]))<<32 | int64(binary.LittleEndian.Uint32(body[8:]))
+			capLen := binary.LittleEndian.Uint32(body[12:])
+			if int(capLen) > len(body)-20-blockTrailSize {
+				return fmt.Errorf("packet at offset %d is longer than its block", offset)
+			}
+			data := body[20 : 20+int(capLen)]
+
+			packet := gopacket.NewPacket(data, f.Interfaces[id].LinkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			entry := newIndexEntry(packet)
+			entry.Timestamp = nanos
+			entry.Offset = offset + epbHeaderSize
+			entry.CapLen = capLen
+			entry.Length = binary.LittleEndian.Uint32(body[16:])
+			entry.Iface = uint16(id)
+			if _, err := indexBuf.Write(entry.encode(nil)); err != nil {
+				return err
+			}
+
+			ts := time.Unix(0, nanos)
+			if f.Packets == 0 || ts.Before(f.First) {
+				f.First = ts
+			}
+			if ts.After(f.Last) {
+				f.Last = ts
+			}
+			f.Packets++
+		}
+		offset += length
+	}
+
+	if f.Packets == 0 {
+		return errors.New("no packets")
+	}
+	if err := indexBuf.Flush(); err != nil {
+		return err
+	}
+	f.Size = offset + f.Packets*indexEntrySize
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.summaryPath(), data, 0o640)
+}
+
+// ipKey converts an address to the form stored in the index.
+func ipKey(ip net.IP) (key [16]byte) {
+	copy(key[:], ip.To16())
+	return key
+}
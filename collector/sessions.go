@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// maxInspectBytes is how much of each direction of a session is kept
+	// for the application parsers.
+	maxInspectBytes = 16 << 10
+	// Out-of-order data the assembler may hold, in pages of about 2KB.
+	maxBufferedPagesPerSession = 256
+	maxBufferedPagesTotal      = 16384
+)
+
+// TCPSession describes one TCP connection. It is published on the session
+// topic once the connection closes or goes idle.
+type TCPSession struct {
+	Sensor     string    `json:"sensor,omitempty"`
+	DeviceName string    `json:"device_name"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	DurationMs int64     `json:"duration_ms"`
+
+	// The client is the side that sent the SYN. For connections picked up
+	// mid-stream it is guessed from the ports.
+	ClientIP   string `json:"client_ip"`
+	ServerIP   string `json:"server_ip"`
+	ClientPort uint16 `json:"client_port"`
+	ServerPort uint16 `json:"server_port"`
+
+	// Handshake is complete (SYN, SYN-ACK, ACK), incomplete (no answer
+	// or no final ACK), rejected (SYN answered with RST) or midstream (no
+	// SYN seen). RTTMs is the time from SYN to the final ACK.
+	Handshake string  `json:"handshake"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+
+	// Bytes are reassembled payload bytes, without retransmissions.
+	ClientBytes     int64 `json:"client_bytes"`
+	ServerBytes     int64 `json:"server_bytes"`
+	ClientPackets   int64 `json:"client_packets"`
+	ServerPackets   int64 `json:"server_packets"`
+	Retransmissions int   `json:"retransmissions"`
+	OutOfOrder      int   `json:"out_of_order"`
+	// MissingBytes were never seen, e.g. dropped by the capture.
+	MissingBytes int64 `json:"missing_bytes"`
+
+	// CloseReason is fin, reset, timeout (idle for -session-idle) or
+	// shutdown (the capture stopped).
+	CloseReason string `json:"close_reason"`
+
+	// Application details found by the stream parsers.
+	AppProtocol   string   `json:"app_protocol,omitempty"`
+	HTTPMethod    string   `json:"http_method,omitempty"`
+	HTTPHost      string   `json:"http_host,omitempty"`
+	HTTPPath      string   `json:"http_path,omitempty"`
+	HTTPUserAgent string   `json:"http_user_agent,omitempty"`
+	HTTPStatus    int      `json:"http_status,omitempty"`
+	TLSVersion    string   `json:"tls_version,omitempty"`
+	TLSSNI        string   `json:"tls_sni,omitempty"`
+	TLSALPN       []string `json:"tls_alpn,omitempty"`
+	TLSJA3        string   `json:"tls_ja3,omitempty"`
+	SMTPHelo      string   `json:"smtp_helo,omitempty"`
+	SMTPMailFrom  string   `json:"smtp_mail_from,omitempty"`
+	SMTPRcptTo    []string `json:"smtp_rcpt_to,omitempty"`
+	SMTPStartTLS  bool     `json:"smtp_starttls,omitempty"`
+}
+
+// captureContext passes a packet's capture info through the assembler.
+type captureContext gopacket.CaptureInfo
+
+func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// SessionTracker reassembles the TCP connections seen on one interface
+// and publishes a TCPSession for each. It is not safe for concurrent use;
+// each capture owns one.
+type SessionTracker struct {
+	device    string
+	writer    *kafka.Writer
+	assembler *reassembly.Assembler
+	stopping  bool
+}
+
+func NewSessionTracker(device string, writer *kafka.Writer) *SessionTracker {
+	t := &SessionTracker{device: device, writer: writer}
+	t.assembler = reassembly.NewAssembler(reassembly.NewStreamPool(t))
+	t.assembler.MaxBufferedPagesPerConnection = maxBufferedPagesPerSession
+	t.assembler.MaxBufferedPagesTotal = maxBufferedPagesTotal
+	return t
+}
+
+// Add feeds a captured packet to the assembler; non-TCP packets are
+// ignored.
+func (t *SessionTracker) Add(packet gopacket.Packet) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || packet.NetworkLayer() == nil {
+		return
+	}
+	ctx := captureContext(packet.Metadata().CaptureInfo)
+	t.assembler.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, &ctx)
+}
+
+// Flush ends sessions without packets since -session-idle before now.
+func (t *SessionTracker) Flush(now time.Time) {
+	t.assembler.FlushCloseOlderThan(now.Add(-*sessionIdle))
+}
+
+// Close ends all open sessions.
+func (t *SessionTracker) Close() {
+	t.stopping = true
+	t.assembler.FlushAll()
+}
+
+// New implements reassembly.StreamFactory.
+func (t *SessionTracker) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	s := &tcpStream{tracker: t}
+	src, dst := netFlow.Endpoints()
+	sport, dport := uint16(tcp.SrcPort), uint16(tcp.DstPort)
+
+	// The first packet normally comes from the client. A SYN-ACK first
+	// means the SYN was missed; mid-stream, a well-known source port
+	// sending to an ephemeral one is most likely the server.
+	s.reversed = (tcp.SYN && tcp.ACK) || (!tcp.SYN && sport < 1024 && dport >= 1024)
+	s.record = TCPSession{
+		Sensor:     *sensorName,
+		DeviceName: t.device,
+		ClientIP:   src.String(),
+		ServerIP:   dst.String(),
+		ClientPort: sport,
+		ServerPort: dport,
+	}
+	if s.reversed {
+		s.record.ClientIP, s.record.ServerIP = s.record.ServerIP, s.record.ClientIP
+		s.record.ClientPort, s.record.ServerPort = s.record.ServerPort, s.record.ClientPort
+	}
+	return s
+}
+
+// tcpStream follows one connection through the assembler.
+type tcpStream struct {
+	tracker  *SessionTracker
+	record   TCPSession
+	reversed bool
+
+	synAt, synAckAt, ackAt time.Time
+	rejected               bool
+	fin                    [2]bool
+	rst                    bool
+	data                   [2][]byte
+}
+
+// side maps an assembler direction, relative to the first packet, to 0
+// for the client and 1 for the server.
+func (s *tcpStream) side(dir reassembly.TCPFlowDirection) int {
+	if (dir == reassembly.TCPDirClientToServer) != s.reversed {
+		return 0
+	}
+	return 1
+}
+
+func (s *tcpStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	side := s.side(dir)
+	if s.record.StartTime.IsZero() || ci.Timestamp.Before(s.record.StartTime) {
+		s.record.StartTime = ci.Timestamp
+	}
+	if ci.Timestamp.After(s.record.EndTime) {
+		s.record.EndTime = ci.Timestamp
+	}
+	if side == 0 {
+		s.record.ClientPackets++
+	} else {
+		s.record.ServerPackets++
+	}
+
+	switch {
+	case tcp.SYN && !tcp.ACK && side == 0:
+		if s.synAt.IsZero() {
+			s.synAt = ci.Timestamp
+		}
+	case tcp.SYN && tcp.ACK && side == 1:
+		if s.synAckAt.IsZero() {
+			s.synAckAt = ci.Timestamp
+		}
+	case tcp.ACK && side == 0 && !s.synAckAt.IsZero() && s.ackAt.IsZero():
+		s.ackAt = ci.Timestamp
+	}
+	if tcp.RST {
+		s.rst = true
+		if side == 1 && !s.synAt.IsZero() && s.synAckAt.IsZero() {
+			s.rejected = true
+		}
+	}
+	if tcp.FIN {
+		s.fin[side] = true
+	}
+
+	if nextSeq < 0 {
+		// Pick up connections that started before the capture.
+		if !tcp.SYN {
+			*start = true
+		}
+	} else if len(tcp.Payload) > 0 {
+		diff := nextSeq.Difference(reassembly.Sequence(tcp.Seq))
+		if diff < 0 {
+			s.record.Retransmissions++
+		} else if diff > 0 {
+			s.record.OutOfOrder++
+		}
+	}
+	return true
+}
+
+func (s *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	side := s.side(dir)
+	length, _ := sg.Lengths()
+	if skip > 0 {
+		s.record.MissingBytes += int64(skip)
+	}
+	if side == 0 {
+		s.record.ClientBytes += int64(length)
+	} else {
+		s.record.ServerBytes += int64(length)
+	}
+
+	// Parsers only look at the start of each direction, and only if it
+	// arrived without gaps.
+	if skip == 0 || len(s.data[side]) == 0 {
+		if room := maxInspectBytes - len(s.data[side]); room > 0 && length > 0 {
+			s.data[side] = append(s.data[side], sg.Fetch(min(length, room))...)
+		}
+	}
+}
+
+func (s *tcpStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	r := &s.record
+	r.DurationMs = r.EndTime.Sub(r.StartTime).Milliseconds()
+
+	switch {
+	case s.synAt.IsZero():
+		r.Handshake = "midstream"
+	case s.rejected:
+		r.Handshake = "rejected"
+	case s.ackAt.IsZero():
+		r.Handshake = "incomplete"
+	default:
+		r.Handshake = "complete"
+		r.RTTMs = float64(s.ackAt.Sub(s.synAt).Microseconds()) / 1000
+	}
+
+	switch {
+	case s.rst:
+		r.CloseReason = "reset"
+	case s.fin[0] || s.fin[1]:
+		r.CloseReason = "fin"
+	case s.tracker.stopping:
+		r.CloseReason = "shutdown"
+	default:
+		r.CloseReason = "timeout"
+	}
+
+	parseApplication(r, s.data[0], s.data[1])
+	s.tracker.publish(r)
+
+	// Keep the connection until it goes idle, so the final ACK of a FIN
+	// exchange does not start a new session.
+	return false
+}
+
+func (t *SessionTracker) publish(r *TCPSession) {
+	value, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("[Sessions] JSON marshaling failed: %v", err)
+		return
+	}
+	err = t.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(r.ClientIP),
+		Value: value,
+	})
+	if err != nil {
+		log.Printf("[Sessions] Failed to send session to Kafka: %v", err)
+	}
+}
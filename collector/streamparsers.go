@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseApplication identifies the application protocol of a session from
+// the start of its client and server streams and fills in what the
+// matching parser finds.
+func parseApplication(r *TCPSession, client, server []byte) {
+	switch {
+	case isHTTPRequest(client):
+		r.AppProtocol = "http"
+		parseHTTP(r, client, server)
+	case isTLSHandshake(client):
+		r.AppProtocol = "tls"
+		parseTLS(r, client, server)
+	case isSMTP(client, server):
+		r.AppProtocol = "smtp"
+		parseSMTP(r, client, server)
+	}
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+func isHTTPRequest(client []byte) bool {
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(client, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTP records the first request of the session and the status of
+// its response.
+func parseHTTP(r *TCPSession, client, server []byte) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(client)))
+	if err != nil {
+		return
+	}
+	r.HTTPMethod = req.Method
+	r.HTTPHost = req.Host
+	r.HTTPPath = req.URL.RequestURI()
+	r.HTTPUserAgent = req.UserAgent()
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(server)), req)
+	if err == nil {
+		r.HTTPStatus = resp.StatusCode
+	}
+}
+
+// TLS record and handshake constants.
+const (
+	tlsRecordHandshake      = 22
+	tlsClientHello          = 1
+	tlsServerHello          = 2
+	tlsExtServerName        = 0
+	tlsExtSupportedGroups   = 10
+	tlsExtECPointFormats    = 11
+	tlsExtALPN              = 16
+	tlsExtSupportedVersions = 43
+)
+
+func isTLSHandshake(client []byte) bool {
+	return len(client) >= 6 && client[0] == tlsRecordHandshake && client[1] == 3 && client[5] == tlsClientHello
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// tlsHandshakeMessage returns the body of the first handshake message in
+// stream if it has type msgType. Only the first record is read, which
+// holds the hello in practice.
+func tlsHandshakeMessage(stream []byte, msgType byte) []byte {
+	if len(stream) < 9 || stream[0] != tlsRecordHandshake {
+		return nil
+	}
+	record := stream[5:]
+	if n := int(binary.BigEndian.Uint16(stream[3:5])); n < len(record) {
+		record = record[:n]
+	}
+	if len(record) < 4 || record[0] != msgType {
+		return nil
+	}
+	n := int(record[1])<<16 | int(record[2])<<8 | int(record[3])
+	body := record[4:]
+	if n < len(body) {
+		body = body[:n]
+	}
+	return body
+}
+
+// tlsReader reads the length-prefixed fields of a hello message, turning
+// truncation into an empty result instead of a panic.
+type tlsReader struct {
+	b  []byte
+	ok bool
+}
+
+func (t *tlsReader) skip(n int) {
+	if !t.ok || n > len(t.b) {
+		t.ok = false
+		return
+	}
+	t.b = t.b[n:]
+}
+
+func (t *tlsReader) uint16() uint16 {
+	if !t.ok || len(t.b) < 2 {
+		t.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint16(t.b)
+	t.b = t.b[2:]
+	return v
+}
+
+func (t *tlsReader) vector(lenBytes int) []byte {
+	if !t.ok || len(t.b) < lenBytes {
+		t.ok = false
+		return nil
+	}
+	n := 0
+	for _, c := range t.b[:lenBytes] {
+		n = n<<8 | int(c)
+	}
+	t.b = t.b[lenBytes:]
+	if n > len(t.b) {
+		t.ok = false
+		return nil
+	}
+	v := t.b[:n]
+	t.b = t.b[n:]
+	return v
+}
+
+// tlsExtensions calls fn for every extension left in t. A truncated
+// extension block is read as far as it goes.
+func tlsExtensions(t *tlsReader, fn func(typ uint16, data []byte)) {
+	n := int(t.uint16())
+	if !t.ok {
+		return
+	}
+	exts := &tlsReader{b: t.b[:min(n, len(t.b))], ok: true}
+	for exts.ok && len(exts.b) >= 4 {
+		typ := exts.uint16()
+		data := exts.vector(2)
+		if exts.ok {
+			fn(typ, data)
+		}
+	}
+}
+
+// clientHello is what the parsers read from a ClientHello.
+type clientHello struct {
+	version    uint16
+	serverName string
+	alpn       []string
+	// ja3 is the MD5 of the client's JA3 fingerprint, or empty when the
+	// message is truncated before the end of its extensions.
+	ja3 string
+}
+
+// readClientHello reads the body of a ClientHello handshake message.
+func readClientHello(hello []byte) clientHello {
+	var h clientHello
+	t := &tlsReader{b: hello, ok: true}
+	h.version = t.uint16()
+	t.skip(32)  // random
+	t.vector(1) // session id
+	ciphers := t.vector(2)
+	t.vector(1) // compression methods
+	complete := t.ok && len(t.b) >= 2 && int(binary.BigEndian.Uint16(t.b)) <= len(t.b)-2
+
+	var extensions, groups []uint16
+	var pointFormats []byte
+	tlsExtensions(t, func(typ uint16, data []byte) {
+		extensions = append(extensions, typ)
+		d := &tlsReader{b: data, ok: true}
+		switch typ {
+		case tlsExtServerName:
+			names := &tlsReader{b: d.vector(2), ok: d.ok}
+			for names.ok && len(names.b) > 0 {
+				nameType := names.b[0]
+				names.skip(1)
+				name := names.vector(2)
+				if names.ok && nameType == 0 {
+					h.serverName = string(name)
+				}
+			}
+		case tlsExtALPN:
+			protos := &tlsReader{b: d.vector(2), ok: d.ok}
+			for protos.ok && len(protos.b) > 0 {
+				if p := protos.vector(1); protos.ok {
+					h.alpn = append(h.alpn, string(p))
+				}
+			}
+		case tlsExtSupportedGroups:
+			list := d.vector(2)
+			for i := 0; i+1 < len(list); i += 2 {
+				groups = append(groups, binary.BigEndian.Uint16(list[i:]))
+			}
+		case tlsExtECPointFormats:
+			pointFormats = d.vector(1)
+		}
+	})
+
+	if complete {
+		var suites []uint16
+		for i := 0; i+1 < len(ciphers); i += 2 {
+			suites = append(suites, binary.BigEndian.Uint16(ciphers[i:]))
+		}
+		formats := make([]uint16, len(pointFormats))
+		for i, f := range pointFormats {
+			formats[i] = uint16(f)
+		}
+		fingerprint := fmt.Sprintf("%d,%s,%s,%s,%s", h.version,
+			ja3List(suites), ja3List(extensions), ja3List(groups), ja3List(formats))
+		sum := md5.Sum([]byte(fingerprint))
+		h.ja3 = hex.EncodeToString(sum[:])
+	}
+	return h
+}
+
+// ja3List joins values with dashes as JA3 does, leaving out the GREASE
+// values (RFC 8701) clients add at random.
+func ja3List(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if v&0x0f0f == 0x0a0a && v>>8 == v&0xff {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(int(v)))
+	}
+	return strings.Join(parts, "-")
+}
+
+// parseTLS records the server name, ALPN protocols and JA3 fingerprint of
+// the ClientHello and the version the ServerHello picked.
+func parseTLS(r *TCPSession, client, server []byte) {
+	if body := tlsHandshakeMessage(client, tlsClientHello); body != nil {
+		hello := readClientHello(body)
+		r.TLSVersion = tlsVersionName(hello.version)
+		r.TLSSNI = hello.serverName
+		r.TLSALPN = hello.alpn
+		r.TLSJA3 = hello.ja3
+	}
+
+	if hello := tlsHandshakeMessage(server, tlsServerHello); hello != nil {
+		t := &tlsReader{b: hello, ok: true}
+		version := t.uint16()
+		t.skip(32)  // random
+		t.vector(1) // session id
+		t.skip(3)   // cipher suite and compression method
+		tlsExtensions(t, func(typ uint16, data []byte) {
+			if typ == tlsExtSupportedVersions && len(data) == 2 {
+				version = binary.BigEndian.Uint16(data)
+			}
+		})
+		if version != 0 {
+			r.TLSVersion = tlsVersionName(version)
+		}
+	}
+}
+
+func isSMTP(client, server []byte) bool {
+	if !bytes.HasPrefix(server, []byte("220")) {
+		return false
+	}
+	verb := strings.ToUpper(string(client[:min(len(client), 5)]))
+	return verb == "EHLO " || verb == "HELO "
+}
+
+// parseSMTP records the greeting, envelope and whether the client
+// switched to TLS, after which the rest of the stream is unreadable.
+func parseSMTP(r *TCPSession, client, server []byte) {
+	for _, line := range strings.Split(string(client), "\r\n") {
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if r.SMTPHelo == "" {
+				r.SMTPHelo = arg
+			}
+		case "MAIL":
+			if r.SMTPMailFrom == "" {
+				r.SMTPMailFrom = smtpAddress(arg, "FROM:")
+			}
+		case "RCPT":
+			if addr := smtpAddress(arg, "TO:"); addr != "" {
+				r.SMTPRcptTo = append(r.SMTPRcptTo, addr)
+			}
+		case "STARTTLS":
+			r.SMTPStartTLS = true
+			return
+		}
+	}
+}
+
+// smtpAddress extracts the address from a MAIL FROM or RCPT TO argument,
+// e.g. "FROM:<a@example.com> SIZE=100".
+func smtpAddress(arg, prefix string) string {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return ""
+	}
+	addr := strings.TrimSpace(arg[len(prefix):])
+	if i := strings.IndexByte(addr, '>'); strings.HasPrefix(addr, "<") && i > 0 {
+		return addr[1:i]
+	}
+	addr, _, _ = strings.Cut(addr, " ")
+	return addr
+}
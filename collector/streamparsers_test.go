@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func FuzzParseApplication(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\n\r\n"))
+	f.Add([]byte("\x16\x03\x01\x00\x30\x01\x00\x00\x2c\x03\x03"), []byte("\x16\x03\x03\x00\x04\x02\x00\x00\x00"))
+	f.Add([]byte("EHLO mail\r\nMAIL FROM:<a@b>\r\nRCPT TO:<c@d>\r\nSTARTTLS\r\n"), []byte("220 mx ESMTP\r\n"))
+
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		var r TCPSession
+		parseApplication(&r, client, server)
+	})
+}
+
+func FuzzReadClientHello(f *testing.F) {
+	f.Add([]byte("\x03\x03" + string(make([]byte, 32)) + "\x00\x00\x02\x13\x01\x01\x00\x00\x0a\x00\x00\x00\x06\x00\x04\x00\x00\x01a"))
+
+	f.Fuzz(func(t *testing.T, hello []byte) {
+		readClientHello(hello)
+	})
+}
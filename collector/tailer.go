@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	tailPollInterval       = 500 * time.Millisecond
+	tailCheckpointInterval = 5 * time.Second
+	tailReadChunk          = 64 * 1024
+	tailMaxLineLength      = 1 << 20
+	tailBatchTimeout       = 10 * time.Millisecond
+
+	// tailDrainLimit bounds how much of one file a pass reads before the
+	// tailer moves on to the others.
+	tailDrainLimit = 1 << 20
+)
+
+type LogData struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Source    string            `json:"source"`
+	LogLevel  string            `json:"log_level"`
+	Message   string            `json:"message"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// tailSpec is one entry of the -tail flag: a file path or glob pattern and
+// the parser used for lines read from matching files.
+type tailSpec struct {
+	pattern string
+	parser  LogParser
+}
+
+// parseTailSpecs parses "pattern[=parser],pattern[=parser],...".
+func parseTailSpecs(value string) ([]tailSpec, error) {
+	var specs []tailSpec
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pattern, parserName := item, ""
+		if i := strings.LastIndexByte(item, '='); i >= 0 {
+			pattern, parserName = item[:i], item[i+1:]
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tail pattern %q: %w", pattern, err)
+		}
+
+		parser, err := lookupLogParser(parserName)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, tailSpec{pattern: pattern, parser: parser})
+	}
+	return specs, nil
+}
+
+type tailedFile struct {
+	path    string
+	parser  LogParser
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial []byte
+	// pending are messages for the lines up to offset that Kafka has not
+	// accepted yet. Nothing more is read from the file until they are
+	// sent.
+	pending []kafka.Message
+}
+
+// Tailer follows a set of log files, re-expanding glob patterns on every
+// poll so that newly created files are picked up, and handles rotation by
+// rename (new inode at the same path) as well as by truncation.
+type Tailer struct {
+	specs      []tailSpec
+	writer     *kafka.Writer
+	checkpoint *Checkpoint
+	hostname   string
+	fromStart  bool
+	files      map[string]*tailedFile
+}
+
+func NewTailer(specs []tailSpec, writer *kafka.Writer, checkpoint *Checkpoint, fromStart bool) *Tailer {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &Tailer{
+		specs:      specs,
+		writer:     writer,
+		checkpoint: checkpoint,
+		hostname:   hostname,
+		fromStart:  fromStart,
+		files:      make(map[string]*tailedFile),
+	}
+}
+
+func (t *Tailer) Run(ctx context.Context) {
+	pollTicker := time.NewTicker(tailPollInterval)
+	defer pollTicker.Stop()
+	saveTicker := time.NewTicker(tailCheckpointInterval)
+	defer saveTicker.Stop()
+
+	// Files that already exist when the collector starts and have no
+	// checkpoint are read from the end unless -tail-from-start is set;
+	// anything discovered later is new and read from the beginning.
+	t.discover(!t.fromStart)
+
+	// While a file is behind, passes run back to back instead of waiting
+	// for the poll ticker. The closed channel competes fairly with ctx
+	// and the checkpoint ticker in select.
+	ready := make(chan struct{})
+	close(ready)
+	var again <-chan struct{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for _, tf := range t.files {
+				tf.file.Close()
+			}
+			if err := t.checkpoint.Save(); err != nil {
+				log.Printf("Error saving tail checkpoint: %v", err)
+			}
+			return
+		case <-saveTicker.C:
+			if err := t.checkpoint.Save(); err != nil {
+				log.Printf("Error saving tail checkpoint: %v", err)
+			}
+		case <-pollTicker.C:
+			t.discover(false)
+			again = t.followAll(ctx, ready)
+		case <-again:
+			again = t.followAll(ctx, ready)
+		}
+	}
+}
+
+// followAll makes one pass over the tailed files. It returns ready if any
+// file has more to read, otherwise nil.
+func (t *Tailer) followAll(ctx context.Context, ready <-chan struct{}) <-chan struct{} {
+	var again <-chan struct{}
+	for _, tf := range t.files {
+		if t.follow(ctx, tf) {
+			again = ready
+		}
+	}
+	return again
+}
+
+func (t *Tailer) discover(seekEnd bool) {
+	for _, spec := range t.specs {
+		matches, err := filepath.Glob(spec.pattern)
+		if err != nil {
+			log.Printf("Error expanding tail pattern %s: %v", spec.pattern, err)
+			continue
+		}
+
+		for _, path := range matches {
+			if _, ok := t.files[path]; ok {
+				continue
+			}
+
+			tf, err := t.open(path, spec.parser, seekEnd)
+			if err != nil {
+				log.Printf("Error opening %s for tailing: %v", path, err)
+				continue
+			}
+			t.files[path] = tf
+			log.Printf("Tailing %s (parser %s, offset %d)", path, spec.parser.Name(), tf.offset)
+		}
+	}
+}
+
+func (t *Tailer) open(path string, parser LogParser, seekEnd bool) (*tailedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	inode := fileInode(fi)
+	offset, ok := t.checkpoint.Lookup(path, inode)
+	switch {
+	case ok && offset <= fi.Size():
+	case seekEnd:
+		offset = fi.Size()
+	default:
+		offset = 0
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t.checkpoint.Set(path, inode, offset)
+
+	return &tailedFile{
+		path:   path,
+		parser: parser,
+		file:   f,
+		inode:  inode,
+		offset: offset,
+	}, nil
+}
+
+// follow drains up to tailDrainLimit bytes from tf and, once it has read
+// everything, checks whether the path has been rotated or truncated
+// underneath it. It returns true if tf has more to read.
+func (t *Tailer) follow(ctx context.Context, tf *tailedFile) bool {
+	if t.drain(ctx, tf) {
+		return true
+	}
+	if len(tf.pending) > 0 {
+		// Keep the file until its lines are sent, rotated or not.
+		return false
+	}
+
+	fi, err := os.Stat(tf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Rotated away with nothing created in its place yet. Keep the
+			// old descriptor until a new file appears so late writes by the
+			// old writer are not lost.
+			return false
+		}
+		log.Printf("Error stating %s: %v", tf.path, err)
+		return false
+	}
+
+	if inode := fileInode(fi); inode != tf.inode {
+		log.Printf("Detected rotation of %s", tf.path)
+		tf.file.Close()
+		delete(t.files, tf.path)
+
+		newTF, err := t.open(tf.path, tf.parser, false)
+		if err != nil {
+			log.Printf("Error reopening %s after rotation: %v", tf.path, err)
+			t.checkpoint.Delete(tf.path)
+			return false
+		}
+		t.files[tf.path] = newTF
+		return t.drain(ctx, newTF)
+	}
+
+	if fi.Size() < tf.offset {
+		log.Printf("Detected truncation of %s", tf.path)
+		if _, err := tf.file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Error rewinding %s: %v", tf.path, err)
+			return false
+		}
+		tf.offset = 0
+		tf.partial = nil
+		t.checkpoint.Set(tf.path, tf.inode, 0)
+		return true
+	}
+	return false
+}
+
+// drain reads and sends lines from tf until it reaches the end, sending
+// fails, ctx is cancelled or tailDrainLimit bytes have been read. It
+// returns true only in the last case, when there may be more to read.
+func (t *Tailer) drain(ctx context.Context, tf *tailedFile) bool {
+	buf := make([]byte, tailReadChunk)
+
+	for read := 0; ctx.Err() == nil; {
+		if read >= tailDrainLimit {
+			return true
+		}
+		if !t.send(ctx, tf) {
+			return false
+		}
+		n, err := tf.file.Read(buf)
+		read += n
+		if n > 0 {
+			t.consume(tf, buf[:n])
+			if !t.send(ctx, tf) {
+				return false
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading %s: %v", tf.path, err)
+			}
+			return false
+		}
+		if n == 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// consume splits the newly read bytes into complete lines and queues them
+// in tf.pending. A trailing line without a newline is kept until the rest
+// arrives, so the checkpointed offset always points at a line boundary.
+func (t *Tailer) consume(tf *tailedFile, data []byte) {
+	tf.partial = append(tf.partial, data...)
+
+	messages := tf.pending
+	for {
+		i := bytes.IndexByte(tf.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := tf.partial[:i]
+		tf.partial = tf.partial[i+1:]
+		tf.offset += int64(i + 1)
+
+		if msg, ok := t.buildMessage(tf, strings.TrimRight(string(line), "\r")); ok {
+			messages = append(messages, msg)
+		}
+	}
+
+	if len(tf.partial) > tailMaxLineLength {
+		tf.offset += int64(len(tf.partial))
+		if msg, ok := t.buildMessage(tf, string(tf.partial)); ok {
+			messages = append(messages, msg)
+		}
+		tf.partial = nil
+	}
+
+	if len(tf.partial) == 0 {
+		tf.partial = nil
+	}
+	tf.pending = messages
+}
+
+// send publishes the pending messages of tf and checkpoints the offset
+// after their lines. On failure the messages stay pending, to be sent
+// again before anything further is read, and send returns false.
+func (t *Tailer) send(ctx context.Context, tf *tailedFile) bool {
+	if len(tf.pending) > 0 {
+		if err := t.writer.WriteMessages(ctx, tf.pending...); err != nil {
+			log.Printf("Failed to send log messages from %s to Kafka, will retry: %v", tf.path, err)
+			return false
+		}
+		tf.pending = nil
+	}
+	t.checkpoint.Set(tf.path, tf.inode, tf.offset)
+	return true
+}
+
+func (t *Tailer) buildMessage(tf *tailedFile, line string) (kafka.Message, bool) {
+	if strings.TrimSpace(line) == "" {
+		return kafka.Message{}, false
+	}
+
+	entry, err := tf.parser.Parse(line)
+	if err != nil {
+		entry, _ = plainParser{}.Parse(line)
+		entry.Metadata = map[string]string{"parse_error": err.Error()}
+	}
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.Metadata == nil {
+		entry.Metadata = map[string]string{}
+	}
+	entry.Source = tf.path
+	entry.Metadata["host"] = t.hostname
+	entry.Metadata["parser"] = tf.parser.Name()
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("JSON marshaling failed: %v", err)
+		return kafka.Message{}, false
+	}
+
+	return kafka.Message{
+		Key:   []byte(t.hostname),
+		Value: jsonBytes,
+	}, true
+}
+
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
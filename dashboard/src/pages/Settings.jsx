@@ -14,7 +14,7 @@ export default function Settings() {
     network: {
       capturePackets: true,
       storeDuration: 7, // days
-      maxPacketSize: 1500, // bytes
+      maxPacketSize: 0, // bytes; 0 keeps the collector's -snaplen
       interfaceMode: 'auto' // auto, specific
     },
     alerts: {
@@ -245,6 +245,7 @@ export default function Settings() {
               value={settings.network.maxPacketSize}
               onChange={(e) => handleSelectChange('network', 'maxPacketSize', parseInt(e.target.value))}
             >
+              <option value={0}>Collector default</option>
               <option value={512}>512 bytes</option>
               <option value={1024}>1024 bytes</option>
               <option value={1500}>1500 bytes (Ethernet MTU)</option>
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/segmentio/kafka-go"
+)
+
+const maxSuppressionDuration = 90 * 24 * time.Hour
+
+// alertFilter reads the alert filters of a request: status and severity
+// (comma-separated), rule, assignee, src_ip, dst_ip and
+// include_suppressed.
+func alertFilter(r *http.Request, search *query.Query, page models.Page) models.AlertFilter {
+	params := r.URL.Query()
+
+	filter := models.AlertFilter{
+		Rule:              params.Get("rule"),
+		Assignee:          params.Get("assignee"),
+		SrcIP:             params.Get("src_ip"),
+		DstIP:             params.Get("dst_ip"),
+		IncludeSuppressed: params.Get("include_suppressed") == "true",
+		Query:             search,
+		Page:              page,
+	}
+	if v := params.Get("status"); v != "" {
+		filter.Status = strings.Split(v, ",")
+	}
+	if v := params.Get("severity"); v != "" {
+		filter.Severity = strings.Split(v, ",")
+	}
+	return filter
+}
+
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	search, ok := parseSearch(w, r, models.AlertSearch)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	alerts, info, err := models.NewDB(db).ListAlerts(alertFilter(r, search, page))
+	if err != nil {
+		log.Printf("Error querying alerts: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"alerts": alerts,
+	}, page, info))
+}
+
+func alertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	alert, err := models.NewDB(db).GetAlert(id)
+	if err == models.ErrAlertNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying alert %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(alert)
+}
+
+// alertWriter publishes alerts raised through the API and by saved
+// searches to the alert topic, so the processor stores them with the same
+// deduplication and incident correlation as its own.
+var alertWriter *kafka.Writer
+
+func publishAlert(ctx context.Context, m models.AlertMessage) error {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return alertWriter.WriteMessages(ctx, kafka.Message{Value: value})
+}
+
+// createAlertHandler queues an alert for the processor and answers 202
+// with the alert as published; it appears in /alerts once stored.
+func createAlertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var alert models.Alert
+	if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if alert.Rule == "" {
+		http.Error(w, "rule is required", http.StatusBadRequest)
+		return
+	}
+	if !models.AlertSeverities[alert.Severity] {
+		http.Error(w, "severity must be one of low, medium, high, critical", http.StatusBadRequest)
+		return
+	}
+	if alert.SrcPort < 0 || alert.SrcPort > 65535 || alert.DstPort < 0 || alert.DstPort > 65535 {
+		http.Error(w, "src_port and dst_port must be between 0 and 65535", http.StatusBadRequest)
+		return
+	}
+	if alert.Title == "" {
+		alert.Title = alert.Rule
+	}
+
+	message := models.NewAlertMessage(alert, "")
+	if err := publishAlert(r.Context(), message); err != nil {
+		log.Printf("Error publishing alert: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(message)
+}
+
+type alertUpdateRequest struct {
+	IDs      []int64 `json:"ids"`
+	Status   *string `json:"status"`
+	Severity *string `json:"severity"`
+	Assignee *string `json:"assignee"`
+}
+
+// updateAlertsHandler handles both PATCH /alerts (bulk, ids in the body)
+// and PATCH /alerts/{id}.
+func updateAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req alertUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := mux.Vars(r)["id"]; ok {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		req.IDs = []int64{id}
+	}
+
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	setAuditDetail(r, "ids", req.IDs)
+	if req.Status != nil && !models.AlertStatuses[*req.Status] {
+		http.Error(w, "status must be one of new, acknowledged, in-progress, resolved, false-positive", http.StatusBadRequest)
+		return
+	}
+	if req.Severity != nil && !models.AlertSeverities[*req.Severity] {
+		http.Error(w, "severity must be one of low, medium, high, critical", http.StatusBadRequest)
+		return
+	}
+	if req.Status == nil && req.Severity == nil && req.Assignee == nil {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := models.NewDB(db).UpdateAlerts(req.IDs, models.AlertUpdate{
+		Status:   req.Status,
+		Severity: req.Severity,
+		Assignee: req.Assignee,
+		Actor:    currentUser(r).Username,
+	})
+	if err != nil {
+		log.Printf("Error updating alerts: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated": updated,
+	})
+}
+
+func addAlertCommentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(body.Body) == "" {
+		http.Error(w, "body is required", http.StatusBadRequest)
+		return
+	}
+
+	comment, err := models.NewDB(db).AddAlertComment(id, currentUser(r).Username, body.Body)
+	if err == models.ErrAlertNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding comment to alert %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+func alertSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	suppressions, err := models.NewDB(db).ListAlertSuppressions(r.URL.Query().Get("include_expired") == "true")
+	if err != nil {
+		log.Printf("Error querying alert suppressions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(suppressions)
+}
+
+func createAlertSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Rule     string `json:"rule"`
+		SrcIP    string `json:"src_ip"`
+		DstIP    string `json:"dst_ip"`
+		DstPort  int    `json:"dst_port"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Rule == "" && body.SrcIP == "" && body.DstIP == "" && body.DstPort == 0 {
+		http.Error(w, "at least one of rule, src_ip, dst_ip, dst_port is required", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := parseIntervalParam(body.Duration)
+	if err != nil || duration > maxSuppressionDuration {
+		http.Error(w, "duration must be between 1s and 90d, e.g. \"4h\" or \"7d\"", http.StatusBadRequest)
+		return
+	}
+
+	suppression, err := models.NewDB(db).CreateAlertSuppression(models.AlertSuppression{
+		Rule:      body.Rule,
+		SrcIP:     body.SrcIP,
+		DstIP:     body.DstIP,
+		DstPort:   body.DstPort,
+		Reason:    body.Reason,
+		CreatedBy: currentUser(r).Username,
+		ExpiresAt: time.Now().Add(duration),
+	})
+	if err != nil {
+		log.Printf("Error creating alert suppression: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(suppression)
+}
+
+func deleteAlertSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	expired, err := models.NewDB(db).ExpireAlertSuppression(id)
+	if err != nil {
+		log.Printf("Error expiring alert suppression %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !expired {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathID parses the {id} route variable, writing a 400 on failure.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
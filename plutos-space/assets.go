@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+func assetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	search, ok := parseSearch(w, r, models.AssetSearch)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	assets, info, err := models.NewDB(db).ListAssets(models.AssetFilter{
+		IP:      query.Get("ip"),
+		MAC:     query.Get("mac"),
+		Service: query.Get("service"),
+		Sensor:  query.Get("sensor"),
+		Query:   search,
+		Page:    page,
+	})
+	if err != nil {
+		log.Printf("Error querying assets: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"assets": assets,
+	}, page, info))
+}
+
+func assetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	asset, err := models.NewDB(db).GetAsset(id)
+	if err == models.ErrAssetNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying asset %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(asset)
+}
+
+func assetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	database := models.NewDB(db)
+	if _, err := database.GetAsset(id); err == models.ErrAssetNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error querying asset %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	history, info, err := database.ListAssetHistory(id, page)
+	if err != nil {
+		log.Printf("Error querying history of asset %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"history": history,
+	}, page, info))
+}
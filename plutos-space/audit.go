@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+// auditRecord collects what a mutating request did while it is handled.
+// requireAuth fills in the user; handlers may add details.
+type auditRecord struct {
+	mu         sync.Mutex
+	user       *models.User
+	authMethod string
+	details    map[string]interface{}
+}
+
+func auditRecordFor(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditContextKey).(*auditRecord)
+	return rec
+}
+
+// setAuditDetail adds a key to the audit entry of the current request. It
+// does nothing for requests that are not audited.
+func setAuditDetail(r *http.Request, key string, value interface{}) {
+	rec := auditRecordFor(r)
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.details[key] = value
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// auditRequests writes every API request that is not a read to
+// siem.audit_log, including rejected ones, once it has been handled.
+// Request bodies are not stored since they may contain passwords.
+func auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &auditRecord{authMethod: "none", details: map[string]interface{}{}}
+		sw := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditContextKey, rec)))
+
+		entry := models.AuditEntry{
+			AuthMethod: rec.authMethod,
+			Method:     r.Method,
+			Route:      r.URL.Path,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+			RemoteAddr: remoteHost(r),
+			UserAgent:  r.UserAgent(),
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				entry.Route = tpl
+			}
+		}
+		entry.ResourceID = mux.Vars(r)["id"]
+		if entry.ResourceID == "" {
+			entry.ResourceID = mux.Vars(r)["table"]
+		}
+		if rec.user != nil {
+			entry.ActorID = &rec.user.ID
+			entry.Actor = rec.user.Username
+			entry.Role = rec.user.Role
+		} else if username, ok := rec.details["username"].(string); ok && r.URL.Path == "/api/auth/login" {
+			// Failed logins are attributed to the name that was tried.
+			entry.Actor = username
+		}
+
+		rec.mu.Lock()
+		details, err := json.Marshal(rec.details)
+		rec.mu.Unlock()
+		if err == nil {
+			entry.Details = details
+		}
+
+		if err := models.NewDB(db).RecordAudit(entry); err != nil {
+			log.Printf("Error writing audit log entry for %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// auditHandler lists audit log entries, newest first. Filters: actor,
+// method, route (e.g. /api/alerts/{id}), resource_id, outcome (success or
+// failure) and the paging parameters of parsePage.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := models.AuditFilter{
+		Actor:      query.Get("actor"),
+		Method:     query.Get("method"),
+		Route:      query.Get("route"),
+		ResourceID: query.Get("resource_id"),
+		Outcome:    strings.ToLower(query.Get("outcome")),
+		Page:       page,
+	}
+	if filter.Outcome != "" && filter.Outcome != "success" && filter.Outcome != "failure" {
+		http.Error(w, "outcome must be success or failure", http.StatusBadRequest)
+		return
+	}
+
+	entries, info, err := models.NewDB(db).ListAuditLog(filter)
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"entries": entries,
+	}, page, info))
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+const (
+	sessionCookie   = "pluto_session"
+	maxAPIKeyExpiry = 365 * 24 * time.Hour
+
+	loginFailureLimit  = 5
+	loginFailureWindow = 15 * time.Minute
+	// loginAddressLimit bounds the failures from one address across all
+	// usernames, so it cannot try a few passwords against many accounts.
+	loginAddressLimit = 20
+	// loginLimiterKeys bounds the addresses and usernames tracked.
+	loginLimiterKeys = 100000
+
+	rolesError = "role must be one of viewer, analyst, admin"
+)
+
+// publicPaths are served without credentials.
+var publicPaths = map[string]bool{
+	"/api/health":     true,
+	"/api/auth/login": true,
+}
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	auditContextKey
+)
+
+// currentUser returns the user authenticated by requireAuth.
+func currentUser(r *http.Request) models.User {
+	u, _ := r.Context().Value(userContextKey).(models.User)
+	return u
+}
+
+// requestToken returns the bearer token, API key or session cookie sent
+// with the request. Browsers cannot set headers on WebSocket upgrades, so
+// allowQuery also accepts a token query parameter there.
+func requestToken(r *http.Request, allowQuery bool) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if allowQuery {
+		if token := r.URL.Query().Get("token"); token != "" {
+			return token
+		}
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// authenticate returns the user behind the request's credentials and
+// whether they came from a session or an API key.
+func authenticate(r *http.Request, allowQuery bool) (models.User, string, error) {
+	token := requestToken(r, allowQuery)
+	if token == "" {
+		return models.User{}, "", models.ErrInvalidCredentials
+	}
+	if strings.HasPrefix(token, models.APIKeyPrefix) {
+		user, err := models.NewDB(db).APIKeyUser(token)
+		return user, "api_key", err
+	}
+	user, err := models.NewDB(db).SessionUser(token)
+	return user, "session", err
+}
+
+// requireAuth rejects API requests without a valid session or API key and
+// stores the authenticated user in the request context.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, method, err := authenticate(r, false)
+		if err == models.ErrInvalidCredentials {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pluto"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating request: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if rec := auditRecordFor(r); rec != nil {
+			rec.user, rec.authMethod = &user, method
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireRole wraps a handler so only users with at least the given role
+// may call it.
+func requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if models.RoleRank(currentUser(r).Role) < models.RoleRank(role) {
+			http.Error(w, "Forbidden: requires the "+role+" role", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// parseOrigins splits the -allowed-origins flag.
+func parseOrigins(value string) []string {
+	origins := []string{}
+	for _, o := range strings.Split(value, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// checkOrigin allows WebSocket upgrades from the server's own origin, from
+// the configured allowed origins and from clients that send no Origin
+// header at all (not browsers).
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginLimiter slows password guessing by refusing logins for a username
+// from an address after repeated failures, and all logins from an address
+// after more failures across usernames. Failures are kept for
+// loginFailureWindow; sweep forgets the expired ones. A trusted proxy's own
+// address, seen when it does not name the client, stands for everyone
+// behind it, so usernames are not locked for it.
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	swept    time.Time
+}
+
+var logins = &loginLimiter{failures: make(map[string][]time.Time)}
+
+func (l *loginLimiter) recent(key string, now time.Time) []time.Time {
+	var kept []time.Time
+	for _, t := range l.failures[key] {
+		if now.Sub(t) < loginFailureWindow {
+			kept = append(kept, t)
+		}
+	}
+	if kept == nil {
+		delete(l.failures, key)
+	} else {
+		l.failures[key] = kept
+	}
+	return kept
+}
+
+func (l *loginLimiter) blocked(host, username string) bool {
+	perUser := !proxies.contains(host)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	return (perUser && len(l.recent(host+"|"+username, now)) >= loginFailureLimit) ||
+		len(l.recent(host, now)) >= loginAddressLimit
+}
+
+func (l *loginLimiter) fail(host, username string) {
+	keys := []string{host}
+	if !proxies.contains(host) {
+		keys = append(keys, host+"|"+username)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		kept := l.recent(key, now)
+		if kept == nil && len(l.failures) >= loginLimiterKeys {
+			if now.Sub(l.swept) >= time.Minute {
+				l.sweepLocked(now)
+			}
+			// Still full: make room by forgetting an arbitrary key.
+			for k := range l.failures {
+				if len(l.failures) < loginLimiterKeys {
+					break
+				}
+				delete(l.failures, k)
+			}
+		}
+		l.failures[key] = append(kept, now)
+	}
+}
+
+// reset clears the failures for a username from an address after a
+// successful login. The address's failures across usernames are kept.
+func (l *loginLimiter) reset(host, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, host+"|"+username)
+}
+
+func (l *loginLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(time.Now())
+}
+
+func (l *loginLimiter) sweepLocked(now time.Time) {
+	for key := range l.failures {
+		l.recent(key, now)
+	}
+	l.swept = now
+}
+
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// loginHandler exchanges a username and password for a session token. The
+// token is returned in the body for API clients and set as an HttpOnly
+// cookie for browsers.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	setAuditDetail(r, "username", body.Username)
+
+	host := remoteHost(r)
+	if logins.blocked(host, body.Username) {
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	database := models.NewDB(db)
+
+	user, err := database.Authenticate(body.Username, body.Password)
+	if err == models.ErrInvalidCredentials {
+		logins.fail(host, body.Username)
+		log.Printf("Failed login for %q from %s", body.Username, host)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error authenticating %q: %v", body.Username, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	logins.reset(host, body.Username)
+	if rec := auditRecordFor(r); rec != nil {
+		rec.user, rec.authMethod = &user, "password"
+	}
+
+	token, expiresAt, err := database.CreateSession(user.ID, *sessionTTL, r.UserAgent(), host)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+		"user":       user,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	token := requestToken(r, false)
+	if token != "" && !strings.HasPrefix(token, models.APIKeyPrefix) {
+		if err := models.NewDB(db).DeleteSession(token); err != nil {
+			log.Printf("Error deleting session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentUser(r))
+}
+
+// changePasswordHandler changes the caller's own password. It ends all of
+// the caller's sessions, including the current one.
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.NewPassword) < models.MinPasswordLength {
+		http.Error(w, fmt.Sprintf("new_password must be at least %d characters", models.MinPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r)
+	database := models.NewDB(db)
+
+	ok, err := database.CheckPassword(user.ID, body.CurrentPassword)
+	if err != nil {
+		log.Printf("Error checking password for user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "current_password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if _, err := database.UpdateUser(user.ID, models.UserUpdate{Password: &body.NewPassword}); err != nil {
+		log.Printf("Error changing password for user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	users, err := models.NewDB(db).ListUsers()
+	if err != nil {
+		log.Printf("Error querying users: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(users)
+}
+
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Username = strings.TrimSpace(body.Username)
+	if body.Role == "" {
+		body.Role = "viewer"
+	}
+	setAuditDetail(r, "username", body.Username)
+	setAuditDetail(r, "role", body.Role)
+	if body.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.Password) < models.MinPasswordLength {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", models.MinPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if models.RoleRank(body.Role) == 0 {
+		http.Error(w, rolesError, http.StatusBadRequest)
+		return
+	}
+
+	user, err := models.NewDB(db).CreateUser(body.Username, body.Password, body.Role)
+	if err == models.ErrUserExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// updateUserHandler resets a user's password, changes their role or
+// disables them. A password reset or disabling ends the user's sessions;
+// API keys stop working while a user is disabled.
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Password *string `json:"password"`
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	setAuditDetail(r, "password_reset", body.Password != nil)
+	if body.Role != nil {
+		setAuditDetail(r, "role", *body.Role)
+	}
+	if body.Disabled != nil {
+		setAuditDetail(r, "disabled", *body.Disabled)
+	}
+	if body.Password == nil && body.Role == nil && body.Disabled == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+	if body.Password != nil && len(*body.Password) < models.MinPasswordLength {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", models.MinPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if body.Role != nil && models.RoleRank(*body.Role) == 0 {
+		http.Error(w, rolesError, http.StatusBadRequest)
+		return
+	}
+	if body.Disabled != nil && *body.Disabled && id == currentUser(r).ID {
+		http.Error(w, "You cannot disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	user, err := models.NewDB(db).UpdateUser(id, models.UserUpdate{Password: body.Password, Role: body.Role, Disabled: body.Disabled})
+	if err == models.ErrUserNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrLastAdmin {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating user %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if id == currentUser(r).ID {
+		http.Error(w, "You cannot delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := models.NewDB(db).DeleteUser(id)
+	if err == models.ErrUserNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrLastAdmin {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting user %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiKeysHandler lists the caller's own API keys.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	keys, err := models.NewDB(db).ListAPIKeys(currentUser(r).ID)
+	if err != nil {
+		log.Printf("Error querying API keys: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// createAPIKeyHandler issues an API key acting as the caller. The key is
+// only included in this response.
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Name      string `json:"name"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresIn != "" {
+		d, err := parseIntervalParam(body.ExpiresIn)
+		if err != nil || d > maxAPIKeyExpiry {
+			http.Error(w, "expires_in must be between 1s and 365d, e.g. \"90d\"", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	key, secret, err := models.NewDB(db).CreateAPIKey(currentUser(r).ID, body.Name, expiresAt)
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	setAuditDetail(r, "api_key_id", key.ID)
+	setAuditDetail(r, "name", key.Name)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.APIKey
+		Key string `json:"key"`
+	}{key, secret})
+}
+
+func deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	err := models.NewDB(db).RevokeAPIKey(currentUser(r).ID, id)
+	if err == models.ErrAPIKeyNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ensureAdminUser creates an "admin" user with the admin role on first
+// start so the API can be logged into. The password comes from
+// PLUTO_ADMIN_PASSWORD or is generated and logged once.
+func ensureAdminUser(database *models.DB) error {
+	n, err := database.CountUsers()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	password := strings.TrimSpace(os.Getenv("PLUTO_ADMIN_PASSWORD"))
+	generated := password == ""
+	if generated {
+		if password, err = models.NewToken(""); err != nil {
+			return err
+		}
+		password = password[:20]
+	}
+
+	if _, err := database.CreateUser("admin", password, "admin"); err != nil && err != models.ErrUserExists {
+		return err
+	}
+
+	if generated {
+		log.Printf("Created user \"admin\" with password %q; change it after logging in", password)
+	} else {
+		log.Printf("Created user \"admin\" with the password from PLUTO_ADMIN_PASSWORD")
+	}
+	return nil
+}
+
+// runSessionPruner deletes expired sessions hourly, and forgets expired
+// login failures.
+func runSessionPruner(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := models.NewDB(db).PruneSessions(); err != nil {
+				log.Printf("Error pruning sessions: %v", err)
+			}
+			logins.sweep()
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package cron parses standard five-field cron expressions (minute, hour,
+// day of month, month, day of week) and computes when they next fire.
+//
+// Fields accept *, numbers, ranges (1-5), lists (1,15) and steps (*/15,
+// 0-30/5). Months and weekdays may also be given by their three-letter
+// English names, and Sunday is 0 or 7. As in Vixie cron, when both day of
+// month and day of week are restricted a time matches if either does. The
+// shorthands @hourly, @daily (@midnight), @weekly, @monthly and @yearly
+// (@annually) are accepted too.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record unrestricted day fields, which changes
+	// how the two are combined.
+	domStar, dowStar bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = bounds{"minute", 0, 59, nil}
+	hourBounds   = bounds{"hour", 0, 23, nil}
+	domBounds    = bounds{"day of month", 1, 31, nil}
+	monthBounds  = bounds{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = bounds{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxSearch bounds how far ahead Next looks, so expressions that can never
+// fire (such as February 30th) terminate.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Parse parses a cron expression. Expressions that can never fire are
+// rejected.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := shorthands[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+	// Sunday can be written as 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	if s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never fires", spec)
+	}
+	return s, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		lo, hi := b.min, b.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			loStr, hiStr, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(loStr, b); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(hiStr, b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", b.name, rangePart)
+			}
+		default:
+			v, err := parseValue(rangePart, b)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			hi = v
+			// "5/10" means from 5 to the end in steps of 10.
+			if hasStep {
+				hi = b.max
+			}
+		}
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", b.name, stepPart)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("invalid %s %q (must be %d-%d)", b.name, s, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that the schedule fires, in t's
+// location, or the zero time if it does not fire within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,109 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2024-01-01 10:07:30", "2024-01-01 10:15:00"},
+		{"*/15 * * * *", "2024-01-01 10:15:00", "2024-01-01 10:30:00"},
+		{"5/20 * * * *", "2024-01-01 10:26:00", "2024-01-01 10:45:00"},
+		{"0,30 9-10 * * *", "2024-01-01 10:30:00", "2024-01-02 09:00:00"},
+		{"0 0 * * *", "2024-12-31 23:59:59", "2025-01-01 00:00:00"},
+		{"@hourly", "2024-01-01 23:00:00", "2024-01-02 00:00:00"},
+		{"@yearly", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		{"59 23 31 12 *", "2024-12-31 23:59:00", "2025-12-31 23:59:00"},
+
+		// Month edges.
+		{"0 0 1 * *", "2024-01-31 12:00:00", "2024-02-01 00:00:00"},
+		{"0 0 31 * *", "2024-02-01 00:00:00", "2024-03-31 00:00:00"},
+		{"0 0 31 * *", "2024-04-15 00:00:00", "2024-05-31 00:00:00"},
+		{"0 0 30 * *", "2024-02-01 00:00:00", "2024-03-30 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 1 */3 *", "2024-02-15 00:00:00", "2024-04-01 00:00:00"},
+		{"0 0 1 jan,jul *", "2024-02-01 00:00:00", "2024-07-01 00:00:00"},
+
+		// Day of week, with Sunday as 0 or 7 and by name.
+		{"0 12 * * mon", "2024-01-01 12:00:00", "2024-01-08 12:00:00"},
+		{"30 9 * * 1-5", "2024-01-05 10:00:00", "2024-01-08 09:30:00"},
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * SAT", "2024-01-01 00:00:00", "2024-01-06 00:00:00"},
+
+		// Both day fields restricted: either may match.
+		{"0 0 13 * 5", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * 5", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 13 * 5", "2024-01-13 00:00:00", "2024-01-19 00:00:00"},
+		// One day field unrestricted: the other must match.
+		{"0 0 13 * *", "2024-01-01 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 * * 5", "2024-01-06 00:00:00", "2024-01-12 00:00:00"},
+		{"0 0 ? * 5", "2024-01-06 00:00:00", "2024-01-12 00:00:00"},
+		{"0 0 1-7 * *", "2024-01-07 00:00:00", "2024-02-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s; want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04:05 Mon"), tt.want)
+		}
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2024, 1, 1, 10, 0, 0, 0, loc))
+	if want := time.Date(2024, 1, 2, 9, 0, 0, 0, loc); !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %v; want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		msg  string
+	}{
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"60 * * * *", "invalid minute"},
+		{"* 24 * * *", "invalid hour"},
+		{"0 0 0 * *", "invalid day of month"},
+		{"0 0 * 13 *", "invalid month"},
+		{"0 0 * * 8", "invalid day of week"},
+		{"0 0 * foo *", "invalid month"},
+		{"5-1 * * * *", "invalid minute range"},
+		{"*/0 * * * *", "invalid minute step"},
+		{"*/x * * * *", "invalid minute step"},
+		{"0 0 30 2 *", "never fires"},
+		{"0 0 31 4,6,9,11 *", "never fires"},
+		{"@often", "must have 5 fields"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("Parse(%q) = %v; want an error containing %q", tt.spec, err, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/h3bzzz/pluto/plutos-space/evidence"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+var evidenceStore evidence.Store
+
+// alertEvidenceHandler downloads the packets kept as evidence for an
+// alert as pcapng. The alert's evidence field shows whether they have
+// been stored yet.
+func alertEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	e, err := models.NewDB(db).GetAlertEvidence(id)
+	if err == models.ErrEvidenceNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying evidence of alert %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if e.Status != "stored" {
+		msg := "alert evidence is " + e.Status
+		if e.Error != "" {
+			msg += ": " + e.Error
+		}
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+
+	body, err := evidenceStore.Open(r.Context(), e.ObjectKey)
+	if err == evidence.ErrObjectNotFound {
+		http.Error(w, "alert evidence is missing from the evidence store", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening evidence of alert %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("alert-%d-evidence.pcapng", id)))
+	w.Header().Set("Content-Length", strconv.FormatInt(e.SizeBytes, 10))
+	w.Header().Set("X-Packet-Count", strconv.Itoa(e.Packets))
+	w.Header().Set("X-Evidence-Sha256", e.SHA256)
+	if _, err := io.Copy(w, body); err != nil && r.Context().Err() == nil {
+		log.Printf("Error streaming evidence of alert %d: %v", id, err)
+	}
+}
@@ -0,0 +1,214 @@
+package evidence
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hex SHA-256 of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Store keeps evidence in an S3-compatible bucket such as MinIO. Objects
+// are addressed path-style (endpoint/bucket/key), which every
+// S3-compatible server supports, and requests are signed with AWS
+// Signature Version 4.
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func newS3Store(u *url.URL, accessKey, secretKey string) (*s3Store, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 evidence store needs a bucket, e.g. s3://evidence")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3 evidence store needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	params := u.Query()
+	s := &s3Store{
+		endpoint:  strings.TrimSuffix(params.Get("endpoint"), "/"),
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    params.Get("region"),
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if e, err := url.Parse(s.endpoint); err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %q is not an http(s) URL", s.endpoint)
+	}
+	return s, nil
+}
+
+func (s *s3Store) objectURL(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	return s.endpoint + "/" + s.bucket + "/" + uriEncode(key, false), nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64, sha256 string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, sha256, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptySHA256, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	return nil, s3Error(resp)
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set on req. payloadHash is the hex
+// SHA-256 of the body.
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	if req.ContentLength > 0 {
+		headers["content-length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for name, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set, as Signature Version 4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+// Package evidence keeps the raw traffic around alerts. A Worker copies
+// the packets of each alert's flow out of the sensor that captured them
+// into a Store before the sensor's capture buffer rotates them away.
+package evidence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrObjectNotFound = errors.New("evidence object not found")
+
+// Store holds evidence files by key. Keys are slash-separated relative
+// paths.
+type Store interface {
+	// Put stores size bytes read from r under key. sha256 is the hex
+	// SHA-256 of the content.
+	Put(ctx context.Context, key string, r io.Reader, size int64, sha256 string) error
+	// Open returns the content stored under key, or ErrObjectNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// OpenStore opens the store described by spec: a directory (optionally as
+// a file:// URL) or an S3-compatible bucket as
+// s3://bucket/prefix?endpoint=http://minio:9000&region=us-east-1. S3
+// credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func OpenStore(spec string) (Store, error) {
+	if !strings.Contains(spec, "://") {
+		return newFileStore(spec)
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return newFileStore(u.Path)
+	case "s3":
+		return newS3Store(u, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	}
+	return nil, fmt.Errorf("unsupported evidence store %q; use a directory or an s3:// URL", u.Scheme)
+}
+
+// validKey rejects keys that could escape the store's directory or
+// prefix.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid evidence key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid evidence key %q", key)
+		}
+	}
+	return nil
+}
+
+// fileStore keeps evidence in a local directory.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("evidence directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file first so a partly written object is
+// never visible under its key.
+func (s *fileStore) Put(ctx context.Context, key string, r io.Reader, size int64, sha256 string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *fileStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
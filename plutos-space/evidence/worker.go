@@ -0,0 +1,207 @@
+package evidence
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/sensors"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	// claimLease is how long other server instances leave a claimed row
+	// alone; it covers copyTimeout.
+	claimLease  = 15 * time.Minute
+	copyTimeout = 10 * time.Minute
+	maxAttempts = 5
+	// settleDelay gives the sensor time to write the packets captured at
+	// the end of a window before they are fetched.
+	settleDelay = 10 * time.Second
+	// pinMargin keeps a window pinned past its end so retries still find
+	// the packets.
+	pinMargin = time.Hour
+	// MaxPackets caps the packets kept for one alert.
+	MaxPackets = 100000
+)
+
+// Worker copies pending alert evidence from the sensors to a store. While
+// an alert's window is still open it pins the window in the sensor's
+// capture buffer; once the window has passed it fetches both directions
+// of the alert's flow as pcapng, stores the file and releases the pin.
+// Rows are claimed from siem.alert_evidence, so several server instances
+// can run workers.
+type Worker struct {
+	db      *models.DB
+	sensors *sensors.Registry
+	store   Store
+}
+
+func NewWorker(db *models.DB, registry *sensors.Registry, store Store) *Worker {
+	return &Worker{db: db, sensors: registry, store: store}
+}
+
+// Key returns the store key of an alert's evidence.
+func Key(e models.AlertEvidence) string {
+	return fmt.Sprintf("alerts/%s/alert-%d.pcapng", e.WindowStart.UTC().Format("2006/01/02"), e.AlertID)
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.poll(ctx)
+		}
+	}
+}
+
+// poll works through the due rows one at a time, so each claim's lease
+// only has to cover one copy.
+func (w *Worker) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now().UTC()
+		jobs, err := w.db.ClaimEvidence(now, claimLease, 1)
+		if err != nil {
+			log.Printf("[Evidence] Error claiming pending evidence: %v", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		e := w.process(ctx, jobs[0], now)
+		if err := w.db.SaveEvidence(e); err != nil {
+			log.Printf("[Evidence] Error saving evidence of alert %d: %v", e.AlertID, err)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, job models.EvidenceJob, now time.Time) models.AlertEvidence {
+	e := job.AlertEvidence
+	sensor, err := w.sensors.Resolve(e.Sensor)
+	if err != nil {
+		e.Status, e.Error = "unavailable", err.Error()
+		return e
+	}
+	e.Sensor = sensor
+
+	ready := e.WindowEnd.Add(settleDelay)
+	if now.Before(ready) {
+		if e.PinID == "" {
+			w.pin(ctx, &e)
+		}
+		e.NextAttemptAt = ready
+		return e
+	}
+
+	err = w.copy(ctx, job, &e)
+	switch {
+	case err == nil:
+		stored := time.Now().UTC()
+		e.Status, e.Error, e.StoredAt = "stored", "", &stored
+	case errors.Is(err, sensors.ErrNoPackets):
+		e.Status, e.Error = "unavailable", err.Error()
+	case ctx.Err() != nil:
+		// Shutting down; the row is claimed again when the lease ends.
+		return e
+	default:
+		e.Attempts++
+		e.Error = err.Error()
+		if e.Attempts < maxAttempts {
+			e.NextAttemptAt = now.Add(time.Minute << (e.Attempts - 1))
+			return e
+		}
+		e.Status = "failed"
+		log.Printf("[Evidence] Giving up on evidence of alert %d: %v", e.AlertID, err)
+	}
+	w.unpin(ctx, &e)
+	return e
+}
+
+// pin holds the window in the sensor's capture buffer. Failing to pin is
+// not fatal: the packets are usually still there when the window ends.
+func (w *Worker) pin(ctx context.Context, e *models.AlertEvidence) {
+	pinCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	id, err := w.sensors.Pin(pinCtx, e.Sensor, e.WindowStart, e.WindowEnd, e.WindowEnd.Add(pinMargin))
+	if err != nil {
+		log.Printf("[Evidence] Error pinning evidence of alert %d on sensor %s: %v", e.AlertID, e.Sensor, err)
+		return
+	}
+	e.PinID = id
+}
+
+// unpin releases the window; pins expire by themselves if this fails.
+func (w *Worker) unpin(ctx context.Context, e *models.AlertEvidence) {
+	if e.PinID == "" {
+		return
+	}
+	unpinCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := w.sensors.Unpin(unpinCtx, e.Sensor, e.PinID); err != nil {
+		log.Printf("[Evidence] Error releasing pin of alert %d on sensor %s: %v", e.AlertID, e.Sensor, err)
+	}
+	e.PinID = ""
+}
+
+// copy fetches the alert's packets into a temporary file, hashing them on
+// the way, and puts the file in the store.
+func (w *Worker) copy(ctx context.Context, job models.EvidenceJob, e *models.AlertEvidence) error {
+	ctx, cancel := context.WithTimeout(ctx, copyTimeout)
+	defer cancel()
+
+	capture, err := w.sensors.Fetch(ctx, e.Sensor, sensors.Query{
+		From:          e.WindowStart,
+		To:            e.WindowEnd,
+		SrcIP:         job.SrcIP,
+		DstIP:         job.DstIP,
+		SrcPort:       job.SrcPort,
+		DstPort:       job.DstPort,
+		Protocol:      job.Protocol,
+		Bidirectional: true,
+		Limit:         MaxPackets,
+		Format:        "pcapng",
+	})
+	if err != nil {
+		return err
+	}
+	defer capture.Body.Close()
+
+	tmp, err := os.CreateTemp("", "evidence-*.pcapng")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// The body fails at its end if the sensor sent fewer packets than it
+	// announced, so a capture cut short is retried rather than stored.
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), capture.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	key := Key(*e)
+	if err := w.store.Put(ctx, key, tmp, size, sum); err != nil {
+		return err
+	}
+	e.ObjectKey, e.Packets, e.SizeBytes, e.SHA256 = key, capture.Packets, size, sum
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/export"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+// maxExportRows caps the rows of one export; larger results need
+// narrower from/to bounds.
+const maxExportRows = 1000000
+
+// exportRequest holds the export parameters common to all export
+// endpoints: format (csv, ndjson, json or parquet; csv by default), gzip
+// and limit (at most maxExportRows, the default), plus the from/to time
+// bounds.
+type exportRequest struct {
+	format string
+	gzip   bool
+	limit  int
+	page   models.Page
+}
+
+// parseExport reads the export parameters, answering 400 for invalid
+// values; ok is false if a response has been written.
+func parseExport(w http.ResponseWriter, r *http.Request) (exportRequest, bool) {
+	params := r.URL.Query()
+	req := exportRequest{format: "csv", gzip: params.Get("gzip") == "true", limit: maxExportRows}
+
+	if v := params.Get("format"); v != "" {
+		if _, ok := export.Formats[v]; !ok {
+			http.Error(w, "format must be csv, ndjson, json or parquet", http.StatusBadRequest)
+			return req, false
+		}
+		req.format = v
+	}
+
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return req, false
+		}
+		req.limit = min(l, maxExportRows)
+	}
+
+	if err := parseBounds(r, &req.page); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// streamExport writes the rows of target matching where as a file
+// download. The response is committed once the query returns its first
+// result, so errors after that point cannot change the status; they are
+// logged, end the body early and are reported in the X-Export-Error
+// trailer. X-Export-Rows carries the number of rows written.
+func streamExport(w http.ResponseWriter, r *http.Request, name string, req exportRequest, target models.SearchTarget, where string, params []interface{}) {
+	format := export.Formats[req.format]
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format.Extension)
+
+	var gz *gzip.Writer
+	started := false
+	start := func(columns []export.Column) (export.Writer, error) {
+		started = true
+		w.Header().Set("Trailer", "X-Export-Rows, X-Export-Error")
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		var out io.Writer = w
+		// Parquet compresses its pages itself and stays readable as is.
+		if req.gzip && req.format != "parquet" {
+			gz = gzip.NewWriter(w)
+			out = gz
+			filename += ".gz"
+			w.Header().Set("Content-Type", "application/gzip")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+
+		return export.NewWriter(req.format, out, columns, req.gzip)
+	}
+
+	n, err := models.NewDB(db).ExportRows(r.Context(), target, where, params, req.limit, start)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil && !started {
+		log.Printf("Error exporting %s: %v", name, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Export-Rows", strconv.Itoa(n))
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("Error exporting %s after %d rows: %v", name, n, err)
+		}
+		w.Header().Set("X-Export-Error", "export incomplete")
+	}
+}
+
+// exportPacketsHandler exports packets matching the filters of
+// packetsHandler.
+func exportPacketsHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := parseSearch(w, r, models.PacketSearch)
+	if !ok {
+		return
+	}
+	req, ok := parseExport(w, r)
+	if !ok {
+		return
+	}
+
+	where, params := packetsWhere(r, search, req.page)
+	streamExport(w, r, "packets", req, models.SearchTargets["packets"], where, params)
+}
+
+// exportLogsHandler exports logs matching the filters of logsHandler.
+func exportLogsHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := parseSearch(w, r, models.LogSearch)
+	if !ok {
+		return
+	}
+	req, ok := parseExport(w, r)
+	if !ok {
+		return
+	}
+
+	where, params := logsWhere(r, search, req.page)
+	streamExport(w, r, "logs", req, models.SearchTargets["logs"], where, params)
+}
+
+// exportAlertsHandler exports alerts matching the filters of
+// alertsHandler. Suppressed alerts are only included with
+// include_suppressed=true, as in the list.
+func exportAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := parseSearch(w, r, models.AlertSearch)
+	if !ok {
+		return
+	}
+	req, ok := parseExport(w, r)
+	if !ok {
+		return
+	}
+
+	where, params := alertFilter(r, search, req.page).Where()
+	streamExport(w, r, "alerts", req, models.SearchTargets["alerts"], where, params)
+}
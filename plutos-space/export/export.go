@@ -0,0 +1,231 @@
+// Package export writes query results as CSV, newline-delimited JSON, a
+// JSON array or Parquet, one row at a time, so large results can be streamed without
+// holding them in memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the type of a column's values.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Bool
+	Time
+	// JSON columns hold JSON text, embedded as JSON in NDJSON output.
+	JSON
+)
+
+// KindOf maps a PostgreSQL type name, as reported by
+// sql.ColumnType.DatabaseTypeName, to a Kind.
+func KindOf(databaseType string) Kind {
+	switch strings.ToUpper(databaseType) {
+	case "INT2", "INT4", "INT8":
+		return Int
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return Float
+	case "BOOL":
+		return Bool
+	case "TIMESTAMP", "TIMESTAMPTZ", "DATE":
+		return Time
+	case "JSON", "JSONB":
+		return JSON
+	default:
+		return String
+	}
+}
+
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+// Writer writes rows of values in column order. Values are nil, int64,
+// float64, bool, time.Time or string (also for JSON and numeric columns).
+// Close flushes buffered output but does not close the underlying writer.
+type Writer interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// Formats lists the supported formats with their content types and file
+// extensions.
+var Formats = map[string]struct {
+	ContentType string
+	Extension   string
+}{
+	"csv":     {"text/csv; charset=utf-8", "csv"},
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"json":    {"application/json", "json"},
+	"parquet": {"application/vnd.apache.parquet", "parquet"},
+}
+
+// NewWriter returns a writer for format. compress only applies to Parquet,
+// which compresses its pages with gzip; compress other formats by wrapping
+// w instead.
+func NewWriter(format string, w io.Writer, columns []Column, compress bool) (Writer, error) {
+	switch format {
+	case "csv":
+		return NewCSV(w, columns)
+	case "ndjson":
+		return NewNDJSON(w, columns), nil
+	case "json":
+		return NewJSON(w, columns), nil
+	case "parquet":
+		return NewParquet(w, columns, compress)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// FormatValue renders a value as text: times as RFC 3339 in UTC and nil
+// as the empty string.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+// NewCSV returns a writer that writes a header row and then one record
+// per row.
+func NewCSV(w io.Writer, columns []Column) (Writer, error) {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: cw, record: make([]string, len(columns))}, nil
+}
+
+func (c *csvWriter) WriteRow(values []interface{}) error {
+	for i, v := range values {
+		c.record[i] = FormatValue(v)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	enc     *json.Encoder
+	columns []Column
+	obj     map[string]interface{}
+}
+
+// NewNDJSON returns a writer that writes one JSON object per line.
+func NewNDJSON(w io.Writer, columns []Column) Writer {
+	bw := bufio.NewWriter(w)
+	return &ndjsonWriter{
+		w:       bw,
+		enc:     json.NewEncoder(bw),
+		columns: columns,
+		obj:     make(map[string]interface{}, len(columns)),
+	}
+}
+
+func (n *ndjsonWriter) WriteRow(values []interface{}) error {
+	return n.enc.Encode(jsonObject(n.obj, n.columns, values))
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+// jsonObject fills obj with values keyed by column name. JSON columns are
+// embedded as JSON, numeric columns read as text become numbers and times
+// are in UTC.
+func jsonObject(obj map[string]interface{}, columns []Column, values []interface{}) map[string]interface{} {
+	for i, v := range values {
+		switch columns[i].Kind {
+		case JSON:
+			if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+				v = json.RawMessage(s)
+			}
+		case Float:
+			if s, ok := v.(string); ok {
+				if f, err := strconv.ParseFloat(s, 64); err == nil {
+					v = f
+				}
+			}
+		case Time:
+			if t, ok := v.(time.Time); ok {
+				v = t.UTC()
+			}
+		}
+		obj[columns[i].Name] = v
+	}
+	return obj
+}
+
+type jsonWriter struct {
+	w       *bufio.Writer
+	columns []Column
+	obj     map[string]interface{}
+	rows    int
+}
+
+// NewJSON returns a writer that writes a single JSON array of objects.
+func NewJSON(w io.Writer, columns []Column) Writer {
+	return &jsonWriter{
+		w:       bufio.NewWriter(w),
+		columns: columns,
+		obj:     make(map[string]interface{}, len(columns)),
+	}
+}
+
+func (j *jsonWriter) WriteRow(values []interface{}) error {
+	data, err := json.Marshal(jsonObject(j.obj, j.columns, values))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.rows == 0 {
+		sep = "[\n"
+	}
+	j.rows++
+	if _, err := j.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	end := "\n]\n"
+	if j.rows == 0 {
+		end = "[]\n"
+	}
+	if _, err := j.w.WriteString(end); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
@@ -0,0 +1,459 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// parquetWriter writes a Parquet file with one optional column per result
+// column. Rows are buffered into row groups of rowGroupSize rows; each
+// column of a group is written as a single PLAIN-encoded data page, gzip
+// compressed when requested. Integers are INT64, times INT64 microseconds
+// since the epoch (TIMESTAMP_MICROS) and strings and JSON UTF-8 byte
+// arrays, which Parquet readers such as pandas, DuckDB and Spark all read.
+//
+// Only the small subset of the format needed for that is implemented, so
+// no Thrift or Parquet library is required.
+type parquetWriter struct {
+	w        *countingWriter
+	columns  []Column
+	codec    int32
+	buffered []*columnBuffer
+	rows     int
+	groups   []rowGroupMeta
+	total    int64
+}
+
+const rowGroupSize = 50000
+
+// Parquet physical types, repetition types, encodings, codecs and
+// converted types from parquet.thrift.
+const (
+	typeBoolean   = 0
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecGzip         = 2
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+	convertedJSON            = 19
+
+	pageTypeData = 0
+)
+
+type columnBuffer struct {
+	defined []bool
+	values  bytes.Buffer
+	bits    []bool // boolean values, bit-packed on flush
+}
+
+type chunkMeta struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+	numValues        int64
+}
+
+type rowGroupMeta struct {
+	chunks  []chunkMeta
+	numRows int64
+	size    int64
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewParquet returns a writer producing a Parquet file. With compress,
+// pages are gzip compressed.
+func NewParquet(w io.Writer, columns []Column, compress bool) (Writer, error) {
+	p := &parquetWriter{
+		w:       &countingWriter{w: w},
+		columns: columns,
+		codec:   codecUncompressed,
+	}
+	if compress {
+		p.codec = codecGzip
+	}
+	p.buffered = make([]*columnBuffer, len(columns))
+	for i := range p.buffered {
+		p.buffered[i] = &columnBuffer{}
+	}
+	if _, err := p.w.Write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func physicalType(k Kind) int32 {
+	switch k {
+	case Int, Time:
+		return typeInt64
+	case Float:
+		return typeDouble
+	case Bool:
+		return typeBoolean
+	default:
+		return typeByteArray
+	}
+}
+
+func (p *parquetWriter) WriteRow(values []interface{}) error {
+	for i, v := range values {
+		buf := p.buffered[i]
+		if v == nil {
+			buf.defined = append(buf.defined, false)
+			continue
+		}
+		if err := buf.append(p.columns[i], v); err != nil {
+			return err
+		}
+		buf.defined = append(buf.defined, true)
+	}
+	p.rows++
+	if p.rows == rowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+func (b *columnBuffer) append(c Column, v interface{}) error {
+	var scratch [8]byte
+	switch c.Kind {
+	case Int:
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("column %s: expected an integer, got %T", c.Name, v)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], uint64(n))
+		b.values.Write(scratch[:])
+	case Time:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("column %s: expected a time, got %T", c.Name, v)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], uint64(t.UnixMicro()))
+		b.values.Write(scratch[:])
+	case Float:
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("column %s: %v", c.Name, err)
+			}
+		default:
+			return fmt.Errorf("column %s: expected a number, got %T", c.Name, v)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(f))
+		b.values.Write(scratch[:])
+	case Bool:
+		bv, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("column %s: expected a boolean, got %T", c.Name, v)
+		}
+		b.bits = append(b.bits, bv)
+	default:
+		s := FormatValue(v)
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(len(s)))
+		b.values.Write(scratch[:4])
+		b.values.WriteString(s)
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+
+	group := rowGroupMeta{numRows: int64(p.rows)}
+	for _, buf := range p.buffered {
+		var page bytes.Buffer
+		levels := bitPackedHybrid(buf.defined)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+		if buf.bits != nil {
+			page.Write(packBits(buf.bits))
+		} else {
+			page.Write(buf.values.Bytes())
+		}
+
+		data := page.Bytes()
+		if p.codec == codecGzip {
+			var compressed bytes.Buffer
+			zw := gzip.NewWriter(&compressed)
+			zw.Write(data)
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data = compressed.Bytes()
+		}
+
+		header := pageHeader(page.Len(), len(data), len(buf.defined))
+		chunk := chunkMeta{
+			offset:           p.w.n,
+			uncompressedSize: int64(len(header) + page.Len()),
+			compressedSize:   int64(len(header) + len(data)),
+			numValues:        int64(len(buf.defined)),
+		}
+		if _, err := p.w.Write(header); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(data); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressedSize
+
+		buf.defined = buf.defined[:0]
+		buf.values.Reset()
+		if buf.bits != nil {
+			buf.bits = buf.bits[:0]
+		}
+	}
+
+	p.groups = append(p.groups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// Close writes the last row group and the file footer.
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	footer := p.fileMetadata()
+	if _, err := p.w.Write(footer); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], uint32(len(footer)))
+	copy(trailer[4:], "PAR1")
+	_, err := p.w.Write(trailer[:])
+	return err
+}
+
+// bitPackedHybrid encodes definition levels (bit width 1) as a single
+// bit-packed run of the RLE/bit-packing hybrid encoding.
+func bitPackedHybrid(levels []bool) []byte {
+	groups := (len(levels) + 7) / 8
+	var out bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], uint64(groups)<<1|1)
+	out.Write(scratch[:n])
+	out.Write(packBits(levels))
+	return out.Bytes()
+}
+
+// packBits packs booleans LSB first, padded to a whole byte.
+func packBits(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			out[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+	return out
+}
+
+func pageHeader(uncompressed, compressed, numValues int) []byte {
+	t := &thriftWriter{}
+	t.i32(1, pageTypeData)
+	t.i32(2, int32(uncompressed))
+	t.i32(3, int32(compressed))
+	t.structBegin(5) // data_page_header
+	t.i32(1, int32(numValues))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE) // definition levels
+	t.i32(4, encodingRLE) // repetition levels
+	t.structEnd()
+	t.stop()
+	return t.buf.Bytes()
+}
+
+func (p *parquetWriter) fileMetadata() []byte {
+	t := &thriftWriter{}
+	t.i32(1, 1) // version
+
+	t.listBegin(2, thriftStruct, len(p.columns)+1)
+	t.elemBegin()
+	t.str(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.elemEnd()
+	for _, c := range p.columns {
+		t.elemBegin()
+		t.i32(1, physicalType(c.Kind))
+		t.i32(3, repetitionOptional)
+		t.str(4, c.Name)
+		switch c.Kind {
+		case Time:
+			t.i32(6, convertedTimestampMicros)
+		case JSON:
+			t.i32(6, convertedJSON)
+		case String:
+			t.i32(6, convertedUTF8)
+		}
+		t.elemEnd()
+	}
+
+	t.i64(3, p.total)
+
+	t.listBegin(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			t.elemBegin()
+			t.i64(2, chunk.offset) // file_offset
+			t.structBegin(3)       // meta_data
+			t.i32(1, physicalType(p.columns[i].Kind))
+			t.listBegin(2, thriftI32, 2)
+			t.listI32(encodingPlain)
+			t.listI32(encodingRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.listStr(p.columns[i].Name)
+			t.i32(4, p.codec)
+			t.i64(5, chunk.numValues)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset) // data_page_offset
+			t.structEnd()
+			t.elemEnd()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.numRows)
+		t.elemEnd()
+	}
+
+	t.str(6, "pluto")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// Thrift compact protocol type codes.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which
+// Parquet uses for page headers and the file footer.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int16
+	lastID  int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], uint64((v<<1)^(v>>63)))
+	t.buf.Write(scratch[:n])
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	t.buf.Write(scratch[:n])
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// elemBegin starts a struct that is a list element and so has no field
+// header.
+func (t *thriftWriter) elemBegin() {
+	t.lastIDs = append(t.lastIDs, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.lastID = t.lastIDs[len(t.lastIDs)-1]
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) listStr(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
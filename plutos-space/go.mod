@@ -8,12 +8,11 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/rs/cors v1.11.1
 	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/crypto v0.31.0
 )
 
 require (
 	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
-	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 )
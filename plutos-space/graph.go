@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+const (
+	defaultGraphNodes = 50
+	maxGraphNodes     = 500
+)
+
+// graphHandler serves the communication graph of the packets matching the
+// same filters as /packets.
+func graphHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	search, ok := parseSearch(w, r, models.PacketSearch)
+	if !ok {
+		return
+	}
+
+	// The same time syntax as /packets; the last hour by default.
+	var bounds models.Page
+	if err := parseBounds(r, &bounds); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := bounds.To
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	from := bounds.From
+	if from.IsZero() {
+		from = to.Add(-time.Hour)
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	intParam := func(name string, def, min, max int) (int, bool) {
+		v := query.Get(name)
+		if v == "" {
+			return def, true
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			http.Error(w, fmt.Sprintf("'%s' must be an integer from %d to %d", name, min, max), http.StatusBadRequest)
+			return 0, false
+		}
+		return n, true
+	}
+
+	v4, ok := intParam("cidr", 32, 0, 32)
+	if !ok {
+		return
+	}
+	v6, ok := intParam("cidr6", 128, 0, 128)
+	if !ok {
+		return
+	}
+	maxNodes, ok := intParam("max_nodes", defaultGraphNodes, 2, maxGraphNodes)
+	if !ok {
+		return
+	}
+
+	where, params := packetsWhere(r, search, models.Page{From: from, To: to})
+	graph, err := models.NewDB(db).GetGraph(models.GraphQuery{
+		Where:      where,
+		Params:     params,
+		From:       from,
+		To:         to,
+		IPv4Prefix: v4,
+		IPv6Prefix: v6,
+		MaxNodes:   maxNodes,
+	})
+	if err != nil {
+		log.Printf("Error querying graph: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+func hostHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, r, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	profile, err := models.NewDB(db).GetHostProfile(ip.String(), from, to)
+	if err != nil {
+		log.Printf("Error querying profile of host %s: %v", ip, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(profile)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+// incidentPacketPadding widens the packet drill-down window around an
+// incident so the traffic leading up to the first alert is included.
+const incidentPacketPadding = 5 * time.Minute
+
+func incidentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := models.IncidentFilter{
+		Rule:     query.Get("rule"),
+		Host:     query.Get("host"),
+		Assignee: query.Get("assignee"),
+		Page:     page,
+	}
+	if v := query.Get("status"); v != "" {
+		filter.Status = strings.Split(v, ",")
+	}
+	if v := query.Get("severity"); v != "" {
+		filter.Severity = strings.Split(v, ",")
+	}
+
+	incidents, info, err := models.NewDB(db).ListIncidents(filter)
+	if err != nil {
+		log.Printf("Error querying incidents: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"incidents": incidents,
+	}, page, info))
+}
+
+func incidentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	incident, err := models.NewDB(db).GetIncident(id)
+	if err == models.ErrIncidentNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying incident %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(incident)
+}
+
+func updateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req alertUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status != nil && !models.AlertStatuses[*req.Status] {
+		http.Error(w, "status must be one of new, acknowledged, in-progress, resolved, false-positive", http.StatusBadRequest)
+		return
+	}
+	if req.Severity != nil && !models.AlertSeverities[*req.Severity] {
+		http.Error(w, "severity must be one of low, medium, high, critical", http.StatusBadRequest)
+		return
+	}
+	if req.Status == nil && req.Severity == nil && req.Assignee == nil {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	incident, err := models.NewDB(db).UpdateIncident(id, models.AlertUpdate{
+		Status:   req.Status,
+		Severity: req.Severity,
+		Assignee: req.Assignee,
+		Actor:    currentUser(r).Username,
+	})
+	if err == models.ErrIncidentNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating incident %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(incident)
+}
+
+func incidentAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	alerts, info, err := models.NewDB(db).ListAlerts(models.AlertFilter{
+		IncidentID:        id,
+		IncludeSuppressed: true,
+		Page:              page,
+	})
+	if err != nil {
+		log.Printf("Error querying alerts for incident %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"alerts": alerts,
+	}, page, info))
+}
+
+func incidentPacketsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 500
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 10000 {
+			limit = l
+		}
+	}
+
+	database := models.NewDB(db)
+
+	incident, err := database.GetIncident(id)
+	if err == models.ErrIncidentNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying incident %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	packets, err := database.GetIncidentPackets(incident, incidentPacketPadding, limit)
+	if err != nil {
+		log.Printf("Error querying packets for incident %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"packets": packets,
+		"from":    incident.FirstSeen.Add(-incidentPacketPadding),
+		"to":      incident.LastSeen.Add(incidentPacketPadding),
+		"limit":   limit,
+	})
+}
@@ -0,0 +1,172 @@
+package live
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+// event holds the fields of a packet, alert or log entry that filters
+// match on. Each channel's messages are decoded into it once, whatever
+// the number of subscribers.
+type event struct {
+	SrcIP       string `json:"src_ip"`
+	DstIP       string `json:"dst_ip"`
+	SrcPort     uint16 `json:"src_port"`
+	DstPort     uint16 `json:"dst_port"`
+	Protocol    string `json:"protocol"`
+	Sensor      string `json:"sensor"`
+	DeviceName  string `json:"device_name"`
+	PayloadSize int    `json:"payload_size"`
+	Fragments   int    `json:"fragments"`
+	IsMalicious bool   `json:"is_malicious"`
+	ThreatType  string `json:"threat_type"`
+
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+
+	Source   string `json:"source"`
+	LogLevel string `json:"log_level"`
+	Message  string `json:"message"`
+}
+
+func (e *event) flagged() bool {
+	return e.IsMalicious || e.ThreatType != ""
+}
+
+// Filter selects the messages of a subscription. Empty fields match
+// everything; IP and Port match either end of a packet or alert.
+type Filter struct {
+	IP        string `json:"ip,omitempty"`
+	SrcIP     string `json:"src_ip,omitempty"`
+	DstIP     string `json:"dst_ip,omitempty"`
+	Port      uint16 `json:"port,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	Sensor    string `json:"sensor,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Malicious bool   `json:"malicious,omitempty"`
+
+	// Severity is the lowest severity of alerts delivered.
+	Severity string `json:"severity,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+
+	Source string `json:"source,omitempty"`
+	Level  string `json:"level,omitempty"`
+	// Text is matched case-insensitively against the log message.
+	Text string `json:"text,omitempty"`
+}
+
+// channelFilters are the filter fields each channel accepts.
+var channelFilters = map[string][]string{
+	Stats:   {},
+	Packets: {"ip", "src_ip", "dst_ip", "port", "protocol", "sensor", "device", "malicious"},
+	Alerts:  {"ip", "src_ip", "dst_ip", "port", "protocol", "sensor", "severity", "rule"},
+	Logs:    {"source", "level", "text"},
+}
+
+// parseFilter decodes the filter of a subscription to channel, rejecting
+// fields the channel does not have.
+func parseFilter(channel string, raw json.RawMessage) (Filter, error) {
+	var f Filter
+	if len(raw) == 0 || string(raw) == "null" {
+		return f, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return f, fmt.Errorf("filter must be an object")
+	}
+	allowed := channelFilters[channel]
+	for name := range fields {
+		if !containsString(allowed, name) {
+			if len(allowed) == 0 {
+				return f, fmt.Errorf("channel %s takes no filter", channel)
+			}
+			return f, fmt.Errorf("unknown filter %q for channel %s (allowed: %s)", name, channel, strings.Join(allowed, ", "))
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return f, fmt.Errorf("invalid filter: %v", err)
+	}
+
+	for name, ip := range map[string]string{"ip": f.IP, "src_ip": f.SrcIP, "dst_ip": f.DstIP} {
+		if ip != "" && net.ParseIP(ip) == nil {
+			return f, fmt.Errorf("filter %s is not an IP address", name)
+		}
+	}
+	if f.Severity != "" && models.SeverityRank(f.Severity) == 0 {
+		return f, fmt.Errorf("filter severity must be one of low, medium, high, critical")
+	}
+	f.Text = strings.ToLower(f.Text)
+	return f, nil
+}
+
+// match reports whether e passes the filter.
+func (f *Filter) match(e *event) bool {
+	if f.IP != "" && !sameIP(e.SrcIP, f.IP) && !sameIP(e.DstIP, f.IP) {
+		return false
+	}
+	if f.SrcIP != "" && !sameIP(e.SrcIP, f.SrcIP) {
+		return false
+	}
+	if f.DstIP != "" && !sameIP(e.DstIP, f.DstIP) {
+		return false
+	}
+	if f.Port != 0 && e.SrcPort != f.Port && e.DstPort != f.Port {
+		return false
+	}
+	if f.Protocol != "" && !strings.EqualFold(e.Protocol, f.Protocol) {
+		return false
+	}
+	if f.Sensor != "" && e.Sensor != f.Sensor {
+		return false
+	}
+	if f.Device != "" && e.DeviceName != f.Device {
+		return false
+	}
+	if f.Malicious && !e.flagged() {
+		return false
+	}
+	if f.Severity != "" && models.SeverityRank(e.Severity) < models.SeverityRank(f.Severity) {
+		return false
+	}
+	if f.Rule != "" && e.Rule != f.Rule {
+		return false
+	}
+	if f.Source != "" && e.Source != f.Source {
+		return false
+	}
+	if f.Level != "" && !strings.EqualFold(e.LogLevel, f.Level) {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(e.Message), f.Text) {
+		return false
+	}
+	return true
+}
+
+// sameIP compares addresses by value, so IPv6 filters match however the
+// collector wrote the address.
+func sameIP(addr, want string) bool {
+	if addr == want {
+		return true
+	}
+	a, b := net.ParseIP(addr), net.ParseIP(want)
+	return a != nil && b != nil && a.Equal(b)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,472 @@
+// Package live streams traffic statistics, packets, alerts and log entries
+// to WebSocket clients as they arrive from Kafka. Clients subscribe to the
+// channels they want with a filter and a rate limit; see Hub.Serve for the
+// protocol.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Channels a client can subscribe to.
+const (
+	Stats   = "stats"
+	Packets = "packets"
+	Alerts  = "alerts"
+	Logs    = "logs"
+)
+
+// messageTypes are the type of the messages sent on each channel.
+var messageTypes = map[string]string{
+	Stats:   "network_stats",
+	Packets: "new_packet",
+	Alerts:  "new_alert",
+	Logs:    "new_log",
+}
+
+// rateLimits are the default and the highest messages per second a
+// subscription to each channel may ask for. Stats are computed once a
+// second.
+var rateLimits = map[string][2]float64{
+	Stats:   {1, 1},
+	Packets: {100, 1000},
+	Alerts:  {100, 1000},
+	Logs:    {100, 1000},
+}
+
+const (
+	sendQueue    = 256
+	writeTimeout = 10 * time.Second
+	// A client that has not answered a ping within pongTimeout is
+	// disconnected.
+	pingInterval = 30 * time.Second
+	pongTimeout  = 60 * time.Second
+	maxRequest   = 4096
+	rateSlack    = 0.05
+)
+
+// Hub fans the messages of each channel out to the subscribed clients.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+	stats   *statsWindow
+}
+
+// NewHub returns a hub whose stats cover the last window of traffic.
+func NewHub(window time.Duration) *Hub {
+	return &Hub{
+		clients: make(map[*client]struct{}),
+		stats:   newStatsWindow(window),
+	}
+}
+
+// Run sends the stats channel every second until ctx is done.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !h.subscribed(Stats) {
+				continue
+			}
+			data, err := json.Marshal(h.stats.snapshot(now))
+			if err != nil {
+				log.Printf("[Live] Error marshaling stats: %v", err)
+				continue
+			}
+			h.deliver(Stats, data, nil, now)
+		}
+	}
+}
+
+// Consume publishes every message read from in on channel until in is
+// closed. When out is not nil, each message is passed on to it, and out
+// is closed with in.
+func (h *Hub) Consume(channel string, in <-chan []byte, out chan<- []byte) {
+	if out != nil {
+		defer close(out)
+	}
+	for message := range in {
+		h.Publish(channel, message)
+		if out != nil {
+			out <- message
+		}
+	}
+}
+
+// Publish sends a JSON message to the clients subscribed to channel whose
+// filter it passes. Packets also count toward the stats channel.
+func (h *Hub) Publish(channel string, message []byte) {
+	now := time.Now()
+	subscribed := h.subscribed(channel)
+	if channel != Packets && !subscribed {
+		return
+	}
+
+	var e event
+	if err := json.Unmarshal(message, &e); err != nil {
+		log.Printf("[Live] Error unmarshaling %s message: %v", channel, err)
+		return
+	}
+	if channel == Packets {
+		h.stats.add(&e, now)
+	}
+	if subscribed {
+		h.deliver(channel, message, &e, now)
+	}
+}
+
+func (h *Hub) subscribed(channel string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		if c.subscription(channel) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver queues data to the subscribers of channel. e is nil for
+// channels without filters.
+func (h *Hub) deliver(channel string, data json.RawMessage, e *event, now time.Time) {
+	var message []byte
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		c.mu.Lock()
+		s := c.subs[channel]
+		if s == nil || e != nil && !s.filter.match(e) {
+			c.mu.Unlock()
+			continue
+		}
+		if !s.allow(now) {
+			// Stats below one a second are skipped, not dropped.
+			if channel != Stats {
+				s.dropped++
+			}
+			c.mu.Unlock()
+			continue
+		}
+		c.mu.Unlock()
+
+		if message == nil {
+			var err error
+			message, err = json.Marshal(outgoing{
+				Type:      messageTypes[channel],
+				Channel:   channel,
+				Data:      data,
+				Timestamp: now.UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				log.Printf("[Live] Error marshaling %s message: %v", channel, err)
+				return
+			}
+		}
+		if !c.queue(message) {
+			c.mu.Lock()
+			if s := c.subs[channel]; s != nil {
+				s.dropped++
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// outgoing is a message sent to a client. Data is the message as it came
+// from Kafka, or the stats.
+type outgoing struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp string          `json:"timestamp,omitempty"`
+}
+
+// request is a message from a client.
+type request struct {
+	Action  string          `json:"action"`
+	Channel string          `json:"channel"`
+	Filter  json.RawMessage `json:"filter,omitempty"`
+	Rate    float64         `json:"rate,omitempty"`
+}
+
+// reply answers a request.
+type reply struct {
+	Type    string   `json:"type"`
+	Channel string   `json:"channel,omitempty"`
+	Filter  *Filter  `json:"filter,omitempty"`
+	Rate    float64  `json:"rate,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Count   int64    `json:"count,omitempty"`
+	Active  []string `json:"channels,omitempty"`
+}
+
+type subscription struct {
+	filter  Filter
+	rate    float64
+	tokens  float64
+	last    time.Time
+	dropped int64
+}
+
+// allow takes a token from the subscription's bucket, which holds up to a
+// second's worth of messages. rateSlack keeps ticker jitter from holding
+// back stats sent exactly at the rate.
+func (s *subscription) allow(now time.Time) bool {
+	burst := math.Max(s.rate, 1)
+	if !s.last.IsZero() {
+		s.tokens = math.Min(burst, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	}
+	s.last = now
+	if s.tokens < 1-rateSlack {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+type client struct {
+	conn       *websocket.Conn
+	send       chan []byte
+	done       chan struct{}
+	authorized func() bool
+
+	mu   sync.Mutex
+	subs map[string]*subscription
+}
+
+func (c *client) subscription(channel string) *subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[channel]
+}
+
+// queue hands message to the writer without waiting; it returns false
+// when the client is too far behind.
+func (c *client) queue(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// reply hands a reply to the writer, waiting for room in the queue for as
+// long as a write may take.
+func (c *client) reply(r reply) {
+	message, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("[Live] Error marshaling reply: %v", err)
+		return
+	}
+	select {
+	case c.send <- message:
+	case <-time.After(writeTimeout):
+	}
+}
+
+// Serve runs the subscription protocol on an upgraded connection until the
+// client disconnects. New connections are subscribed to stats. Clients
+// send JSON requests:
+//
+//	{"action": "subscribe", "channel": "packets", "filter": {"ip": "10.0.0.5"}, "rate": 50}
+//	{"action": "unsubscribe", "channel": "packets"}
+//	{"action": "list"}
+//
+// Subscribing again replaces the channel's filter and rate. Requests are
+// answered with subscribed, unsubscribed, subscriptions or error messages,
+// and messages a rate limit or a slow connection held back are counted in
+// a dropped message once a second.
+//
+// authorized is called with every ping; once it returns false, e.g. because
+// the session was logged out or the user disabled, the connection is
+// closed.
+func (h *Hub) Serve(conn *websocket.Conn, authorized func() bool) {
+	c := &client{
+		conn:       conn,
+		send:       make(chan []byte, sendQueue),
+		done:       make(chan struct{}),
+		authorized: authorized,
+		subs: map[string]*subscription{
+			Stats: {rate: rateLimits[Stats][0], tokens: 1},
+		},
+	}
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.write()
+	}()
+
+	c.read()
+
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+
+	close(c.done)
+	wg.Wait()
+}
+
+func (c *client) read() {
+	c.conn.SetReadLimit(maxRequest)
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		messageType, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("[Live] Error reading WebSocket message: %v", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+
+		if messageType != websocket.TextMessage {
+			c.reply(reply{Type: "error", Error: "requests must be JSON text messages"})
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.reply(reply{Type: "error", Error: "invalid request: " + err.Error()})
+			continue
+		}
+		c.reply(c.handle(req))
+	}
+}
+
+func (c *client) handle(req request) reply {
+	fail := func(msg string) reply {
+		return reply{Type: "error", Channel: req.Channel, Error: msg}
+	}
+
+	switch req.Action {
+	case "list":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		active := []string{}
+		for _, channel := range []string{Stats, Packets, Alerts, Logs} {
+			if c.subs[channel] != nil {
+				active = append(active, channel)
+			}
+		}
+		return reply{Type: "subscriptions", Active: active}
+
+	case "subscribe", "unsubscribe":
+		limits, ok := rateLimits[req.Channel]
+		if !ok {
+			return fail("channel must be one of stats, packets, alerts, logs")
+		}
+		if req.Action == "unsubscribe" {
+			c.mu.Lock()
+			delete(c.subs, req.Channel)
+			c.mu.Unlock()
+			return reply{Type: "unsubscribed", Channel: req.Channel}
+		}
+
+		filter, err := parseFilter(req.Channel, req.Filter)
+		if err != nil {
+			return fail(err.Error())
+		}
+		rate := req.Rate
+		if rate == 0 {
+			rate = limits[0]
+		}
+		if rate <= 0 || rate > limits[1] {
+			return fail(fmt.Sprintf("rate must be above 0 and at most %g messages per second", limits[1]))
+		}
+
+		c.mu.Lock()
+		c.subs[req.Channel] = &subscription{filter: filter, rate: rate, tokens: math.Max(rate, 1)}
+		c.mu.Unlock()
+		return reply{Type: "subscribed", Channel: req.Channel, Filter: &filter, Rate: rate}
+	}
+	return fail("action must be one of subscribe, unsubscribe, list")
+}
+
+func (c *client) write() {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	report := time.NewTicker(time.Second)
+	defer report.Stop()
+
+	write := func(messageType int, message []byte) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.conn.WriteMessage(messageType, message); err != nil {
+			log.Printf("[Live] Error writing WebSocket message: %v", err)
+			// Unblock the reader so Serve returns.
+			c.conn.Close()
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			if !write(websocket.TextMessage, message) {
+				return
+			}
+		case <-ping.C:
+			if !c.authorized() {
+				message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "credentials are no longer valid")
+				write(websocket.CloseMessage, message)
+				// Unblock the reader so Serve returns.
+				c.conn.Close()
+				return
+			}
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		case <-report.C:
+			for _, r := range c.dropped() {
+				message, _ := json.Marshal(r)
+				if !write(websocket.TextMessage, message) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// dropped returns a dropped message for each subscription that held back
+// messages since the last call, and resets the counts.
+func (c *client) dropped() []reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var replies []reply
+	for channel, s := range c.subs {
+		if s.dropped > 0 {
+			replies = append(replies, reply{Type: "dropped", Channel: channel, Count: s.dropped})
+			s.dropped = 0
+		}
+	}
+	return replies
+}
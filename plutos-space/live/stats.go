@@ -0,0 +1,199 @@
+package live
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStatsHosts bounds the addresses counted individually over the
+	// window; packets of further addresses still count toward the totals.
+	maxStatsHosts = 65536
+	statsTop      = 10
+)
+
+type trafficCount struct {
+	packets int64
+	bytes   int64
+}
+
+type trafficCounts map[string]*trafficCount
+
+func (c trafficCounts) add(key string, bytes int64) {
+	t, ok := c[key]
+	if !ok {
+		t = &trafficCount{}
+		c[key] = t
+	}
+	t.packets++
+	t.bytes += bytes
+}
+
+// top returns the busiest keys by packets as rows keyed by name.
+func (c trafficCounts) top(name string, n int) []map[string]interface{} {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c[keys[i]].packets != c[keys[j]].packets {
+			return c[keys[i]].packets > c[keys[j]].packets
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	rows := []map[string]interface{}{}
+	for _, k := range keys {
+		rows = append(rows, map[string]interface{}{
+			name:           k,
+			"packet_count": c[k].packets,
+			"total_bytes":  c[k].bytes,
+		})
+	}
+	return rows
+}
+
+// statsBucket is the traffic of one second of the window.
+type statsBucket struct {
+	second                      int64
+	packets, bytes, malicious   int64
+	protocols, sources, targets trafficCounts
+}
+
+func newStatsBucket(second int64) *statsBucket {
+	return &statsBucket{
+		second:    second,
+		protocols: trafficCounts{},
+		sources:   trafficCounts{},
+		targets:   trafficCounts{},
+	}
+}
+
+// statsWindow keeps traffic statistics over a sliding window of whole
+// seconds. Totals are updated as packets arrive and as seconds leave the
+// window, so a snapshot costs no more than sorting the hosts.
+type statsWindow struct {
+	mu      sync.Mutex
+	buckets []*statsBucket
+	total   *statsBucket
+}
+
+func newStatsWindow(window time.Duration) *statsWindow {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w := &statsWindow{
+		buckets: make([]*statsBucket, seconds),
+		total:   newStatsBucket(0),
+	}
+	for i := range w.buckets {
+		w.buckets[i] = newStatsBucket(0)
+	}
+	return w
+}
+
+// bucket returns the bucket of second, first expiring what it held from
+// an earlier pass through the ring. The caller must hold w.mu.
+func (w *statsWindow) bucket(second int64) *statsBucket {
+	b := w.buckets[int(second%int64(len(w.buckets)))]
+	if b.second != second {
+		w.expire(b)
+		*b = *newStatsBucket(second)
+	}
+	return b
+}
+
+// expire takes the traffic of b out of the totals. The caller must hold
+// w.mu.
+func (w *statsWindow) expire(b *statsBucket) {
+	w.total.packets -= b.packets
+	w.total.bytes -= b.bytes
+	w.total.malicious -= b.malicious
+	for _, pair := range [][2]trafficCounts{
+		{w.total.protocols, b.protocols},
+		{w.total.sources, b.sources},
+		{w.total.targets, b.targets},
+	} {
+		total, counts := pair[0], pair[1]
+		for k, c := range counts {
+			t := total[k]
+			t.packets -= c.packets
+			t.bytes -= c.bytes
+			if t.packets <= 0 {
+				delete(total, k)
+			}
+		}
+	}
+}
+
+func (w *statsWindow) add(e *event, now time.Time) {
+	// Reassembled datagrams are already counted by their fragments.
+	if e.Fragments > 0 {
+		return
+	}
+	bytes := int64(e.PayloadSize)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := w.bucket(now.Unix())
+	for _, x := range []*statsBucket{b, w.total} {
+		x.packets++
+		x.bytes += bytes
+		if e.flagged() {
+			x.malicious++
+		}
+	}
+
+	count := func(key string, bucket, total trafficCounts) {
+		if key == "" {
+			return
+		}
+		if _, ok := total[key]; !ok && len(total) >= maxStatsHosts {
+			return
+		}
+		bucket.add(key, bytes)
+		total.add(key, bytes)
+	}
+	count(e.Protocol, b.protocols, w.total.protocols)
+	count(e.SrcIP, b.sources, w.total.sources)
+	count(e.DstIP, b.targets, w.total.targets)
+}
+
+// snapshot returns the statistics of the window ending at now, shaped like
+// the network_stats message the server used to build from Postgres.
+func (w *statsWindow) snapshot(now time.Time) map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	second := now.Unix()
+	oldest := second - int64(len(w.buckets)) + 1
+	for _, b := range w.buckets {
+		if b.second < oldest && b.packets > 0 {
+			w.expire(b)
+			*b = *newStatsBucket(0)
+		}
+	}
+
+	window := time.Duration(len(w.buckets)) * time.Second
+	t := w.total
+	return map[string]interface{}{
+		"packet_count":       t.packets,
+		"total_bytes":        t.bytes,
+		"malicious_packets":  t.malicious,
+		"unique_src_ips":     len(t.sources),
+		"unique_dst_ips":     len(t.targets),
+		"packets_per_second": float64(t.packets) / window.Seconds(),
+		"bytes_per_second":   float64(t.bytes) / window.Seconds(),
+		"protocols":          t.protocols.top("protocol", statsTop),
+		"top_sources":        t.sources.top("src_ip", statsTop),
+		"top_destinations":   t.targets.top("dst_ip", statsTop),
+		"period_start":       time.Unix(oldest, 0).UTC().Format(time.RFC3339),
+		"period_end":         now.UTC().Format(time.RFC3339),
+	}
+}
@@ -10,16 +10,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/h3bzzz/pluto/plutos-space/evidence"
+	"github.com/h3bzzz/pluto/plutos-space/live"
 	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/notifier"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/h3bzzz/pluto/plutos-space/scheduler"
+	"github.com/h3bzzz/pluto/plutos-space/sensors"
 	_ "github.com/lib/pq"
 	"github.com/rs/cors"
+	"github.com/segmentio/kafka-go"
 )
 
 var (
@@ -27,27 +33,61 @@ var (
 	port         = flag.Int("port", 8000, "Server port")
 	kafkaAddr    = flag.String("kafka", "kafka:29092", "Kafka-Broker Address")
 	networkTopic = flag.String("network-topic", "network-pluto", "Kafka topic for network data")
+	logTopic     = flag.String("log-topic", "log-data", "Kafka topic for log data streamed to WebSocket clients")
+	alertTopic   = flag.String("alert-topic", "alerts", "Kafka topic alerts are published to and streamed to WebSocket clients from")
+	statsWindow  = flag.Duration("live-stats-window", 5*time.Minute, "Traffic covered by the stats pushed to WebSocket clients")
+	controlTopic = flag.String("control-topic", "pluto-control", "Kafka topic settings changes are published on for the collector")
+	proxiesFlag  = flag.String("trusted-proxies", "", "Comma-separated addresses, networks or hostnames of reverse proxies whose X-Real-IP and X-Forwarded-For headers are trusted")
+	originsFlag  = flag.String("allowed-origins", "http://localhost:3000", "Comma-separated origins allowed to call the API and open WebSockets from a browser")
+	sessionTTL   = flag.Duration("session-ttl", 12*time.Hour, "How long a login session stays valid")
+	reportsDir   = flag.String("reports-dir", "./reports", "Directory scheduled search reports are written to")
+	reportTTL    = flag.Duration("report-retention", 30*24*time.Hour, "How long scheduled search reports are kept")
+	publicURL    = flag.String("public-url", "", "External base URL of the server, used for report links in notifications")
+	sensorsFlag  = flag.String("sensors", "", "Comma-separated name=URL of collectors keeping full packets, e.g. edge=http://10.0.0.5:8087")
+	sensorToken  = flag.String("sensor-token", os.Getenv("PLUTO_PCAP_TOKEN"), "Bearer token for the collectors' packet retrieval API (default $PLUTO_PCAP_TOKEN)")
+	newerSchema  = flag.Bool("allow-newer-schema", false, "Start even if the database schema has migrations newer than this server knows")
+	evidenceFlag = flag.String("evidence-store", "./evidence", "Directory or s3://bucket/prefix?endpoint=URL alert evidence packets are kept in")
 )
 
+var allowedOrigins []string
+
+// requiredSchemaVersion is the lowest schema migration version this server
+// build understands. Bump it whenever a handler starts relying on a newer
+// migration from processor/migrations.
+const requiredSchemaVersion = 19
+
+// latestSchemaVersion is the newest migration in processor/migrations when
+// this server was built; bump it with every new migration. Later
+// migrations may change tables under it.
+const latestSchemaVersion = 19
+
 var db *sql.DB
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:     checkOrigin,
 }
 
-var (
-	clients   = make(map[*websocket.Conn]bool)
-	clientsMu sync.Mutex
-)
+var liveHub *live.Hub
 
 func main() {
 	flag.Parse()
+	allowedOrigins = parseOrigins(*originsFlag)
 
 	var err error
+	if proxies, err = parseProxies(*proxiesFlag); err != nil {
+		log.Fatalf("Invalid -trusted-proxies value: %v", err)
+	}
+	sensorRegistry, err = sensors.Parse(*sensorsFlag, *sensorToken)
+	if err != nil {
+		log.Fatalf("Invalid -sensors value: %v", err)
+	}
+	evidenceStore, err = evidence.OpenStore(*evidenceFlag)
+	if err != nil {
+		log.Fatalf("Invalid -evidence-store value: %v", err)
+	}
+
 	db, err = sql.Open("postgres", *pgConnStr)
 	if err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
@@ -61,34 +101,127 @@ func main() {
 
 	dbWrapper := models.NewDB(db)
 
+	schemaVersion, err := dbWrapper.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read schema version (has the processor migrated the database?): %v", err)
+	}
+	if schemaVersion < requiredSchemaVersion {
+		log.Fatalf("Database schema is at version %d but this server requires at least %d; run `processor migrate up`", schemaVersion, requiredSchemaVersion)
+	}
+	if schemaVersion > latestSchemaVersion {
+		if !*newerSchema {
+			log.Fatalf("Database schema is at version %d, newer than the %d this server knows; upgrade the server or pass -allow-newer-schema", schemaVersion, latestSchemaVersion)
+		}
+		log.Printf("Warning: database schema is at version %d, newer than the %d this server knows", schemaVersion, latestSchemaVersion)
+	}
+	log.Printf("Database schema version %d", schemaVersion)
+
+	if err := ensureAdminUser(dbWrapper); err != nil {
+		log.Fatalf("Failed to create initial admin user: %v", err)
+	}
+
+	liveHub = live.NewHub(*statsWindow)
+
 	consumer := models.NewKafkaConsumer(*kafkaAddr, *networkTopic, "plutos-space-server")
 	consumer.Start()
 	defer consumer.Stop()
 
-	go models.ProcessMessages(dbWrapper, consumer.MessageChan)
+	packets := make(chan []byte, 100)
+	go liveHub.Consume(live.Packets, consumer.MessageChan, packets)
+	go models.ProcessMessages(dbWrapper, packets)
 	log.Printf("Started Kafka consumer for topic: %s", *networkTopic)
 
+	alertConsumer := models.NewKafkaConsumer(*kafkaAddr, *alertTopic, "plutos-space-live-alerts")
+	alertConsumer.Start()
+	defer alertConsumer.Stop()
+	go liveHub.Consume(live.Alerts, alertConsumer.MessageChan, nil)
+
+	logConsumer := models.NewKafkaConsumer(*kafkaAddr, *logTopic, "plutos-space-live-logs")
+	logConsumer.Start()
+	defer logConsumer.Stop()
+	go liveHub.Consume(live.Logs, logConsumer.MessageChan, nil)
+
 	r := mux.NewRouter()
 
 	api := r.PathPrefix("/api").Subrouter()
+	api.Use(auditRequests, requireAuth)
 	api.HandleFunc("/health", healthHandler).Methods("GET")
+	api.HandleFunc("/auth/login", loginHandler).Methods("POST")
+	api.HandleFunc("/auth/logout", logoutHandler).Methods("POST")
+	api.HandleFunc("/auth/me", meHandler).Methods("GET")
+	api.HandleFunc("/auth/password", changePasswordHandler).Methods("PUT")
+	api.HandleFunc("/users", requireRole("admin", usersHandler)).Methods("GET")
+	api.HandleFunc("/users", requireRole("admin", createUserHandler)).Methods("POST")
+	api.HandleFunc("/users/{id:[0-9]+}", requireRole("admin", updateUserHandler)).Methods("PATCH")
+	api.HandleFunc("/users/{id:[0-9]+}", requireRole("admin", deleteUserHandler)).Methods("DELETE")
+	api.HandleFunc("/api-keys", apiKeysHandler).Methods("GET")
+	api.HandleFunc("/api-keys", createAPIKeyHandler).Methods("POST")
+	api.HandleFunc("/api-keys/{id:[0-9]+}", deleteAPIKeyHandler).Methods("DELETE")
+	api.HandleFunc("/audit", requireRole("admin", auditHandler)).Methods("GET")
 	api.HandleFunc("/stats", statsHandler).Methods("GET")
 	api.HandleFunc("/packets", packetsHandler).Methods("GET")
+	api.HandleFunc("/packets/export", requireRole("analyst", exportPacketsHandler)).Methods("GET")
+	api.HandleFunc("/packets/{id:[0-9]+}/pcap", requireRole("analyst", packetPcapHandler)).Methods("GET")
+	api.HandleFunc("/flows/pcap", requireRole("analyst", flowPcapHandler)).Methods("GET")
 	api.HandleFunc("/logs", logsHandler).Methods("GET")
+	api.HandleFunc("/logs/export", requireRole("analyst", exportLogsHandler)).Methods("GET")
+	api.HandleFunc("/sessions", sessionsHandler).Methods("GET")
+	api.HandleFunc("/assets", assetsHandler).Methods("GET")
+	api.HandleFunc("/assets/{id:[0-9]+}", assetHandler).Methods("GET")
+	api.HandleFunc("/assets/{id:[0-9]+}/history", assetHistoryHandler).Methods("GET")
+	api.HandleFunc("/hosts/{ip}", hostHandler).Methods("GET")
 	api.HandleFunc("/top-sources", topSourcesHandler).Methods("GET")
 	api.HandleFunc("/top-destinations", topDestinationsHandler).Methods("GET")
 	api.HandleFunc("/protocols", protocolsHandler).Methods("GET")
+	api.HandleFunc("/top-ports", topPortsHandler).Methods("GET")
 	api.HandleFunc("/packet-timeline", packetTimelineHandler).Methods("GET")
+	api.HandleFunc("/graph", graphHandler).Methods("GET")
 	api.HandleFunc("/alerts", alertsHandler).Methods("GET")
+	api.HandleFunc("/alerts/export", requireRole("analyst", exportAlertsHandler)).Methods("GET")
+	api.HandleFunc("/alerts", requireRole("analyst", createAlertHandler)).Methods("POST")
+	api.HandleFunc("/alerts", requireRole("analyst", updateAlertsHandler)).Methods("PATCH")
+	api.HandleFunc("/alerts/{id:[0-9]+}", alertHandler).Methods("GET")
+	api.HandleFunc("/alerts/{id:[0-9]+}/pcap", requireRole("analyst", alertPcapHandler)).Methods("GET")
+	api.HandleFunc("/alerts/{id:[0-9]+}/evidence", requireRole("analyst", alertEvidenceHandler)).Methods("GET")
+	api.HandleFunc("/alerts/{id:[0-9]+}", requireRole("analyst", updateAlertsHandler)).Methods("PATCH")
+	api.HandleFunc("/alerts/{id:[0-9]+}/comments", requireRole("analyst", addAlertCommentHandler)).Methods("POST")
+	api.HandleFunc("/alert-suppressions", alertSuppressionsHandler).Methods("GET")
+	api.HandleFunc("/alert-suppressions", requireRole("analyst", createAlertSuppressionHandler)).Methods("POST")
+	api.HandleFunc("/alert-suppressions/{id:[0-9]+}", requireRole("analyst", deleteAlertSuppressionHandler)).Methods("DELETE")
+	api.HandleFunc("/incidents", incidentsHandler).Methods("GET")
+	api.HandleFunc("/incidents/{id:[0-9]+}", incidentHandler).Methods("GET")
+	api.HandleFunc("/incidents/{id:[0-9]+}", requireRole("analyst", updateIncidentHandler)).Methods("PATCH")
+	api.HandleFunc("/incidents/{id:[0-9]+}/alerts", incidentAlertsHandler).Methods("GET")
+	api.HandleFunc("/incidents/{id:[0-9]+}/packets", incidentPacketsHandler).Methods("GET")
+	api.HandleFunc("/notification-channels", requireRole("admin", notificationChannelsHandler)).Methods("GET")
+	api.HandleFunc("/notification-channels", requireRole("admin", createNotificationChannelHandler)).Methods("POST")
+	api.HandleFunc("/notification-channels/{id:[0-9]+}", requireRole("admin", notificationChannelHandler)).Methods("GET")
+	api.HandleFunc("/notification-channels/{id:[0-9]+}", requireRole("admin", updateNotificationChannelHandler)).Methods("PUT")
+	api.HandleFunc("/notification-channels/{id:[0-9]+}", requireRole("admin", deleteNotificationChannelHandler)).Methods("DELETE")
+	api.HandleFunc("/notification-channels/{id:[0-9]+}/test", requireRole("admin", testNotificationChannelHandler)).Methods("POST")
+	api.HandleFunc("/notification-channels/{id:[0-9]+}/deliveries", requireRole("admin", notificationDeliveriesHandler)).Methods("GET")
+	api.HandleFunc("/saved-searches", savedSearchesHandler).Methods("GET")
+	api.HandleFunc("/saved-searches", requireRole("analyst", createSavedSearchHandler)).Methods("POST")
+	api.HandleFunc("/saved-searches/{id:[0-9]+}", savedSearchHandler).Methods("GET")
+	api.HandleFunc("/saved-searches/{id:[0-9]+}", requireRole("analyst", updateSavedSearchHandler)).Methods("PUT")
+	api.HandleFunc("/saved-searches/{id:[0-9]+}", requireRole("analyst", deleteSavedSearchHandler)).Methods("DELETE")
+	api.HandleFunc("/saved-searches/{id:[0-9]+}/run", requireRole("analyst", runSavedSearchHandler)).Methods("POST")
+	api.HandleFunc("/saved-searches/{id:[0-9]+}/reports", savedSearchReportsHandler).Methods("GET")
+	api.HandleFunc("/reports/{id:[0-9]+}/download", requireRole("analyst", downloadReportHandler)).Methods("GET")
+	api.HandleFunc("/settings", settingsHandler).Methods("GET")
+	api.HandleFunc("/settings", requireRole("admin", updateSettingsHandler)).Methods("PUT")
+	api.HandleFunc("/settings/history", requireRole("admin", settingsHistoryHandler)).Methods("GET")
+	api.HandleFunc("/retention", retentionHandler).Methods("GET")
+	api.HandleFunc("/retention/{table}", requireRole("admin", updateRetentionHandler)).Methods("PUT")
 
 	r.HandleFunc("/ws", wsHandler)
 
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./static")))
 
 	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
 		AllowCredentials: true,
 	}).Handler(r)
 
@@ -98,7 +231,34 @@ func main() {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	go broadcastNetworkStats(ctx)
+	go liveHub.Run(ctx)
+
+	go runSessionPruner(ctx)
+
+	dispatcher = notifier.NewDispatcher(dbWrapper)
+	go dispatcher.Run(ctx)
+
+	alertWriter = &kafka.Writer{
+		Addr:                   kafka.TCP(*kafkaAddr),
+		Topic:                  *alertTopic,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+	defer alertWriter.Close()
+
+	searchScheduler = scheduler.New(dbWrapper, dispatcher, publishAlert, *reportsDir, *publicURL, *reportTTL)
+	go searchScheduler.Run(ctx)
+
+	go evidence.NewWorker(dbWrapper, sensorRegistry, evidenceStore).Run(ctx)
+
+	controlWriter = &kafka.Writer{
+		Addr:                   kafka.TCP(*kafkaAddr),
+		Topic:                  *controlTopic,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+	defer controlWriter.Close()
+	go runSettingsPublisher(ctx)
 
 	go func() {
 		log.Printf("Starting server on port %d", *port)
@@ -139,93 +299,42 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 	if period == "" {
 		period = "24h"
 	}
+	since := periodSince(period, time.Now().Add(-24*time.Hour))
 
-	var since time.Time
-	switch period {
-	case "1h":
-		since = time.Now().Add(-1 * time.Hour)
-	case "6h":
-		since = time.Now().Add(-6 * time.Hour)
-	case "24h":
-		since = time.Now().Add(-24 * time.Hour)
-	case "7d":
-		since = time.Now().Add(-7 * 24 * time.Hour)
-	case "30d":
-		since = time.Now().Add(-30 * 24 * time.Hour)
-	default:
-		since = time.Now().Add(-24 * time.Hour)
-	}
-
-	rows, err := db.Query(`
-		SELECT
-			COUNT(*) AS packet_count,
-			COUNT(DISTINCT src_ip) AS unique_src_ips,
-			COUNT(DISTINCT dst_ip) AS unique_dst_ips,
-			SUM(payload_size) AS total_bytes,
-			COUNT(CASE WHEN is_malicious THEN 1 END) AS malicious_packets
-		FROM
-			siem.packet_data
-		WHERE
-			timestamp > $1
-	`, since)
+	stats, err := models.NewDB(db).GetNetworkStats(since)
 	if err != nil {
 		log.Printf("Error querying statistics: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"packet_count":      0,
-			"unique_src_ips":    0,
-			"unique_dst_ips":    0,
-			"total_bytes":       0,
-			"malicious_packets": 0,
-			"period":            period,
-			"period_start":      since.Format(time.RFC3339),
-			"period_end":        time.Now().Format(time.RFC3339),
-		})
-		return
-	}
-
-	var packetCount, uniqueSrcIPs, uniqueDstIPs, maliciousPackets int
-	var totalBytes int64
-	if err := rows.Scan(&packetCount, &uniqueSrcIPs, &uniqueDstIPs, &totalBytes, &maliciousPackets); err != nil {
-		log.Printf("Error scanning statistics: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"packet_count":      packetCount,
-		"unique_src_ips":    uniqueSrcIPs,
-		"unique_dst_ips":    uniqueDstIPs,
-		"total_bytes":       totalBytes,
-		"malicious_packets": maliciousPackets,
-		"period":            period,
-		"period_start":      since.Format(time.RFC3339),
-		"period_end":        time.Now().Format(time.RFC3339),
-	})
+	stats["period"] = period
+	json.NewEncoder(w).Encode(stats)
 }
 
-func packetsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	limit := 100
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
-	}
-
-	offset := 0
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
+// periodSince turns one of the dashboard's period names into the start of
+// that period, falling back to def for unknown or missing values.
+func periodSince(period string, def time.Time) time.Time {
+	switch period {
+	case "1h":
+		return time.Now().Add(-1 * time.Hour)
+	case "6h":
+		return time.Now().Add(-6 * time.Hour)
+	case "24h":
+		return time.Now().Add(-24 * time.Hour)
+	case "7d":
+		return time.Now().Add(-7 * 24 * time.Hour)
+	case "30d":
+		return time.Now().Add(-30 * 24 * time.Hour)
+	default:
+		return def
 	}
+}
 
+// packetsWhere builds the WHERE clause for the packet filters of a
+// request: src_ip, dst_ip, protocol, the search query and the page's time
+// bounds.
+func packetsWhere(r *http.Request, search *query.Query, page models.Page) (string, []interface{}) {
 	filterClauses := []string{"1=1"}
 	filterParams := []interface{}{}
 	paramIndex := 1
@@ -248,34 +357,51 @@ func packetsHandler(w http.ResponseWriter, r *http.Request) {
 		paramIndex++
 	}
 
-	whereClause := ""
-	for i, clause := range filterClauses {
-		if i == 0 {
-			whereClause = "WHERE " + clause
-		} else {
-			whereClause += " AND " + clause
-		}
+	if search != nil {
+		var clause string
+		clause, filterParams = search.SQL(filterParams)
+		filterClauses = append(filterClauses, clause)
 	}
 
-	filterParams = append(filterParams, limit, offset)
-	limitOffsetParams := []interface{}{paramIndex, paramIndex + 1}
-	paramIndex += 2
+	filterClauses, filterParams = page.Bounds("timestamp", filterClauses, filterParams)
+	return "WHERE " + strings.Join(filterClauses, " AND "), filterParams
+}
+
+func packetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	search, ok := parseSearch(w, r, models.PacketSearch)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
 
+	whereClause, filterParams := packetsWhere(r, search, page)
+
+	info, err := models.NewDB(db).CountRows(page.Count, "siem.packet_data", whereClause, filterParams)
+	if err != nil {
+		log.Printf("Error counting packets: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	pageWhere, pageParams := page.Keyset("timestamp", whereClause, filterParams)
 	query := fmt.Sprintf(`
 		SELECT
 			id, timestamp, device_name, src_mac, dst_mac,
 			src_ip, dst_ip, protocol, src_port, dst_port,
 			ip_version, ttl, tcp_flags, payload_size,
-			is_malicious, threat_type
+			is_malicious, threat_type, sensor, app
 		FROM
 			siem.packet_data
 		%s
-		ORDER BY
-			timestamp DESC
-		LIMIT $%d OFFSET $%d
-	`, whereClause, limitOffsetParams[0], limitOffsetParams[1])
+	`, pageWhere)
 
-	rows, err := db.Query(query, filterParams...)
+	rows, err := db.Query(query, pageParams...)
 	if err != nil {
 		log.Printf("Error querying packets: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -283,31 +409,28 @@ func packetsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) FROM siem.packet_data %s
-	`, whereClause)
-
-	var totalCount int
-	if err := db.QueryRow(countQuery, filterParams[:len(filterParams)-2]...).Scan(&totalCount); err != nil {
-		log.Printf("Error counting packets: %v", err)
-		totalCount = 0
-	}
-
 	packets := []map[string]interface{}{}
+	var last models.Cursor
 	for rows.Next() {
+		if len(packets) == page.Limit {
+			info.NextCursor = last.Encode()
+			break
+		}
+
 		var (
 			id, srcPort, dstPort, ttl, payloadSize                       sql.NullInt64
 			deviceName, srcMAC, dstMAC, srcIP, dstIP, protocol, tcpFlags sql.NullString
-			ipVersion, threatType                                        sql.NullString
+			ipVersion, threatType, sensor                                sql.NullString
 			timestamp                                                    time.Time
 			isMalicious                                                  sql.NullBool
+			app                                                          []byte
 		)
 
 		if err := rows.Scan(
 			&id, &timestamp, &deviceName, &srcMAC, &dstMAC,
 			&srcIP, &dstIP, &protocol, &srcPort, &dstPort,
 			&ipVersion, &ttl, &tcpFlags, &payloadSize,
-			&isMalicious, &threatType,
+			&isMalicious, &threatType, &sensor, &app,
 		); err != nil {
 			log.Printf("Error scanning packet row: %v", err)
 			continue
@@ -330,36 +453,24 @@ func packetsHandler(w http.ResponseWriter, r *http.Request) {
 			"payload_size": nullInt64ToInt(payloadSize),
 			"is_malicious": nullBoolToBool(isMalicious),
 			"threat_type":  nullStringToString(threatType),
+			"sensor":       nullStringToString(sensor),
+		}
+		if app != nil {
+			packet["app"] = json.RawMessage(app)
 		}
 
 		packets = append(packets, packet)
+		last = models.Cursor{Timestamp: timestamp, ID: id.Int64}
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"packets":     packets,
-		"total_count": totalCount,
-		"limit":       limit,
-		"offset":      offset,
-	})
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"packets": packets,
+	}, page, info))
 }
 
-func logsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	limit := 100
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
-	}
-
-	offset := 0
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
-	}
-
+// logsWhere builds the WHERE clause for the log filters of a request:
+// source, log_level, the search query and the page's time bounds.
+func logsWhere(r *http.Request, search *query.Query, page models.Page) (string, []interface{}) {
 	filterClauses := []string{"1=1"}
 	filterParams := []interface{}{}
 	paramIndex := 1
@@ -376,31 +487,48 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
 		paramIndex++
 	}
 
-	whereClause := ""
-	for i, clause := range filterClauses {
-		if i == 0 {
-			whereClause = "WHERE " + clause
-		} else {
-			whereClause += " AND " + clause
-		}
+	if search != nil {
+		var clause string
+		clause, filterParams = search.SQL(filterParams)
+		filterClauses = append(filterClauses, clause)
 	}
 
-	filterParams = append(filterParams, limit, offset)
-	limitOffsetParams := []interface{}{paramIndex, paramIndex + 1}
-	paramIndex += 2
+	filterClauses, filterParams = page.Bounds("timestamp", filterClauses, filterParams)
+	return "WHERE " + strings.Join(filterClauses, " AND "), filterParams
+}
 
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	search, ok := parseSearch(w, r, models.LogSearch)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	whereClause, filterParams := logsWhere(r, search, page)
+
+	info, err := models.NewDB(db).CountRows(page.Count, "siem.log_data", whereClause, filterParams)
+	if err != nil {
+		log.Printf("Error counting logs: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	pageWhere, pageParams := page.Keyset("timestamp", whereClause, filterParams)
 	query := fmt.Sprintf(`
 		SELECT
 			id, timestamp, source, log_level, message, metadata
 		FROM
 			siem.log_data
 		%s
-		ORDER BY
-			timestamp DESC
-		LIMIT $%d OFFSET $%d
-	`, whereClause, limitOffsetParams[0], limitOffsetParams[1])
+	`, pageWhere)
 
-	rows, err := db.Query(query, filterParams...)
+	rows, err := db.Query(query, pageParams...)
 	if err != nil {
 		log.Printf("Error querying logs: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -408,18 +536,14 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) FROM siem.log_data %s
-	`, whereClause)
-
-	var totalCount int
-	if err := db.QueryRow(countQuery, filterParams[:len(filterParams)-2]...).Scan(&totalCount); err != nil {
-		log.Printf("Error counting logs: %v", err)
-		totalCount = 0
-	}
-
 	logs := []map[string]interface{}{}
+	var last models.Cursor
 	for rows.Next() {
+		if len(logs) == page.Limit {
+			info.NextCursor = last.Encode()
+			break
+		}
+
 		var (
 			id                        sql.NullInt64
 			source, logLevel, message sql.NullString
@@ -454,54 +578,30 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		logs = append(logs, logEntry)
+		last = models.Cursor{Timestamp: timestamp, ID: id.Int64}
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"logs":        logs,
-		"total_count": totalCount,
-		"limit":       limit,
-		"offset":      offset,
-	})
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"logs": logs,
+	}, page, info))
 }
 
 func topSourcesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	rows, err := db.Query(`
-		SELECT
-			src_ip, COUNT(*) AS packet_count
-		FROM
-			siem.packet_data
-		GROUP BY
-			src_ip
-		ORDER BY
-			packet_count DESC
-		LIMIT 10
-	`)
+	since := periodSince(r.URL.Query().Get("period"), time.Time{})
+
+	sort, ok := topHostsSort(w, r)
+	if !ok {
+		return
+	}
+
+	topSources, err := models.NewDB(db).GetTopSources(10, since, sort)
 	if err != nil {
 		log.Printf("Error querying top sources: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	topSources := []map[string]interface{}{}
-	for rows.Next() {
-		var (
-			srcIP       string
-			packetCount int
-		)
-
-		if err := rows.Scan(&srcIP, &packetCount); err != nil {
-			log.Printf("Error scanning top source row: %v", err)
-			continue
-		}
-
-		topSources = append(topSources, map[string]interface{}{
-			"src_ip":       srcIP,
-			"packet_count": packetCount,
-		})
-	}
 
 	json.NewEncoder(w).Encode(topSources)
 }
@@ -509,265 +609,80 @@ func topSourcesHandler(w http.ResponseWriter, r *http.Request) {
 func topDestinationsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	rows, err := db.Query(`
-		SELECT
-			dst_ip, COUNT(*) AS packet_count
-		FROM
-			siem.packet_data
-		GROUP BY
-			dst_ip
-		ORDER BY
-			packet_count DESC
-		LIMIT 10
-	`)
+	since := periodSince(r.URL.Query().Get("period"), time.Time{})
+
+	sort, ok := topHostsSort(w, r)
+	if !ok {
+		return
+	}
+
+	topDestinations, err := models.NewDB(db).GetTopDestinations(10, since, sort)
 	if err != nil {
 		log.Printf("Error querying top destinations: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	topDestinations := []map[string]interface{}{}
-	for rows.Next() {
-		var (
-			dstIP       string
-			packetCount int
-		)
-
-		if err := rows.Scan(&dstIP, &packetCount); err != nil {
-			log.Printf("Error scanning top destination row: %v", err)
-			continue
-		}
-
-		topDestinations = append(topDestinations, map[string]interface{}{
-			"dst_ip":       dstIP,
-			"packet_count": packetCount,
-		})
-	}
 
 	json.NewEncoder(w).Encode(topDestinations)
 }
 
-func protocolsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	rows, err := db.Query(`
-		SELECT
-			protocol, COUNT(*) AS packet_count
-		FROM
-			siem.packet_data
-		GROUP BY
-			protocol
-		ORDER BY
-			packet_count DESC
-		LIMIT 10
-	`)
-	if err != nil {
-		log.Printf("Error querying top protocols: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// topHostsSort reads the sort parameter of the top hosts endpoints:
+// packets (the default) or risk.
+func topHostsSort(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		return "packets", true
 	}
-	defer rows.Close()
-
-	topProtocols := []map[string]interface{}{}
-	for rows.Next() {
-		var (
-			protocol    string
-			packetCount int
-		)
-
-		if err := rows.Scan(&protocol, &packetCount); err != nil {
-			log.Printf("Error scanning top protocol row: %v", err)
-			continue
-		}
-
-		topProtocols = append(topProtocols, map[string]interface{}{
-			"protocol":     protocol,
-			"packet_count": packetCount,
-		})
+	if _, ok := models.TopHostOrders[sort]; !ok {
+		http.Error(w, "sort must be one of packets, risk", http.StatusBadRequest)
+		return "", false
 	}
-
-	json.NewEncoder(w).Encode(topProtocols)
+	return sort, true
 }
 
-func packetTimelineHandler(w http.ResponseWriter, r *http.Request) {
+func topPortsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	rows, err := db.Query(`
-		SELECT
-			timestamp, COUNT(*) AS packet_count
-		FROM
-			siem.packet_data
-		GROUP BY
-			timestamp
-		ORDER BY
-			timestamp
-	`)
+	since := periodSince(r.URL.Query().Get("period"), time.Time{})
+
+	topPorts, err := models.NewDB(db).GetTopPorts(10, since)
 	if err != nil {
-		log.Printf("Error querying packet timeline: %v", err)
+		log.Printf("Error querying top ports: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	packetTimeline := []map[string]interface{}{}
-	for rows.Next() {
-		var (
-			timestamp   time.Time
-			packetCount int
-		)
-
-		if err := rows.Scan(&timestamp, &packetCount); err != nil {
-			log.Printf("Error scanning packet timeline row: %v", err)
-			continue
-		}
-
-		packetTimeline = append(packetTimeline, map[string]interface{}{
-			"timestamp":    timestamp.Format(time.RFC3339),
-			"packet_count": packetCount,
-		})
-	}
 
-	json.NewEncoder(w).Encode(packetTimeline)
+	json.NewEncoder(w).Encode(topPorts)
 }
 
-func alertsHandler(w http.ResponseWriter, r *http.Request) {
+func protocolsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	limit := 100
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
-	}
-
-	offset := 0
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
-	}
-
-	filterClauses := []string{"1=1"}
-	filterParams := []interface{}{}
-	paramIndex := 1
-
-	if srcIP := r.URL.Query().Get("src_ip"); srcIP != "" {
-		filterClauses = append(filterClauses, fmt.Sprintf("src_ip = $%d", paramIndex))
-		filterParams = append(filterParams, srcIP)
-		paramIndex++
-	}
+	since := periodSince(r.URL.Query().Get("period"), time.Time{})
 
-	if dstIP := r.URL.Query().Get("dst_ip"); dstIP != "" {
-		filterClauses = append(filterClauses, fmt.Sprintf("dst_ip = $%d", paramIndex))
-		filterParams = append(filterParams, dstIP)
-		paramIndex++
-	}
-
-	if protocol := r.URL.Query().Get("protocol"); protocol != "" {
-		filterClauses = append(filterClauses, fmt.Sprintf("protocol = $%d", paramIndex))
-		filterParams = append(filterParams, protocol)
-		paramIndex++
-	}
-
-	whereClause := ""
-	for i, clause := range filterClauses {
-		if i == 0 {
-			whereClause = "WHERE " + clause
-		} else {
-			whereClause += " AND " + clause
-		}
-	}
-
-	// Add limit and offset parameters
-	filterParams = append(filterParams, limit, offset)
-	limitOffsetParams := []interface{}{paramIndex, paramIndex + 1}
-	paramIndex += 2
-
-	// Query alerts
-	query := fmt.Sprintf(`
-		SELECT
-			id, timestamp, device_name, src_mac, dst_mac,
-			src_ip, dst_ip, protocol, src_port, dst_port,
-			ip_version, ttl, tcp_flags, payload_size,
-			is_malicious, threat_type
-		FROM
-			siem.packet_data
-		%s
-		ORDER BY
-			timestamp DESC
-		LIMIT $%d OFFSET $%d
-	`, whereClause, limitOffsetParams[0], limitOffsetParams[1])
-
-	rows, err := db.Query(query, filterParams...)
+	protocols, err := models.NewDB(db).GetProtocolStats(since)
 	if err != nil {
-		log.Printf("Error querying alerts: %v", err)
+		log.Printf("Error querying top protocols: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) FROM siem.packet_data %s
-	`, whereClause)
 
-	var totalCount int
-	if err := db.QueryRow(countQuery, filterParams[:len(filterParams)-2]...).Scan(&totalCount); err != nil {
-		log.Printf("Error counting alerts: %v", err)
-		totalCount = 0
+	if len(protocols) > 10 {
+		protocols = protocols[:10]
 	}
 
-	alerts := []map[string]interface{}{}
-	for rows.Next() {
-		var (
-			id, srcPort, dstPort, ttl, payloadSize                       sql.NullInt64
-			deviceName, srcMAC, dstMAC, srcIP, dstIP, protocol, tcpFlags sql.NullString
-			ipVersion, threatType                                        sql.NullString
-			timestamp                                                    time.Time
-			isMalicious                                                  sql.NullBool
-		)
-
-		if err := rows.Scan(
-			&id, &timestamp, &deviceName, &srcMAC, &dstMAC,
-			&srcIP, &dstIP, &protocol, &srcPort, &dstPort,
-			&ipVersion, &ttl, &tcpFlags, &payloadSize,
-			&isMalicious, &threatType,
-		); err != nil {
-			log.Printf("Error scanning alert row: %v", err)
-			continue
-		}
+	json.NewEncoder(w).Encode(protocols)
+}
 
-		alert := map[string]interface{}{
-			"id":           nullInt64ToInt(id),
-			"timestamp":    timestamp,
-			"device_name":  nullStringToString(deviceName),
-			"src_mac":      nullStringToString(srcMAC),
-			"dst_mac":      nullStringToString(dstMAC),
-			"src_ip":       nullStringToString(srcIP),
-			"dst_ip":       nullStringToString(dstIP),
-			"protocol":     nullStringToString(protocol),
-			"src_port":     nullInt64ToInt(srcPort),
-			"dst_port":     nullInt64ToInt(dstPort),
-			"ip_version":   nullStringToString(ipVersion),
-			"ttl":          nullInt64ToInt(ttl),
-			"tcp_flags":    nullStringToString(tcpFlags),
-			"payload_size": nullInt64ToInt(payloadSize),
-			"is_malicious": nullBoolToBool(isMalicious),
-			"threat_type":  nullStringToString(threatType),
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := authenticate(r, true); err != nil {
+		if err != models.ErrInvalidCredentials {
+			log.Printf("Error authenticating WebSocket connection: %v", err)
 		}
-
-		alerts = append(alerts, alert)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"alerts":      alerts,
-		"total_count": totalCount,
-		"limit":       limit,
-		"offset":      offset,
-	})
-}
-
-func wsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Error upgrading WebSocket connection: %v", err)
@@ -776,105 +691,16 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	clientsMu.Lock()
-	clients[conn] = true
-	clientsMu.Unlock()
-
-	for {
-		messageType, message, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("Error reading WebSocket message: %v", err)
-			break
-		}
-
-		if messageType == websocket.TextMessage {
-			handleTextMessage(conn, message)
-		} else if messageType == websocket.BinaryMessage {
-			handleBinaryMessage(conn, message)
-		}
-	}
-
-	clientsMu.Lock()
-	delete(clients, conn)
-	clientsMu.Unlock()
-}
-
-func handleTextMessage(conn *websocket.Conn, message []byte) {
-	log.Printf("Received text message: %s", string(message))
-	conn.WriteMessage(websocket.TextMessage, []byte("Message received"))
-}
-
-func handleBinaryMessage(conn *websocket.Conn, message []byte) {
-	log.Printf("Received binary message: %v", message)
-	conn.WriteMessage(websocket.BinaryMessage, []byte("Message received"))
-}
-
-func broadcastNetworkStats(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// Get latest network stats for the last hour
-			dbWrapper := models.NewDB(db)
-			stats, err := dbWrapper.GetNetworkStats(time.Now().Add(-1 * time.Hour))
-			if err != nil {
-				log.Printf("Error getting network stats: %v", err)
-				continue
-			}
-
-			// add packet counts by protocol
-			protocolStats, err := dbWrapper.GetProtocolStats(time.Now().Add(-1 * time.Hour))
-			if err != nil {
-				log.Printf("Error getting protocol stats: %v", err)
-			} else {
-				stats["protocols"] = protocolStats
-			}
-
-			// Add top sources
-			topSources, err := dbWrapper.GetTopSources(10, time.Now().Add(-1*time.Hour))
-			if err != nil {
-				log.Printf("Error getting top sources: %v", err)
-			} else {
-				stats["top_sources"] = topSources
-			}
-
-			// Add top destinations
-			topDestinations, err := dbWrapper.GetTopDestinations(10, time.Now().Add(-1*time.Hour))
-			if err != nil {
-				log.Printf("Error getting top destinations: %v", err)
-			} else {
-				stats["top_destinations"] = topDestinations
-			}
-
-			message := map[string]interface{}{
-				"type":      "network_stats",
-				"data":      stats,
-				"timestamp": time.Now().Format(time.RFC3339),
-			}
-
-			jsonData, err := json.Marshal(message)
-			if err != nil {
-				log.Printf("Error marshaling stats to JSON: %v", err)
-				continue
-			}
-
-			// Broadcast to all clients
-			clientsMu.Lock()
-			for client := range clients {
-				err := client.WriteMessage(websocket.TextMessage, jsonData)
-				if err != nil {
-					log.Printf("Error sending message to client: %v", err)
-					client.Close()
-					delete(clients, client)
-				}
-			}
-			clientsMu.Unlock()
+	// The credential is checked again with every ping, so logging out,
+	// disabling the user or revoking the API key ends the stream.
+	liveHub.Serve(conn, func() bool {
+		_, _, err := authenticate(r, true)
+		if err != nil && err != models.ErrInvalidCredentials {
+			log.Printf("Error re-authenticating WebSocket connection: %v", err)
+			return true
 		}
-	}
+		return err == nil
+	})
 }
 
 func nullInt64ToInt(nullInt sql.NullInt64) int {
@@ -0,0 +1,515 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/lib/pq"
+)
+
+var ErrAlertNotFound = errors.New("alert not found")
+
+var AlertStatuses = map[string]bool{
+	"new":            true,
+	"acknowledged":   true,
+	"in-progress":    true,
+	"resolved":       true,
+	"false-positive": true,
+}
+
+var AlertSeverities = map[string]bool{
+	"low":      true,
+	"medium":   true,
+	"high":     true,
+	"critical": true,
+}
+
+// SeverityRank orders severities from low (1) to critical (4), matching
+// siem.severity_rank. Unknown severities rank 0.
+func SeverityRank(severity string) int {
+	switch severity {
+	case "critical":
+		return 4
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+type Alert struct {
+	ID             int64                  `json:"id"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+	Timestamp      time.Time              `json:"timestamp"`
+	Rule           string                 `json:"rule"`
+	Title          string                 `json:"title"`
+	Description    string                 `json:"description,omitempty"`
+	Severity       string                 `json:"severity"`
+	SrcIP          string                 `json:"src_ip,omitempty"`
+	DstIP          string                 `json:"dst_ip,omitempty"`
+	SrcPort        int                    `json:"src_port,omitempty"`
+	DstPort        int                    `json:"dst_port,omitempty"`
+	Protocol       string                 `json:"protocol,omitempty"`
+	DeviceName     string                 `json:"device_name,omitempty"`
+	Sensor         string                 `json:"sensor,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	Status         string                 `json:"status"`
+	Assignee       string                 `json:"assignee,omitempty"`
+	AcknowledgedAt *time.Time             `json:"acknowledged_at,omitempty"`
+	ResolvedAt     *time.Time             `json:"resolved_at,omitempty"`
+	SuppressedBy   *int64                 `json:"suppressed_by,omitempty"`
+	Occurrences    int                    `json:"occurrences"`
+	LastSeen       time.Time              `json:"last_seen"`
+	IncidentID     *int64                 `json:"incident_id,omitempty"`
+	Comments       []AlertComment         `json:"comments,omitempty"`
+	Evidence       *AlertEvidence         `json:"evidence,omitempty"`
+}
+
+type AlertComment struct {
+	ID        int64     `json:"id"`
+	AlertID   int64     `json:"alert_id"`
+	Kind      string    `json:"kind"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AlertSuppression struct {
+	ID        int64     `json:"id"`
+	Rule      string    `json:"rule,omitempty"`
+	SrcIP     string    `json:"src_ip,omitempty"`
+	DstIP     string    `json:"dst_ip,omitempty"`
+	DstPort   int       `json:"dst_port,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AlertFilter struct {
+	Status            []string
+	Severity          []string
+	Rule              string
+	Assignee          string
+	SrcIP             string
+	DstIP             string
+	IncidentID        int64
+	IncludeSuppressed bool
+	Query             *query.Query
+	Page              Page
+}
+
+// AlertUpdate carries the fields of a bulk update; nil fields are left
+// untouched. An empty Assignee unassigns.
+type AlertUpdate struct {
+	Status   *string
+	Severity *string
+	Assignee *string
+	Actor    string
+}
+
+const alertColumns = `
+	id, created_at, updated_at, timestamp, rule, title, description, severity,
+	src_ip, dst_ip, src_port, dst_port, protocol, device_name, metadata,
+	status, assignee, acknowledged_at, resolved_at, suppressed_by,
+	occurrences, last_seen, incident_id, sensor
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAlert(row rowScanner) (Alert, error) {
+	var (
+		a                                                         Alert
+		description, srcIP, dstIP, protocol, deviceName, assignee sql.NullString
+		sensor                                                    sql.NullString
+		srcPort, dstPort, suppressedBy, incidentID                sql.NullInt64
+		acknowledgedAt, resolvedAt                                sql.NullTime
+		metadata                                                  []byte
+	)
+
+	err := row.Scan(
+		&a.ID, &a.CreatedAt, &a.UpdatedAt, &a.Timestamp, &a.Rule, &a.Title, &description, &a.Severity,
+		&srcIP, &dstIP, &srcPort, &dstPort, &protocol, &deviceName, &metadata,
+		&a.Status, &assignee, &acknowledgedAt, &resolvedAt, &suppressedBy,
+		&a.Occurrences, &a.LastSeen, &incidentID, &sensor,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	a.Description = description.String
+	a.SrcIP = srcIP.String
+	a.DstIP = dstIP.String
+	a.SrcPort = int(srcPort.Int64)
+	a.DstPort = int(dstPort.Int64)
+	a.Protocol = protocol.String
+	a.DeviceName = deviceName.String
+	a.Sensor = sensor.String
+	a.Assignee = assignee.String
+	if acknowledgedAt.Valid {
+		a.AcknowledgedAt = &acknowledgedAt.Time
+	}
+	if resolvedAt.Valid {
+		a.ResolvedAt = &resolvedAt.Time
+	}
+	if suppressedBy.Valid {
+		a.SuppressedBy = &suppressedBy.Int64
+	}
+	if incidentID.Valid {
+		a.IncidentID = &incidentID.Int64
+	}
+	if len(metadata) > 0 {
+		json.Unmarshal(metadata, &a.Metadata)
+	}
+
+	return a, nil
+}
+
+// Where returns the WHERE clause selecting the filter's alerts, ignoring
+// paging other than the time bounds.
+func (f AlertFilter) Where() (string, []interface{}) {
+	clauses := []string{"1=1"}
+	params := []interface{}{}
+
+	add := func(clause string, value interface{}) {
+		params = append(params, value)
+		clauses = append(clauses, fmt.Sprintf(clause, len(params)))
+	}
+
+	if len(f.Status) > 0 {
+		add("status = ANY($%d)", pq.Array(f.Status))
+	}
+	if len(f.Severity) > 0 {
+		add("severity = ANY($%d)", pq.Array(f.Severity))
+	}
+	if f.Rule != "" {
+		add("rule = $%d", f.Rule)
+	}
+	if f.Assignee != "" {
+		add("assignee = $%d", f.Assignee)
+	}
+	if f.SrcIP != "" {
+		add("src_ip = $%d", f.SrcIP)
+	}
+	if f.DstIP != "" {
+		add("dst_ip = $%d", f.DstIP)
+	}
+	if f.IncidentID != 0 {
+		add("incident_id = $%d", f.IncidentID)
+	}
+	if !f.IncludeSuppressed {
+		clauses = append(clauses, "suppressed_by IS NULL")
+	}
+	if f.Query != nil {
+		var clause string
+		clause, params = f.Query.SQL(params)
+		clauses = append(clauses, clause)
+	}
+	clauses, params = f.Page.Bounds("timestamp", clauses, params)
+
+	return "WHERE " + strings.Join(clauses, " AND "), params
+}
+
+// ListAlerts returns a page of matching alerts, newest first.
+func (db *DB) ListAlerts(f AlertFilter) ([]Alert, PageInfo, error) {
+	where, params := f.Where()
+
+	info, err := db.CountRows(f.Page.Count, "siem.alerts", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := f.Page.Keyset("timestamp", where, params)
+	rows, err := db.Query(`SELECT `+alertColumns+` FROM siem.alerts `+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if f.Page.More(len(alerts)) {
+		alerts = alerts[:f.Page.Limit]
+		last := alerts[len(alerts)-1]
+		info.NextCursor = Cursor{last.Timestamp, last.ID}.Encode()
+	}
+	return alerts, info, nil
+}
+
+func (db *DB) GetAlert(id int64) (Alert, error) {
+	a, err := scanAlert(db.QueryRow(`SELECT `+alertColumns+` FROM siem.alerts WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return a, ErrAlertNotFound
+	}
+	if err != nil {
+		return a, err
+	}
+
+	if a.Comments, err = db.GetAlertComments(id); err != nil {
+		return a, err
+	}
+
+	evidence, err := db.GetAlertEvidence(id)
+	if err == ErrEvidenceNotFound {
+		return a, nil
+	}
+	if err != nil {
+		return a, err
+	}
+	a.Evidence = &evidence
+	return a, nil
+}
+
+// AlertMessage is an alert as published on the alert topic, the format the
+// processor's detectors use. The processor stores it, counting repeats of
+// an open alert on the existing row and correlating new alerts into
+// incidents. Fingerprint, when set, replaces the addresses, port and
+// protocol in the key repeats are matched by.
+type AlertMessage struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Rule        string            `json:"rule"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Severity    string            `json:"severity"`
+	SrcIP       string            `json:"src_ip,omitempty"`
+	DstIP       string            `json:"dst_ip,omitempty"`
+	SrcPort     int               `json:"src_port,omitempty"`
+	DstPort     int               `json:"dst_port,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	DeviceName  string            `json:"device_name,omitempty"`
+	Sensor      string            `json:"sensor,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+}
+
+// NewAlertMessage converts a for publishing. Metadata values that are not
+// strings are JSON encoded, since the processor keeps string values only.
+func NewAlertMessage(a Alert, fingerprint string) AlertMessage {
+	if a.Timestamp.IsZero() {
+		a.Timestamp = time.Now()
+	}
+	m := AlertMessage{
+		Timestamp:   a.Timestamp.UTC(),
+		Rule:        a.Rule,
+		Title:       a.Title,
+		Description: a.Description,
+		Severity:    a.Severity,
+		SrcIP:       a.SrcIP,
+		DstIP:       a.DstIP,
+		SrcPort:     a.SrcPort,
+		DstPort:     a.DstPort,
+		Protocol:    a.Protocol,
+		DeviceName:  a.DeviceName,
+		Sensor:      a.Sensor,
+		Fingerprint: fingerprint,
+	}
+	if len(a.Metadata) > 0 {
+		m.Metadata = make(map[string]string, len(a.Metadata))
+		for k, v := range a.Metadata {
+			if s, ok := v.(string); ok {
+				m.Metadata[k] = s
+				continue
+			}
+			encoded, _ := json.Marshal(v)
+			m.Metadata[k] = string(encoded)
+		}
+	}
+	return m
+}
+
+// UpdateAlerts applies the same change to every alert in ids and records a
+// "change" comment on each one describing what changed. It returns the
+// number of alerts updated.
+func (db *DB) UpdateAlerts(ids []int64, u AlertUpdate) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	updated, err := updateAlerts(tx, ids, u)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func updateAlerts(tx *sql.Tx, ids []int64, u AlertUpdate) (int, error) {
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	params := []interface{}{pq.Array(ids)}
+	var changes []string
+
+	if u.Status != nil {
+		params = append(params, *u.Status)
+		n := len(params)
+		sets = append(sets,
+			fmt.Sprintf("status = $%d", n),
+			fmt.Sprintf("acknowledged_at = CASE WHEN $%d <> 'new' THEN COALESCE(acknowledged_at, CURRENT_TIMESTAMP) ELSE acknowledged_at END", n),
+			fmt.Sprintf("resolved_at = CASE WHEN $%d IN ('resolved', 'false-positive') THEN CURRENT_TIMESTAMP ELSE NULL END", n),
+		)
+		changes = append(changes, "status set to "+*u.Status)
+	}
+	if u.Severity != nil {
+		params = append(params, *u.Severity)
+		sets = append(sets, fmt.Sprintf("severity = $%d", len(params)))
+		changes = append(changes, "severity set to "+*u.Severity)
+	}
+	if u.Assignee != nil {
+		params = append(params, *u.Assignee)
+		sets = append(sets, fmt.Sprintf("assignee = NULLIF($%d, '')", len(params)))
+		if *u.Assignee == "" {
+			changes = append(changes, "unassigned")
+		} else {
+			changes = append(changes, "assigned to "+*u.Assignee)
+		}
+	}
+
+	if len(changes) == 0 || len(ids) == 0 {
+		return 0, nil
+	}
+
+	res, err := tx.Exec(fmt.Sprintf(`UPDATE siem.alerts SET %s WHERE id = ANY($1)`, strings.Join(sets, ", ")), params...)
+	if err != nil {
+		return 0, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO siem.alert_comments (alert_id, kind, author, body)
+		SELECT id, 'change', NULLIF($2, ''), $3 FROM siem.alerts WHERE id = ANY($1)
+	`, pq.Array(ids), u.Actor, strings.Join(changes, ", "))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(updated), nil
+}
+
+func (db *DB) AddAlertComment(alertID int64, author, body string) (AlertComment, error) {
+	c := AlertComment{AlertID: alertID, Kind: "note", Author: author, Body: body}
+
+	err := db.QueryRow(`
+		INSERT INTO siem.alert_comments (alert_id, kind, author, body)
+		SELECT id, 'note', NULLIF($2, ''), $3 FROM siem.alerts WHERE id = $1
+		RETURNING id, created_at
+	`, alertID, author, body).Scan(&c.ID, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c, ErrAlertNotFound
+	}
+	return c, err
+}
+
+func (db *DB) GetAlertComments(alertID int64) ([]AlertComment, error) {
+	rows, err := db.Query(`
+		SELECT id, alert_id, kind, author, body, created_at
+		FROM siem.alert_comments
+		WHERE alert_id = $1
+		ORDER BY created_at, id
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []AlertComment{}
+	for rows.Next() {
+		var c AlertComment
+		var author sql.NullString
+		if err := rows.Scan(&c.ID, &c.AlertID, &c.Kind, &author, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.Author = author.String
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
+func (db *DB) ListAlertSuppressions(includeExpired bool) ([]AlertSuppression, error) {
+	query := `
+		SELECT id, rule, src_ip, dst_ip, dst_port, reason, created_by, created_at, expires_at
+		FROM siem.alert_suppressions
+	`
+	if !includeExpired {
+		query += ` WHERE expires_at > CURRENT_TIMESTAMP`
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []AlertSuppression{}
+	for rows.Next() {
+		var s AlertSuppression
+		var rule, srcIP, dstIP, reason, createdBy sql.NullString
+		var dstPort sql.NullInt64
+
+		if err := rows.Scan(&s.ID, &rule, &srcIP, &dstIP, &dstPort, &reason, &createdBy, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.Rule = rule.String
+		s.SrcIP = srcIP.String
+		s.DstIP = dstIP.String
+		s.DstPort = int(dstPort.Int64)
+		s.Reason = reason.String
+		s.CreatedBy = createdBy.String
+		suppressions = append(suppressions, s)
+	}
+
+	return suppressions, rows.Err()
+}
+
+func (db *DB) CreateAlertSuppression(s AlertSuppression) (AlertSuppression, error) {
+	err := db.QueryRow(`
+		INSERT INTO siem.alert_suppressions (rule, src_ip, dst_ip, dst_port, reason, created_by, expires_at)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, ''), NULLIF($6, ''), $7)
+		RETURNING id, created_at
+	`, s.Rule, s.SrcIP, s.DstIP, s.DstPort, s.Reason, s.CreatedBy, s.ExpiresAt).Scan(&s.ID, &s.CreatedAt)
+	return s, err
+}
+
+// ExpireAlertSuppression ends a suppression immediately rather than
+// deleting it, so alerts it muted keep a valid reference.
+func (db *DB) ExpireAlertSuppression(id int64) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE siem.alert_suppressions
+		SET expires_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/lib/pq"
+)
+
+var ErrAssetNotFound = errors.New("asset not found")
+
+// Asset is a host on the local network as the processor learned it from
+// traffic: its MAC from ARP, NDP or DHCP, the vendor of that MAC, a
+// hostname from DHCP, mDNS or reverse DNS, a guessed OS and the ports seen
+// answering connections.
+type Asset struct {
+	ID             int64     `json:"id"`
+	IP             string    `json:"ip"`
+	MAC            string    `json:"mac,omitempty"`
+	Vendor         string    `json:"vendor,omitempty"`
+	Hostname       string    `json:"hostname,omitempty"`
+	HostnameSource string    `json:"hostname_source,omitempty"`
+	OS             string    `json:"os,omitempty"`
+	OSSource       string    `json:"os_source,omitempty"`
+	Services       []string  `json:"services"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Sensor         string    `json:"sensor,omitempty"`
+}
+
+// AssetChange is an entry of an asset's history. Field ip marks when the
+// asset was first seen and service a newly seen open port.
+type AssetChange struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Field     string    `json:"field"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
+}
+
+type AssetFilter struct {
+	IP  string
+	MAC string
+	// Service is an open port, e.g. tcp/22.
+	Service string
+	Sensor  string
+	Query   *query.Query
+	// Page bounds and pages by last_seen.
+	Page Page
+}
+
+const assetColumns = `
+	id, ip, mac, vendor, hostname, hostname_source, os, os_source,
+	services, first_seen, last_seen, sensor
+`
+
+func scanAsset(row rowScanner) (Asset, error) {
+	var (
+		a                                     Asset
+		mac, vendor, hostname, hostnameSource sql.NullString
+		os, osSource, sensor                  sql.NullString
+	)
+
+	err := row.Scan(
+		&a.ID, &a.IP, &mac, &vendor, &hostname, &hostnameSource, &os, &osSource,
+		pq.Array(&a.Services), &a.FirstSeen, &a.LastSeen, &sensor,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	a.MAC = mac.String
+	a.Vendor = vendor.String
+	a.Hostname = hostname.String
+	a.HostnameSource = hostnameSource.String
+	a.OS = os.String
+	a.OSSource = osSource.String
+	a.Sensor = sensor.String
+	if a.Services == nil {
+		a.Services = []string{}
+	}
+	return a, nil
+}
+
+func (f AssetFilter) where() (string, []interface{}) {
+	clauses := []string{"1=1"}
+	params := []interface{}{}
+
+	add := func(clause string, value interface{}) {
+		params = append(params, value)
+		clauses = append(clauses, fmt.Sprintf(clause, len(params)))
+	}
+
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if f.MAC != "" {
+		add("mac = lower($%d)", f.MAC)
+	}
+	if f.Service != "" {
+		add("$%d = ANY(services)", f.Service)
+	}
+	if f.Sensor != "" {
+		add("sensor = $%d", f.Sensor)
+	}
+	if f.Query != nil {
+		var clause string
+		clause, params = f.Query.SQL(params)
+		clauses = append(clauses, clause)
+	}
+	clauses, params = f.Page.Bounds("last_seen", clauses, params)
+
+	return "WHERE " + strings.Join(clauses, " AND "), params
+}
+
+// ListAssets returns a page of matching assets, most recently seen first.
+func (db *DB) ListAssets(f AssetFilter) ([]Asset, PageInfo, error) {
+	where, params := f.where()
+
+	info, err := db.CountRows(f.Page.Count, "siem.assets", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := f.Page.Keyset("last_seen", where, params)
+	rows, err := db.Query(`SELECT `+assetColumns+` FROM siem.assets `+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	assets := []Asset{}
+	for rows.Next() {
+		a, err := scanAsset(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		assets = append(assets, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if f.Page.More(len(assets)) {
+		assets = assets[:f.Page.Limit]
+		last := assets[len(assets)-1]
+		info.NextCursor = Cursor{last.LastSeen, last.ID}.Encode()
+	}
+	return assets, info, nil
+}
+
+func (db *DB) GetAsset(id int64) (Asset, error) {
+	a, err := scanAsset(db.QueryRow(`SELECT `+assetColumns+` FROM siem.assets WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return a, ErrAssetNotFound
+	}
+	return a, err
+}
+
+func (db *DB) GetAssetByIP(ip string) (Asset, error) {
+	a, err := scanAsset(db.QueryRow(`SELECT `+assetColumns+` FROM siem.assets WHERE ip = $1`, ip))
+	if err == sql.ErrNoRows {
+		return a, ErrAssetNotFound
+	}
+	return a, err
+}
+
+// ListAssetHistory returns a page of an asset's changes, newest first.
+func (db *DB) ListAssetHistory(assetID int64, page Page) ([]AssetChange, PageInfo, error) {
+	clauses, params := page.Bounds("timestamp", []string{"asset_id = $1"}, []interface{}{assetID})
+	where := "WHERE " + strings.Join(clauses, " AND ")
+
+	info, err := db.CountRows(page.Count, "siem.asset_history", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := page.Keyset("timestamp", where, params)
+	rows, err := db.Query(`
+		SELECT id, timestamp, field, COALESCE(old_value, ''), COALESCE(new_value, '')
+		FROM siem.asset_history `+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	changes := []AssetChange{}
+	for rows.Next() {
+		var c AssetChange
+		if err := rows.Scan(&c.ID, &c.Timestamp, &c.Field, &c.OldValue, &c.NewValue); err != nil {
+			return nil, info, err
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if page.More(len(changes)) {
+		changes = changes[:page.Limit]
+		last := changes[len(changes)-1]
+		info.NextCursor = Cursor{last.Timestamp, last.ID}.Encode()
+	}
+	return changes, info, nil
+}
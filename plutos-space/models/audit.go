@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEntry records one mutating API request.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id,omitempty"`
+	Actor      string          `json:"actor,omitempty"`
+	Role       string          `json:"role,omitempty"`
+	AuthMethod string          `json:"auth_method"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Path       string          `json:"path"`
+	ResourceID string          `json:"resource_id,omitempty"`
+	Status     int             `json:"status"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	Details    json.RawMessage `json:"details"`
+}
+
+type AuditFilter struct {
+	Actor      string
+	Method     string
+	Route      string
+	ResourceID string
+	// Outcome is "success" (status below 400) or "failure".
+	Outcome string
+	// Page bounds and pages by created_at.
+	Page Page
+}
+
+func (db *DB) RecordAudit(e AuditEntry) error {
+	details := []byte(e.Details)
+	if len(details) == 0 {
+		details = []byte("{}")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO siem.audit_log (
+			actor_id, actor, role, auth_method, method, route, path,
+			resource_id, status, remote_addr, user_agent, details
+		) VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7, NULLIF($8, ''), $9, NULLIF($10, ''), NULLIF($11, ''), $12)
+	`, e.ActorID, e.Actor, e.Role, e.AuthMethod, e.Method, e.Route, e.Path,
+		e.ResourceID, e.Status, e.RemoteAddr, e.UserAgent, details)
+	return err
+}
+
+// ListAuditLog returns a page of matching entries, newest first.
+func (db *DB) ListAuditLog(f AuditFilter) ([]AuditEntry, PageInfo, error) {
+	clauses := []string{"1=1"}
+	params := []interface{}{}
+	add := func(clause string, v interface{}) {
+		params = append(params, v)
+		clauses = append(clauses, fmt.Sprintf(clause, len(params)))
+	}
+
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Method != "" {
+		add("method = $%d", strings.ToUpper(f.Method))
+	}
+	if f.Route != "" {
+		add("route = $%d", f.Route)
+	}
+	if f.ResourceID != "" {
+		add("resource_id = $%d", f.ResourceID)
+	}
+	switch f.Outcome {
+	case "success":
+		clauses = append(clauses, "status < 400")
+	case "failure":
+		clauses = append(clauses, "status >= 400")
+	}
+	clauses, params = f.Page.Bounds("created_at", clauses, params)
+	where := "WHERE " + strings.Join(clauses, " AND ")
+
+	info, err := db.CountRows(f.Page.Count, "siem.audit_log", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := f.Page.Keyset("created_at", where, params)
+	rows, err := db.Query(`
+		SELECT id, created_at, actor_id, actor, role, auth_method, method, route, path,
+			resource_id, status, remote_addr, user_agent, details
+		FROM siem.audit_log
+		`+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var actorID sql.NullInt64
+		var actor, role, resourceID, remoteAddr, userAgent sql.NullString
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &actorID, &actor, &role, &e.AuthMethod, &e.Method, &e.Route, &e.Path,
+			&resourceID, &e.Status, &remoteAddr, &userAgent, &details); err != nil {
+			return nil, info, err
+		}
+		if actorID.Valid {
+			e.ActorID = &actorID.Int64
+		}
+		e.Actor, e.Role, e.ResourceID = actor.String, role.String, resourceID.String
+		e.RemoteAddr, e.UserAgent = remoteAddr.String, userAgent.String
+		e.Details = details
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if f.Page.More(len(entries)) {
+		entries = entries[:f.Page.Limit]
+		last := entries[len(entries)-1]
+		info.NextCursor = Cursor{last.CreatedAt, last.ID}.Encode()
+	}
+	return entries, info, nil
+}
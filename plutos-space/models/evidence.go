@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrEvidenceNotFound = errors.New("alert has no evidence")
+
+// AlertEvidence is the raw traffic kept for an alert: the packets of its
+// flow from WindowStart to WindowEnd, copied from the sensor's capture
+// buffer to the evidence store under ObjectKey once Status is "stored".
+type AlertEvidence struct {
+	AlertID       int64      `json:"alert_id"`
+	Sensor        string     `json:"sensor,omitempty"`
+	WindowStart   time.Time  `json:"window_start"`
+	WindowEnd     time.Time  `json:"window_end"`
+	Status        string     `json:"status"`
+	PinID         string     `json:"-"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"-"`
+	Error         string     `json:"error,omitempty"`
+	ObjectKey     string     `json:"-"`
+	Packets       int        `json:"packets,omitempty"`
+	SizeBytes     int64      `json:"size_bytes,omitempty"`
+	SHA256        string     `json:"sha256,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StoredAt      *time.Time `json:"stored_at,omitempty"`
+}
+
+// EvidenceJob is pending evidence with the flow of its alert, which
+// selects the packets to keep.
+type EvidenceJob struct {
+	AlertEvidence
+	SrcIP    string
+	DstIP    string
+	SrcPort  int
+	DstPort  int
+	Protocol string
+}
+
+const evidenceColumns = `
+	alert_id, sensor, window_start, window_end, status, pin_id, attempts, next_attempt_at,
+	error, object_key, packets, size_bytes, sha256, created_at, stored_at`
+
+func scanEvidence(row rowScanner, extra ...interface{}) (AlertEvidence, error) {
+	var (
+		e                                 AlertEvidence
+		sensor, pinID, errText, key, hash sql.NullString
+		packets, size                     sql.NullInt64
+		storedAt                          sql.NullTime
+	)
+	dest := []interface{}{
+		&e.AlertID, &sensor, &e.WindowStart, &e.WindowEnd, &e.Status, &pinID, &e.Attempts, &e.NextAttemptAt,
+		&errText, &key, &packets, &size, &hash, &e.CreatedAt, &storedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return e, err
+	}
+	e.Sensor = sensor.String
+	e.PinID = pinID.String
+	e.Error = errText.String
+	e.ObjectKey = key.String
+	e.Packets = int(packets.Int64)
+	e.SizeBytes = size.Int64
+	e.SHA256 = hash.String
+	if storedAt.Valid {
+		e.StoredAt = &storedAt.Time
+	}
+	return e, nil
+}
+
+// GetAlertEvidence returns the evidence of an alert, or
+// ErrEvidenceNotFound if none was kept for it.
+func (db *DB) GetAlertEvidence(alertID int64) (AlertEvidence, error) {
+	e, err := scanEvidence(db.QueryRow(`SELECT `+evidenceColumns+` FROM siem.alert_evidence WHERE alert_id = $1`, alertID))
+	if err == sql.ErrNoRows {
+		return e, ErrEvidenceNotFound
+	}
+	return e, err
+}
+
+// ClaimEvidence returns up to limit pending evidence rows due at now and
+// moves them lease into the future, so other server instances skip them
+// while they are worked on. Rows not saved before the lease ends are
+// claimed again.
+func (db *DB) ClaimEvidence(now time.Time, lease time.Duration, limit int) ([]EvidenceJob, error) {
+	rows, err := db.Query(`
+		WITH claimed AS (
+			UPDATE siem.alert_evidence SET next_attempt_at = $2
+			WHERE alert_id IN (
+				SELECT alert_id FROM siem.alert_evidence
+				WHERE status = 'pending' AND next_attempt_at <= $1
+				ORDER BY next_attempt_at
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+evidenceColumns+`
+		)
+		SELECT claimed.*, a.src_ip, a.dst_ip, a.src_port, a.dst_port, a.protocol
+		FROM claimed JOIN siem.alerts a ON a.id = claimed.alert_id
+	`, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []EvidenceJob
+	for rows.Next() {
+		var (
+			job                    EvidenceJob
+			srcIP, dstIP, protocol sql.NullString
+			srcPort, dstPort       sql.NullInt64
+		)
+		job.AlertEvidence, err = scanEvidence(rows, &srcIP, &dstIP, &srcPort, &dstPort, &protocol)
+		if err != nil {
+			return nil, err
+		}
+		job.SrcIP = srcIP.String
+		job.DstIP = dstIP.String
+		job.SrcPort = int(srcPort.Int64)
+		job.DstPort = int(dstPort.Int64)
+		job.Protocol = protocol.String
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// SaveEvidence records the progress of a claimed evidence row.
+func (db *DB) SaveEvidence(e AlertEvidence) error {
+	_, err := db.Exec(`
+		UPDATE siem.alert_evidence SET
+			sensor = NULLIF($2, ''), status = $3, pin_id = NULLIF($4, ''), attempts = $5,
+			next_attempt_at = $6, error = NULLIF($7, ''), object_key = NULLIF($8, ''),
+			packets = NULLIF($9, 0), size_bytes = NULLIF($10, 0), sha256 = NULLIF($11, ''),
+			stored_at = $12
+		WHERE alert_id = $1
+	`,
+		e.AlertID, e.Sensor, e.Status, e.PinID, e.Attempts,
+		e.NextAttemptAt, e.Error, e.ObjectKey,
+		e.Packets, e.SizeBytes, e.SHA256,
+		e.StoredAt,
+	)
+	return err
+}
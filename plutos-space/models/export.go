@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/export"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+)
+
+// ExportRows streams the rows of target's table matching where, newest
+// first. target.Where is not applied; where must include it if wanted.
+// Rows are read from the database and written one at a time, so the
+// result is never held in memory. With limit > 0 at most limit rows are
+// exported. start is called with the result columns before the first row
+// and returns the writer rows are written to. It returns the number of
+// rows written.
+func (db *DB) ExportRows(ctx context.Context, target SearchTarget, where string, params []interface{}, limit int, start func([]export.Column) (export.Writer, error)) (int, error) {
+	columns := target.Export
+	if columns == nil {
+		columns = target.Columns
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY timestamp DESC, id DESC`,
+		strings.Join(columns, ", "), target.Table, where)
+	if limit > 0 {
+		params = append(params, limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(params))
+	}
+
+	rows, err := db.QueryContext(ctx, q, params...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	exportColumns := make([]export.Column, len(types))
+	for i, t := range types {
+		exportColumns[i] = export.Column{Name: t.Name(), Kind: export.KindOf(t.DatabaseTypeName())}
+	}
+	w, err := start(exportColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if err := w.WriteRow(values); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// ExportSearch streams the rows of target matching q with a timestamp in
+// (from, to] through ExportRows.
+func (db *DB) ExportSearch(ctx context.Context, target SearchTarget, q *query.Query, from, to time.Time, limit int, start func([]export.Column) (export.Writer, error)) (int, error) {
+	where, params := target.where(q, from, to)
+	return db.ExportRows(ctx, target, where, params, limit, start)
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxGraphEdges bounds the node pairs read for a graph; the heaviest
+	// are kept and the graph is marked truncated.
+	maxGraphEdges = 20000
+	// graphEdgePorts is the number of ports listed per edge.
+	graphEdgePorts = 10
+	// GraphOther is the node the smallest nodes are folded into.
+	GraphOther = "other"
+)
+
+// GraphQuery selects the packets of a communication graph. Where and
+// Params are a WHERE clause over siem.packet_data. Addresses are grouped
+// into networks of IPv4Prefix and IPv6Prefix bits; full-length prefixes
+// keep single hosts. Past MaxNodes, the nodes with the least traffic are
+// folded into one "other" node.
+type GraphQuery struct {
+	Where      string
+	Params     []interface{}
+	From       time.Time
+	To         time.Time
+	IPv4Prefix int
+	IPv6Prefix int
+	MaxNodes   int
+}
+
+// GraphNode is a host or a network, or the "other" node. Members counts
+// the nodes folded into "other".
+type GraphNode struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	Internal        bool   `json:"internal"`
+	Members         int    `json:"members,omitempty"`
+	PacketsSent     int64  `json:"packets_sent"`
+	BytesSent       int64  `json:"bytes_sent"`
+	PacketsReceived int64  `json:"packets_received"`
+	BytesReceived   int64  `json:"bytes_received"`
+}
+
+// GraphEdge is the traffic sent from Source to Target. Ports are the
+// busiest destination ports, e.g. TCP/443.
+type GraphEdge struct {
+	Source    string      `json:"source"`
+	Target    string      `json:"target"`
+	Packets   int64       `json:"packet_count"`
+	Bytes     int64       `json:"total_bytes"`
+	Protocols []string    `json:"protocols"`
+	Ports     []GraphPort `json:"ports"`
+}
+
+type GraphPort struct {
+	Port    string `json:"port"`
+	Packets int64  `json:"packet_count"`
+}
+
+type Graph struct {
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	IPv4Prefix int          `json:"ipv4_prefix"`
+	IPv6Prefix int          `json:"ipv6_prefix"`
+	Nodes      []*GraphNode `json:"nodes"`
+	Edges      []*GraphEdge `json:"edges"`
+	// Truncated is set when the pairs beyond maxGraphEdges were left out.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (n *GraphNode) total() int64 {
+	return n.BytesSent + n.BytesReceived
+}
+
+// graphNodeSQL groups the inet in column into its network, or keeps the
+// host for full-length prefixes.
+func graphNodeSQL(column string, v4, v6 int) string {
+	group := func(bits, prefix int) string {
+		if prefix == bits {
+			return fmt.Sprintf("host(%s)", column)
+		}
+		return fmt.Sprintf("network(set_masklen(%s, %d))::text", column, prefix)
+	}
+	return fmt.Sprintf("CASE WHEN family(%s) = 4 THEN %s ELSE %s END",
+		column, group(32, v4), group(128, v6))
+}
+
+// GetGraph aggregates the matching packets into the traffic between each
+// pair of nodes.
+func (db *DB) GetGraph(q GraphQuery) (*Graph, error) {
+	params := append(q.Params, maxGraphEdges+1)
+	rows, err := db.Query(fmt.Sprintf(`
+		WITH flows AS (
+			SELECT src, dst, protocol, dst_port,
+				COUNT(*) AS packets, COALESCE(SUM(payload_size), 0) AS bytes
+			FROM (
+				SELECT %s AS src, %s AS dst, protocol, dst_port, payload_size
+				FROM (
+					-- Malformed addresses are skipped rather than failing the cast.
+					SELECT siem.try_inet(src_ip) AS src_inet, siem.try_inet(dst_ip) AS dst_inet,
+						protocol, dst_port, payload_size
+					FROM siem.packet_data
+					%s AND COALESCE(fragments, 0) = 0
+				) a
+				WHERE src_inet IS NOT NULL AND dst_inet IS NOT NULL
+			) p
+			GROUP BY src, dst, protocol, dst_port
+		)
+		SELECT src, dst, SUM(packets), SUM(bytes),
+			COALESCE(array_agg(DISTINCT protocol) FILTER (WHERE protocol IS NOT NULL), '{}'),
+			COALESCE((array_agg(protocol || '/' || dst_port ORDER BY packets DESC)
+				FILTER (WHERE protocol IS NOT NULL AND dst_port > 0))[1:%[4]d], '{}'),
+			COALESCE((array_agg(packets ORDER BY packets DESC)
+				FILTER (WHERE protocol IS NOT NULL AND dst_port > 0))[1:%[4]d], '{}')
+		FROM flows
+		GROUP BY src, dst
+		ORDER BY 4 DESC, 3 DESC
+		LIMIT $%d
+	`, graphNodeSQL("src_inet", q.IPv4Prefix, q.IPv6Prefix), graphNodeSQL("dst_inet", q.IPv4Prefix, q.IPv6Prefix),
+		q.Where, graphEdgePorts, len(params)), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := &Graph{
+		From:       q.From,
+		To:         q.To,
+		IPv4Prefix: q.IPv4Prefix,
+		IPv6Prefix: q.IPv6Prefix,
+	}
+
+	var edges []*GraphEdge
+	for rows.Next() {
+		var (
+			e           GraphEdge
+			ports       []string
+			portPackets []int64
+		)
+		if err := rows.Scan(&e.Source, &e.Target, &e.Packets, &e.Bytes,
+			pq.Array(&e.Protocols), pq.Array(&ports), pq.Array(&portPackets)); err != nil {
+			return nil, err
+		}
+		if len(edges) == maxGraphEdges {
+			graph.Truncated = true
+			break
+		}
+		e.Ports = []GraphPort{}
+		for i := range ports {
+			if i < len(portPackets) {
+				e.Ports = append(e.Ports, GraphPort{Port: ports[i], Packets: portPackets[i]})
+			}
+		}
+		edges = append(edges, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	graph.Nodes, graph.Edges = foldGraph(edges, q.MaxNodes)
+	return graph, nil
+}
+
+// foldGraph derives the nodes of edges and, past maxNodes, folds the nodes
+// with the least traffic into "other", merging their edges.
+func foldGraph(edges []*GraphEdge, maxNodes int) ([]*GraphNode, []*GraphEdge) {
+	nodes := map[string]*GraphNode{}
+	node := func(id string) *GraphNode {
+		n, ok := nodes[id]
+		if !ok {
+			n = newGraphNode(id)
+			nodes[id] = n
+		}
+		return n
+	}
+	for _, e := range edges {
+		src, dst := node(e.Source), node(e.Target)
+		src.PacketsSent += e.Packets
+		src.BytesSent += e.Bytes
+		dst.PacketsReceived += e.Packets
+		dst.BytesReceived += e.Bytes
+	}
+
+	ranked := make([]*GraphNode, 0, len(nodes))
+	for _, n := range nodes {
+		ranked = append(ranked, n)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].total() != ranked[j].total() {
+			return ranked[i].total() > ranked[j].total()
+		}
+		if a, b := ranked[i].PacketsSent+ranked[i].PacketsReceived, ranked[j].PacketsSent+ranked[j].PacketsReceived; a != b {
+			return a > b
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	merged := []*GraphEdge{}
+	if maxNodes <= 0 || len(ranked) <= maxNodes {
+		return ranked, append(merged, edges...)
+	}
+
+	folded := map[string]bool{}
+	other := &GraphNode{ID: GraphOther, Type: GraphOther}
+	for _, n := range ranked[maxNodes-1:] {
+		folded[n.ID] = true
+		other.Members++
+		other.PacketsSent += n.PacketsSent
+		other.BytesSent += n.BytesSent
+		other.PacketsReceived += n.PacketsReceived
+		other.BytesReceived += n.BytesReceived
+	}
+	ranked = append(ranked[:maxNodes-1], other)
+
+	byPair := map[[2]string]*GraphEdge{}
+	for _, e := range edges {
+		if folded[e.Source] {
+			e.Source = GraphOther
+		}
+		if folded[e.Target] {
+			e.Target = GraphOther
+		}
+		key := [2]string{e.Source, e.Target}
+		if m, ok := byPair[key]; ok {
+			mergeGraphEdge(m, e)
+			continue
+		}
+		byPair[key] = e
+		merged = append(merged, e)
+	}
+	return ranked, merged
+}
+
+func newGraphNode(id string) *GraphNode {
+	n := &GraphNode{ID: id, Type: "host"}
+	ip := net.ParseIP(id)
+	if strings.Contains(id, "/") {
+		n.Type = "subnet"
+		ip, _, _ = net.ParseCIDR(id)
+	}
+	n.Internal = ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+	return n
+}
+
+// mergeGraphEdge adds the traffic of e to m, keeping the busiest ports of
+// both.
+func mergeGraphEdge(m, e *GraphEdge) {
+	m.Packets += e.Packets
+	m.Bytes += e.Bytes
+
+	for _, p := range e.Protocols {
+		if !containsString(m.Protocols, p) {
+			m.Protocols = append(m.Protocols, p)
+		}
+	}
+	sort.Strings(m.Protocols)
+
+	ports := map[string]int64{}
+	for _, p := range append(m.Ports, e.Ports...) {
+		ports[p.Port] += p.Packets
+	}
+	m.Ports = m.Ports[:0]
+	for port, packets := range ports {
+		m.Ports = append(m.Ports, GraphPort{Port: port, Packets: packets})
+	}
+	sort.Slice(m.Ports, func(i, j int) bool {
+		if m.Ports[i].Packets != m.Ports[j].Packets {
+			return m.Ports[i].Packets > m.Ports[j].Packets
+		}
+		return m.Ports[i].Port < m.Ports[j].Port
+	})
+	if len(m.Ports) > graphEdgePorts {
+		m.Ports = m.Ports[:graphEdgePorts]
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
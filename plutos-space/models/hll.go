@@ -0,0 +1,46 @@
+package models
+
+import "math"
+
+// hllSketch mirrors the processor's HyperLogLog layout: 2^12 one-byte
+// registers. The server only merges and estimates, it never adds values.
+type hllSketch []byte
+
+const hllRegisters = 1 << 12
+
+func newHLLSketch() hllSketch {
+	return make(hllSketch, hllRegisters)
+}
+
+func (s hllSketch) Merge(other []byte) {
+	if len(other) != len(s) {
+		return
+	}
+	for i, v := range other {
+		if v > s[i] {
+			s[i] = v
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct values added to the
+// sketch, using linear counting for small cardinalities.
+func (s hllSketch) Estimate() int64 {
+	m := float64(len(s))
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	var sum float64
+	zeros := 0
+	for _, v := range s {
+		sum += math.Ldexp(1, -int(v))
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// hostProfileTop bounds the lists of a host profile.
+const hostProfileTop = 10
+
+// HostRisk is the risk score the processor keeps for a host, from 0 to
+// 100, with the points of each component it was computed from.
+type HostRisk struct {
+	Score          float64   `json:"score"`
+	AlertScore     float64   `json:"alert_score"`
+	IndicatorScore float64   `json:"indicator_score"`
+	AnomalyScore   float64   `json:"anomaly_score"`
+	Alerts         int       `json:"alerts"`
+	IndicatorHits  int64     `json:"indicator_hits"`
+	Anomalies      int       `json:"anomalies"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// HostProfile is everything known about an address between From and To.
+// Risk and Asset are nil for hosts the processor has not scored or
+// inventoried; Country is the GeoIP country most often recorded for it.
+type HostProfile struct {
+	IP             string         `json:"ip"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	Risk           *HostRisk      `json:"risk"`
+	Asset          *Asset         `json:"asset"`
+	Country        string         `json:"country,omitempty"`
+	Traffic        HostTraffic    `json:"traffic"`
+	Peers          []HostPeer     `json:"peers"`
+	Ports          []HostPort     `json:"ports"`
+	Protocols      []HostProtocol `json:"protocols"`
+	DNSQueries     []HostCount    `json:"dns_queries"`
+	TLSServerNames []HostCount    `json:"tls_server_names"`
+	JA3s           []HostCount    `json:"ja3s"`
+	Alerts         HostAlerts     `json:"alerts"`
+}
+
+// HostTraffic is what the host sent and received per rollup bucket.
+type HostTraffic struct {
+	Resolution      string              `json:"resolution"`
+	SentPackets     int64               `json:"sent_packets"`
+	SentBytes       int64               `json:"sent_bytes"`
+	ReceivedPackets int64               `json:"received_packets"`
+	ReceivedBytes   int64               `json:"received_bytes"`
+	Buckets         []HostTrafficBucket `json:"buckets"`
+}
+
+type HostTrafficBucket struct {
+	Bucket          time.Time `json:"bucket"`
+	SentPackets     int64     `json:"sent_packets"`
+	SentBytes       int64     `json:"sent_bytes"`
+	ReceivedPackets int64     `json:"received_packets"`
+	ReceivedBytes   int64     `json:"received_bytes"`
+}
+
+type HostPeer struct {
+	IP      string `json:"ip"`
+	Packets int64  `json:"packet_count"`
+	Bytes   int64  `json:"total_bytes"`
+}
+
+// HostPort is a port the host connected to (outbound) or was connected on
+// (inbound).
+type HostPort struct {
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	Packets   int64  `json:"packet_count"`
+	Bytes     int64  `json:"total_bytes"`
+}
+
+type HostProtocol struct {
+	Protocol string `json:"protocol"`
+	Packets  int64  `json:"packet_count"`
+	Bytes    int64  `json:"total_bytes"`
+}
+
+// HostCount is a value seen in the host's traffic and how often, e.g. a
+// DNS name it queried.
+type HostCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// HostAlerts counts the unsuppressed alerts the host was source or
+// destination of by severity, with the most recent ones.
+type HostAlerts struct {
+	BySeverity map[string]int `json:"by_severity"`
+	Recent     []Alert        `json:"recent"`
+}
+
+// hostPackets selects sent from the host's packets and received from the
+// packets sent to it, between $2 and $3, as one relation. Reassembled
+// datagrams are left out as their fragments are already there.
+func hostPackets(sent, received string) string {
+	const where = ` FROM siem.packet_data
+		WHERE %s = $1 AND timestamp >= $2 AND timestamp < $3 AND COALESCE(fragments, 0) = 0`
+	return `SELECT ` + sent + fmt.Sprintf(where, "src_ip") +
+		` UNION ALL SELECT ` + received + fmt.Sprintf(where, "dst_ip")
+}
+
+// GetHostRisk returns the risk score of ip, or nil if it has none.
+func (db *DB) GetHostRisk(ip string) (*HostRisk, error) {
+	var r HostRisk
+	err := db.QueryRow(`
+		SELECT score, alert_score, indicator_score, anomaly_score,
+			alerts, indicator_hits, anomalies, updated_at
+		FROM siem.host_risk WHERE ip = $1
+	`, ip).Scan(
+		&r.Score, &r.AlertScore, &r.IndicatorScore, &r.AnomalyScore,
+		&r.Alerts, &r.IndicatorHits, &r.Anomalies, &r.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetHostProfile aggregates the traffic, alerts, inventory and risk of ip
+// between from and to.
+func (db *DB) GetHostProfile(ip string, from, to time.Time) (*HostProfile, error) {
+	p := &HostProfile{IP: ip, From: from, To: to}
+
+	var err error
+	if p.Risk, err = db.GetHostRisk(ip); err != nil {
+		return nil, err
+	}
+	asset, err := db.GetAssetByIP(ip)
+	if err == nil {
+		p.Asset = &asset
+	} else if err != ErrAssetNotFound {
+		return nil, err
+	}
+
+	steps := []func(*HostProfile) error{
+		db.hostTraffic,
+		db.hostCountry,
+		db.hostPeers,
+		db.hostPorts,
+		db.hostProtocols,
+		db.hostDNSQueries,
+		db.hostTLS,
+		db.hostAlerts,
+	}
+	for _, step := range steps {
+		if err := step(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (db *DB) hostTraffic(p *HostProfile) error {
+	resolution := RollupResolution(p.From, p.To)
+	p.Traffic = HostTraffic{Resolution: resolution, Buckets: []HostTrafficBucket{}}
+
+	rows, err := db.Query(`
+		SELECT bucket,
+			SUM(packet_count) FILTER (WHERE direction = 'src'),
+			SUM(total_bytes) FILTER (WHERE direction = 'src'),
+			SUM(packet_count) FILTER (WHERE direction = 'dst'),
+			SUM(total_bytes) FILTER (WHERE direction = 'dst')
+		FROM siem.host_rollup
+		WHERE ip = $1 AND resolution = $2 AND bucket >= $3 AND bucket < $4
+		GROUP BY bucket
+		ORDER BY bucket
+	`, p.IP, resolution, rollupBucketStart(p.From, resolution), p.To.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b HostTrafficBucket
+		var sentPackets, sentBytes, receivedPackets, receivedBytes sql.NullInt64
+		if err := rows.Scan(&b.Bucket, &sentPackets, &sentBytes, &receivedPackets, &receivedBytes); err != nil {
+			return err
+		}
+		b.SentPackets, b.SentBytes = sentPackets.Int64, sentBytes.Int64
+		b.ReceivedPackets, b.ReceivedBytes = receivedPackets.Int64, receivedBytes.Int64
+
+		p.Traffic.SentPackets += b.SentPackets
+		p.Traffic.SentBytes += b.SentBytes
+		p.Traffic.ReceivedPackets += b.ReceivedPackets
+		p.Traffic.ReceivedBytes += b.ReceivedBytes
+		p.Traffic.Buckets = append(p.Traffic.Buckets, b)
+	}
+	return rows.Err()
+}
+
+func (db *DB) hostCountry(p *HostProfile) error {
+	err := db.QueryRow(`
+		SELECT country FROM (`+hostPackets("src_country AS country", "dst_country")+`) c
+		WHERE country IS NOT NULL AND country <> ''
+		GROUP BY country
+		ORDER BY COUNT(*) DESC
+		LIMIT 1
+	`, p.IP, p.From, p.To).Scan(&p.Country)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+func (db *DB) hostPeers(p *HostProfile) error {
+	rows, err := db.Query(`
+		SELECT peer, COUNT(*), COALESCE(SUM(payload_size), 0)
+		FROM (`+hostPackets("dst_ip AS peer, payload_size", "src_ip, payload_size")+`) t
+		WHERE peer IS NOT NULL AND peer <> ''
+		GROUP BY peer
+		ORDER BY 2 DESC
+		LIMIT $4
+	`, p.IP, p.From, p.To, hostProfileTop)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.Peers = []HostPeer{}
+	for rows.Next() {
+		var peer HostPeer
+		if err := rows.Scan(&peer.IP, &peer.Packets, &peer.Bytes); err != nil {
+			return err
+		}
+		p.Peers = append(p.Peers, peer)
+	}
+	return rows.Err()
+}
+
+func (db *DB) hostPorts(p *HostProfile) error {
+	rows, err := db.Query(`
+		SELECT direction, protocol, port, COUNT(*), COALESCE(SUM(payload_size), 0)
+		FROM (`+hostPackets(
+		"'outbound' AS direction, protocol, dst_port AS port, payload_size",
+		"'inbound', protocol, dst_port, payload_size",
+	)+`) t
+		WHERE port > 0
+		GROUP BY direction, protocol, port
+		ORDER BY 4 DESC
+		LIMIT $4
+	`, p.IP, p.From, p.To, 2*hostProfileTop)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.Ports = []HostPort{}
+	for rows.Next() {
+		var port HostPort
+		var protocol sql.NullString
+		if err := rows.Scan(&port.Direction, &protocol, &port.Port, &port.Packets, &port.Bytes); err != nil {
+			return err
+		}
+		port.Protocol = protocol.String
+		p.Ports = append(p.Ports, port)
+	}
+	return rows.Err()
+}
+
+func (db *DB) hostProtocols(p *HostProfile) error {
+	rows, err := db.Query(`
+		SELECT protocol, COUNT(*), COALESCE(SUM(payload_size), 0)
+		FROM (`+hostPackets("protocol, payload_size", "protocol, payload_size")+`) t
+		WHERE protocol IS NOT NULL
+		GROUP BY protocol
+		ORDER BY 2 DESC
+		LIMIT $4
+	`, p.IP, p.From, p.To, hostProfileTop)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.Protocols = []HostProtocol{}
+	for rows.Next() {
+		var proto HostProtocol
+		if err := rows.Scan(&proto.Protocol, &proto.Packets, &proto.Bytes); err != nil {
+			return err
+		}
+		p.Protocols = append(p.Protocols, proto)
+	}
+	return rows.Err()
+}
+
+// hostDNSQueries counts the names the host asked its resolvers for.
+func (db *DB) hostDNSQueries(p *HostProfile) (err error) {
+	p.DNSQueries, err = db.hostCounts(`
+		SELECT name, COUNT(*)
+		FROM siem.packet_data,
+			jsonb_array_elements_text(CASE WHEN jsonb_typeof(dns_query) = 'array' THEN dns_query END) AS name
+		WHERE src_ip = $1 AND timestamp >= $2 AND timestamp < $3 AND dst_port = 53
+		GROUP BY name
+		ORDER BY 2 DESC
+		LIMIT $4
+	`, p.IP, p.From, p.To, hostProfileTop)
+	return err
+}
+
+// hostTLS counts the server names and JA3 fingerprints of the TLS
+// sessions the host opened.
+func (db *DB) hostTLS(p *HostProfile) (err error) {
+	const query = `
+		SELECT app_details->>'%[1]s', COUNT(*)
+		FROM siem.tcp_sessions
+		WHERE client_ip = $1 AND start_time >= $2 AND start_time < $3
+			AND app_details ? '%[1]s'
+		GROUP BY 1
+		ORDER BY 2 DESC
+		LIMIT $4
+	`
+	if p.TLSServerNames, err = db.hostCounts(fmt.Sprintf(query, "tls_sni"), p.IP, p.From, p.To, hostProfileTop); err != nil {
+		return err
+	}
+	p.JA3s, err = db.hostCounts(fmt.Sprintf(query, "tls_ja3"), p.IP, p.From, p.To, hostProfileTop)
+	return err
+}
+
+func (db *DB) hostCounts(query string, args ...interface{}) ([]HostCount, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []HostCount{}
+	for rows.Next() {
+		var c HostCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func (db *DB) hostAlerts(p *HostProfile) error {
+	const where = `
+		WHERE (src_ip = $1 OR dst_ip = $1) AND suppressed_by IS NULL
+			AND timestamp >= $2 AND timestamp < $3
+	`
+	p.Alerts = HostAlerts{BySeverity: map[string]int{}, Recent: []Alert{}}
+
+	rows, err := db.Query(`SELECT severity, COUNT(*) FROM siem.alerts `+where+` GROUP BY severity`, p.IP, p.From, p.To)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var severity string
+		var count int
+		if err := rows.Scan(&severity, &count); err != nil {
+			return err
+		}
+		p.Alerts.BySeverity[severity] = count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	recent, err := db.Query(`SELECT `+alertColumns+` FROM siem.alerts `+where+`
+		ORDER BY timestamp DESC, id DESC LIMIT $4`, p.IP, p.From, p.To, hostProfileTop)
+	if err != nil {
+		return err
+	}
+	defer recent.Close()
+	for recent.Next() {
+		a, err := scanAlert(recent)
+		if err != nil {
+			return err
+		}
+		p.Alerts.Recent = append(p.Alerts.Recent, a)
+	}
+	return recent.Err()
+}
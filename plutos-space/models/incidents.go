@@ -0,0 +1,262 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrIncidentNotFound = errors.New("incident not found")
+
+// Incident groups related alerts. It is created and extended by the
+// processor as alerts arrive; the API only changes its workflow fields.
+type Incident struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Title      string     `json:"title"`
+	Severity   string     `json:"severity"`
+	Status     string     `json:"status"`
+	Assignee   string     `json:"assignee,omitempty"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	AlertCount int        `json:"alert_count"`
+	EventCount int        `json:"event_count"`
+	Rules      []string   `json:"rules"`
+	SrcIPs     []string   `json:"src_ips"`
+	DstIPs     []string   `json:"dst_ips"`
+	Indicators []string   `json:"indicators"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+type IncidentFilter struct {
+	Status   []string
+	Severity []string
+	Rule     string
+	Host     string
+	Assignee string
+	// Page bounds and pages by last_seen.
+	Page Page
+}
+
+const incidentColumns = `
+	id, created_at, updated_at, title, severity, status, assignee,
+	first_seen, last_seen, alert_count, event_count,
+	rules, src_ips, dst_ips, indicators, resolved_at
+`
+
+func scanIncident(row rowScanner) (Incident, error) {
+	var (
+		i          Incident
+		assignee   sql.NullString
+		resolvedAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&i.ID, &i.CreatedAt, &i.UpdatedAt, &i.Title, &i.Severity, &i.Status, &assignee,
+		&i.FirstSeen, &i.LastSeen, &i.AlertCount, &i.EventCount,
+		pq.Array(&i.Rules), pq.Array(&i.SrcIPs), pq.Array(&i.DstIPs), pq.Array(&i.Indicators), &resolvedAt,
+	)
+	if err != nil {
+		return i, err
+	}
+
+	i.Assignee = assignee.String
+	if resolvedAt.Valid {
+		i.ResolvedAt = &resolvedAt.Time
+	}
+	return i, nil
+}
+
+func (f IncidentFilter) where() (string, []interface{}) {
+	clauses := []string{"1=1"}
+	params := []interface{}{}
+
+	add := func(clause string, value interface{}) {
+		params = append(params, value)
+		clauses = append(clauses, fmt.Sprintf(clause, len(params)))
+	}
+
+	if len(f.Status) > 0 {
+		add("status = ANY($%d)", pq.Array(f.Status))
+	}
+	if len(f.Severity) > 0 {
+		add("severity = ANY($%d)", pq.Array(f.Severity))
+	}
+	if f.Rule != "" {
+		add("$%d = ANY(rules)", f.Rule)
+	}
+	if f.Host != "" {
+		add("($%[1]d = ANY(src_ips) OR $%[1]d = ANY(dst_ips))", f.Host)
+	}
+	if f.Assignee != "" {
+		add("assignee = $%d", f.Assignee)
+	}
+	clauses, params = f.Page.Bounds("last_seen", clauses, params)
+
+	return "WHERE " + strings.Join(clauses, " AND "), params
+}
+
+// ListIncidents returns incidents ordered by most recent activity, and the
+// total number matching the filter.
+// ListIncidents returns a page of matching incidents by most recent
+// activity.
+func (db *DB) ListIncidents(f IncidentFilter) ([]Incident, PageInfo, error) {
+	where, params := f.where()
+
+	info, err := db.CountRows(f.Page.Count, "siem.incidents", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := f.Page.Keyset("last_seen", where, params)
+	rows, err := db.Query(`SELECT `+incidentColumns+` FROM siem.incidents `+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	incidents := []Incident{}
+	for rows.Next() {
+		i, err := scanIncident(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		incidents = append(incidents, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if f.Page.More(len(incidents)) {
+		incidents = incidents[:f.Page.Limit]
+		last := incidents[len(incidents)-1]
+		info.NextCursor = Cursor{last.LastSeen, last.ID}.Encode()
+	}
+	return incidents, info, nil
+}
+
+func (db *DB) GetIncident(id int64) (Incident, error) {
+	i, err := scanIncident(db.QueryRow(`SELECT `+incidentColumns+` FROM siem.incidents WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return i, ErrIncidentNotFound
+	}
+	return i, err
+}
+
+// UpdateIncident changes an incident's status, severity or assignee. A
+// status change is applied to its member alerts that are still open as
+// well, so closing an incident clears its alerts from the queue.
+func (db *DB) UpdateIncident(id int64, u AlertUpdate) (Incident, error) {
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	params := []interface{}{id}
+
+	if u.Status != nil {
+		params = append(params, *u.Status)
+		n := len(params)
+		sets = append(sets,
+			fmt.Sprintf("status = $%d", n),
+			fmt.Sprintf("resolved_at = CASE WHEN $%d IN ('resolved', 'false-positive') THEN CURRENT_TIMESTAMP ELSE NULL END", n),
+		)
+	}
+	if u.Severity != nil {
+		params = append(params, *u.Severity)
+		sets = append(sets, fmt.Sprintf("severity = $%d", len(params)))
+	}
+	if u.Assignee != nil {
+		params = append(params, *u.Assignee)
+		sets = append(sets, fmt.Sprintf("assignee = NULLIF($%d, '')", len(params)))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Incident{}, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`UPDATE siem.incidents SET %s WHERE id = $1 RETURNING %s`, strings.Join(sets, ", "), incidentColumns)
+	incident, err := scanIncident(tx.QueryRow(query, params...))
+	if err == sql.ErrNoRows {
+		return incident, ErrIncidentNotFound
+	}
+	if err != nil {
+		return incident, err
+	}
+
+	if u.Status != nil {
+		rows, err := tx.Query(`
+			SELECT id FROM siem.alerts
+			WHERE incident_id = $1 AND status NOT IN ('resolved', 'false-positive') AND status <> $2
+		`, id, *u.Status)
+		if err != nil {
+			return incident, err
+		}
+		var ids []int64
+		for rows.Next() {
+			var alertID int64
+			if err := rows.Scan(&alertID); err != nil {
+				rows.Close()
+				return incident, err
+			}
+			ids = append(ids, alertID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return incident, err
+		}
+
+		if _, err := updateAlerts(tx, ids, AlertUpdate{Status: u.Status, Actor: u.Actor}); err != nil {
+			return incident, err
+		}
+	}
+
+	return incident, tx.Commit()
+}
+
+// GetIncidentPackets returns packets exchanged between the incident's
+// source and destination hosts from pad before its first alert to pad
+// after its last, newest first. Incidents without destinations match any
+// traffic from their sources.
+func (db *DB) GetIncidentPackets(i Incident, pad time.Duration, limit int) ([]NetworkPacket, error) {
+	if i.DstIPs == nil {
+		i.DstIPs = []string{}
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			timestamp, COALESCE(device_name, ''), COALESCE(src_ip, ''), COALESCE(dst_ip, ''),
+			COALESCE(src_port, 0), COALESCE(dst_port, 0), COALESCE(protocol, ''),
+			COALESCE(payload_size, 0), COALESCE(is_malicious, false)
+		FROM siem.packet_data
+		WHERE timestamp >= $1 AND timestamp <= $2
+			AND (
+				(src_ip = ANY($3) AND (cardinality($4::text[]) = 0 OR dst_ip = ANY($4)))
+				OR (dst_ip = ANY($3) AND (cardinality($4::text[]) = 0 OR src_ip = ANY($4)))
+			)
+		ORDER BY timestamp DESC
+		LIMIT $5
+	`, i.FirstSeen.Add(-pad), i.LastSeen.Add(pad), pq.Array(i.SrcIPs), pq.Array(i.DstIPs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	packets := []NetworkPacket{}
+	for rows.Next() {
+		var p NetworkPacket
+		if err := rows.Scan(
+			&p.Timestamp, &p.DeviceName, &p.SrcIP, &p.DstIP,
+			&p.SrcPort, &p.DstPort, &p.Protocol,
+			&p.PayloadSize, &p.IsMalicious,
+		); err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+
+	return packets, rows.Err()
+}
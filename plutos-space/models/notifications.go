@@ -0,0 +1,211 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrChannelExists   = errors.New("a notification channel with that name already exists")
+)
+
+func channelError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrChannelExists
+	}
+	return err
+}
+
+type NotificationChannel struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Enabled     bool            `json:"enabled"`
+	MinSeverity string          `json:"min_severity"`
+	Template    string          `json:"template,omitempty"`
+	Config      json.RawMessage `json:"config"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type NotificationDelivery struct {
+	ID        int64     `json:"id"`
+	ChannelID int64     `json:"channel_id"`
+	AlertID   *int64    `json:"alert_id,omitempty"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const channelColumns = `id, name, type, enabled, min_severity, template, config, created_at, updated_at`
+
+func scanChannel(row rowScanner) (NotificationChannel, error) {
+	var c NotificationChannel
+	var config []byte
+	err := row.Scan(&c.ID, &c.Name, &c.Type, &c.Enabled, &c.MinSeverity, &c.Template, &config, &c.CreatedAt, &c.UpdatedAt)
+	c.Config = config
+	return c, err
+}
+
+func (db *DB) ListNotificationChannels(enabledOnly bool) ([]NotificationChannel, error) {
+	query := `SELECT ` + channelColumns + ` FROM siem.notification_channels`
+	if enabledOnly {
+		query += ` WHERE enabled`
+	}
+	query += ` ORDER BY name`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []NotificationChannel{}
+	for rows.Next() {
+		c, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+
+	return channels, rows.Err()
+}
+
+func (db *DB) GetNotificationChannel(id int64) (NotificationChannel, error) {
+	c, err := scanChannel(db.QueryRow(`SELECT `+channelColumns+` FROM siem.notification_channels WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return c, ErrChannelNotFound
+	}
+	return c, err
+}
+
+func (db *DB) CreateNotificationChannel(c NotificationChannel) (NotificationChannel, error) {
+	created, err := scanChannel(db.QueryRow(`
+		INSERT INTO siem.notification_channels (name, type, enabled, min_severity, template, config)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+channelColumns,
+		c.Name, c.Type, c.Enabled, c.MinSeverity, c.Template, []byte(c.Config),
+	))
+	return created, channelError(err)
+}
+
+func (db *DB) UpdateNotificationChannel(c NotificationChannel) (NotificationChannel, error) {
+	updated, err := scanChannel(db.QueryRow(`
+		UPDATE siem.notification_channels SET
+			name = $2, type = $3, enabled = $4, min_severity = $5, template = $6, config = $7,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+channelColumns,
+		c.ID, c.Name, c.Type, c.Enabled, c.MinSeverity, c.Template, []byte(c.Config),
+	))
+	if err == sql.ErrNoRows {
+		return updated, ErrChannelNotFound
+	}
+	return updated, channelError(err)
+}
+
+func (db *DB) DeleteNotificationChannel(id int64) error {
+	res, err := db.Exec(`DELETE FROM siem.notification_channels WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrChannelNotFound
+	}
+	return err
+}
+
+// RecordNotificationDelivery logs the outcome of sending an alert to a
+// channel. alertID is 0 for test sends.
+func (db *DB) RecordNotificationDelivery(channelID, alertID int64, attempts int, sendErr error) error {
+	status, errText := "sent", ""
+	if sendErr != nil {
+		status, errText = "failed", sendErr.Error()
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO siem.notification_deliveries (channel_id, alert_id, status, attempts, error)
+		VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''))
+	`, channelID, alertID, status, attempts, errText)
+	return err
+}
+
+func (db *DB) ListNotificationDeliveries(channelID int64, limit int) ([]NotificationDelivery, error) {
+	rows, err := db.Query(`
+		SELECT id, channel_id, alert_id, status, attempts, error, created_at
+		FROM siem.notification_deliveries
+		WHERE channel_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []NotificationDelivery{}
+	for rows.Next() {
+		var d NotificationDelivery
+		var alertID sql.NullInt64
+		var errText sql.NullString
+		if err := rows.Scan(&d.ID, &d.ChannelID, &alertID, &d.Status, &d.Attempts, &errText, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if alertID.Valid {
+			d.AlertID = &alertID.Int64
+		}
+		d.Error = errText.String
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (db *DB) PruneNotificationDeliveries(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM siem.notification_deliveries WHERE created_at < $1`, before)
+	return err
+}
+
+// ClaimAlertNotifications marks up to limit alerts not yet picked up for
+// notification as notified at now and returns the unsuppressed ones in id
+// order. Rows claimed by another server instance are skipped.
+func (db *DB) ClaimAlertNotifications(now time.Time, limit int) ([]Alert, error) {
+	rows, err := db.Query(`
+		WITH claimed AS (
+			UPDATE siem.alerts SET notified_at = $1
+			WHERE id IN (
+				SELECT id FROM siem.alerts
+				WHERE notified_at IS NULL
+				ORDER BY id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+alertColumns+`
+		)
+		SELECT * FROM claimed
+		WHERE suppressed_by IS NULL
+		ORDER BY id
+	`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+
+	return alerts, rows.Err()
+}
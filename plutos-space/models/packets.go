@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrPacketNotFound = errors.New("packet not found")
+
+// PacketFlow is what identifies a stored packet on the wire: when and
+// where it was captured and its 5-tuple.
+type PacketFlow struct {
+	Timestamp time.Time
+	Sensor    string
+	SrcIP     string
+	DstIP     string
+	SrcPort   int
+	DstPort   int
+	Protocol  string
+}
+
+func (db *DB) GetPacketFlow(id int64) (PacketFlow, error) {
+	var (
+		f                              PacketFlow
+		sensor, srcIP, dstIP, protocol sql.NullString
+		srcPort, dstPort               sql.NullInt64
+	)
+	err := db.QueryRow(`
+		SELECT timestamp, sensor, src_ip, dst_ip, src_port, dst_port, protocol
+		FROM siem.packet_data
+		WHERE id = $1
+	`, id).Scan(&f.Timestamp, &sensor, &srcIP, &dstIP, &srcPort, &dstPort, &protocol)
+	if err == sql.ErrNoRows {
+		return f, ErrPacketNotFound
+	}
+	f.Sensor = sensor.String
+	f.SrcIP = srcIP.String
+	f.DstIP = dstIP.String
+	f.SrcPort = int(srcPort.Int64)
+	f.DstPort = int(dstPort.Int64)
+	f.Protocol = protocol.String
+	return f, err
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+	// MaxOffset bounds offset paging, which gets slower the deeper it goes;
+	// clients should follow next_cursor instead.
+	MaxOffset = 10000
+	// Estimates at or below this are replaced by an exact count, which is
+	// cheap at that size and avoids misleading totals for narrow filters.
+	exactCountThreshold = 10000
+)
+
+// CountMode selects how list endpoints compute total_count.
+type CountMode string
+
+const (
+	CountExact    CountMode = "exact"
+	CountEstimate CountMode = "estimate"
+	CountNone     CountMode = "none"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor identifies the last row of a page of a list sorted newest first
+// by (timestamp, id). Clients treat its encoding as opaque.
+type Cursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixMicro(), 10) + "." + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	tsPart, idPart, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	micros, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Timestamp: time.UnixMicro(micros).UTC(), ID: id}, nil
+}
+
+// Page selects one page of a list sorted newest first. With After set,
+// the page starts after that row (keyset paging) and Offset is ignored.
+// From and To, if set, bound the list's time column to [From, To).
+type Page struct {
+	Limit  int
+	Offset int
+	After  *Cursor
+	From   time.Time
+	To     time.Time
+	Count  CountMode
+}
+
+// PageInfo describes a page returned by a list query. TotalCount is nil
+// when counting was not requested.
+type PageInfo struct {
+	TotalCount     *int   `json:"total_count"`
+	TotalEstimated bool   `json:"total_estimated,omitempty"`
+	NextCursor     string `json:"next_cursor,omitempty"`
+}
+
+// Bounds adds the page's time bounds on column to a where clause list.
+func (p Page) Bounds(column string, clauses []string, params []interface{}) ([]string, []interface{}) {
+	if !p.From.IsZero() {
+		params = append(params, p.From)
+		clauses = append(clauses, fmt.Sprintf("%s >= $%d", column, len(params)))
+	}
+	if !p.To.IsZero() {
+		params = append(params, p.To)
+		clauses = append(clauses, fmt.Sprintf("%s < $%d", column, len(params)))
+	}
+	return clauses, params
+}
+
+// Keyset extends where (a "WHERE ..." clause over params) to rows after
+// the cursor in (column, id) order, and appends the ORDER BY, LIMIT and
+// OFFSET for the page. One row more than Limit is requested so Next can
+// tell whether another page follows.
+func (p Page) Keyset(column, where string, params []interface{}) (string, []interface{}) {
+	offset := p.Offset
+	if p.After != nil {
+		params = append(params, p.After.Timestamp, p.After.ID)
+		where += fmt.Sprintf(" AND (%s, id) < ($%d, $%d)", column, len(params)-1, len(params))
+		offset = 0
+	}
+	params = append(params, p.Limit+1, offset)
+	return fmt.Sprintf("%s ORDER BY %s DESC, id DESC LIMIT $%d OFFSET $%d", where, column, len(params)-1, len(params)), params
+}
+
+// More reports whether a query run with Keyset returned more than a page,
+// in which case the caller drops the extra row and links to the next page
+// with the cursor of the last row kept.
+func (p Page) More(rows int) bool {
+	return rows > p.Limit
+}
+
+// CountRows counts the rows of table matching where according to mode.
+// Estimates come from the planner and are only used for large results.
+func (db *DB) CountRows(mode CountMode, table, where string, params []interface{}) (PageInfo, error) {
+	var info PageInfo
+	switch mode {
+	case CountNone:
+		return info, nil
+	case CountEstimate:
+		estimate, err := db.estimateRows(`SELECT 1 FROM `+table+` `+where, params)
+		if err != nil {
+			return info, err
+		}
+		if estimate > exactCountThreshold {
+			info.TotalCount = &estimate
+			info.TotalEstimated = true
+			return info, nil
+		}
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+table+` `+where, params...).Scan(&total); err != nil {
+		return info, err
+	}
+	info.TotalCount = &total
+	return info, nil
+}
+
+// estimateRows returns the planner's row estimate for query.
+func (db *DB) estimateRows(query string, params []interface{}) (int, error) {
+	var plan []byte
+	if err := db.QueryRow(`EXPLAIN (FORMAT JSON) `+query, params...).Scan(&plan); err != nil {
+		return 0, err
+	}
+	var parsed []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &parsed); err != nil || len(parsed) == 0 {
+		return 0, fmt.Errorf("unexpected EXPLAIN output: %s", plan)
+	}
+	return int(parsed[0].Plan.Rows), nil
+}
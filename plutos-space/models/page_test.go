@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []Cursor{
+		{Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC), ID: 42},
+		{Timestamp: time.Unix(0, 0).UTC(), ID: 0},
+		{Timestamp: time.Date(1969, 12, 31, 23, 59, 59, 999999000, time.UTC), ID: 1},
+		{Timestamp: time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC), ID: 1<<63 - 1},
+	} {
+		got, err := DecodeCursor(c.Encode())
+		if err != nil {
+			t.Errorf("DecodeCursor(%v): %v", c, err)
+			continue
+		}
+		if got != c {
+			t.Errorf("round trip of %v = %v", c, got)
+		}
+	}
+}
+
+func TestCursorTruncatesToMicroseconds(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.FixedZone("UTC+2", 2*60*60))
+	got, err := DecodeCursor(Cursor{Timestamp: ts, ID: 7}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ts.Truncate(time.Microsecond).UTC(); got.Timestamp != want || got.ID != 7 {
+		t.Errorf("decoded %v; want %v and ID 7", got, want)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, s := range []string{
+		"",
+		"not base64!",
+		encode("1700000000000000"),
+		encode("x.1"),
+		encode("1700000000000000.x"),
+		encode("1700000000000000."),
+		encode(".1"),
+		base64.URLEncoding.EncodeToString([]byte("1700000000000000.12")), // padded
+	} {
+		if c, err := DecodeCursor(s); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) = %v, %v; want ErrInvalidCursor", s, c, err)
+		}
+	}
+}
+
+func TestPageKeyset(t *testing.T) {
+	after := Cursor{Timestamp: time.Unix(1700000000, 0).UTC(), ID: 9}
+	tests := []struct {
+		page   Page
+		sql    string
+		params []interface{}
+	}{
+		{
+			Page{Limit: 50, Offset: 100},
+			"WHERE x = $1 ORDER BY timestamp DESC, id DESC LIMIT $2 OFFSET $3",
+			[]interface{}{"a", 51, 100},
+		},
+		{
+			Page{Limit: 50, Offset: 100, After: &after},
+			"WHERE x = $1 AND (timestamp, id) < ($2, $3) ORDER BY timestamp DESC, id DESC LIMIT $4 OFFSET $5",
+			[]interface{}{"a", after.Timestamp, int64(9), 51, 0},
+		},
+	}
+	for _, tt := range tests {
+		sql, params := tt.page.Keyset("timestamp", "WHERE x = $1", []interface{}{"a"})
+		if sql != tt.sql || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("Keyset(%+v) = %q, %#v; want %q, %#v", tt.page, sql, params, tt.sql, tt.params)
+		}
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	from := time.Unix(1700000000, 0).UTC()
+	to := from.Add(time.Hour)
+
+	clauses, params := Page{From: from, To: to}.Bounds("ts", []string{"a = $1"}, []interface{}{1})
+	if want := []string{"a = $1", "ts >= $2", "ts < $3"}; !reflect.DeepEqual(clauses, want) {
+		t.Errorf("clauses = %q; want %q", clauses, want)
+	}
+	if want := []interface{}{1, from, to}; !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %#v; want %#v", params, want)
+	}
+
+	clauses, params = Page{}.Bounds("ts", nil, nil)
+	if len(clauses) != 0 || len(params) != 0 {
+		t.Errorf("unbounded page added %q, %v", clauses, params)
+	}
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrUnknownRetentionTable = errors.New("unknown retention table")
+
+type RetentionPolicy struct {
+	TableName     string    `json:"table_name"`
+	RetentionDays int       `json:"retention_days"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (db *DB) GetRetentionPolicies() ([]RetentionPolicy, error) {
+	rows, err := db.Query(`
+		SELECT table_name, retention_days, updated_at
+		FROM siem.retention_policy
+		ORDER BY table_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.TableName, &p.RetentionDays, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// SetRetentionDays updates the retention of an existing policy. The
+// processor applies the new value on its next partition maintenance run.
+func (db *DB) SetRetentionDays(table string, days int) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	err := db.QueryRow(`
+		UPDATE siem.retention_policy
+		SET retention_days = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE table_name = $1
+		RETURNING table_name, retention_days, updated_at
+	`, table, days).Scan(&p.TableName, &p.RetentionDays, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return p, ErrUnknownRetentionTable
+	}
+	return p, err
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RollupResolution picks the rollup granularity for a time range: minute
+// buckets for short ranges where precision matters, hour buckets for
+// everything else.
+func RollupResolution(since, until time.Time) string {
+	if !since.IsZero() && until.Sub(since) <= 6*time.Hour {
+		return "1m"
+	}
+	return "1h"
+}
+
+// MinuteRollupsSince returns the time from which the processor keeps minute
+// rollups under the rollup_1m retention policy, or the zero time if it
+// keeps them all.
+func (db *DB) MinuteRollupsSince(now time.Time) (time.Time, error) {
+	var days int
+	err := db.QueryRow(`SELECT retention_days FROM siem.retention_policy WHERE table_name = 'rollup_1m'`).Scan(&days)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.AddDate(0, 0, -days), nil
+}
+
+func rollupBucketStart(since time.Time, resolution string) time.Time {
+	if resolution == "1m" {
+		return since.UTC().Truncate(time.Minute)
+	}
+	return since.UTC().Truncate(time.Hour)
+}
+
+func (db *DB) GetNetworkStats(since time.Time) (map[string]interface{}, error) {
+	until := time.Now()
+	resolution := RollupResolution(since, until)
+
+	rows, err := db.Query(`
+		SELECT
+			packet_count, total_bytes, malicious_packets, src_ip_sketch, dst_ip_sketch
+		FROM
+			siem.traffic_rollup
+		WHERE
+			resolution = $1 AND bucket >= $2
+	`, resolution, rollupBucketStart(since, resolution))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var packetCount, totalBytes, maliciousPackets int64
+	srcIPs, dstIPs := newHLLSketch(), newHLLSketch()
+
+	for rows.Next() {
+		var packets, bytes, malicious int64
+		var srcSketch, dstSketch []byte
+
+		if err := rows.Scan(&packets, &bytes, &malicious, &srcSketch, &dstSketch); err != nil {
+			return nil, err
+		}
+
+		packetCount += packets
+		totalBytes += bytes
+		maliciousPackets += malicious
+		srcIPs.Merge(srcSketch)
+		dstIPs.Merge(dstSketch)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]interface{})
+	stats["packet_count"] = packetCount
+	stats["unique_src_ips"] = srcIPs.Estimate()
+	stats["unique_dst_ips"] = dstIPs.Estimate()
+	stats["total_bytes"] = totalBytes
+	stats["malicious_packets"] = maliciousPackets
+	stats["resolution"] = resolution
+	stats["period_start"] = since.Format(time.RFC3339)
+	stats["period_end"] = until.Format(time.RFC3339)
+
+	return stats, nil
+}
+
+// TopHostOrders maps the sort names accepted by the top hosts API to the
+// ORDER BY of getTopHosts. risk ranks by the processor's host risk score,
+// with unscored hosts last.
+var TopHostOrders = map[string]string{
+	"packets": "packet_count DESC",
+	"risk":    "risk_score DESC, packet_count DESC",
+}
+
+func (db *DB) GetTopSources(limit int, since time.Time, sort string) ([]map[string]interface{}, error) {
+	return db.getTopHosts("src", limit, since, sort)
+}
+
+func (db *DB) GetTopDestinations(limit int, since time.Time, sort string) ([]map[string]interface{}, error) {
+	return db.getTopHosts("dst", limit, since, sort)
+}
+
+func (db *DB) getTopHosts(direction string, limit int, since time.Time, sort string) ([]map[string]interface{}, error) {
+	resolution := RollupResolution(since, time.Now())
+	order, ok := TopHostOrders[sort]
+	if !ok {
+		order = TopHostOrders["packets"]
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			h.ip,
+			SUM(h.packet_count) AS packet_count,
+			SUM(h.total_bytes) AS total_bytes,
+			COALESCE(MAX(r.score), 0) AS risk_score
+		FROM siem.host_rollup h
+		LEFT JOIN siem.host_risk r ON r.ip = h.ip
+		WHERE h.resolution = $1 AND h.bucket >= $2 AND h.direction = $3
+		GROUP BY h.ip
+		ORDER BY `+order+`
+		LIMIT $4
+	`, resolution, rollupBucketStart(since, resolution), direction, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		var ip string
+		var packetCount int64
+		var totalBytes sql.NullInt64
+		var riskScore float64
+
+		if err := rows.Scan(&ip, &packetCount, &totalBytes, &riskScore); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			direction + "_ip": ip,
+			"packet_count":    packetCount,
+			"total_bytes":     totalBytes.Int64,
+			"risk_score":      riskScore,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (db *DB) GetProtocolStats(since time.Time) ([]map[string]interface{}, error) {
+	resolution := RollupResolution(since, time.Now())
+
+	rows, err := db.Query(`
+		SELECT
+			protocol,
+			SUM(packet_count) AS packet_count,
+			SUM(total_bytes) AS total_bytes
+		FROM siem.protocol_rollup
+		WHERE resolution = $1 AND bucket >= $2
+		GROUP BY protocol
+		ORDER BY packet_count DESC
+	`, resolution, rollupBucketStart(since, resolution))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		var protocol string
+		var packetCount int64
+		var totalBytes sql.NullInt64
+
+		if err := rows.Scan(&protocol, &packetCount, &totalBytes); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"protocol":     protocol,
+			"packet_count": packetCount,
+			"total_bytes":  totalBytes.Int64,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (db *DB) GetTopPorts(limit int, since time.Time) ([]map[string]interface{}, error) {
+	resolution := RollupResolution(since, time.Now())
+
+	rows, err := db.Query(`
+		SELECT
+			protocol, port,
+			SUM(packet_count) AS packet_count,
+			SUM(total_bytes) AS total_bytes
+		FROM siem.port_rollup
+		WHERE resolution = $1 AND bucket >= $2
+		GROUP BY protocol, port
+		ORDER BY packet_count DESC
+		LIMIT $3
+	`, resolution, rollupBucketStart(since, resolution), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		var protocol string
+		var port int
+		var packetCount int64
+		var totalBytes sql.NullInt64
+
+		if err := rows.Scan(&protocol, &port, &packetCount, &totalBytes); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"protocol":     protocol,
+			"port":         port,
+			"packet_count": packetCount,
+			"total_bytes":  totalBytes.Int64,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,314 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+	ErrSavedSearchExists   = errors.New("a saved search with that name already exists")
+	ErrReportNotFound      = errors.New("report not found")
+)
+
+func savedSearchError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrSavedSearchExists
+	}
+	return err
+}
+
+// SavedSearch is a named query against packets, logs or alerts. With a
+// schedule and an action it runs periodically over the last Window.
+type SavedSearch struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	Target       string     `json:"target"`
+	Query        string     `json:"query"`
+	OwnerID      *int64     `json:"owner_id,omitempty"`
+	Owner        string     `json:"owner"`
+	Shared       bool       `json:"shared"`
+	Schedule     string     `json:"schedule,omitempty"`
+	Action       string     `json:"action,omitempty"`
+	Window       string     `json:"window"`
+	Threshold    int        `json:"threshold"`
+	Severity     string     `json:"severity"`
+	ReportFormat string     `json:"report_format"`
+	ChannelIDs   []int64    `json:"channel_ids"`
+	Enabled      bool       `json:"enabled"`
+	NextRunAt    *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastStatus   string     `json:"last_status,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// VisibleTo reports whether u may see the search: shared searches are
+// visible to everyone, others only to their owner and admins.
+func (s SavedSearch) VisibleTo(u User) bool {
+	return s.Shared || s.EditableBy(u)
+}
+
+// EditableBy reports whether u may change or delete the search.
+func (s SavedSearch) EditableBy(u User) bool {
+	return u.Role == "admin" || (s.OwnerID != nil && *s.OwnerID == u.ID)
+}
+
+// SearchReport is a report file written by a scheduled search.
+type SearchReport struct {
+	ID        int64     `json:"id"`
+	SearchID  int64     `json:"search_id"`
+	CreatedAt time.Time `json:"created_at"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Format    string    `json:"format"`
+	RowCount  int       `json:"row_count"`
+	Truncated bool      `json:"truncated"`
+	SizeBytes int64     `json:"size_bytes"`
+	Path      string    `json:"-"`
+}
+
+const savedSearchColumns = `
+	id, name, description, target, query, owner_id, owner, shared,
+	COALESCE(schedule, ''), COALESCE(action, ''), time_window, threshold, severity,
+	report_format, channel_ids, enabled, next_run_at, last_run_at,
+	COALESCE(last_status, ''), COALESCE(last_error, ''), created_at, updated_at`
+
+func scanSavedSearch(row rowScanner) (SavedSearch, error) {
+	var s SavedSearch
+	var ownerID sql.NullInt64
+	var nextRun, lastRun sql.NullTime
+	var channelIDs pq.Int64Array
+	err := row.Scan(
+		&s.ID, &s.Name, &s.Description, &s.Target, &s.Query, &ownerID, &s.Owner, &s.Shared,
+		&s.Schedule, &s.Action, &s.Window, &s.Threshold, &s.Severity,
+		&s.ReportFormat, &channelIDs, &s.Enabled, &nextRun, &lastRun,
+		&s.LastStatus, &s.LastError, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if ownerID.Valid {
+		s.OwnerID = &ownerID.Int64
+	}
+	if nextRun.Valid {
+		s.NextRunAt = &nextRun.Time
+	}
+	if lastRun.Valid {
+		s.LastRunAt = &lastRun.Time
+	}
+	s.ChannelIDs = []int64(channelIDs)
+	if s.ChannelIDs == nil {
+		s.ChannelIDs = []int64{}
+	}
+	return s, err
+}
+
+func (db *DB) querySavedSearches(where string, args ...interface{}) ([]SavedSearch, error) {
+	rows, err := db.Query(`SELECT `+savedSearchColumns+` FROM siem.saved_searches `+where+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// ListSavedSearches returns the searches visible to u.
+func (db *DB) ListSavedSearches(u User) ([]SavedSearch, error) {
+	return db.querySavedSearches(`WHERE shared OR owner_id = $1 OR $2`, u.ID, u.Role == "admin")
+}
+
+func (db *DB) GetSavedSearch(id int64) (SavedSearch, error) {
+	s, err := scanSavedSearch(db.QueryRow(`SELECT `+savedSearchColumns+` FROM siem.saved_searches WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return s, ErrSavedSearchNotFound
+	}
+	return s, err
+}
+
+func (db *DB) CreateSavedSearch(s SavedSearch) (SavedSearch, error) {
+	created, err := scanSavedSearch(db.QueryRow(`
+		INSERT INTO siem.saved_searches (
+			name, description, target, query, owner_id, owner, shared,
+			schedule, action, time_window, threshold, severity,
+			report_format, channel_ids, enabled, next_run_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, $13, $14, $15, $16)
+		RETURNING `+savedSearchColumns,
+		s.Name, s.Description, s.Target, s.Query, s.OwnerID, s.Owner, s.Shared,
+		s.Schedule, s.Action, s.Window, s.Threshold, s.Severity,
+		s.ReportFormat, pq.Array(s.ChannelIDs), s.Enabled, s.NextRunAt,
+	))
+	return created, savedSearchError(err)
+}
+
+// UpdateSavedSearch replaces a search's definition. The owner and run
+// history are kept.
+func (db *DB) UpdateSavedSearch(s SavedSearch) (SavedSearch, error) {
+	updated, err := scanSavedSearch(db.QueryRow(`
+		UPDATE siem.saved_searches SET
+			name = $2, description = $3, target = $4, query = $5, shared = $6,
+			schedule = NULLIF($7, ''), action = NULLIF($8, ''), time_window = $9, threshold = $10,
+			severity = $11, report_format = $12, channel_ids = $13, enabled = $14, next_run_at = $15,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+savedSearchColumns,
+		s.ID, s.Name, s.Description, s.Target, s.Query, s.Shared,
+		s.Schedule, s.Action, s.Window, s.Threshold,
+		s.Severity, s.ReportFormat, pq.Array(s.ChannelIDs), s.Enabled, s.NextRunAt,
+	))
+	if err == sql.ErrNoRows {
+		return updated, ErrSavedSearchNotFound
+	}
+	return updated, savedSearchError(err)
+}
+
+// DeleteSavedSearch deletes a search and its report records, returning
+// the report file paths so they can be removed.
+func (db *DB) DeleteSavedSearch(id int64) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT path FROM siem.search_reports WHERE search_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM siem.saved_searches WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrSavedSearchNotFound
+	}
+	return paths, tx.Commit()
+}
+
+// DueSavedSearches returns enabled, scheduled searches whose next run is
+// at or before now.
+func (db *DB) DueSavedSearches(now time.Time) ([]SavedSearch, error) {
+	return db.querySavedSearches(`WHERE enabled AND action IS NOT NULL AND next_run_at <= $1`, now)
+}
+
+// ClaimSavedSearchRun moves a due search's next run from prev to next. It
+// reports false if another server instance claimed the run first.
+func (db *DB) ClaimSavedSearchRun(id int64, prev, next time.Time) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE siem.saved_searches SET next_run_at = $3
+		WHERE id = $1 AND next_run_at = $2
+	`, id, prev, next)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RecordSavedSearchRun stores the outcome of a run. runErr is nil on
+// success.
+func (db *DB) RecordSavedSearchRun(id int64, status string, runErr error) error {
+	errText := ""
+	if runErr != nil {
+		errText = runErr.Error()
+	}
+	_, err := db.Exec(`
+		UPDATE siem.saved_searches SET
+			last_run_at = CURRENT_TIMESTAMP, last_status = $2, last_error = NULLIF($3, '')
+		WHERE id = $1
+	`, id, status, errText)
+	return err
+}
+
+const reportColumns = `id, search_id, created_at, range_from, range_to, format, row_count, truncated, size_bytes, path`
+
+func scanReport(row rowScanner) (SearchReport, error) {
+	var r SearchReport
+	err := row.Scan(&r.ID, &r.SearchID, &r.CreatedAt, &r.From, &r.To, &r.Format, &r.RowCount, &r.Truncated, &r.SizeBytes, &r.Path)
+	return r, err
+}
+
+func (db *DB) CreateSearchReport(r SearchReport) (SearchReport, error) {
+	return scanReport(db.QueryRow(`
+		INSERT INTO siem.search_reports (search_id, range_from, range_to, format, row_count, truncated, size_bytes, path)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+reportColumns,
+		r.SearchID, r.From, r.To, r.Format, r.RowCount, r.Truncated, r.SizeBytes, r.Path,
+	))
+}
+
+func (db *DB) ListSearchReports(searchID int64, limit int) ([]SearchReport, error) {
+	rows, err := db.Query(`
+		SELECT `+reportColumns+` FROM siem.search_reports
+		WHERE search_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, searchID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []SearchReport{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+func (db *DB) GetSearchReport(id int64) (SearchReport, error) {
+	r, err := scanReport(db.QueryRow(`SELECT `+reportColumns+` FROM siem.search_reports WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return r, ErrReportNotFound
+	}
+	return r, err
+}
+
+// PruneSearchReports deletes report records older than before and returns
+// their file paths.
+func (db *DB) PruneSearchReports(before time.Time) ([]string, error) {
+	rows, err := db.Query(`DELETE FROM siem.search_reports WHERE created_at < $1 RETURNING path`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
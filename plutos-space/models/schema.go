@@ -0,0 +1,14 @@
+package models
+
+import "database/sql"
+
+// SchemaVersion returns the highest migration version recorded by the
+// processor in siem.schema_migrations, or 0 if none has been applied yet.
+func (db *DB) SchemaVersion() (int64, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(version) FROM siem.schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
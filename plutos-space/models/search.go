@@ -0,0 +1,174 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/query"
+)
+
+// PacketSearch is the search schema for siem.packet_data.
+var PacketSearch = query.Schema{
+	Fields: map[string]query.Field{
+		"timestamp":    {Columns: []string{"timestamp"}, Type: query.Time},
+		"device":       {Columns: []string{"device_name"}, Type: query.String},
+		"sensor":       {Columns: []string{"sensor"}, Type: query.String},
+		"direction":    {Columns: []string{"direction"}, Type: query.String},
+		"src_mac":      {Columns: []string{"src_mac"}, Type: query.String},
+		"dst_mac":      {Columns: []string{"dst_mac"}, Type: query.String},
+		"mac":          {Columns: []string{"src_mac", "dst_mac"}, Type: query.String},
+		"vlan":         {Columns: []string{"vlan_id"}, Type: query.Int},
+		"src_ip":       {Columns: []string{"src_ip"}, Type: query.IP},
+		"dst_ip":       {Columns: []string{"dst_ip"}, Type: query.IP},
+		"ip":           {Columns: []string{"src_ip", "dst_ip"}, Type: query.IP},
+		"ip_version":   {Columns: []string{"ip_version"}, Type: query.String},
+		"ttl":          {Columns: []string{"ttl"}, Type: query.Int},
+		"protocol":     {Columns: []string{"protocol"}, Type: query.String},
+		"src_port":     {Columns: []string{"src_port"}, Type: query.Int},
+		"dst_port":     {Columns: []string{"dst_port"}, Type: query.Int},
+		"port":         {Columns: []string{"src_port", "dst_port"}, Type: query.Int},
+		"tcp_flags":    {Columns: []string{"tcp_flags"}, Type: query.String},
+		"dns_query":    {Columns: []string{"dns_query"}, Type: query.StringArray},
+		"http_method":  {Columns: []string{"http_method"}, Type: query.String},
+		"tls_version":  {Columns: []string{"tls_version"}, Type: query.String},
+		"sni":          {Columns: []string{"sni"}, Type: query.String},
+		"payload_size": {Columns: []string{"payload_size"}, Type: query.Int},
+		"payload_hash": {Columns: []string{"payload_hash"}, Type: query.String},
+		"is_malicious": {Columns: []string{"is_malicious"}, Type: query.Bool},
+		"threat_type":  {Columns: []string{"threat_type"}, Type: query.String},
+		"cve":          {Columns: []string{"cve_ids"}, Type: query.StringArray},
+		"src_country":  {Columns: []string{"src_country"}, Type: query.String},
+		"dst_country":  {Columns: []string{"dst_country"}, Type: query.String},
+		"country":      {Columns: []string{"src_country", "dst_country"}, Type: query.String},
+		"app":          {Columns: []string{"app"}, Type: query.Map},
+	},
+}
+
+// LogSearch is the search schema for siem.log_data. Words without a field
+// search the message.
+var LogSearch = query.Schema{
+	Fields: map[string]query.Field{
+		"timestamp": {Columns: []string{"timestamp"}, Type: query.Time},
+		"source":    {Columns: []string{"source"}, Type: query.String},
+		"log_level": {Columns: []string{"log_level"}, Type: query.String},
+		"level":     {Columns: []string{"log_level"}, Type: query.String},
+		"message":   {Columns: []string{"message"}, Type: query.Text},
+		"metadata":  {Columns: []string{"metadata"}, Type: query.Map},
+	},
+	Text: "message",
+}
+
+// AlertSearch is the search schema for siem.alerts. Words without a field
+// search the title.
+var AlertSearch = query.Schema{
+	Fields: map[string]query.Field{
+		"timestamp":   {Columns: []string{"timestamp"}, Type: query.Time},
+		"last_seen":   {Columns: []string{"last_seen"}, Type: query.Time},
+		"rule":        {Columns: []string{"rule"}, Type: query.String},
+		"title":       {Columns: []string{"title"}, Type: query.Text},
+		"description": {Columns: []string{"description"}, Type: query.Text},
+		"severity":    {Columns: []string{"severity"}, Type: query.String},
+		"status":      {Columns: []string{"status"}, Type: query.String},
+		"assignee":    {Columns: []string{"assignee"}, Type: query.String},
+		"src_ip":      {Columns: []string{"src_ip"}, Type: query.IP},
+		"dst_ip":      {Columns: []string{"dst_ip"}, Type: query.IP},
+		"ip":          {Columns: []string{"src_ip", "dst_ip"}, Type: query.IP},
+		"src_port":    {Columns: []string{"src_port"}, Type: query.Int},
+		"dst_port":    {Columns: []string{"dst_port"}, Type: query.Int},
+		"protocol":    {Columns: []string{"protocol"}, Type: query.String},
+		"device":      {Columns: []string{"device_name"}, Type: query.String},
+		"sensor":      {Columns: []string{"sensor"}, Type: query.String},
+		"occurrences": {Columns: []string{"occurrences"}, Type: query.Int},
+		"incident_id": {Columns: []string{"incident_id"}, Type: query.Int},
+		"metadata":    {Columns: []string{"metadata"}, Type: query.Map},
+	},
+	Text: "title",
+}
+
+// AssetSearch is the search schema for siem.assets.
+var AssetSearch = query.Schema{
+	Fields: map[string]query.Field{
+		"ip":              {Columns: []string{"ip"}, Type: query.IP},
+		"mac":             {Columns: []string{"mac"}, Type: query.String},
+		"vendor":          {Columns: []string{"vendor"}, Type: query.String},
+		"hostname":        {Columns: []string{"hostname"}, Type: query.String},
+		"hostname_source": {Columns: []string{"hostname_source"}, Type: query.String},
+		"os":              {Columns: []string{"os"}, Type: query.String},
+		"os_source":       {Columns: []string{"os_source"}, Type: query.String},
+		"sensor":          {Columns: []string{"sensor"}, Type: query.String},
+		"first_seen":      {Columns: []string{"first_seen"}, Type: query.Time},
+		"last_seen":       {Columns: []string{"last_seen"}, Type: query.Time},
+	},
+}
+
+// SearchTarget describes a table that saved searches and exports can run
+// against.
+type SearchTarget struct {
+	Table  string
+	Schema query.Schema
+	// Columns are the columns included in scheduled reports.
+	Columns []string
+	// Export, if set, are the columns included in exports instead of
+	// Columns.
+	Export []string
+	// Where, if set, is always applied, e.g. to hide suppressed alerts.
+	Where string
+}
+
+var SearchTargets = map[string]SearchTarget{
+	"packets": {
+		Table:  "siem.packet_data",
+		Schema: PacketSearch,
+		Columns: []string{
+			"id", "timestamp", "device_name", "src_mac", "dst_mac",
+			"src_ip", "dst_ip", "protocol", "src_port", "dst_port",
+			"ip_version", "ttl", "tcp_flags", "payload_size",
+			"is_malicious", "threat_type", "sensor",
+		},
+	},
+	"logs": {
+		Table:   "siem.log_data",
+		Schema:  LogSearch,
+		Columns: []string{"id", "timestamp", "source", "log_level", "message", "metadata"},
+	},
+	"alerts": {
+		Table:  "siem.alerts",
+		Schema: AlertSearch,
+		Columns: []string{
+			"id", "timestamp", "rule", "title", "severity", "status",
+			"src_ip", "dst_ip", "src_port", "dst_port", "protocol",
+			"assignee", "occurrences",
+		},
+		Export: []string{
+			"id", "timestamp", "rule", "title", "description", "severity",
+			"status", "src_ip", "dst_ip", "src_port", "dst_port", "protocol",
+			"device_name", "metadata", "assignee", "acknowledged_at",
+			"resolved_at", "suppressed_by", "occurrences", "last_seen",
+			"incident_id", "sensor", "created_at", "updated_at",
+		},
+		Where: "suppressed_by IS NULL",
+	},
+}
+
+func (t SearchTarget) where(q *query.Query, from, to time.Time) (string, []interface{}) {
+	clauses := []string{"timestamp > $1", "timestamp <= $2"}
+	params := []interface{}{from, to}
+	if t.Where != "" {
+		clauses = append(clauses, t.Where)
+	}
+	if q != nil {
+		var clause string
+		clause, params = q.SQL(params)
+		clauses = append(clauses, clause)
+	}
+	return "WHERE " + strings.Join(clauses, " AND "), params
+}
+
+// CountSearch counts the rows of target matching q with a timestamp in
+// (from, to].
+func (db *DB) CountSearch(target SearchTarget, q *query.Query, from, to time.Time) (int, error) {
+	where, params := target.where(q, from, to)
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM `+target.Table+` `+where, params...).Scan(&count)
+	return count, err
+}
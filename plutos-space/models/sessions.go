@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TCPSession is a TCP connection reconstructed by a collector. AppDetails
+// holds what the collector's HTTP, TLS or SMTP parser found, e.g.
+// http_host or tls_sni.
+type TCPSession struct {
+	ID              int64           `json:"id"`
+	Sensor          string          `json:"sensor,omitempty"`
+	DeviceName      string          `json:"device_name"`
+	StartTime       time.Time       `json:"start_time"`
+	EndTime         time.Time       `json:"end_time"`
+	DurationMs      int64           `json:"duration_ms"`
+	ClientIP        string          `json:"client_ip"`
+	ServerIP        string          `json:"server_ip"`
+	ClientPort      int             `json:"client_port"`
+	ServerPort      int             `json:"server_port"`
+	Handshake       string          `json:"handshake"`
+	RTTMs           *float64        `json:"rtt_ms,omitempty"`
+	ClientBytes     int64           `json:"client_bytes"`
+	ServerBytes     int64           `json:"server_bytes"`
+	ClientPackets   int64           `json:"client_packets"`
+	ServerPackets   int64           `json:"server_packets"`
+	Retransmissions int             `json:"retransmissions"`
+	OutOfOrder      int             `json:"out_of_order"`
+	MissingBytes    int64           `json:"missing_bytes"`
+	CloseReason     string          `json:"close_reason"`
+	AppProtocol     string          `json:"app_protocol,omitempty"`
+	AppDetails      json.RawMessage `json:"app_details"`
+}
+
+type SessionFilter struct {
+	// Host matches either end of the connection.
+	Host        string
+	ClientIP    string
+	ServerIP    string
+	ServerPort  int
+	AppProtocol string
+	Handshake   string
+	CloseReason string
+	Sensor      string
+	// Page bounds and pages by start_time.
+	Page Page
+}
+
+const sessionColumns = `
+	id, sensor, device_name, start_time, end_time, duration_ms,
+	client_ip, server_ip, client_port, server_port, handshake, rtt_ms,
+	client_bytes, server_bytes, client_packets, server_packets,
+	retransmissions, out_of_order, missing_bytes, close_reason,
+	app_protocol, app_details
+`
+
+func scanSession(row rowScanner) (TCPSession, error) {
+	var (
+		s                   TCPSession
+		sensor, appProtocol sql.NullString
+		rtt                 sql.NullFloat64
+		details             []byte
+	)
+
+	err := row.Scan(
+		&s.ID, &sensor, &s.DeviceName, &s.StartTime, &s.EndTime, &s.DurationMs,
+		&s.ClientIP, &s.ServerIP, &s.ClientPort, &s.ServerPort, &s.Handshake, &rtt,
+		&s.ClientBytes, &s.ServerBytes, &s.ClientPackets, &s.ServerPackets,
+		&s.Retransmissions, &s.OutOfOrder, &s.MissingBytes, &s.CloseReason,
+		&appProtocol, &details,
+	)
+	if err != nil {
+		return s, err
+	}
+
+	s.Sensor = sensor.String
+	s.AppProtocol = appProtocol.String
+	if rtt.Valid {
+		s.RTTMs = &rtt.Float64
+	}
+	s.AppDetails = json.RawMessage(details)
+	return s, nil
+}
+
+func (f SessionFilter) where() (string, []interface{}) {
+	clauses := []string{"1=1"}
+	params := []interface{}{}
+
+	add := func(clause string, value interface{}) {
+		params = append(params, value)
+		clauses = append(clauses, fmt.Sprintf(clause, len(params)))
+	}
+
+	if f.Host != "" {
+		add("(client_ip = $%[1]d OR server_ip = $%[1]d)", f.Host)
+	}
+	if f.ClientIP != "" {
+		add("client_ip = $%d", f.ClientIP)
+	}
+	if f.ServerIP != "" {
+		add("server_ip = $%d", f.ServerIP)
+	}
+	if f.ServerPort != 0 {
+		add("server_port = $%d", f.ServerPort)
+	}
+	if f.AppProtocol != "" {
+		add("app_protocol = $%d", f.AppProtocol)
+	}
+	if f.Handshake != "" {
+		add("handshake = $%d", f.Handshake)
+	}
+	if f.CloseReason != "" {
+		add("close_reason = $%d", f.CloseReason)
+	}
+	if f.Sensor != "" {
+		add("sensor = $%d", f.Sensor)
+	}
+	clauses, params = f.Page.Bounds("start_time", clauses, params)
+
+	return "WHERE " + strings.Join(clauses, " AND "), params
+}
+
+// ListSessions returns a page of matching TCP sessions, newest first.
+func (db *DB) ListSessions(f SessionFilter) ([]TCPSession, PageInfo, error) {
+	where, params := f.where()
+
+	info, err := db.CountRows(f.Page.Count, "siem.tcp_sessions", where, params)
+	if err != nil {
+		return nil, info, err
+	}
+
+	pageWhere, pageParams := f.Page.Keyset("start_time", where, params)
+	rows, err := db.Query(`SELECT `+sessionColumns+` FROM siem.tcp_sessions `+pageWhere, pageParams...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	sessions := []TCPSession{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if f.Page.More(len(sessions)) {
+		sessions = sessions[:f.Page.Limit]
+		last := sessions[len(sessions)-1]
+		info.NextCursor = Cursor{last.StartTime, last.ID}.Encode()
+	}
+	return sessions, info, nil
+}
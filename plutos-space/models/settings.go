@@ -0,0 +1,244 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrSettingsConflict = errors.New("settings were changed by someone else")
+
+// settingsLockKey serialises settings saves so version checks are reliable.
+const settingsLockKey = 7_261_003
+
+// Settings is the document behind the dashboard Settings page. Field names
+// match the dashboard's state so it can be sent back unchanged.
+type Settings struct {
+	Notifications NotificationSettings `json:"notifications"`
+	Network       NetworkSettings      `json:"network"`
+	Alerts        AlertSettings        `json:"alerts"`
+	System        SystemSettings       `json:"system"`
+}
+
+// NotificationSettings switch notification channel types on or off
+// globally. Desktop notifications are shown by the dashboard itself.
+type NotificationSettings struct {
+	Email            bool `json:"email"`
+	Desktop          bool `json:"desktop"`
+	Slack            bool `json:"slack"`
+	HighSeverityOnly bool `json:"highSeverityOnly"`
+}
+
+type NetworkSettings struct {
+	// CapturePackets controls whether the processor stores individual
+	// packets; rollups and detection run either way.
+	CapturePackets bool `json:"capturePackets"`
+	// StoreDuration is the packet_data retention in days.
+	StoreDuration int `json:"storeDuration"`
+	// MaxPacketSize is the collector's capture snap length in bytes; 0
+	// keeps the collector's -snaplen.
+	MaxPacketSize int `json:"maxPacketSize"`
+	// InterfaceMode is "auto" to capture on every interface or "specific"
+	// to capture only on Interfaces.
+	InterfaceMode string   `json:"interfaceMode"`
+	Interfaces    []string `json:"interfaces"`
+}
+
+// AlertSettings enable the processor's built-in detectors. Threshold is
+// the detection sensitivity: "low" raises more alerts, "high" fewer.
+type AlertSettings struct {
+	PortScans        bool   `json:"portScans"`
+	BruteForce       bool   `json:"bruteForce"`
+	DataExfiltration bool   `json:"dataExfiltration"`
+	SuspiciousIPs    bool   `json:"suspiciousIPs"`
+	UnusualTraffic   bool   `json:"unusualTraffic"`
+	Threshold        string `json:"threshold"`
+	// KnownGateways are the routers and DHCP servers the ARP spoofing and
+	// rogue DHCP detectors trust.
+	KnownGateways []KnownGateway `json:"knownGateways"`
+}
+
+// KnownGateway is a trusted router or DHCP server. With MAC set, only
+// that MAC may claim IP in ARP; routers sharing a virtual address (VRRP,
+// HSRP) are listed once per MAC. DHCP marks an approved DHCP server.
+type KnownGateway struct {
+	IP   string `json:"ip"`
+	MAC  string `json:"mac,omitempty"`
+	DHCP bool   `json:"dhcp,omitempty"`
+}
+
+// SystemSettings are stored for the dashboard but not acted on by any
+// service.
+type SystemSettings struct {
+	AutoUpdate       bool `json:"autoUpdate"`
+	StartWithSystem  bool `json:"startWithSystem"`
+	AnalyticsEnabled bool `json:"analyticsEnabled"`
+}
+
+// DefaultSettings are used until settings are first saved. They match the
+// dashboard's initial state.
+func DefaultSettings() Settings {
+	return Settings{
+		Notifications: NotificationSettings{Email: true, Desktop: true, Slack: false, HighSeverityOnly: false},
+		Network:       NetworkSettings{CapturePackets: true, StoreDuration: 7, MaxPacketSize: 0, InterfaceMode: "auto", Interfaces: []string{}},
+		Alerts:        AlertSettings{PortScans: true, BruteForce: true, DataExfiltration: true, SuspiciousIPs: true, UnusualTraffic: true, Threshold: "medium", KnownGateways: []KnownGateway{}},
+		System:        SystemSettings{AutoUpdate: true, StartWithSystem: true, AnalyticsEnabled: false},
+	}
+}
+
+func (s Settings) Validate() error {
+	if s.Network.StoreDuration < 1 || s.Network.StoreDuration > 3650 {
+		return fmt.Errorf("network.storeDuration must be between 1 and 3650 days")
+	}
+	if s.Network.MaxPacketSize != 0 && (s.Network.MaxPacketSize < 64 || s.Network.MaxPacketSize > 65535) {
+		return fmt.Errorf("network.maxPacketSize must be 0 or between 64 and 65535 bytes")
+	}
+	switch s.Network.InterfaceMode {
+	case "auto":
+	case "specific":
+		if len(s.Network.Interfaces) == 0 {
+			return fmt.Errorf("network.interfaces is required when network.interfaceMode is specific")
+		}
+	default:
+		return fmt.Errorf("network.interfaceMode must be auto or specific")
+	}
+	switch s.Alerts.Threshold {
+	case "low", "medium", "high":
+	default:
+		return fmt.Errorf("alerts.threshold must be one of low, medium, high")
+	}
+	for i, g := range s.Alerts.KnownGateways {
+		if net.ParseIP(g.IP) == nil {
+			return fmt.Errorf("alerts.knownGateways[%d].ip must be an IP address", i)
+		}
+		if _, err := net.ParseMAC(g.MAC); g.MAC != "" && err != nil {
+			return fmt.Errorf("alerts.knownGateways[%d].mac must be a MAC address", i)
+		}
+	}
+	return nil
+}
+
+type SettingsVersion struct {
+	Version   int64     `json:"version"`
+	Settings  Settings  `json:"settings"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetSettings returns the current settings, or version 0 with the defaults
+// if they have never been saved. network.storeDuration always reflects the
+// packet_data retention policy, which can also be changed through
+// /api/retention.
+func (db *DB) GetSettings() (SettingsVersion, error) {
+	v := SettingsVersion{Settings: DefaultSettings()}
+
+	var raw []byte
+	var changedBy sql.NullString
+	err := db.QueryRow(`
+		SELECT version, settings, changed_by, created_at
+		FROM siem.settings
+		ORDER BY version DESC
+		LIMIT 1
+	`).Scan(&v.Version, &raw, &changedBy, &v.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return v, err
+	}
+	if err == nil {
+		// Decode over the defaults so settings added after a version was
+		// saved get their default value.
+		if err := json.Unmarshal(raw, &v.Settings); err != nil {
+			return v, err
+		}
+		v.ChangedBy = changedBy.String
+	}
+
+	err = db.QueryRow(`SELECT retention_days FROM siem.retention_policy WHERE table_name = 'packet_data'`).Scan(&v.Settings.Network.StoreDuration)
+	if err != nil && err != sql.ErrNoRows {
+		return v, err
+	}
+	return v, nil
+}
+
+// SaveSettings stores s as a new version. baseVersion must be the version
+// the change was made against; if settings have been saved since,
+// ErrSettingsConflict is returned. The packet_data retention policy is
+// updated in the same transaction.
+func (db *DB) SaveSettings(baseVersion int64, s Settings, changedBy string) (SettingsVersion, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return SettingsVersion{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return SettingsVersion{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, settingsLockKey); err != nil {
+		return SettingsVersion{}, err
+	}
+
+	var current int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM siem.settings`).Scan(&current); err != nil {
+		return SettingsVersion{}, err
+	}
+	if current != baseVersion {
+		return SettingsVersion{}, ErrSettingsConflict
+	}
+
+	v := SettingsVersion{Settings: s, ChangedBy: changedBy}
+	err = tx.QueryRow(`
+		INSERT INTO siem.settings (settings, changed_by)
+		VALUES ($1, NULLIF($2, ''))
+		RETURNING version, created_at
+	`, raw, changedBy).Scan(&v.Version, &v.CreatedAt)
+	if err != nil {
+		return v, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE siem.retention_policy
+		SET retention_days = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE table_name = 'packet_data' AND retention_days <> $1
+	`, s.Network.StoreDuration)
+	if err != nil {
+		return v, err
+	}
+
+	return v, tx.Commit()
+}
+
+// GetSettingsHistory returns saved versions, newest first.
+func (db *DB) GetSettingsHistory(limit int) ([]SettingsVersion, error) {
+	rows, err := db.Query(`
+		SELECT version, settings, changed_by, created_at
+		FROM siem.settings
+		ORDER BY version DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []SettingsVersion{}
+	for rows.Next() {
+		v := SettingsVersion{Settings: DefaultSettings()}
+		var raw []byte
+		var changedBy sql.NullString
+		if err := rows.Scan(&v.Version, &raw, &changedBy, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &v.Settings); err != nil {
+			return nil, err
+		}
+		v.ChangedBy = changedBy.String
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimelineDimensions maps the group_by names accepted by the timeline API
+// to packet_data columns.
+var TimelineDimensions = map[string]string{
+	"protocol":  "protocol",
+	"direction": "direction",
+	"device":    "device_name",
+	"src":       "src_ip",
+	"dst":       "dst_ip",
+}
+
+type TimelineQuery struct {
+	From      time.Time
+	To        time.Time
+	Interval  time.Duration
+	GroupBy   []string
+	MaxSeries int
+	// MinuteRollupsSince is where minute rollups start; ranges starting
+	// earlier are read from hour rollups.
+	MinuteRollupsSince time.Time
+}
+
+// FromRollups reports whether the timeline is served from the rollup
+// tables: ungrouped or protocol-only timelines at minute granularity or
+// coarser.
+func (q TimelineQuery) FromRollups() bool {
+	return q.Interval%time.Minute == 0 &&
+		(len(q.GroupBy) == 0 || (len(q.GroupBy) == 1 && q.GroupBy[0] == "protocol"))
+}
+
+type TimelineSeries struct {
+	Key     map[string]string `json:"key"`
+	Packets []int64           `json:"packets"`
+	Bytes   []int64           `json:"bytes"`
+	total   int64
+}
+
+type Timeline struct {
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Interval int64             `json:"interval_seconds"`
+	GroupBy  []string          `json:"group_by"`
+	Buckets  []time.Time       `json:"buckets"`
+	Series   []*TimelineSeries `json:"series"`
+	Source   string            `json:"source"`
+}
+
+// GetPacketTimeline returns packet and byte counts per interval bucket
+// between q.From and q.To, one series per distinct combination of the
+// group_by dimensions. Every series has a value for every bucket, with
+// empty buckets zero-filled. When there are more than q.MaxSeries series
+// the smallest ones are folded into a single "other" series.
+//
+// Ungrouped or protocol-only timelines at minute granularity or coarser are
+// served from the rollup tables; anything else scans packet_data within the
+// time range, which partition pruning keeps to the relevant days.
+func (db *DB) GetPacketTimeline(q TimelineQuery) (*Timeline, error) {
+	// Buckets are aligned to the Unix epoch, matching date_bin below.
+	step := int64(q.Interval / time.Second)
+	from := time.Unix(q.From.Unix()/step*step, 0).UTC()
+	to := q.To.UTC()
+
+	var buckets []time.Time
+	for t := from; t.Before(to); t = t.Add(q.Interval) {
+		buckets = append(buckets, t)
+	}
+
+	timeline := &Timeline{
+		From:     from,
+		To:       to,
+		Interval: int64(q.Interval / time.Second),
+		GroupBy:  q.GroupBy,
+		Buckets:  buckets,
+		Series:   []*TimelineSeries{},
+	}
+	if len(buckets) == 0 {
+		return timeline, nil
+	}
+
+	query, args, source := timelineSQL(q, from, to)
+	timeline.Source = source
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seriesByKey := map[string]*TimelineSeries{}
+	var order []string
+
+	for rows.Next() {
+		var bucket time.Time
+		var packets, bytes int64
+		values := make([]*string, len(q.GroupBy))
+
+		dest := []interface{}{&bucket, &packets, &bytes}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		key := map[string]string{}
+		parts := make([]string, len(q.GroupBy))
+		for i, dim := range q.GroupBy {
+			if values[i] != nil {
+				key[dim] = *values[i]
+				parts[i] = *values[i]
+			} else {
+				key[dim] = ""
+			}
+		}
+		id := strings.Join(parts, "\x00")
+
+		s, ok := seriesByKey[id]
+		if !ok {
+			s = &TimelineSeries{
+				Key:     key,
+				Packets: make([]int64, len(buckets)),
+				Bytes:   make([]int64, len(buckets)),
+			}
+			seriesByKey[id] = s
+			order = append(order, id)
+		}
+
+		idx := int(bucket.UTC().Sub(from) / q.Interval)
+		if idx < 0 || idx >= len(buckets) {
+			continue
+		}
+		s.Packets[idx] += packets
+		s.Bytes[idx] += bytes
+		s.total += packets
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := make([]*TimelineSeries, 0, len(order))
+	for _, id := range order {
+		series = append(series, seriesByKey[id])
+	}
+	sort.SliceStable(series, func(i, j int) bool { return series[i].total > series[j].total })
+
+	if q.MaxSeries > 0 && len(series) > q.MaxSeries {
+		other := &TimelineSeries{
+			Key:     map[string]string{},
+			Packets: make([]int64, len(buckets)),
+			Bytes:   make([]int64, len(buckets)),
+		}
+		for _, dim := range q.GroupBy {
+			other.Key[dim] = "other"
+		}
+		for _, s := range series[q.MaxSeries:] {
+			for i := range buckets {
+				other.Packets[i] += s.Packets[i]
+				other.Bytes[i] += s.Bytes[i]
+			}
+		}
+		series = append(series[:q.MaxSeries], other)
+	}
+
+	if len(q.GroupBy) == 0 && len(series) == 0 {
+		series = append(series, &TimelineSeries{
+			Key:     map[string]string{},
+			Packets: make([]int64, len(buckets)),
+			Bytes:   make([]int64, len(buckets)),
+		})
+	}
+
+	timeline.Series = series
+	return timeline, nil
+}
+
+func timelineSQL(q TimelineQuery, from, to time.Time) (string, []interface{}, string) {
+	interval := fmt.Sprintf("%d seconds", int64(q.Interval/time.Second))
+
+	if q.FromRollups() {
+		resolution := "1m"
+		if q.Interval%time.Hour == 0 && (RollupResolution(from, to) == "1h" || from.Before(q.MinuteRollupsSince)) {
+			resolution = "1h"
+		}
+
+		table, groupCols := "siem.traffic_rollup", ""
+		if len(q.GroupBy) == 1 {
+			table, groupCols = "siem.protocol_rollup", ", protocol"
+		}
+
+		query := fmt.Sprintf(`
+			SELECT
+				date_bin($1::interval, bucket, TIMESTAMP '1970-01-01') AS b,
+				SUM(packet_count), SUM(total_bytes)%s
+			FROM %s
+			WHERE resolution = $2 AND bucket >= $3 AND bucket < $4
+			GROUP BY b%s
+		`, groupCols, table, groupCols)
+		return query, []interface{}{interval, resolution, from, to}, "rollup_" + resolution
+	}
+
+	var cols []string
+	for _, dim := range q.GroupBy {
+		cols = append(cols, TimelineDimensions[dim])
+	}
+	groupCols := ""
+	if len(cols) > 0 {
+		groupCols = ", " + strings.Join(cols, ", ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			date_bin($1::interval, timestamp, TIMESTAMP '1970-01-01') AS b,
+			COUNT(*), COALESCE(SUM(payload_size), 0)%s
+		FROM siem.packet_data
+		WHERE timestamp >= $2 AND timestamp < $3
+		GROUP BY b%s
+	`, groupCols, groupCols)
+	return query, []interface{}{interval, from, to}, "packet_data"
+}
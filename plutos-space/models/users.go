@@ -0,0 +1,422 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("a user with that name already exists")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrLastAdmin          = errors.New("at least one enabled admin must remain")
+)
+
+// usersLockKey serialises changes that could remove the last admin.
+const usersLockKey = 7_261_004
+
+// APIKeyPrefix starts every API key so they can be told apart from
+// session tokens.
+const APIKeyPrefix = "pluto_"
+
+// MinPasswordLength is enforced when passwords are set through the API.
+const MinPasswordLength = 10
+
+// Roles in increasing order of privilege. Each role can do everything the
+// roles before it can.
+var Roles = []string{"viewer", "analyst", "admin"}
+
+// RoleRank orders roles by privilege; unknown roles rank below viewer.
+func RoleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+type User struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Role        string     `json:"role"`
+	Disabled    bool       `json:"disabled"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// UserUpdate holds the fields to change on a user; nil fields are left
+// unchanged.
+type UserUpdate struct {
+	Password *string
+	Role     *string
+	Disabled *bool
+}
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// dummyHash is compared against when a login names an unknown user, so
+// the response time does not reveal which usernames exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("pluto-dummy-password"), bcrypt.DefaultCost)
+
+func userError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrUserExists
+	}
+	return err
+}
+
+// NewToken returns a random URL-safe token with the given prefix.
+func NewToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func tokenHash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+const userColumns = `id, username, role, disabled, last_login_at, created_at, updated_at`
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var lastLogin sql.NullTime
+	err := row.Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &lastLogin, &u.CreatedAt, &u.UpdatedAt)
+	if lastLogin.Valid {
+		u.LastLoginAt = &lastLogin.Time
+	}
+	return u, err
+}
+
+func (db *DB) CountUsers() (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM siem.users`).Scan(&n)
+	return n, err
+}
+
+func (db *DB) ListUsers() ([]User, error) {
+	rows, err := db.Query(`SELECT ` + userColumns + ` FROM siem.users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+func (db *DB) GetUser(id int64) (User, error) {
+	u, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM siem.users WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return u, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (db *DB) CreateUser(username, password, role string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	u, err := scanUser(db.QueryRow(`
+		INSERT INTO siem.users (username, password_hash, role)
+		VALUES ($1, $2, $3)
+		RETURNING `+userColumns,
+		username, string(hash), role,
+	))
+	return u, userError(err)
+}
+
+// UpdateUser changes a user's password, role or disabled flag. Changing
+// the password or disabling the user ends their existing sessions.
+func (db *DB) UpdateUser(id int64, u UserUpdate) (User, error) {
+	var hash sql.NullString
+	if u.Password != nil {
+		b, err := bcrypt.GenerateFromPassword([]byte(*u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
+		hash = sql.NullString{String: string(b), Valid: true}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, usersLockKey); err != nil {
+		return User{}, err
+	}
+
+	updated, err := scanUser(tx.QueryRow(`
+		UPDATE siem.users SET
+			password_hash = COALESCE($2, password_hash),
+			role = COALESCE($3, role),
+			disabled = COALESCE($4, disabled),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+userColumns,
+		id, hash, u.Role, u.Disabled,
+	))
+	if err == sql.ErrNoRows {
+		return updated, ErrUserNotFound
+	}
+	if err != nil {
+		return updated, err
+	}
+
+	if err := checkAdminRemains(tx); err != nil {
+		return updated, err
+	}
+
+	if u.Password != nil || (u.Disabled != nil && *u.Disabled) {
+		if _, err := tx.Exec(`DELETE FROM siem.sessions WHERE user_id = $1`, id); err != nil {
+			return updated, err
+		}
+	}
+
+	return updated, tx.Commit()
+}
+
+func (db *DB) DeleteUser(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, usersLockKey); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM siem.users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	if err := checkAdminRemains(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkAdminRemains returns ErrLastAdmin if tx has left no enabled admin.
+func checkAdminRemains(tx *sql.Tx) error {
+	var admins int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM siem.users WHERE role = 'admin' AND NOT disabled`).Scan(&admins); err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Authenticate checks a username and password and records the login.
+// Unknown users, wrong passwords and disabled users all return
+// ErrInvalidCredentials.
+func (db *DB) Authenticate(username, password string) (User, error) {
+	var u User
+	var lastLogin sql.NullTime
+	var hash string
+	err := db.QueryRow(`
+		SELECT `+userColumns+`, password_hash FROM siem.users WHERE username = $1
+	`, username).Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &lastLogin, &u.CreatedAt, &u.UpdatedAt, &hash)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return u, ErrInvalidCredentials
+	}
+	if err != nil {
+		return u, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || u.Disabled {
+		return User{}, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	u.LastLoginAt = &now
+	_, err = db.Exec(`UPDATE siem.users SET last_login_at = $2 WHERE id = $1`, u.ID, now)
+	return u, err
+}
+
+// CheckPassword reports whether password is the user's current password.
+func (db *DB) CheckPassword(id int64, password string) (bool, error) {
+	var hash string
+	err := db.QueryRow(`SELECT password_hash FROM siem.users WHERE id = $1`, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+}
+
+// CreateSession starts a login session for a user and returns its token.
+// Only a hash of the token is stored.
+func (db *DB) CreateSession(userID int64, ttl time.Duration, userAgent, remoteAddr string) (string, time.Time, error) {
+	token, err := NewToken("")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	_, err = db.Exec(`
+		INSERT INTO siem.sessions (token_hash, user_id, user_agent, remote_addr, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+	`, tokenHash(token), userID, userAgent, remoteAddr, expiresAt)
+	return token, expiresAt, err
+}
+
+// SessionUser returns the user an unexpired session token belongs to, or
+// ErrInvalidCredentials.
+func (db *DB) SessionUser(token string) (User, error) {
+	u, err := scanUser(db.QueryRow(`
+		UPDATE siem.sessions s SET last_used_at = CURRENT_TIMESTAMP
+		FROM siem.users u
+		WHERE s.token_hash = $1 AND s.user_id = u.id
+		  AND s.expires_at > CURRENT_TIMESTAMP AND NOT u.disabled
+		RETURNING u.id, u.username, u.role, u.disabled, u.last_login_at, u.created_at, u.updated_at
+	`, tokenHash(token)))
+	if err == sql.ErrNoRows {
+		return u, ErrInvalidCredentials
+	}
+	return u, err
+}
+
+func (db *DB) DeleteSession(token string) error {
+	_, err := db.Exec(`DELETE FROM siem.sessions WHERE token_hash = $1`, tokenHash(token))
+	return err
+}
+
+func (db *DB) PruneSessions() error {
+	_, err := db.Exec(`DELETE FROM siem.sessions WHERE expires_at < CURRENT_TIMESTAMP`)
+	return err
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, created_at, last_used_at, expires_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var lastUsed, expires sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.CreatedAt, &lastUsed, &expires)
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		k.ExpiresAt = &expires.Time
+	}
+	return k, err
+}
+
+// CreateAPIKey issues a new API key for a user. The key itself is only
+// returned here; afterwards just its prefix is shown.
+func (db *DB) CreateAPIKey(userID int64, name string, expiresAt *time.Time) (APIKey, string, error) {
+	key, err := NewToken(APIKeyPrefix)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	k, err := scanAPIKey(db.QueryRow(`
+		INSERT INTO siem.api_keys (user_id, name, prefix, key_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+apiKeyColumns,
+		userID, name, key[:len(APIKeyPrefix)+6], tokenHash(key), expiresAt,
+	))
+	return k, key, err
+}
+
+// ListAPIKeys returns a user's keys that have not been revoked, including
+// expired ones.
+func (db *DB) ListAPIKeys(userID int64) ([]APIKey, error) {
+	rows, err := db.Query(`
+		SELECT `+apiKeyColumns+`
+		FROM siem.api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (db *DB) RevokeAPIKey(userID, id int64) error {
+	res, err := db.Exec(`
+		UPDATE siem.api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// APIKeyUser returns the user a valid API key belongs to, or
+// ErrInvalidCredentials.
+func (db *DB) APIKeyUser(key string) (User, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return User{}, ErrInvalidCredentials
+	}
+
+	u, err := scanUser(db.QueryRow(`
+		UPDATE siem.api_keys k SET last_used_at = CURRENT_TIMESTAMP
+		FROM siem.users u
+		WHERE k.key_hash = $1 AND k.user_id = u.id AND k.revoked_at IS NULL
+		  AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP) AND NOT u.disabled
+		RETURNING u.id, u.username, u.role, u.disabled, u.last_login_at, u.created_at, u.updated_at
+	`, tokenHash(key)))
+	if err == sql.ErrNoRows {
+		return u, ErrInvalidCredentials
+	}
+	return u, err
+}
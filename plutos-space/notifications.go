@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/notifier"
+)
+
+var dispatcher *notifier.Dispatcher
+
+type channelRequest struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Enabled     *bool           `json:"enabled"`
+	MinSeverity string          `json:"min_severity"`
+	Template    string          `json:"template"`
+	Config      json.RawMessage `json:"config"`
+}
+
+// toChannel validates the request and converts it to a channel. Enabled
+// defaults to true and min_severity to low.
+func (req channelRequest) toChannel() (models.NotificationChannel, error) {
+	c := models.NotificationChannel{
+		Name:        strings.TrimSpace(req.Name),
+		Type:        req.Type,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		MinSeverity: req.MinSeverity,
+		Template:    req.Template,
+		Config:      req.Config,
+	}
+
+	if c.Name == "" {
+		return c, fmt.Errorf("name is required")
+	}
+	if c.MinSeverity == "" {
+		c.MinSeverity = "low"
+	}
+	if !models.AlertSeverities[c.MinSeverity] {
+		return c, fmt.Errorf("min_severity must be one of low, medium, high, critical")
+	}
+	if len(c.Config) == 0 {
+		c.Config = json.RawMessage("{}")
+	}
+	if _, err := notifier.NewChannel(c.Type, c.Config); err != nil {
+		return c, fmt.Errorf("%v (types: %s)", err, strings.Join(notifier.ChannelTypes(), ", "))
+	}
+	if err := notifier.ParseTemplate(c.Template); err != nil {
+		return c, fmt.Errorf("invalid template: %v", err)
+	}
+	return c, nil
+}
+
+func redactChannel(c models.NotificationChannel) models.NotificationChannel {
+	c.Config = notifier.RedactConfig(c.Config)
+	return c
+}
+
+func notificationChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	channels, err := models.NewDB(db).ListNotificationChannels(false)
+	if err != nil {
+		log.Printf("Error querying notification channels: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range channels {
+		channels[i] = redactChannel(channels[i])
+	}
+	json.NewEncoder(w).Encode(channels)
+}
+
+func notificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	channel, err := models.NewDB(db).GetNotificationChannel(id)
+	if err == models.ErrChannelNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(redactChannel(channel))
+}
+
+func createNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := req.toChannel()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := models.NewDB(db).CreateNotificationChannel(channel)
+	if err == models.ErrChannelExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating notification channel: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(redactChannel(created))
+}
+
+// updateNotificationChannelHandler replaces a channel. Secrets returned
+// redacted by GET may be sent back as-is to keep their stored value.
+func updateNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	database := models.NewDB(db)
+
+	existing, err := database.GetNotificationChannel(id)
+	if err == models.ErrChannelNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(req.Config) > 0 {
+		req.Config = notifier.MergeRedacted(req.Config, existing.Config)
+	}
+
+	channel, err := req.toChannel()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	channel.ID = id
+
+	updated, err := database.UpdateNotificationChannel(channel)
+	if err == models.ErrChannelNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrChannelExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(redactChannel(updated))
+}
+
+func deleteNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	err := models.NewDB(db).DeleteNotificationChannel(id)
+	if err == models.ErrChannelNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testNotificationChannelHandler sends a sample alert through a channel
+// and reports the result. Delivery failures are returned as 502 with the
+// channel's error so misconfiguration can be fixed from the UI.
+func testNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	channel, err := models.NewDB(db).GetNotificationChannel(id)
+	if err == models.ErrChannelNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dispatcher.SendTest(r.Context(), channel); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func notificationDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	deliveries, err := models.NewDB(db).ListNotificationDeliveries(id, limit)
+	if err != nil {
+		log.Printf("Error querying deliveries for notification channel %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+}
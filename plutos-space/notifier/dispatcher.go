@@ -0,0 +1,260 @@
+package notifier
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+const (
+	pollInterval    = 5 * time.Second
+	sendTimeout     = 15 * time.Second
+	maxAttempts     = 5
+	initialBackoff  = 2 * time.Second
+	maxBackoff      = time.Minute
+	maxInFlight     = 16
+	deliveryHistory = 30 * 24 * time.Hour
+)
+
+// Dispatcher watches siem.alerts for new, unsuppressed alerts and sends
+// each one to every enabled channel whose minimum severity it meets. The
+// Email and Slack switches and High Severity Only in settings apply on top
+// of each channel's own configuration. Channels and settings are reloaded
+// on every poll, so API changes apply without a restart. Each alert is
+// claimed in the database before it is sent, so alerts raised while the
+// server is down are sent when it starts, and server instances sharing the
+// database send each alert once.
+type Dispatcher struct {
+	db       *models.DB
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewDispatcher(db *models.DB) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		inFlight: make(chan struct{}, maxInFlight),
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer d.wg.Wait()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pruneTicker.C:
+			if err := d.db.PruneNotificationDeliveries(time.Now().Add(-deliveryHistory)); err != nil {
+				log.Printf("[Notifier] Error pruning delivery history: %v", err)
+			}
+		case <-ticker.C:
+			d.poll(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) poll(ctx context.Context) {
+	// Load the channels first, so a failure leaves the alerts unclaimed.
+	targets, err := d.targets(nil)
+	if err != nil {
+		log.Printf("[Notifier] Error loading notification channels: %v", err)
+		return
+	}
+
+	alerts, err := d.db.ClaimAlertNotifications(time.Now(), 500)
+	if err != nil {
+		log.Printf("[Notifier] Error claiming new alerts: %v", err)
+		return
+	}
+
+	for _, alert := range alerts {
+		for _, t := range targets {
+			if models.SeverityRank(alert.Severity) < models.SeverityRank(t.config.MinSeverity) {
+				continue
+			}
+			msg, err := Render(t.config, alert)
+			if err != nil {
+				log.Printf("[Notifier] Error rendering alert %d for channel %q: %v", alert.ID, t.config.Name, err)
+				continue
+			}
+
+			if !d.send(ctx, t, msg) {
+				return
+			}
+		}
+	}
+}
+
+type target struct {
+	config  models.NotificationChannel
+	channel Channel
+}
+
+// targets loads the enabled channels, restricted to ids when it is not
+// nil, applying the notification switches in settings.
+func (d *Dispatcher) targets(ids []int64) ([]target, error) {
+	configs, err := d.db.ListNotificationChannels(true)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := d.db.GetSettings()
+	if err != nil {
+		log.Printf("[Notifier] Error loading settings, using defaults: %v", err)
+		settings.Settings = models.DefaultSettings()
+	}
+	prefs := settings.Settings.Notifications
+
+	wanted := map[int64]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var targets []target
+	for _, c := range configs {
+		if ids != nil && !wanted[c.ID] {
+			continue
+		}
+		if (c.Type == "smtp" && !prefs.Email) || (c.Type == "slack" && !prefs.Slack) {
+			continue
+		}
+		if prefs.HighSeverityOnly && models.SeverityRank(c.MinSeverity) < models.SeverityRank("high") {
+			c.MinSeverity = "high"
+		}
+		ch, err := NewChannel(c.Type, c.Config)
+		if err != nil {
+			log.Printf("[Notifier] Skipping channel %q: %v", c.Name, err)
+			continue
+		}
+		targets = append(targets, target{c, ch})
+	}
+	return targets, nil
+}
+
+// send delivers msg to t in the background, waiting for a free slot
+// first. It returns false if ctx was cancelled while waiting.
+func (d *Dispatcher) send(ctx context.Context, t target, msg Message) bool {
+	select {
+	case d.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() { <-d.inFlight }()
+		d.deliver(ctx, t.config, t.channel, msg)
+	}()
+	return true
+}
+
+// SendReport notifies the given channels of a scheduled search report,
+// with the same retries as alerts. Channel minimum severities do not
+// apply to reports, but the Email and Slack switches in settings do.
+func (d *Dispatcher) SendReport(ctx context.Context, channelIDs []int64, r Report) error {
+	if len(channelIDs) == 0 {
+		return nil
+	}
+	targets, err := d.targets(channelIDs)
+	if err != nil {
+		return err
+	}
+	msg, err := RenderReport(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if !d.send(ctx, t, msg) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deliver sends msg with exponential backoff between attempts and records
+// the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, config models.NotificationChannel, ch Channel, msg Message) {
+	backoff := initialBackoff
+	attempts := 0
+	var err error
+
+	for attempts < maxAttempts {
+		attempts++
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err = ch.Send(sendCtx, msg)
+		cancel()
+		if err == nil || attempts == maxAttempts {
+			break
+		}
+
+		log.Printf("[Notifier] Channel %q attempt %d for %s failed: %v", config.Name, attempts, msg.describe(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	if err != nil {
+		log.Printf("[Notifier] Giving up on %s for channel %q: %v", msg.describe(), config.Name, err)
+	}
+	if recErr := d.db.RecordNotificationDelivery(config.ID, msg.Alert.ID, attempts, err); recErr != nil {
+		log.Printf("[Notifier] Error recording delivery: %v", recErr)
+	}
+}
+
+// SendTest sends a sample alert to a channel once, without retries, and
+// returns the error so it can be shown to the user.
+func (d *Dispatcher) SendTest(ctx context.Context, config models.NotificationChannel) error {
+	ch, err := NewChannel(config.Type, config.Config)
+	if err != nil {
+		return err
+	}
+
+	msg, err := Render(config, sampleAlert())
+	if err != nil {
+		return err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	err = ch.Send(sendCtx, msg)
+
+	if recErr := d.db.RecordNotificationDelivery(config.ID, 0, 1, err); recErr != nil {
+		log.Printf("[Notifier] Error recording delivery: %v", recErr)
+	}
+	return err
+}
+
+func sampleAlert() models.Alert {
+	now := time.Now()
+	return models.Alert{
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Timestamp:   now,
+		LastSeen:    now,
+		Rule:        "test",
+		Title:       "Test notification from Pluto",
+		Description: "This is a test alert sent from the notification settings.",
+		Severity:    "high",
+		SrcIP:       "192.0.2.10",
+		DstIP:       "198.51.100.20",
+		DstPort:     443,
+		Protocol:    "TCP",
+		Status:      "new",
+		Occurrences: 1,
+	}
+}
@@ -0,0 +1,189 @@
+// Package notifier delivers alerts and scheduled search reports to outbound
+// notification channels such as webhooks, email and Slack.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+// Message is a notification rendered for one alert or, when Report is
+// set, for a scheduled search report.
+type Message struct {
+	Subject string
+	Body    string
+	Alert   models.Alert
+	Report  *Report
+}
+
+// Report is a report written by a scheduled saved search. URL is where
+// it can be downloaded.
+type Report struct {
+	Search models.SavedSearch  `json:"search"`
+	Report models.SearchReport `json:"report"`
+	URL    string              `json:"url"`
+}
+
+// describe names what msg is about in log messages.
+func (msg Message) describe() string {
+	if msg.Report != nil {
+		return fmt.Sprintf("report %d", msg.Report.Report.ID)
+	}
+	return fmt.Sprintf("alert %d", msg.Alert.ID)
+}
+
+// Channel sends messages to one destination. Send makes a single attempt;
+// retries are handled by the Dispatcher.
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type channelType struct {
+	new      func(config json.RawMessage) (Channel, error)
+	template string
+}
+
+var channelTypes = map[string]channelType{}
+
+func registerChannelType(name string, new func(config json.RawMessage) (Channel, error), defaultTemplate string) {
+	channelTypes[name] = channelType{new: new, template: defaultTemplate}
+}
+
+// ChannelTypes returns the names of the registered channel types.
+func ChannelTypes() []string {
+	names := make([]string, 0, len(channelTypes))
+	for name := range channelTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewChannel builds a channel of the given type from its JSON config,
+// validating the config.
+func NewChannel(typ string, config json.RawMessage) (Channel, error) {
+	t, ok := channelTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel type %q", typ)
+	}
+	if len(config) == 0 {
+		config = json.RawMessage("{}")
+	}
+	return t.new(config)
+}
+
+const subjectTemplate = `[{{upper .Severity}}] {{.Title}}{{if .SrcIP}} from {{.SrcIP}}{{end}}`
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+// ParseTemplate checks that text is a valid message template.
+func ParseTemplate(text string) error {
+	_, err := template.New("body").Funcs(templateFuncs).Parse(text)
+	return err
+}
+
+// Render builds the message for an alert using the channel's template, or
+// the channel type's default when it has none. Templates are Go
+// text/templates executed against models.Alert.
+func Render(c models.NotificationChannel, alert models.Alert) (Message, error) {
+	body := c.Template
+	if body == "" {
+		body = channelTypes[c.Type].template
+	}
+
+	msg := Message{Alert: alert}
+	var err error
+	if msg.Subject, err = execTemplate(subjectTemplate, alert); err != nil {
+		return msg, err
+	}
+	if msg.Body, err = execTemplate(body, alert); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+const reportTemplate = `Saved search "{{.Search.Name}}" matched {{.Report.RowCount}}{{if .Report.Truncated}}+{{end}} {{.Search.Target}} between {{time .Report.From}} and {{time .Report.To}}.
+
+Query: {{.Search.Query}}{{if .Search.Description}}
+{{.Search.Description}}{{end}}
+
+Download ({{.Report.Format}}): {{.URL}}
+`
+
+// RenderReport builds the message for a report. Channel templates are
+// written for alerts, so reports always use the same plain text body.
+func RenderReport(r Report) (Message, error) {
+	msg := Message{
+		Subject: fmt.Sprintf("[REPORT] %s: %d results", r.Search.Name, r.Report.RowCount),
+		Report:  &r,
+	}
+	var err error
+	msg.Body, err = execTemplate(reportTemplate, r)
+	return msg, err
+}
+
+func execTemplate(text string, data interface{}) (string, error) {
+	t, err := template.New("").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// redactedValue replaces secrets in channel configs returned by the API.
+const redactedValue = "********"
+
+var secretKeys = map[string]bool{"secret": true, "password": true, "webhook_url": true}
+
+// RedactConfig replaces secret values in a channel config for display.
+func RedactConfig(config json.RawMessage) json.RawMessage {
+	var m map[string]interface{}
+	if err := json.Unmarshal(config, &m); err != nil {
+		return config
+	}
+	for k, v := range m {
+		if secretKeys[k] && v != "" {
+			m[k] = redactedValue
+		}
+	}
+	out, _ := json.Marshal(m)
+	return out
+}
+
+// MergeRedacted restores secrets in an updated config that were sent back
+// unchanged in their redacted form.
+func MergeRedacted(updated, existing json.RawMessage) json.RawMessage {
+	var u, e map[string]interface{}
+	if json.Unmarshal(updated, &u) != nil || json.Unmarshal(existing, &e) != nil {
+		return updated
+	}
+	for k := range secretKeys {
+		if u[k] == redactedValue {
+			u[k] = e[k]
+		}
+	}
+	out, _ := json.Marshal(u)
+	return out
+}
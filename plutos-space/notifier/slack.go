@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	registerChannelType("slack", newSlackChannel, slackTemplate)
+}
+
+const slackTemplate = `*[{{upper .Severity}}] {{.Title}}*
+Rule: ` + "`{{.Rule}}`" + `{{if .SrcIP}}
+Source: {{.SrcIP}}{{if .SrcPort}}:{{.SrcPort}}{{end}}{{end}}{{if .DstIP}}
+Destination: {{.DstIP}}{{if .DstPort}}:{{.DstPort}}{{end}}{{end}}
+Time: {{time .Timestamp}}{{if .Description}}
+{{.Description}}{{end}}`
+
+// slackChannel posts to a Slack incoming webhook. Mattermost and
+// Rocket.Chat accept the same payload.
+type slackChannel struct {
+	WebhookURL string `json:"webhook_url"`
+	Channel    string `json:"channel"`
+	Username   string `json:"username"`
+}
+
+func newSlackChannel(config json.RawMessage) (Channel, error) {
+	var c slackChannel
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, fmt.Errorf("invalid slack config: %w", err)
+	}
+	if err := validateURL(c.WebhookURL); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *slackChannel) Send(ctx context.Context, msg Message) error {
+	payload := map[string]string{"text": msg.Body}
+	if c.Channel != "" {
+		payload["channel"] = c.Channel
+	}
+	if c.Username != "" {
+		payload["username"] = c.Username
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, c.WebhookURL, body, nil)
+}
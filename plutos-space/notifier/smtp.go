@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerChannelType("smtp", newSMTPChannel, smtpTemplate)
+}
+
+const smtpTemplate = `{{.Title}}
+
+Severity:    {{.Severity}}
+Rule:        {{.Rule}}
+Time:        {{time .Timestamp}}{{if .SrcIP}}
+Source:      {{.SrcIP}}{{if .SrcPort}}:{{.SrcPort}}{{end}}{{end}}{{if .DstIP}}
+Destination: {{.DstIP}}{{if .DstPort}}:{{.DstPort}}{{end}}{{end}}{{if .Protocol}}
+Protocol:    {{.Protocol}}{{end}}{{if .Description}}
+
+{{.Description}}{{end}}
+
+Alert #{{.ID}}
+`
+
+// smtpChannel sends plain text email. STARTTLS is used whenever the server
+// offers it; TLS selects implicit TLS (usually port 465) instead. Without
+// a username no AUTH is attempted, which suits local relays and test
+// servers such as MailHog.
+type smtpChannel struct {
+	Host               string   `json:"host"`
+	Port               int      `json:"port"`
+	Username           string   `json:"username"`
+	Password           string   `json:"password"`
+	From               string   `json:"from"`
+	To                 []string `json:"to"`
+	TLS                bool     `json:"tls"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+}
+
+func newSMTPChannel(config json.RawMessage) (Channel, error) {
+	var c smtpChannel
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, fmt.Errorf("invalid smtp config: %w", err)
+	}
+	if c.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return nil, fmt.Errorf("smtp from and to are required")
+	}
+	if c.Port == 0 {
+		c.Port = 25
+	}
+	return &c, nil
+}
+
+func (c *smtpChannel) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	tlsConfig := &tls.Config{ServerName: c.Host, InsecureSkipVerify: c.InsecureSkipVerify}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if c.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !c.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("starttls: %w", err)
+			}
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("rcpt %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(c.buildMessage(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (c *smtpChannel) buildMessage(msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + c.From + "\r\n")
+	b.WriteString("To: " + strings.Join(c.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,109 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func init() {
+	registerChannelType("webhook", newWebhookChannel, webhookTemplate)
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+const webhookTemplate = `{{.Title}} ({{.Severity}}, rule {{.Rule}}){{if .Description}}: {{.Description}}{{end}}`
+
+// webhookChannel POSTs a JSON payload to an arbitrary URL. When a secret
+// is configured the request carries X-Pluto-Timestamp and
+// X-Pluto-Signature: sha256=HMAC-SHA256(secret, timestamp + "." + body)
+// so receivers can authenticate it and reject replays.
+type webhookChannel struct {
+	URL     string            `json:"url"`
+	Secret  string            `json:"secret"`
+	Headers map[string]string `json:"headers"`
+}
+
+func newWebhookChannel(config json.RawMessage) (Channel, error) {
+	var c webhookChannel
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+	if err := validateURL(c.URL); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *webhookChannel) Send(ctx context.Context, msg Message) error {
+	payload := map[string]interface{}{
+		"subject": msg.Subject,
+		"text":    msg.Body,
+	}
+	if msg.Report != nil {
+		payload["report"] = msg.Report
+	} else {
+		payload["alert"] = msg.Alert
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	if c.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(c.Secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		headers["X-Pluto-Timestamp"] = ts
+		headers["X-Pluto-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return postJSON(ctx, c.URL, body, headers)
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pluto-notifier")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, bytes.TrimSpace(snippet))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
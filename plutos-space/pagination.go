@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+)
+
+// parsePage reads the paging parameters shared by list endpoints: limit
+// (capped at models.MaxPageSize), cursor (a next_cursor from a previous
+// page), offset, from and to (as accepted in search queries, e.g. RFC
+// 3339 or -1h) and count (estimate, exact or none). Invalid values are
+// answered with 400; ok is false if a response has been written.
+func parsePage(w http.ResponseWriter, r *http.Request) (models.Page, bool) {
+	params := r.URL.Query()
+	page := models.Page{Limit: models.DefaultPageSize, Count: models.CountEstimate}
+
+	fail := func(format string, args ...interface{}) (models.Page, bool) {
+		http.Error(w, fmt.Sprintf(format, args...), http.StatusBadRequest)
+		return page, false
+	}
+
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return fail("limit must be a positive number")
+		}
+		page.Limit = min(l, models.MaxPageSize)
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		c, err := models.DecodeCursor(v)
+		if err != nil {
+			return fail("invalid cursor")
+		}
+		page.After = &c
+	} else if v := params.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return fail("offset must be a non-negative number")
+		}
+		if o > models.MaxOffset {
+			return fail("offset must be at most %d; use cursor to page further", models.MaxOffset)
+		}
+		page.Offset = o
+	}
+
+	if err := parseBounds(r, &page); err != nil {
+		return fail("%v", err)
+	}
+
+	switch mode := models.CountMode(params.Get("count")); mode {
+	case "":
+	case models.CountEstimate, models.CountExact, models.CountNone:
+		page.Count = mode
+	default:
+		return fail("count must be estimate, exact or none")
+	}
+
+	return page, true
+}
+
+// parseBounds reads the from and to parameters into page.
+func parseBounds(r *http.Request, page *models.Page) error {
+	now := time.Now()
+	for name, dst := range map[string]*time.Time{"from": &page.From, "to": &page.To} {
+		if v := r.URL.Query().Get(name); v != "" {
+			t, err := query.ParseTime(v, now)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			*dst = t.UTC()
+		}
+	}
+	if !page.From.IsZero() && !page.To.IsZero() && !page.From.Before(page.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// pageFields adds the paging fields of a list response to resp.
+func pageFields(resp map[string]interface{}, page models.Page, info models.PageInfo) map[string]interface{} {
+	resp["total_count"] = info.TotalCount
+	if info.TotalEstimated {
+		resp["total_estimated"] = true
+	}
+	resp["next_cursor"] = nil
+	if info.NextCursor != "" {
+		resp["next_cursor"] = info.NextCursor
+	}
+	resp["limit"] = page.Limit
+	resp["offset"] = page.Offset
+	return resp
+}
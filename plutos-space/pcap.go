@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/h3bzzz/pluto/plutos-space/sensors"
+)
+
+var sensorRegistry *sensors.Registry
+
+// packetWindow is how far either side of a stored packet's timestamp the
+// capture buffer is searched for it; stored timestamps are rounded to
+// microseconds.
+const packetWindow = time.Millisecond
+
+// parseWindow reads the window parameter, a duration, defaulting to def.
+func parseWindow(w http.ResponseWriter, r *http.Request, def time.Duration) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return def, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 || d > 24*time.Hour {
+		http.Error(w, "window must be a positive duration of at most 24h, e.g. 5m", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// servePcap fetches the packets matching q from the sensor that recorded
+// them and streams them to the client as a download. The sensor can be
+// overridden with the sensor parameter; limit and format (pcap or
+// pcapng) are passed on to the sensor.
+func servePcap(w http.ResponseWriter, r *http.Request, sensor string, q sensors.Query, filename string) {
+	params := r.URL.Query()
+	if v := params.Get("sensor"); v != "" {
+		sensor = v
+	}
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.Limit = l
+	}
+	q.Format = params.Get("format")
+	if q.Format == "" {
+		q.Format = "pcap"
+	}
+	if q.Format != "pcap" && q.Format != "pcapng" {
+		http.Error(w, "format must be pcap or pcapng", http.StatusBadRequest)
+		return
+	}
+
+	sensor, err := sensorRegistry.Resolve(sensor)
+	if err == sensors.ErrNoSensors {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	capture, err := sensorRegistry.Fetch(r.Context(), sensor, q)
+	var reqErr *sensors.RequestError
+	switch {
+	case err == sensors.ErrNoPackets:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.As(err, &reqErr):
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	case err != nil:
+		log.Printf("Error fetching packets from sensor %s: %v", sensor, err)
+		http.Error(w, "Error fetching packets from sensor "+sensor, http.StatusBadGateway)
+		return
+	}
+	defer capture.Body.Close()
+
+	w.Header().Set("Content-Type", capture.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+q.Format))
+	w.Header().Set("X-Packet-Count", strconv.Itoa(capture.Packets))
+	if _, err := io.Copy(w, capture.Body); err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("Error streaming packets from sensor %s: %v", sensor, err)
+		}
+		// Abort rather than end a download that is missing packets.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// packetPcapHandler returns the raw bytes of a stored packet. With
+// flow=true it returns both directions of the packet's connection within
+// window (default 5m) either side of it instead.
+func packetPcapHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	flow, err := models.NewDB(db).GetPacketFlow(id)
+	if err == models.ErrPacketNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying packet %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	q := sensors.Query{
+		From:     flow.Timestamp.Add(-packetWindow),
+		To:       flow.Timestamp.Add(packetWindow),
+		SrcIP:    flow.SrcIP,
+		DstIP:    flow.DstIP,
+		SrcPort:  flow.SrcPort,
+		DstPort:  flow.DstPort,
+		Protocol: flow.Protocol,
+	}
+	filename := fmt.Sprintf("packet-%d", id)
+	if r.URL.Query().Get("flow") == "true" {
+		window, ok := parseWindow(w, r, 5*time.Minute)
+		if !ok {
+			return
+		}
+		q.From, q.To = flow.Timestamp.Add(-window), flow.Timestamp.Add(window)
+		q.Bidirectional = true
+		filename = fmt.Sprintf("packet-%d-flow", id)
+	}
+	servePcap(w, r, flow.Sensor, q, filename)
+}
+
+// alertPcapHandler returns the packets between an alert's addresses,
+// ports and protocol in both directions, from window (default 1m) before
+// the alert was first seen to window after it was last seen.
+func alertPcapHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	alert, err := models.NewDB(db).GetAlert(id)
+	if err == models.ErrAlertNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying alert %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if alert.SrcIP == "" && alert.DstIP == "" {
+		http.Error(w, "alert has no addresses to select packets by", http.StatusUnprocessableEntity)
+		return
+	}
+
+	window, ok := parseWindow(w, r, time.Minute)
+	if !ok {
+		return
+	}
+	q := sensors.Query{
+		From:          alert.Timestamp.Add(-window),
+		To:            alert.LastSeen.Add(window),
+		SrcIP:         alert.SrcIP,
+		DstIP:         alert.DstIP,
+		SrcPort:       alert.SrcPort,
+		DstPort:       alert.DstPort,
+		Protocol:      alert.Protocol,
+		Bidirectional: true,
+	}
+	servePcap(w, r, alert.Sensor, q, fmt.Sprintf("alert-%d", id))
+}
+
+// flowPcapHandler returns the packets of a flow given by src_ip, dst_ip,
+// src_port, dst_port and protocol (at least one address is required)
+// between from (default an hour before to) and to (default now). Both
+// directions are returned unless bidirectional=false.
+func flowPcapHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := sensors.Query{
+		SrcIP:         params.Get("src_ip"),
+		DstIP:         params.Get("dst_ip"),
+		Protocol:      params.Get("protocol"),
+		Bidirectional: params.Get("bidirectional") != "false",
+	}
+	if q.SrcIP == "" && q.DstIP == "" {
+		http.Error(w, "src_ip or dst_ip is required", http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]*int{"src_port": &q.SrcPort, "dst_port": &q.DstPort} {
+		if v := params.Get(name); v != "" {
+			port, err := strconv.Atoi(v)
+			if err != nil || port <= 0 || port > 65535 {
+				http.Error(w, name+" must be a port number", http.StatusBadRequest)
+				return
+			}
+			*dst = port
+		}
+	}
+
+	now := time.Now()
+	q.To = now
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := params.Get(name); v != "" {
+			t, err := query.ParseTime(v, now)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-time.Hour)
+	}
+	if !q.From.Before(q.To) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	servePcap(w, r, "", q, "flow-"+now.UTC().Format("20060102T150405Z"))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxies are the reverse proxies from -trusted-proxies. Requests from
+// them name the client in X-Real-IP or X-Forwarded-For.
+var proxies = &proxyList{}
+
+// proxyList holds addresses and networks, and hostnames that are
+// resolved again every minute so a restarted container is still found.
+type proxyList struct {
+	nets  []*net.IPNet
+	hosts []string
+
+	mu       sync.Mutex
+	resolved []net.IP
+	expires  time.Time
+}
+
+// parseProxies splits the -trusted-proxies flag into IP addresses, CIDR
+// networks and hostnames.
+func parseProxies(value string) (*proxyList, error) {
+	p := &proxyList{}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+		case strings.Contains(s, "/"):
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			p.nets = append(p.nets, n)
+		case net.ParseIP(s) != nil:
+			ip := net.ParseIP(s)
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			p.hosts = append(p.hosts, s)
+		}
+	}
+	return p, nil
+}
+
+// contains reports whether host, an IP address, is a trusted proxy.
+func (p *proxyList) contains(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if len(p.hosts) == 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now := time.Now(); now.After(p.expires) {
+		p.resolved = p.resolved[:0]
+		for _, h := range p.hosts {
+			addrs, err := net.LookupIP(h)
+			if err != nil {
+				log.Printf("Error resolving trusted proxy %s: %v", h, err)
+				continue
+			}
+			p.resolved = append(p.resolved, addrs...)
+		}
+		p.expires = now.Add(time.Minute)
+	}
+	for _, r := range p.resolved {
+		if r.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the client address of a request. For requests from a
+// trusted proxy it is X-Real-IP or else the last address in
+// X-Forwarded-For not itself a trusted proxy; without either header it is
+// the proxy's own address.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !proxies.contains(host) {
+		return host
+	}
+
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		if i == 0 || !proxies.contains(addr) {
+			return addr
+		}
+	}
+	return host
+}
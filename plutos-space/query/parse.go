@@ -0,0 +1,368 @@
+// Package query implements the search language accepted by the list
+// endpoints and compiles it to parameterized SQL.
+//
+// A query is a boolean combination of field comparisons:
+//
+//	src_ip in 10.0.0.0/8 and dst_port:443 and not sni:*.google.com and payload_size > 1000
+//
+// Terms next to each other are ANDed; "and", "or", "not" (or "!") and
+// parentheses combine them. Comparisons are field:value (match, with *
+// wildcards, CIDR blocks for IPs and lo..hi ranges), field = value,
+// field != value, <, <=, >, >= and field in (a, b, ...). Words without a
+// field search the schema's text column. Values with spaces or special
+// characters can be double-quoted.
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SyntaxError reports where and why a query could not be parsed.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Query is a parsed and validated search.
+type Query struct {
+	root node
+}
+
+// maxDepth bounds nesting so a hostile query cannot exhaust the stack.
+const maxDepth = 50
+
+type parser struct {
+	input  string
+	pos    int
+	schema Schema
+	depth  int
+}
+
+// Parse parses input against schema. Unknown fields, values of the wrong
+// type and unsupported operators are reported as *SyntaxError. An empty
+// input yields a nil Query, which matches everything.
+func Parse(input string, schema Schema) (*Query, error) {
+	p := &parser{input: input, schema: schema}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+	return &Query{root: root}, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.input) }
+
+func (p *parser) rest() string {
+	r := p.input[p.pos:]
+	if len(r) > 20 {
+		r = r[:20] + "..."
+	}
+	return r
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes kw (case-insensitively) if it is the next word.
+func (p *parser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.input) && isWordByte(p.input[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) peekByte(b byte) bool {
+	p.skipSpace()
+	return !p.eof() && p.input[p.pos] == b
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b == '.' || b == '-' || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+}
+
+func isFieldByte(b byte) bool {
+	return b == '_' || b == '.' || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf("query is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.eof() || p.peekByte(')') {
+			return left, nil
+		}
+		// "or" ends this conjunction; look ahead without consuming it.
+		save := p.pos
+		if p.keyword("or") {
+			p.pos = save
+			return left, nil
+		}
+		p.keyword("and")
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	if p.keyword("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	if p.peekByte('!') && !strings.HasPrefix(p.input[p.pos:], "!=") {
+		p.pos++
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("expected a search term")
+	}
+
+	if p.input[p.pos] == '(' {
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekByte(')') {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return x, nil
+	}
+
+	start := p.pos
+	if p.input[p.pos] == '"' {
+		word, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return p.freeText(start, word)
+	}
+
+	for !p.eof() && isFieldByte(p.input[p.pos]) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+
+	op := p.operator()
+	if op == "" {
+		// Not a comparison; treat the whole word as free text.
+		p.pos = start
+		word := p.bareValue()
+		if word == "" {
+			return nil, p.errorf("unexpected %q", p.rest())
+		}
+		return p.freeText(start, word)
+	}
+	if name == "" {
+		return nil, &SyntaxError{Pos: start, Msg: "expected a field name before " + op}
+	}
+
+	field, key, ok := p.schema.lookup(name)
+	if !ok {
+		return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unknown field %q (fields: %s)", name, strings.Join(p.schema.FieldNames(), ", "))}
+	}
+
+	if op == "in" {
+		values, err := p.valueList()
+		if err != nil {
+			return nil, err
+		}
+		return p.compare(start, name, field, key, op, values)
+	}
+
+	p.skipSpace()
+	valuePos := p.pos
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, &SyntaxError{Pos: valuePos, Msg: fmt.Sprintf("expected a value after %s%s", name, op)}
+	}
+	return p.compare(valuePos, name, field, key, op, []string{value})
+}
+
+// operator consumes a comparison operator following a field name. ":" must
+// follow the field directly; the others may be surrounded by spaces.
+func (p *parser) operator() string {
+	if !p.eof() && p.input[p.pos] == ':' {
+		p.pos++
+		return ":"
+	}
+	save := p.pos
+	p.skipSpace()
+	for _, op := range []string{">=", "<=", "!=", "=", ">", "<"} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	if p.keyword("in") {
+		return "in"
+	}
+	p.pos = save
+	return ""
+}
+
+func (p *parser) value() (string, error) {
+	if !p.eof() && p.input[p.pos] == '"' {
+		return p.quoted()
+	}
+	return p.bareValue(), nil
+}
+
+// bareValue reads up to the next space, parenthesis or comma.
+func (p *parser) bareValue() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.input[p.pos]
+		if unicode.IsSpace(rune(c)) || c == '(' || c == ')' || c == ',' || c == '"' {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.eof() {
+		c := p.input[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.input):
+			b.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", &SyntaxError{Pos: start, Msg: "unterminated quoted string"}
+}
+
+// valueList reads "(a, b, c)" or a single value after "in".
+func (p *parser) valueList() ([]string, error) {
+	p.skipSpace()
+	if !p.peekByte('(') {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			return nil, p.errorf("expected a value or list after in")
+		}
+		return []string{v}, nil
+	}
+	p.pos++
+
+	var values []string
+	for {
+		p.skipSpace()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			return nil, p.errorf("expected a value in list")
+		}
+		values = append(values, v)
+
+		p.skipSpace()
+		if p.peekByte(',') {
+			p.pos++
+			continue
+		}
+		if p.peekByte(')') {
+			p.pos++
+			return values, nil
+		}
+		return nil, p.errorf("expected , or ) in list")
+	}
+}
+
+func (p *parser) freeText(pos int, word string) (node, error) {
+	if p.schema.Text == "" {
+		return nil, &SyntaxError{Pos: pos, Msg: fmt.Sprintf("%q is not a field comparison; use field:value (fields: %s)", word, strings.Join(p.schema.FieldNames(), ", "))}
+	}
+	return p.compare(pos, "text", Field{Columns: []string{p.schema.Text}, Type: Text}, "", ":", []string{word})
+}
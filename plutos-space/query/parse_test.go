@@ -0,0 +1,110 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	Fields: map[string]Field{
+		"ip":        {Columns: []string{"src_ip", "dst_ip"}, Type: IP},
+		"src_ip":    {Columns: []string{"src_ip"}, Type: IP},
+		"port":      {Columns: []string{"src_port", "dst_port"}, Type: Int},
+		"dst_port":  {Columns: []string{"dst_port"}, Type: Int},
+		"protocol":  {Columns: []string{"protocol"}, Type: String},
+		"sni":       {Columns: []string{"sni"}, Type: String},
+		"title":     {Columns: []string{"title"}, Type: Text},
+		"timestamp": {Columns: []string{"timestamp"}, Type: Time},
+		"valid":     {Columns: []string{"checksum_valid"}, Type: Bool},
+		"names":     {Columns: []string{"names"}, Type: StringArray},
+		"metadata":  {Columns: []string{"metadata"}, Type: Map},
+	},
+	Text: "message",
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, input := range []string{"", "   ", "\t\n"} {
+		q, err := Parse(input, testSchema)
+		if err != nil || q != nil {
+			t.Errorf("Parse(%q) = %v, %v; want nil, nil", input, q, err)
+		}
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	for _, input := range []string{
+		"dst_port:443",
+		"dst_port:443 or dst_port:80",
+		"protocol = TCP",
+		"src_ip:10.0.0.0/8 dst_port:443 and not sni:*.google.com",
+		"(protocol:tcp or protocol:udp) and !ip:192.168.*",
+		"NOT (port >= 1024 AND port <= 2048)",
+		"dst_port in (22, 80, 443)",
+		"dst_port in 22",
+		"dst_port:1..1024",
+		"timestamp >= -24h timestamp < now",
+		"valid = false",
+		"names:*.example.com",
+		"metadata.user:admin metadata.http.status:404",
+		`title:"port scan" "connection refused"`,
+		`sni:"a \"quoted\" name"`,
+		"error",
+		"android-", // free text
+	} {
+		if _, err := Parse(input, testSchema); err != nil {
+			t.Errorf("Parse(%q): %v", input, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{"(dst_port:443", 13, "expected )"},
+		{"dst_port:443)", 12, "unexpected"},
+		{"unknown:1", 0, `unknown field "unknown"`},
+		{"metadata:x", 0, `unknown field "metadata"`},
+		{"metadata..a:x", 0, `unknown field "metadata..a"`},
+		{"dst_port : 443", 9, "expected a field name before :"},
+		{"dst_port:abc", 9, `"abc" is not a number`},
+		{"dst_port:1..x", 9, `"x" is not a number`},
+		{"valid:maybe", 6, "not true or false"},
+		{"timestamp > yesterday", 12, "invalid time"},
+		{"src_ip:10.0.0.0/33", 7, "invalid CIDR"},
+		{"src_ip:10.0.0.300", 7, "invalid IP address"},
+		{"protocol > tcp", 11, "operator > is not supported"},
+		{"names = x", 8, "operator = is not supported"},
+		{`sni:"open`, 4, "unterminated quoted string"},
+		{"not", 3, "expected a search term"},
+		{"dst_port:", 9, "expected a value after dst_port:"},
+		{"dst_port in (80, 443", 20, "expected , or )"},
+		{"dst_port in (80,)", 16, "expected a value in list"},
+		{":443", 0, "expected a field name before :"},
+		{"dst_port:443 and", 16, "expected a search term"},
+		{strings.Repeat("(", maxDepth+1) + "x" + strings.Repeat(")", maxDepth+1), maxDepth, "nested too deeply"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input, testSchema)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) = %v; want a *SyntaxError", tt.input, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos || !strings.Contains(syntaxErr.Msg, tt.msg) {
+			t.Errorf("Parse(%q) = error at %d %q; want at %d containing %q", tt.input, syntaxErr.Pos, syntaxErr.Msg, tt.pos, tt.msg)
+		}
+	}
+}
+
+func TestParseFreeTextWithoutTextColumn(t *testing.T) {
+	schema := Schema{Fields: testSchema.Fields}
+	_, err := Parse("refused", schema)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || !strings.Contains(syntaxErr.Msg, "not a field comparison") {
+		t.Errorf("Parse without a text column = %v; want a field comparison error", err)
+	}
+}
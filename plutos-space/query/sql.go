@@ -0,0 +1,352 @@
+package query
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type determines which operators a field supports and how values are
+// interpreted.
+type Type int
+
+const (
+	// String fields match case-insensitively with ":" (with * wildcards)
+	// and exactly with "=".
+	String Type = iota
+	// Text fields match substrings with ":".
+	Text
+	// Int fields support comparisons and lo..hi ranges.
+	Int
+	// IP fields match addresses, CIDR blocks (10.0.0.0/8) or wildcards
+	// (10.0.*) stored as text.
+	IP
+	Bool
+	// Time fields accept RFC 3339, YYYY-MM-DD, Unix seconds, "now" and
+	// relative times such as -15m or now-7d.
+	Time
+	// StringArray fields are JSONB arrays of strings; ":" matches if any
+	// element matches.
+	StringArray
+	// Map fields are JSONB objects searched as field.key:value, or
+	// field.key.subkey:value for nested objects.
+	Map
+)
+
+// Field maps a query field to one or more columns. With several columns a
+// comparison matches if it holds for any of them, e.g. ip for src_ip or
+// dst_ip.
+type Field struct {
+	Columns []string
+	Type    Type
+}
+
+// Schema lists the fields that can be searched on a table. Text, if set,
+// is the column searched by words without a field.
+type Schema struct {
+	Fields map[string]Field
+	Text   string
+}
+
+func (s Schema) FieldNames() []string {
+	names := make([]string, 0, len(s.Fields))
+	for name, f := range s.Fields {
+		if f.Type == Map {
+			name += ".<key>"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var mapKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*$`)
+
+// lookup resolves a field name, including map.key for Map fields.
+func (s Schema) lookup(name string) (Field, string, bool) {
+	if f, ok := s.Fields[name]; ok && f.Type != Map {
+		return f, "", true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if f, ok := s.Fields[name[:i]]; ok && f.Type == Map && mapKeyPattern.MatchString(name[i+1:]) {
+			return f, name[i+1:], true
+		}
+	}
+	return Field{}, "", false
+}
+
+// node is a compiled boolean expression. Predicates use $? for each
+// parameter; SQL numbers them.
+type node interface {
+	build(b *builder) string
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ x node }
+
+type predicate struct {
+	format string
+	args   []interface{}
+}
+
+type builder struct {
+	params []interface{}
+}
+
+func (n andNode) build(b *builder) string {
+	return "(" + n.left.build(b) + " AND " + n.right.build(b) + ")"
+}
+
+func (n orNode) build(b *builder) string {
+	return "(" + n.left.build(b) + " OR " + n.right.build(b) + ")"
+}
+
+func (n notNode) build(b *builder) string {
+	return "NOT " + n.x.build(b)
+}
+
+func (n predicate) build(b *builder) string {
+	var sb strings.Builder
+	rest := n.format
+	for _, arg := range n.args {
+		i := strings.Index(rest, "$?")
+		sb.WriteString(rest[:i])
+		b.params = append(b.params, arg)
+		fmt.Fprintf(&sb, "$%d", len(b.params))
+		rest = rest[i+2:]
+	}
+	sb.WriteString(rest)
+	return sb.String()
+}
+
+// SQL returns a boolean SQL expression for q, numbering its parameters
+// after those already in params, and the extended params. A nil Query
+// yields TRUE.
+func (q *Query) SQL(params []interface{}) (string, []interface{}) {
+	if q == nil {
+		return "TRUE", params
+	}
+	b := &builder{params: params}
+	return q.root.build(b), b.params
+}
+
+// compare validates a comparison and turns it into a predicate over every
+// column of the field.
+func (p *parser) compare(pos int, name string, f Field, key, op string, values []string) (node, error) {
+	fail := func(format string, args ...interface{}) error {
+		return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	var parts []predicate
+	for _, col := range f.Columns {
+		expr := col
+		typ := f.Type
+		if typ == Map {
+			expr = col + "->>" + quoteLiteral(key)
+			if strings.Contains(key, ".") {
+				expr = col + "#>>" + quoteLiteral("{"+strings.ReplaceAll(key, ".", ",")+"}")
+			}
+			typ = String
+		}
+
+		var pred predicate
+		var err error
+		switch op {
+		case "in":
+			pred, err = inPredicate(expr, typ, values)
+		default:
+			pred, err = comparePredicate(expr, typ, op, values[0])
+		}
+		if err != nil {
+			return nil, fail("%s: %v", name, err)
+		}
+		parts = append(parts, pred)
+	}
+
+	// NULL columns never match, and "not" of them does, rather than
+	// both yielding NULL. A field matches if any of its columns does,
+	// except for != which, like "not", requires every column to differ.
+	var n node
+	for _, pred := range parts {
+		pred.format = "COALESCE(" + pred.format + ", FALSE)"
+		switch {
+		case n == nil:
+			n = pred
+		case op == "!=":
+			n = andNode{n, pred}
+		default:
+			n = orNode{n, pred}
+		}
+	}
+	return n, nil
+}
+
+func inPredicate(expr string, typ Type, values []string) (predicate, error) {
+	var parts []string
+	var args []interface{}
+	for _, v := range values {
+		pred, err := comparePredicate(expr, typ, ":", v)
+		if err != nil {
+			return predicate{}, err
+		}
+		parts = append(parts, pred.format)
+		args = append(args, pred.args...)
+	}
+	return predicate{"(" + strings.Join(parts, " OR ") + ")", args}, nil
+}
+
+func comparePredicate(expr string, typ Type, op, value string) (predicate, error) {
+	if lo, hi, ok := strings.Cut(value, ".."); ok && op == ":" && (typ == Int || typ == Time) {
+		loArg, err := parseValue(typ, lo)
+		if err != nil {
+			return predicate{}, err
+		}
+		hiArg, err := parseValue(typ, hi)
+		if err != nil {
+			return predicate{}, err
+		}
+		return predicate{expr + " BETWEEN $? AND $?", []interface{}{loArg, hiArg}}, nil
+	}
+
+	switch typ {
+	case String, Text:
+		switch op {
+		case ":":
+			pattern := likePattern(value)
+			if typ == Text && !strings.Contains(value, "*") {
+				pattern = "%" + pattern + "%"
+			}
+			return predicate{expr + " ILIKE $?", []interface{}{pattern}}, nil
+		case "=":
+			return predicate{expr + " = $?", []interface{}{value}}, nil
+		case "!=":
+			return predicate{expr + " <> $?", []interface{}{value}}, nil
+		}
+
+	case StringArray:
+		if op == ":" {
+			return predicate{"EXISTS (SELECT 1 FROM jsonb_array_elements_text(" + expr + ") e WHERE e ILIKE $?)", []interface{}{likePattern(value)}}, nil
+		}
+
+	case IP:
+		if op != ":" && op != "=" && op != "!=" {
+			break
+		}
+		var pred predicate
+		switch {
+		case strings.Contains(value, "/"):
+			_, ipnet, err := net.ParseCIDR(value)
+			if err != nil {
+				return predicate{}, fmt.Errorf("invalid CIDR %q", value)
+			}
+			pred = predicate{"siem.try_inet(" + expr + ") <<= $?::inet", []interface{}{ipnet.String()}}
+		case strings.Contains(value, "*"):
+			pred = predicate{expr + " LIKE $?", []interface{}{likePattern(value)}}
+		default:
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return predicate{}, fmt.Errorf("invalid IP address %q", value)
+			}
+			pred = predicate{expr + " = $?", []interface{}{ip.String()}}
+		}
+		if op == "!=" {
+			pred.format = "NOT " + pred.format
+		}
+		return pred, nil
+
+	case Int, Time, Bool:
+		if typ == Bool && op != ":" && op != "=" && op != "!=" {
+			break
+		}
+		arg, err := parseValue(typ, value)
+		if err != nil {
+			return predicate{}, err
+		}
+		sqlOp := op
+		switch op {
+		case ":":
+			sqlOp = "="
+		case "!=":
+			sqlOp = "<>"
+		}
+		return predicate{expr + " " + sqlOp + " $?", []interface{}{arg}}, nil
+	}
+
+	return predicate{}, fmt.Errorf("operator %s is not supported for this field", op)
+}
+
+func parseValue(typ Type, value string) (interface{}, error) {
+	switch typ {
+	case Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return n, nil
+	case Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", value)
+		}
+		return b, nil
+	case Time:
+		t, err := ParseTime(value, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	return value, nil
+}
+
+// ParseTime accepts RFC 3339, YYYY-MM-DD, Unix seconds, "now", and times
+// relative to now such as -15m, now-1h or -7d.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	v := strings.ToLower(value)
+	if v == "now" {
+		return now, nil
+	}
+	if rel := strings.TrimPrefix(v, "now"); strings.HasPrefix(rel, "-") {
+		d, err := parseDuration(rel[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q", value)
+		}
+		return now.Add(-d), nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD, Unix seconds or e.g. -15m)", value)
+}
+
+func parseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// likePattern escapes LIKE metacharacters and turns * into %.
+func likePattern(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return r.Replace(value)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
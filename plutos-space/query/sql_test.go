@@ -0,0 +1,193 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		input  string
+		sql    string
+		params []interface{}
+	}{
+		{
+			`sni:*.google.com`,
+			`COALESCE(sni ILIKE $1, FALSE)`,
+			[]interface{}{"%.google.com"},
+		},
+		{
+			`sni:50%_off\`,
+			`COALESCE(sni ILIKE $1, FALSE)`,
+			[]interface{}{`50\%\_off\\`},
+		},
+		{
+			`title:scan`,
+			`COALESCE(title ILIKE $1, FALSE)`,
+			[]interface{}{"%scan%"},
+		},
+		{
+			`title:scan*`,
+			`COALESCE(title ILIKE $1, FALSE)`,
+			[]interface{}{"scan%"},
+		},
+		{
+			`refused`,
+			`COALESCE(message ILIKE $1, FALSE)`,
+			[]interface{}{"%refused%"},
+		},
+		{
+			`protocol = TCP`,
+			`COALESCE(protocol = $1, FALSE)`,
+			[]interface{}{"TCP"},
+		},
+		{
+			`protocol != TCP`,
+			`COALESCE(protocol <> $1, FALSE)`,
+			[]interface{}{"TCP"},
+		},
+		{
+			`ip:10.0.0.1`,
+			`(COALESCE(src_ip = $1, FALSE) OR COALESCE(dst_ip = $2, FALSE))`,
+			[]interface{}{"10.0.0.1", "10.0.0.1"},
+		},
+		{
+			`ip != 10.0.0.1`,
+			`(COALESCE(NOT src_ip = $1, FALSE) AND COALESCE(NOT dst_ip = $2, FALSE))`,
+			[]interface{}{"10.0.0.1", "10.0.0.1"},
+		},
+		{
+			`not ip:10.0.0.1`,
+			`NOT (COALESCE(src_ip = $1, FALSE) OR COALESCE(dst_ip = $2, FALSE))`,
+			[]interface{}{"10.0.0.1", "10.0.0.1"},
+		},
+		{
+			`port != 80`,
+			`(COALESCE(src_port <> $1, FALSE) AND COALESCE(dst_port <> $2, FALSE))`,
+			[]interface{}{int64(80), int64(80)},
+		},
+		{
+			`src_ip:10.1.2.3/8`,
+			`COALESCE(siem.try_inet(src_ip) <<= $1::inet, FALSE)`,
+			[]interface{}{"10.0.0.0/8"},
+		},
+		{
+			`src_ip != 10.0.0.0/8`,
+			`COALESCE(NOT siem.try_inet(src_ip) <<= $1::inet, FALSE)`,
+			[]interface{}{"10.0.0.0/8"},
+		},
+		{
+			`src_ip:fe80_1.*`,
+			`COALESCE(src_ip LIKE $1, FALSE)`,
+			[]interface{}{`fe80\_1.%`},
+		},
+		{
+			`dst_port:1..1024`,
+			`COALESCE(dst_port BETWEEN $1 AND $2, FALSE)`,
+			[]interface{}{int64(1), int64(1024)},
+		},
+		{
+			`dst_port in (80, 443)`,
+			`COALESCE((dst_port = $1 OR dst_port = $2), FALSE)`,
+			[]interface{}{int64(80), int64(443)},
+		},
+		{
+			`timestamp >= 2024-01-02`,
+			`COALESCE(timestamp >= $1, FALSE)`,
+			[]interface{}{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			`valid = false`,
+			`COALESCE(checksum_valid = $1, FALSE)`,
+			[]interface{}{false},
+		},
+		{
+			`names:*.example.com`,
+			`COALESCE(EXISTS (SELECT 1 FROM jsonb_array_elements_text(names) e WHERE e ILIKE $1), FALSE)`,
+			[]interface{}{"%.example.com"},
+		},
+		{
+			`metadata.user:adm*`,
+			`COALESCE(metadata->>'user' ILIKE $1, FALSE)`,
+			[]interface{}{"adm%"},
+		},
+		{
+			`metadata.http.status:404`,
+			`COALESCE(metadata#>>'{http,status}' ILIKE $1, FALSE)`,
+			[]interface{}{"404"},
+		},
+		{
+			`protocol:tcp or dst_port:1 dst_port:2`,
+			`(COALESCE(protocol ILIKE $1, FALSE) OR (COALESCE(dst_port = $2, FALSE) AND COALESCE(dst_port = $3, FALSE)))`,
+			[]interface{}{"tcp", int64(1), int64(2)},
+		},
+		{
+			`!(protocol:tcp or protocol:udp)`,
+			`NOT (COALESCE(protocol ILIKE $1, FALSE) OR COALESCE(protocol ILIKE $2, FALSE))`,
+			[]interface{}{"tcp", "udp"},
+		},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.input, testSchema)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		sql, params := q.SQL(nil)
+		if sql != tt.sql {
+			t.Errorf("%q compiles to\n\t%s\nwant\n\t%s", tt.input, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("%q has params %#v; want %#v", tt.input, params, tt.params)
+		}
+	}
+}
+
+func TestSQLNumbersAfterExistingParams(t *testing.T) {
+	q, err := Parse("dst_port:80", testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, params := q.SQL([]interface{}{"x"})
+	if want := "COALESCE(dst_port = $2, FALSE)"; sql != want {
+		t.Errorf("SQL = %s; want %s", sql, want)
+	}
+	if want := []interface{}{"x", int64(80)}; !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %#v; want %#v", params, want)
+	}
+
+	var none *Query
+	if sql, params := none.SQL([]interface{}{"x"}); sql != "TRUE" || len(params) != 1 {
+		t.Errorf("nil Query = %s, %v; want TRUE and the params unchanged", sql, params)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"now", now},
+		{"NOW", now},
+		{"-15m", now.Add(-15 * time.Minute)},
+		{"now-1h", now.Add(-time.Hour)},
+		{"-7d", now.AddDate(0, 0, -7)},
+		{"1700000000", time.Unix(1700000000, 0)},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "-", "now-", "-1x", "2024-13-01"} {
+		if _, err := ParseTime(value, now); err == nil {
+			t.Errorf("ParseTime(%q) succeeded; want an error", value)
+		}
+	}
+}
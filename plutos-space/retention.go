@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+const maxRetentionDays = 3650
+
+func retentionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	policies, err := models.NewDB(db).GetRetentionPolicies()
+	if err != nil {
+		log.Printf("Error querying retention policies: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(policies)
+}
+
+func updateRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		RetentionDays int `json:"retention_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.RetentionDays < 1 || body.RetentionDays > maxRetentionDays {
+		http.Error(w, "retention_days must be between 1 and 3650", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := models.NewDB(db).SetRetentionDays(mux.Vars(r)["table"], body.RetentionDays)
+	if err == models.ErrUnknownRetentionTable {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating retention policy: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(policy)
+}
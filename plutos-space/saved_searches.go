@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/export"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+	"github.com/h3bzzz/pluto/plutos-space/scheduler"
+)
+
+var searchScheduler *scheduler.Scheduler
+
+type savedSearchRequest struct {
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Target       string  `json:"target"`
+	Query        string  `json:"query"`
+	Shared       bool    `json:"shared"`
+	Schedule     string  `json:"schedule"`
+	Action       string  `json:"action"`
+	Window       string  `json:"window"`
+	Threshold    int     `json:"threshold"`
+	Severity     string  `json:"severity"`
+	ReportFormat string  `json:"report_format"`
+	ChannelIDs   []int64 `json:"channel_ids"`
+	Enabled      *bool   `json:"enabled"`
+}
+
+// toSavedSearch validates the request and converts it to a saved search
+// with its next run computed. Enabled defaults to true, window to 1h,
+// severity to medium and report_format to csv.
+func (req savedSearchRequest) toSavedSearch() (models.SavedSearch, error) {
+	s := models.SavedSearch{
+		Name:         strings.TrimSpace(req.Name),
+		Description:  req.Description,
+		Target:       req.Target,
+		Query:        strings.TrimSpace(req.Query),
+		Shared:       req.Shared,
+		Schedule:     strings.TrimSpace(req.Schedule),
+		Action:       req.Action,
+		Window:       req.Window,
+		Threshold:    req.Threshold,
+		Severity:     req.Severity,
+		ReportFormat: req.ReportFormat,
+		ChannelIDs:   req.ChannelIDs,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+	}
+
+	if s.Name == "" {
+		return s, fmt.Errorf("name is required")
+	}
+	target, ok := models.SearchTargets[s.Target]
+	if !ok {
+		return s, fmt.Errorf("target must be one of packets, logs, alerts")
+	}
+	if _, err := query.Parse(s.Query, target.Schema); err != nil {
+		return s, err
+	}
+
+	if s.Action != "" && s.Action != "alert" && s.Action != "report" {
+		return s, fmt.Errorf("action must be alert or report")
+	}
+	if s.Schedule != "" && s.Action == "" {
+		return s, fmt.Errorf("a schedule requires an action (alert or report)")
+	}
+	if s.Window == "" {
+		s.Window = "1h"
+	}
+	if _, err := scheduler.ParseWindow(s.Window); err != nil {
+		return s, err
+	}
+	if s.Threshold < 0 {
+		return s, fmt.Errorf("threshold must not be negative")
+	}
+	if s.Severity == "" {
+		s.Severity = "medium"
+	}
+	if !models.AlertSeverities[s.Severity] {
+		return s, fmt.Errorf("severity must be one of low, medium, high, critical")
+	}
+	if s.ReportFormat == "" {
+		s.ReportFormat = "csv"
+	}
+	if s.ReportFormat != "csv" && s.ReportFormat != "json" {
+		return s, fmt.Errorf("report_format must be csv or json")
+	}
+	if s.ChannelIDs == nil {
+		s.ChannelIDs = []int64{}
+	}
+
+	if s.Schedule != "" {
+		next, err := scheduler.NextRun(s.Schedule, time.Now())
+		if err != nil {
+			return s, fmt.Errorf("invalid schedule: %v", err)
+		}
+		if s.Enabled {
+			s.NextRunAt = &next
+		}
+	}
+	return s, nil
+}
+
+// decodeSavedSearch reads and validates a saved search from the request
+// body, writing a 400 for invalid input. ok is false if a response has
+// been written.
+func decodeSavedSearch(w http.ResponseWriter, r *http.Request, database *models.DB) (models.SavedSearch, bool) {
+	var req savedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return models.SavedSearch{}, false
+	}
+
+	s, err := req.toSavedSearch()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+
+	for _, id := range s.ChannelIDs {
+		_, err := database.GetNotificationChannel(id)
+		if err == models.ErrChannelNotFound {
+			http.Error(w, fmt.Sprintf("notification channel %d does not exist", id), http.StatusBadRequest)
+			return s, false
+		}
+		if err != nil {
+			log.Printf("Error querying notification channel %d: %v", id, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return s, false
+		}
+	}
+	return s, true
+}
+
+// visibleSavedSearch loads the search named in the path and checks that
+// the current user may see it, answering 404 otherwise so private searches
+// are not disclosed.
+func visibleSavedSearch(w http.ResponseWriter, r *http.Request, database *models.DB) (models.SavedSearch, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return models.SavedSearch{}, false
+	}
+
+	s, err := database.GetSavedSearch(id)
+	if err == models.ErrSavedSearchNotFound || (err == nil && !s.VisibleTo(currentUser(r))) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return s, false
+	}
+	if err != nil {
+		log.Printf("Error querying saved search %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return s, false
+	}
+	return s, true
+}
+
+func savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	searches, err := models.NewDB(db).ListSavedSearches(currentUser(r))
+	if err != nil {
+		log.Printf("Error querying saved searches: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(searches)
+}
+
+func savedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, ok := visibleSavedSearch(w, r, models.NewDB(db))
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+func createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	database := models.NewDB(db)
+	s, ok := decodeSavedSearch(w, r, database)
+	if !ok {
+		return
+	}
+	user := currentUser(r)
+	s.OwnerID = &user.ID
+	s.Owner = user.Username
+
+	created, err := database.CreateSavedSearch(s)
+	if err == models.ErrSavedSearchExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating saved search: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	setAuditDetail(r, "name", created.Name)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// updateSavedSearchHandler replaces a search's definition. Only its owner
+// and admins may change it.
+func updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	database := models.NewDB(db)
+	existing, ok := visibleSavedSearch(w, r, database)
+	if !ok {
+		return
+	}
+	if !existing.EditableBy(currentUser(r)) {
+		http.Error(w, "Forbidden: only the owner or an admin can change this search", http.StatusForbidden)
+		return
+	}
+
+	s, ok := decodeSavedSearch(w, r, database)
+	if !ok {
+		return
+	}
+	s.ID = existing.ID
+
+	updated, err := database.UpdateSavedSearch(s)
+	if err == models.ErrSavedSearchNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrSavedSearchExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating saved search %d: %v", s.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(updated)
+}
+
+func deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	database := models.NewDB(db)
+	existing, ok := visibleSavedSearch(w, r, database)
+	if !ok {
+		return
+	}
+	if !existing.EditableBy(currentUser(r)) {
+		http.Error(w, "Forbidden: only the owner or an admin can delete this search", http.StatusForbidden)
+		return
+	}
+
+	paths, err := database.DeleteSavedSearch(existing.ID)
+	if err == models.ErrSavedSearchNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting saved search %d: %v", existing.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	scheduler.RemoveReports(paths)
+	setAuditDetail(r, "name", existing.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSavedSearchHandler runs a search once now, performing its action: an
+// alert search raises an alert if over its threshold and a report search
+// writes and sends a report. Searches without an action just count their
+// matches over the window.
+func runSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, ok := visibleSavedSearch(w, r, models.NewDB(db))
+	if !ok {
+		return
+	}
+
+	result, err := searchScheduler.RunSearch(r.Context(), s)
+	if err != nil {
+		log.Printf("Error running saved search %d: %v", s.ID, err)
+		http.Error(w, "Error running search: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func savedSearchReportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	database := models.NewDB(db)
+	s, ok := visibleSavedSearch(w, r, database)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	reports, err := database.ListSearchReports(s.ID, limit)
+	if err != nil {
+		log.Printf("Error querying reports for saved search %d: %v", s.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(reports)
+}
+
+// downloadReportHandler serves a report file to users who can see the
+// search that produced it.
+func downloadReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	database := models.NewDB(db)
+	report, err := database.GetSearchReport(id)
+	if err == models.ErrReportNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying report %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s, err := database.GetSavedSearch(report.SearchID)
+	if err == models.ErrSavedSearchNotFound || (err == nil && !s.VisibleTo(currentUser(r))) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying saved search %d: %v", report.SearchID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(report.Path)
+	if os.IsNotExist(err) {
+		http.Error(w, "Report file no longer exists", http.StatusGone)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening report %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", export.Formats[report.Format].ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(report.Path)))
+	http.ServeContent(w, r, filepath.Base(report.Path), report.CreatedAt, f)
+}
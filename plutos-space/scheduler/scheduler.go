@@ -0,0 +1,341 @@
+// Package scheduler runs saved searches on their cron schedules, raising
+// alerts when a search matches more rows than its threshold or writing
+// the matching rows to report files that are announced through
+// notification channels.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/cron"
+	"github.com/h3bzzz/pluto/plutos-space/export"
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/h3bzzz/pluto/plutos-space/notifier"
+	"github.com/h3bzzz/pluto/plutos-space/query"
+)
+
+const (
+	pollInterval = 30 * time.Second
+	// MaxReportRows caps the rows written to one report.
+	MaxReportRows = 100000
+)
+
+// Scheduler polls siem.saved_searches for due runs. Runs are claimed by
+// advancing next_run_at, so several server instances can share the table
+// without running a search twice. Runs missed while no server was up are
+// skipped rather than caught up.
+type Scheduler struct {
+	db         *models.DB
+	dispatcher *notifier.Dispatcher
+	publish    func(context.Context, models.AlertMessage) error
+	dir        string
+	publicURL  string
+	retention  time.Duration
+}
+
+// New creates a scheduler that raises alerts through publish, writes
+// reports under dir and deletes them after retention. publicURL is the
+// server's external base URL, used for download links in notifications;
+// without it links are relative.
+func New(db *models.DB, dispatcher *notifier.Dispatcher, publish func(context.Context, models.AlertMessage) error, dir, publicURL string, retention time.Duration) *Scheduler {
+	return &Scheduler{
+		db:         db,
+		dispatcher: dispatcher,
+		publish:    publish,
+		dir:        dir,
+		publicURL:  strings.TrimRight(publicURL, "/"),
+		retention:  retention,
+	}
+}
+
+// Result is the outcome of one run of a saved search.
+type Result struct {
+	From   time.Time            `json:"from"`
+	To     time.Time            `json:"to"`
+	Count  int                  `json:"count"`
+	Alert  *models.AlertMessage `json:"alert,omitempty"`
+	Report *models.SearchReport `json:"report,omitempty"`
+}
+
+// NextRun returns when a schedule next fires after now, in UTC.
+func NextRun(schedule string, now time.Time) (time.Time, error) {
+	s, err := cron.Parse(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.Next(now.UTC()), nil
+}
+
+// ParseWindow accepts Go durations plus a "d" suffix for days, e.g. 15m,
+// 1h or 7d.
+func ParseWindow(v string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(v)
+	}
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("window must be at least 1m, e.g. \"15m\", \"1h\" or \"7d\"")
+	}
+	return d, nil
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pruneTicker.C:
+			s.prune()
+		case <-ticker.C:
+			s.poll(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) poll(ctx context.Context) {
+	now := time.Now().UTC()
+	due, err := s.db.DueSavedSearches(now)
+	if err != nil {
+		log.Printf("[Scheduler] Error querying due searches: %v", err)
+		return
+	}
+
+	for _, search := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		next, err := NextRun(search.Schedule, now)
+		if err != nil {
+			log.Printf("[Scheduler] Search %q has an invalid schedule: %v", search.Name, err)
+			continue
+		}
+		claimed, err := s.db.ClaimSavedSearchRun(search.ID, *search.NextRunAt, next)
+		if err != nil {
+			log.Printf("[Scheduler] Error claiming run of search %q: %v", search.Name, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		_, err = s.RunSearch(ctx, search)
+		if err != nil {
+			log.Printf("[Scheduler] Search %q failed: %v", search.Name, err)
+		}
+	}
+}
+
+// RunSearch runs search once over its window ending now, performs its
+// action and records the outcome. Searches without an action only count
+// their matches.
+func (s *Scheduler) RunSearch(ctx context.Context, search models.SavedSearch) (Result, error) {
+	result, err := s.run(ctx, search)
+	s.record(search, err)
+	return result, err
+}
+
+func (s *Scheduler) record(search models.SavedSearch, runErr error) {
+	status := "ok"
+	if runErr != nil {
+		status = "failed"
+	}
+	if err := s.db.RecordSavedSearchRun(search.ID, status, runErr); err != nil {
+		log.Printf("[Scheduler] Error recording run of search %q: %v", search.Name, err)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, search models.SavedSearch) (Result, error) {
+	target, ok := models.SearchTargets[search.Target]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown target %q", search.Target)
+	}
+	q, err := query.Parse(search.Query, target.Schema)
+	if err != nil {
+		return Result{}, err
+	}
+	window, err := ParseWindow(search.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{To: time.Now().UTC()}
+	result.From = result.To.Add(-window)
+
+	switch search.Action {
+	case "report":
+		return s.report(ctx, search, target, q, result)
+	case "alert":
+		if result.Count, err = s.db.CountSearch(target, q, result.From, result.To); err != nil {
+			return result, err
+		}
+		if result.Count > search.Threshold {
+			alert, err := s.raiseAlert(ctx, search, result)
+			if err != nil {
+				return result, err
+			}
+			result.Alert = &alert
+		}
+		return result, nil
+	default:
+		result.Count, err = s.db.CountSearch(target, q, result.From, result.To)
+		return result, err
+	}
+}
+
+// raiseAlert publishes an alert for a search over its threshold. Repeats
+// of a search's open alert are counted on it rather than raised again; the
+// notifier picks up new ones like any other alert.
+func (s *Scheduler) raiseAlert(ctx context.Context, search models.SavedSearch, result Result) (models.AlertMessage, error) {
+	alert := models.NewAlertMessage(models.Alert{
+		Timestamp:   result.To,
+		Rule:        "saved-search",
+		Title:       fmt.Sprintf("Saved search %q matched %d %s", search.Name, result.Count, search.Target),
+		Description: fmt.Sprintf("%d %s matched %s in the last %s (threshold %d).", result.Count, search.Target, search.Query, search.Window, search.Threshold),
+		Severity:    search.Severity,
+		Metadata: map[string]interface{}{
+			"saved_search_id": search.ID,
+			"saved_search":    search.Name,
+			"query":           search.Query,
+			"count":           result.Count,
+			"threshold":       search.Threshold,
+			"from":            result.From.Format(time.RFC3339),
+			"to":              result.To.Format(time.RFC3339),
+		},
+	}, "saved-search:"+strconv.FormatInt(search.ID, 10))
+	return alert, s.publish(ctx, alert)
+}
+
+func (s *Scheduler) report(ctx context.Context, search models.SavedSearch, target models.SearchTarget, q *query.Query, result Result) (Result, error) {
+	report, err := s.writeReport(ctx, search, target, q, result)
+	if err != nil {
+		return result, err
+	}
+	result.Count = report.RowCount
+
+	stored, err := s.db.CreateSearchReport(report)
+	if err != nil {
+		os.Remove(report.Path)
+		return result, err
+	}
+	report = stored
+	result.Report = &report
+
+	err = s.dispatcher.SendReport(ctx, search.ChannelIDs, notifier.Report{
+		Search: search,
+		Report: report,
+		URL:    fmt.Sprintf("%s/api/reports/%d/download", s.publicURL, report.ID),
+	})
+	if err != nil {
+		return result, fmt.Errorf("report written but not sent: %w", err)
+	}
+	return result, nil
+}
+
+// errReportFull stops an export one row past MaxReportRows.
+var errReportFull = errors.New("report is full")
+
+// reportWriter writes up to MaxReportRows rows and notes whether more
+// matched.
+type reportWriter struct {
+	export.Writer
+	rows      int
+	truncated bool
+}
+
+func (w *reportWriter) WriteRow(values []interface{}) error {
+	if w.rows == MaxReportRows {
+		w.truncated = true
+		return errReportFull
+	}
+	w.rows++
+	return w.Writer.WriteRow(values)
+}
+
+// writeReport streams the matching rows, newest first, into a new report
+// file and describes it.
+func (s *Scheduler) writeReport(ctx context.Context, search models.SavedSearch, target models.SearchTarget, q *query.Query, result Result) (models.SearchReport, error) {
+	report := models.SearchReport{
+		SearchID: search.ID,
+		From:     result.From,
+		To:       result.To,
+		Format:   search.ReportFormat,
+	}
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return report, err
+	}
+	pattern := fmt.Sprintf("search-%d-%s-*.%s", search.ID, result.To.Format("20060102T150405Z"), search.ReportFormat)
+	f, err := os.CreateTemp(s.dir, pattern)
+	if err != nil {
+		return report, err
+	}
+
+	var w *reportWriter
+	_, err = s.db.ExportSearch(ctx, target, q, result.From, result.To, MaxReportRows+1, func(columns []export.Column) (export.Writer, error) {
+		ew, err := export.NewWriter(search.ReportFormat, f, columns, false)
+		if err != nil {
+			return nil, err
+		}
+		w = &reportWriter{Writer: ew}
+		return w, nil
+	})
+	if err == errReportFull {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if info, statErr := f.Stat(); statErr == nil {
+		report.SizeBytes = info.Size()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return report, err
+	}
+
+	if report.Path, err = filepath.Abs(f.Name()); err != nil {
+		report.Path = f.Name()
+	}
+	report.RowCount, report.Truncated = w.rows, w.truncated
+	return report, nil
+}
+
+// prune deletes reports older than the retention period and their files.
+func (s *Scheduler) prune() {
+	paths, err := s.db.PruneSearchReports(time.Now().UTC().Add(-s.retention))
+	if err != nil {
+		log.Printf("[Scheduler] Error pruning reports: %v", err)
+		return
+	}
+	RemoveReports(paths)
+}
+
+// RemoveReports deletes report files, ignoring ones already gone.
+func RemoveReports(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Scheduler] Error removing report %s: %v", path, err)
+		}
+	}
+}
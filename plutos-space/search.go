@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/h3bzzz/pluto/plutos-space/query"
+)
+
+// parseSearch parses the q parameter against schema. Syntax errors are
+// answered with 400 and the position of the problem; ok is false if a
+// response has been written.
+func parseSearch(w http.ResponseWriter, r *http.Request, schema query.Schema) (*query.Query, bool) {
+	q, err := query.Parse(r.URL.Query().Get("q"), schema)
+	if err != nil {
+		var syntaxErr *query.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		log.Printf("Error parsing search query: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return q, true
+}
@@ -0,0 +1,131 @@
+package sensors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	pcapFileHeader   = 24
+	pcapRecordHeader = 16
+	// pcapng blocks are at least 12 bytes: type, length and the trailing
+	// length. The first 12 bytes of a section header include its byte
+	// order magic.
+	pcapngBlockHeader = 12
+	pcapngSection     = 0x0A0D0D0A
+	pcapngByteOrder   = 0x1A2B3C4D
+)
+
+var errMalformed = errors.New("malformed capture file")
+
+// countingBody counts the packets of a pcap or pcapng stream as it is read
+// and fails the read that reaches the end of the stream if the count is not
+// the one the sensor announced, so a capture cut short is not taken for a
+// complete one.
+type countingBody struct {
+	io.ReadCloser
+	sensor string
+	want   int
+	ng     bool
+
+	order   binary.ByteOrder
+	header  []byte
+	need    int
+	skip    int64
+	packets int
+	err     error
+}
+
+func newCountingBody(body io.ReadCloser, sensor string, want int, contentType string) *countingBody {
+	b := &countingBody{ReadCloser: body, sensor: sensor, want: want, need: pcapFileHeader}
+	if contentType == "application/x-pcapng" {
+		b.ng, b.need = true, pcapngBlockHeader
+	}
+	return b
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.scan(p[:n])
+	if b.err != nil {
+		return n, b.err
+	}
+	if err == io.EOF {
+		if len(b.header) > 0 || b.skip > 0 || b.order == nil {
+			return n, fmt.Errorf("capture from sensor %s ended mid-record after %d of %d packets", b.sensor, b.packets, b.want)
+		}
+		if b.packets != b.want {
+			return n, fmt.Errorf("capture from sensor %s has %d packets, expected %d", b.sensor, b.packets, b.want)
+		}
+	}
+	return n, err
+}
+
+// scan walks data through record headers, skipping record bodies.
+func (b *countingBody) scan(data []byte) {
+	for len(data) > 0 && b.err == nil {
+		if b.skip > 0 {
+			k := min(b.skip, int64(len(data)))
+			data = data[k:]
+			b.skip -= k
+			continue
+		}
+		k := min(b.need-len(b.header), len(data))
+		b.header = append(b.header, data[:k]...)
+		data = data[k:]
+		if len(b.header) == b.need {
+			b.record()
+			b.header = b.header[:0]
+		}
+	}
+}
+
+func (b *countingBody) record() {
+	h := b.header
+	if b.ng {
+		if binary.LittleEndian.Uint32(h) == pcapngSection {
+			b.order = byteOrder(h[8:12], pcapngByteOrder)
+		}
+		if b.order == nil {
+			b.err = fmt.Errorf("sensor %s: %w", b.sensor, errMalformed)
+			return
+		}
+		length := b.order.Uint32(h[4:8])
+		if length < pcapngBlockHeader || length%4 != 0 {
+			b.err = fmt.Errorf("sensor %s: %w", b.sensor, errMalformed)
+			return
+		}
+		b.skip = int64(length - pcapngBlockHeader)
+		// Enhanced, simple and obsolete packet blocks.
+		switch b.order.Uint32(h[0:4]) {
+		case 2, 3, 6:
+			b.packets++
+		}
+		return
+	}
+
+	if b.order == nil {
+		if b.order = byteOrder(h[0:4], 0xa1b2c3d4, 0xa1b23c4d); b.order == nil {
+			b.err = fmt.Errorf("sensor %s: %w", b.sensor, errMalformed)
+			return
+		}
+		b.need = pcapRecordHeader
+		return
+	}
+	b.skip = int64(b.order.Uint32(h[8:12]))
+	b.packets++
+}
+
+// byteOrder returns the byte order in which magic reads as one of want.
+func byteOrder(magic []byte, want ...uint32) binary.ByteOrder {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, w := range want {
+			if order.Uint32(magic) == w {
+				return order
+			}
+		}
+	}
+	return nil
+}
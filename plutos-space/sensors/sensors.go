@@ -0,0 +1,250 @@
+// Package sensors fetches and pins raw packets in the rolling capture buffers
+// collectors keep when started with -pcap-dir.
+package sensors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSensors     = errors.New("no sensors are configured; start the server with -sensors")
+	ErrUnknownSensor = errors.New("unknown sensor")
+	ErrNoSensor      = errors.New("the packets were not recorded with a sensor name and several sensors are configured; choose one with sensor")
+	ErrNoPackets     = errors.New("no matching packets in the sensor's capture buffer; they may have aged out")
+)
+
+// Registry holds the sensors packets can be fetched from.
+type Registry struct {
+	urls   map[string]string
+	token  string
+	client *http.Client
+}
+
+// Parse reads a comma-separated list of name=URL pairs, e.g.
+// "edge=http://10.0.0.5:8087,dmz=http://10.1.0.5:8087". The name is the
+// collector's -sensor name. token authenticates to every sensor.
+func Parse(spec, token string) (*Registry, error) {
+	// Captures can be large, so only the wait for the sensor to answer is
+	// bounded; downloads end when the requesting client goes away.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = time.Minute
+	r := &Registry{
+		urls:   make(map[string]string),
+		token:  token,
+		client: &http.Client{Transport: transport},
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, rawURL, ok := strings.Cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("sensor %q is not name=URL", item)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("sensor %s: %q is not an http(s) URL", name, rawURL)
+		}
+		r.urls[name] = strings.TrimSuffix(rawURL, "/")
+	}
+	return r, nil
+}
+
+// Names returns the configured sensor names, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.urls))
+	for name := range r.urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the sensor to fetch packets recorded by name from. An
+// empty name, as on packets stored before sensors were recorded, resolves
+// to the only sensor if just one is configured.
+func (r *Registry) Resolve(name string) (string, error) {
+	if len(r.urls) == 0 {
+		return "", ErrNoSensors
+	}
+	if name == "" {
+		if len(r.urls) > 1 {
+			return "", ErrNoSensor
+		}
+		return r.Names()[0], nil
+	}
+	if _, ok := r.urls[name]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownSensor, name)
+	}
+	return name, nil
+}
+
+// Query selects packets from a sensor's capture buffer in [From, To).
+// Empty addresses, zero ports and an empty protocol match anything.
+type Query struct {
+	From, To         time.Time
+	SrcIP, DstIP     string
+	SrcPort, DstPort int
+	Protocol         string
+	// Bidirectional also matches the reverse direction, so a query for a
+	// connection returns both sides of it.
+	Bidirectional bool
+	Limit         int
+	// Format is pcap (the default) or pcapng.
+	Format string
+}
+
+func (q Query) values() url.Values {
+	v := url.Values{}
+	v.Set("from", q.From.UTC().Format(time.RFC3339Nano))
+	v.Set("to", q.To.UTC().Format(time.RFC3339Nano))
+	if q.SrcIP != "" {
+		v.Set("src_ip", q.SrcIP)
+	}
+	if q.DstIP != "" {
+		v.Set("dst_ip", q.DstIP)
+	}
+	if q.SrcPort != 0 {
+		v.Set("src_port", strconv.Itoa(q.SrcPort))
+	}
+	if q.DstPort != 0 {
+		v.Set("dst_port", strconv.Itoa(q.DstPort))
+	}
+	if q.Protocol != "" {
+		v.Set("protocol", q.Protocol)
+	}
+	if q.Bidirectional {
+		v.Set("bidirectional", "true")
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Format != "" {
+		v.Set("format", q.Format)
+	}
+	return v
+}
+
+// Capture is a pcap file being streamed from a sensor. The caller must
+// close Body.
+type Capture struct {
+	Body        io.ReadCloser
+	ContentType string
+	Packets     int
+}
+
+// RequestError is a request a sensor rejected as invalid.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// Fetch requests the packets matching q from sensor. It returns
+// ErrNoPackets if the sensor has none and a *RequestError if the sensor
+// rejected the query. Reading Body fails at the end of the capture if it
+// holds fewer packets than the sensor announced.
+func (r *Registry) Fetch(ctx context.Context, sensor string, q Query) (*Capture, error) {
+	resp, err := r.do(ctx, sensor, http.MethodGet, "/pcap?"+q.values().Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		capture := &Capture{Body: resp.Body, ContentType: resp.Header.Get("Content-Type")}
+		if packets, err := strconv.Atoi(resp.Header.Get("X-Packet-Count")); err == nil {
+			capture.Packets = packets
+			capture.Body = newCountingBody(resp.Body, sensor, packets, capture.ContentType)
+		}
+		return capture, nil
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	message := strings.TrimSpace(string(body))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNoPackets
+	case http.StatusBadRequest, http.StatusConflict:
+		return nil, &RequestError{Status: resp.StatusCode, Message: message}
+	}
+	return nil, fmt.Errorf("sensor %s returned %s: %s", sensor, resp.Status, message)
+}
+
+// Pin asks sensor to keep the packets from from to to until expires,
+// whatever its size and age limits, and returns the pin's id.
+func (r *Registry) Pin(ctx context.Context, sensor string, from, to, expires time.Time) (string, error) {
+	body, err := json.Marshal(map[string]time.Time{"from": from.UTC(), "to": to.UTC(), "expires": expires.UTC()})
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.do(ctx, sensor, http.MethodPost, "/pins", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(sensor, resp)
+	}
+
+	var pin struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pin); err != nil {
+		return "", fmt.Errorf("sensor %s: %w", sensor, err)
+	}
+	return pin.ID, nil
+}
+
+// Unpin releases a pin. Pins the sensor no longer has, e.g. because they
+// expired, are not an error.
+func (r *Registry) Unpin(ctx context.Context, sensor, id string) error {
+	resp, err := r.do(ctx, sensor, http.MethodDelete, "/pins/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return responseError(sensor, resp)
+	}
+	return nil
+}
+
+func (r *Registry) do(ctx context.Context, sensor, method, path string, body io.Reader) (*http.Response, error) {
+	base, ok := r.urls[sensor]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSensor, sensor)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sensor %s: %w", sensor, err)
+	}
+	return resp, nil
+}
+
+func responseError(sensor string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("sensor %s returned %s: %s", sensor, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := models.SessionFilter{
+		Host:        query.Get("host"),
+		ClientIP:    query.Get("client_ip"),
+		ServerIP:    query.Get("server_ip"),
+		AppProtocol: query.Get("app_protocol"),
+		Handshake:   query.Get("handshake"),
+		CloseReason: query.Get("close_reason"),
+		Sensor:      query.Get("sensor"),
+		Page:        page,
+	}
+	if v := query.Get("server_port"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			http.Error(w, "server_port must be between 1 and 65535", http.StatusBadRequest)
+			return
+		}
+		filter.ServerPort = port
+	}
+
+	sessions, info, err := models.NewDB(db).ListSessions(filter)
+	if err != nil {
+		log.Printf("Error querying TCP sessions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pageFields(map[string]interface{}{
+		"sessions": sessions,
+	}, page, info))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// settingsRepublishInterval keeps the current settings within the control
+// topic's retention so a collector starting later still receives them.
+const settingsRepublishInterval = 10 * time.Minute
+
+var controlWriter *kafka.Writer
+
+// controlMessage is published on the control topic whenever settings
+// change. Consumers ignore versions older than the one they applied.
+type controlMessage struct {
+	Type     string          `json:"type"`
+	Version  int64           `json:"version"`
+	Settings models.Settings `json:"settings"`
+}
+
+func publishSettings(ctx context.Context, v models.SettingsVersion) error {
+	value, err := json.Marshal(controlMessage{Type: "settings", Version: v.Version, Settings: v.Settings})
+	if err != nil {
+		return err
+	}
+	return controlWriter.WriteMessages(ctx, kafka.Message{Key: []byte("settings"), Value: value})
+}
+
+// runSettingsPublisher publishes the current settings on startup and then
+// periodically. Nothing is published until settings are first saved, so
+// collectors keep their command-line defaults.
+func runSettingsPublisher(ctx context.Context) {
+	ticker := time.NewTicker(settingsRepublishInterval)
+	defer ticker.Stop()
+
+	for {
+		v, err := models.NewDB(db).GetSettings()
+		if err != nil {
+			log.Printf("Error loading settings: %v", err)
+		} else if v.Version == 0 {
+			// Only the defaults; nothing has been saved yet.
+		} else if err := publishSettings(ctx, v); err != nil && ctx.Err() == nil {
+			log.Printf("Error publishing settings: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	v, err := models.NewDB(db).GetSettings()
+	if err != nil {
+		log.Printf("Error querying settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// updateSettingsHandler saves a new settings version. settings may be
+// partial; omitted fields keep their current value. version must be the
+// version the client last read, otherwise 409 is returned so concurrent
+// edits are not silently overwritten.
+func updateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Version  *int64          `json:"version"`
+		Settings json.RawMessage `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Version == nil {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.Settings) == 0 {
+		http.Error(w, "settings is required", http.StatusBadRequest)
+		return
+	}
+
+	database := models.NewDB(db)
+
+	current, err := database.GetSettings()
+	if err != nil {
+		log.Printf("Error querying settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	settings := current.Settings
+	dec := json.NewDecoder(bytes.NewReader(body.Settings))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&settings); err != nil {
+		http.Error(w, "Invalid settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved, err := database.SaveSettings(*body.Version, settings, currentUser(r).Username)
+	if err == models.ErrSettingsConflict {
+		http.Error(w, "Settings have changed since version "+strconv.FormatInt(*body.Version, 10)+"; reload and try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	setAuditDetail(r, "version", saved.Version)
+
+	// The processor also polls the table, so a failed publish only delays
+	// the collector until the next republish.
+	if err := publishSettings(r.Context(), saved); err != nil {
+		log.Printf("Error publishing settings version %d: %v", saved.Version, err)
+	}
+
+	json.NewEncoder(w).Encode(saved)
+}
+
+func settingsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	versions, err := models.NewDB(db).GetSettingsHistory(limit)
+	if err != nil {
+		log.Printf("Error querying settings history: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(versions)
+}
@@ -106,6 +106,10 @@ function connectWebSocket() {
     socket.onopen = () => {
         console.log('WebSocket connection established');
         updateConnectionStatus(true);
+
+        // Stats are sent by default; ask for the live packet and alert tails.
+        socket.send(JSON.stringify({ action: 'subscribe', channel: 'packets', rate: 20 }));
+        socket.send(JSON.stringify({ action: 'subscribe', channel: 'alerts' }));
     };
     
     socket.onmessage = (event) => {
@@ -160,6 +164,13 @@ function handleWebSocketMessage(data) {
                 addNewAlert(data.data);
             }
             break;
+        case 'subscribed':
+        case 'unsubscribed':
+        case 'dropped':
+            break;
+        case 'error':
+            console.error('WebSocket request failed:', data.error);
+            break;
         default:
             console.log('Unknown message type:', data.type);
     }
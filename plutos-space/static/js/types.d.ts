@@ -96,5 +96,8 @@ interface AlertsResponse {
 
 interface WebSocketMessage {
   type: string;
+  channel?: string;
   data: any;
+  error?: string;
+  count?: number;
 } 
\ No newline at end of file
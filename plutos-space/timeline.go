@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h3bzzz/pluto/plutos-space/models"
+)
+
+const (
+	maxTimelineBuckets    = 1000
+	targetTimelineBuckets = 300
+	defaultTimelineSeries = 10
+)
+
+// timelineIntervals are the candidate bucket sizes when the client does not
+// ask for a specific interval.
+var timelineIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+func packetTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+
+	from, to, ok := parseTimeRange(w, r, time.Hour)
+	if !ok {
+		return
+	}
+
+	var groupBy []string
+	if v := query.Get("group_by"); v != "" {
+		for _, dim := range strings.Split(v, ",") {
+			dim = strings.TrimSpace(dim)
+			if _, ok := models.TimelineDimensions[dim]; !ok {
+				http.Error(w, fmt.Sprintf("Unknown group_by dimension %q", dim), http.StatusBadRequest)
+				return
+			}
+			groupBy = append(groupBy, dim)
+		}
+	}
+
+	maxSeries := defaultTimelineSeries
+	if v := query.Get("series_limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxSeries = n
+		}
+	}
+
+	database := models.NewDB(db)
+	minuteSince, err := database.MinuteRollupsSince(time.Now())
+	if err != nil {
+		log.Printf("Error reading rollup retention: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	q := models.TimelineQuery{
+		From:               from,
+		To:                 to,
+		GroupBy:            groupBy,
+		MaxSeries:          maxSeries,
+		MinuteRollupsSince: minuteSince,
+	}
+	explicit := query.Get("interval") != ""
+	if explicit {
+		d, err := parseIntervalParam(query.Get("interval"))
+		if err != nil {
+			http.Error(w, "Invalid 'interval': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from)/d > maxTimelineBuckets {
+			http.Error(w, fmt.Sprintf("Interval too small: at most %d buckets per request", maxTimelineBuckets), http.StatusBadRequest)
+			return
+		}
+		q.Interval = d
+	} else {
+		q.Interval = autoTimelineInterval(to.Sub(from))
+	}
+
+	// Minute rollups older than their retention are gone; rollup
+	// timelines starting before then need whole-hour buckets.
+	if q.FromRollups() && from.Before(minuteSince) && q.Interval%time.Hour != 0 {
+		if explicit {
+			http.Error(w, fmt.Sprintf("Interval must be whole hours for ranges starting before %s, when minute rollups expire", minuteSince.UTC().Format(time.RFC3339)), http.StatusBadRequest)
+			return
+		}
+		q.Interval = q.Interval.Truncate(time.Hour) + time.Hour
+	}
+
+	timeline, err := database.GetPacketTimeline(q)
+	if err != nil {
+		log.Printf("Error querying packet timeline: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// autoTimelineInterval picks the smallest candidate interval that keeps
+// the number of buckets at or below targetTimelineBuckets, or for ranges
+// too long for a day, the smallest number of days that does.
+func autoTimelineInterval(span time.Duration) time.Duration {
+	for _, d := range timelineIntervals {
+		if span/d <= targetTimelineBuckets {
+			return d
+		}
+	}
+	day := 24 * time.Hour
+	days := (span + targetTimelineBuckets*day - 1) / (targetTimelineBuckets * day)
+	return days * day
+}
+
+// parseTimeRange reads the from and to parameters of a request. to
+// defaults to now and from to span before to. On error it writes a 400
+// response and returns false.
+func parseTimeRange(w http.ResponseWriter, r *http.Request, span time.Duration) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			http.Error(w, "Invalid 'to': "+err.Error(), http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+
+	from := to.Add(-span)
+	if v := query.Get("from"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			http.Error(w, "Invalid 'from': "+err.Error(), http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or Unix seconds.
+func parseTimeParam(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseIntervalParam accepts Go durations ("30s", "5m", "1h") plus a "d"
+// suffix for days. Intervals are whole seconds.
+func parseIntervalParam(v string) (time.Duration, error) {
+	var d time.Duration
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(v)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("must be a whole number of seconds")
+	}
+	return d, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/segmentio/kafka-go"
+)
+
+// Alert is the message format detectors publish on the alert topic, and
+// what in-process detectors pass to insertAlert.
+type Alert struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Rule        string            `json:"rule"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Severity    string            `json:"severity"`
+	SrcIP       string            `json:"src_ip,omitempty"`
+	DstIP       string            `json:"dst_ip,omitempty"`
+	SrcPort     uint16            `json:"src_port,omitempty"`
+	DstPort     uint16            `json:"dst_port,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	DeviceName  string            `json:"device_name,omitempty"`
+	Sensor      string            `json:"sensor,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Fingerprint replaces the addresses, port and protocol in the key
+	// repeats are deduplicated by, for alerts raised by something other
+	// than traffic, e.g. a saved search.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+var alertSeverities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+func (a *Alert) validate() error {
+	if a.Rule == "" {
+		return fmt.Errorf("alert has no rule")
+	}
+	if !alertSeverities[a.Severity] {
+		return fmt.Errorf("alert has invalid severity %q", a.Severity)
+	}
+	if a.Title == "" {
+		a.Title = a.Rule
+	}
+	if a.Timestamp.IsZero() {
+		a.Timestamp = time.Now()
+	}
+	return nil
+}
+
+// alertIngestLockKey serialises alert ingestion across processor instances
+// so concurrent copies of an alert cannot both miss the dedup lookup.
+const alertIngestLockKey = 7_261_002
+
+// fingerprint identifies repeats of the same alert for deduplication.
+func (a *Alert) fingerprint() string {
+	key := []string{a.Rule, a.SrcIP, a.DstIP, strconv.Itoa(int(a.DstPort)), a.Protocol}
+	if a.Fingerprint != "" {
+		key = []string{a.Rule, a.Fingerprint}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(key, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// insertAlert stores an alert and returns its id. A repeat of an open alert
+// seen within -alert-dedup-window is counted on the existing row instead,
+// and new alerts are correlated into incidents. Suppression rules are
+// applied by a trigger on siem.alerts; suppressed alerts are stored but
+// never start or join an incident.
+func insertAlert(ctx context.Context, dbPool *pgxpool.Pool, alert Alert) (int64, error) {
+	if err := alert.validate(); err != nil {
+		return 0, err
+	}
+
+	metadataJSON, err := json.Marshal(alert.Metadata)
+	if err != nil {
+		metadataJSON = []byte("{}")
+	}
+
+	var id int64
+	err = pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, alertIngestLockKey); err != nil {
+			return err
+		}
+
+		fingerprint := alert.fingerprint()
+
+		var incidentID *int64
+		err := tx.QueryRow(ctx, `
+			UPDATE siem.alerts SET
+				occurrences = occurrences + 1,
+				last_seen = GREATEST(last_seen, $2),
+				severity = CASE WHEN siem.severity_rank($3) > siem.severity_rank(severity) THEN $3 ELSE severity END,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = (
+				SELECT id FROM siem.alerts
+				WHERE fingerprint = $1
+					AND status NOT IN ('resolved', 'false-positive')
+					AND last_seen >= $4
+					AND suppressed_by IS NOT DISTINCT FROM
+						siem.active_alert_suppression($5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, 0))
+				ORDER BY last_seen DESC
+				LIMIT 1
+			)
+			RETURNING id, incident_id
+		`,
+			fingerprint, alert.Timestamp, alert.Severity, alert.Timestamp.Add(-*alertDedupWindow),
+			alert.Rule, alert.SrcIP, alert.DstIP, int(alert.DstPort),
+		).Scan(&id, &incidentID)
+
+		if err == nil {
+			if incidentID == nil {
+				return nil
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE siem.incidents SET
+					event_count = event_count + 1,
+					last_seen = GREATEST(last_seen, $2),
+					severity = CASE WHEN siem.severity_rank($3) > siem.severity_rank(severity) THEN $3 ELSE severity END,
+					updated_at = CURRENT_TIMESTAMP
+				WHERE id = $1
+			`, *incidentID, alert.Timestamp, alert.Severity)
+			return err
+		}
+		if err != pgx.ErrNoRows {
+			return err
+		}
+
+		var suppressedBy *int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO siem.alerts (
+				timestamp, last_seen, fingerprint, rule, title, description, severity,
+				src_ip, dst_ip, src_port, dst_port, protocol, device_name, metadata, sensor
+			) VALUES (
+				$1, $1, $2, $3, $4, $5, $6,
+				NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, 0), NULLIF($10, 0), NULLIF($11, ''), NULLIF($12, ''), $13,
+				NULLIF($14, '')
+			)
+			RETURNING id, suppressed_by
+		`,
+			alert.Timestamp, fingerprint, alert.Rule, alert.Title, alert.Description, alert.Severity,
+			alert.SrcIP, alert.DstIP, int(alert.SrcPort), int(alert.DstPort), alert.Protocol, alert.DeviceName, metadataJSON,
+			alert.Sensor,
+		).Scan(&id, &suppressedBy)
+		if err != nil || suppressedBy != nil {
+			return err
+		}
+
+		if err := markEvidence(ctx, tx, id, alert); err != nil {
+			return err
+		}
+		return correlateAlert(ctx, tx, id, alert)
+	})
+	return id, err
+}
+
+// markEvidence queues the traffic of a new alert's flow from
+// -evidence-before it to -evidence-after it to be kept as evidence. The
+// server copies it out of the sensor's capture buffer; alerts without
+// addresses have no flow to select packets by.
+func markEvidence(ctx context.Context, tx pgx.Tx, alertID int64, alert Alert) error {
+	if !*keepEvidence || (alert.SrcIP == "" && alert.DstIP == "") {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO siem.alert_evidence (alert_id, sensor, window_start, window_end)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+	`, alertID, alert.Sensor, alert.Timestamp.Add(-*evidenceBefore), alert.Timestamp.Add(*evidenceAfter))
+	return err
+}
+
+func consumeAlerts(ctx context.Context, dbPool *pgxpool.Pool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{*kafkaAddr},
+		Topic:       *alertTopic,
+		GroupID:     "alert-processor",
+		MinBytes:    1,
+		MaxBytes:    10e6,
+		StartOffset: kafka.FirstOffset,
+		MaxWait:     500 * time.Millisecond,
+	})
+	defer reader.Close()
+
+	log.Printf("[Alerts] Started consuming alerts from Kafka")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			readCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			m, err := reader.ReadMessage(readCtx)
+			cancel()
+
+			if err != nil {
+				if err == context.Canceled || err == context.DeadlineExceeded {
+					continue
+				}
+				log.Printf("[Alerts] Error reading message: %v", err)
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
+			var alert Alert
+			if err := json.Unmarshal(m.Value, &alert); err != nil {
+				log.Printf("[Alerts] Error unmarshaling alert: %v", err)
+				continue
+			}
+
+			if _, err := insertAlert(ctx, dbPool, alert); err != nil {
+				log.Printf("[Alerts] Error inserting alert: %v", err)
+			}
+		}
+	}
+}
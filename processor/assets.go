@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxAssets bounds the inventory held in memory. Addresses seen once the
+// inventory is full are not added.
+const maxAssets = 65536
+
+// Hostname and OS sources, from least to most trusted. A source does not
+// replace a value learned from a more trusted one.
+var (
+	hostnameSources = map[string]int{"dns": 1, "mdns": 2, "dhcp": 3}
+	osSources       = map[string]int{"tcp": 1, "dhcp": 2}
+)
+
+// Asset is what the processor has learned about a host on the local
+// network from its traffic.
+type Asset struct {
+	IP             string
+	MAC            string
+	Vendor         string
+	Hostname       string
+	HostnameSource string
+	OS             string
+	OSSource       string
+	// Services are the open TCP ports seen answering a SYN, as "tcp/22".
+	Services  map[string]bool
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Sensor    string
+}
+
+// assetChange is a row of an asset's history: a field that got a new
+// value, or a service seen for the first time.
+type assetChange struct {
+	ip       string
+	at       time.Time
+	field    string
+	oldValue string
+	newValue string
+}
+
+// Inventory builds the asset inventory from packets of internal hosts. It
+// is shared by all network workers, so every method takes the lock.
+type Inventory struct {
+	mu      sync.Mutex
+	assets  map[string]*Asset
+	dirty   map[string]bool
+	changes []assetChange
+	// dhcpClients are what DHCP clients said about themselves, by client
+	// MAC, until the server's ACK binds an address to them.
+	dhcpClients map[string]dhcpClient
+	ouis        ouiTable
+}
+
+type dhcpClient struct {
+	hostname string
+	os       string
+}
+
+func NewInventory(ouis ouiTable) *Inventory {
+	return &Inventory{
+		assets:      make(map[string]*Asset),
+		dirty:       make(map[string]bool),
+		dhcpClients: make(map[string]dhcpClient),
+		ouis:        ouis,
+	}
+}
+
+// The parts of the collector's dissector output the inventory and the
+// layer 2 detectors read.
+type (
+	arpFields struct {
+		SenderMAC  string `json:"sender_mac"`
+		SenderIP   string `json:"sender_ip"`
+		Gratuitous bool   `json:"gratuitous"`
+	}
+	dhcpFields struct {
+		MessageType string `json:"message_type"`
+		ClientMAC   string `json:"client_mac"`
+		Hostname    string `json:"hostname"`
+		VendorClass string `json:"vendor_class"`
+		YourIP      string `json:"your_ip"`
+		ServerID    string `json:"server_id"`
+	}
+	ndpFields struct {
+		Type     string `json:"type"`
+		TargetIP string `json:"target_ip"`
+		LinkAddr string `json:"link_addr"`
+	}
+	nameFields struct {
+		Answers []struct {
+			Name  string `json:"name"`
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"answers"`
+	}
+)
+
+// appFields decodes the dissector output for protocol into v, reporting
+// whether the packet had any.
+func appFields(p *PacketData, protocol string, v interface{}) bool {
+	raw, ok := p.App[protocol]
+	return ok && json.Unmarshal(raw, v) == nil
+}
+
+func (inv *Inventory) Observe(p *PacketData) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	now := p.Timestamp
+
+	if isInternalIP(p.SrcIP) {
+		if a := inv.touch(p.SrcIP, now, p.Sensor); a != nil && p.Protocol == "TCP" && strings.Contains(p.TCPFlags, "S") {
+			// SYN and SYN-ACK options are set by the OS, before any
+			// application; only a SYN-ACK proves a listening port.
+			if guess := guessOS(p.TTL, p.WindowSize); guess != "" {
+				inv.setOS(a, guess, "tcp", now)
+			}
+			if strings.Contains(p.TCPFlags, "A") {
+				inv.addService(a, "tcp/"+strconv.Itoa(int(p.SrcPort)), now)
+			}
+		}
+	}
+
+	var arp arpFields
+	if appFields(p, "arp", &arp) && arp.SenderIP != "0.0.0.0" && isInternalIP(arp.SenderIP) {
+		if a := inv.touch(arp.SenderIP, now, p.Sensor); a != nil {
+			inv.setMAC(a, arp.SenderMAC, now)
+		}
+	}
+
+	var ndp ndpFields
+	if appFields(p, "ndp", &ndp) && ndp.Type == "neighbor-advertisement" && ndp.LinkAddr != "" && isInternalIP(ndp.TargetIP) {
+		if a := inv.touch(ndp.TargetIP, now, p.Sensor); a != nil {
+			inv.setMAC(a, ndp.LinkAddr, now)
+		}
+	}
+
+	var dhcp dhcpFields
+	if appFields(p, "dhcp", &dhcp) {
+		inv.observeDHCP(&dhcp, now, p.Sensor)
+	}
+
+	for _, protocol := range []string{"mdns", "dns"} {
+		var names nameFields
+		if !appFields(p, protocol, &names) {
+			continue
+		}
+		for _, rr := range names.Answers {
+			ip, name := rr.Value, rr.Name
+			switch rr.Type {
+			case "A", "AAAA":
+			case "PTR":
+				ip, name = reverseDNSAddr(rr.Name), rr.Value
+			default:
+				continue
+			}
+			// Only existing assets are named; a lookup says nothing about
+			// whether the host is up.
+			if a := inv.assets[ip]; a != nil && name != "" {
+				inv.setHostname(a, strings.TrimSuffix(name, "."), protocol, now)
+			}
+		}
+	}
+}
+
+func (inv *Inventory) observeDHCP(d *dhcpFields, now time.Time, sensor string) {
+	switch d.MessageType {
+	case "discover", "request", "inform":
+		client := dhcpClient{hostname: d.Hostname, os: dhcpVendorOS(d.VendorClass)}
+		if client == (dhcpClient{}) {
+			return
+		}
+		if _, ok := inv.dhcpClients[d.ClientMAC]; ok || len(inv.dhcpClients) < maxAssets {
+			inv.dhcpClients[d.ClientMAC] = client
+		}
+	case "ack":
+		if d.YourIP == "" || !isInternalIP(d.YourIP) {
+			return
+		}
+		a := inv.touch(d.YourIP, now, sensor)
+		if a == nil {
+			return
+		}
+		inv.setMAC(a, d.ClientMAC, now)
+		if client, ok := inv.dhcpClients[d.ClientMAC]; ok {
+			if client.hostname != "" {
+				inv.setHostname(a, client.hostname, "dhcp", now)
+			}
+			if client.os != "" {
+				inv.setOS(a, client.os, "dhcp", now)
+			}
+			delete(inv.dhcpClients, d.ClientMAC)
+		}
+	}
+}
+
+// touch returns the asset for ip, adding it if the inventory has room,
+// and marks it seen.
+func (inv *Inventory) touch(ip string, now time.Time, sensor string) *Asset {
+	a := inv.assets[ip]
+	if a == nil {
+		if len(inv.assets) >= maxAssets {
+			return nil
+		}
+		a = &Asset{IP: ip, Services: make(map[string]bool), FirstSeen: now}
+		inv.assets[ip] = a
+		inv.record(a, now, "ip", "", ip)
+	}
+	if now.After(a.LastSeen) {
+		a.LastSeen = now
+	}
+	if now.Before(a.FirstSeen) {
+		a.FirstSeen = now
+	}
+	if sensor != "" {
+		a.Sensor = sensor
+	}
+	inv.dirty[ip] = true
+	return a
+}
+
+func (inv *Inventory) record(a *Asset, now time.Time, field, oldValue, newValue string) {
+	inv.changes = append(inv.changes, assetChange{ip: a.IP, at: now, field: field, oldValue: oldValue, newValue: newValue})
+	inv.dirty[a.IP] = true
+}
+
+func (inv *Inventory) setMAC(a *Asset, mac string, now time.Time) {
+	if mac == "" || mac == a.MAC || mac == "00:00:00:00:00:00" {
+		return
+	}
+	inv.record(a, now, "mac", a.MAC, mac)
+	a.MAC = mac
+	if vendor := inv.ouis.vendor(mac); vendor != a.Vendor {
+		inv.record(a, now, "vendor", a.Vendor, vendor)
+		a.Vendor = vendor
+	}
+}
+
+func (inv *Inventory) setHostname(a *Asset, name, source string, now time.Time) {
+	if name == a.Hostname || hostnameSources[source] < hostnameSources[a.HostnameSource] {
+		return
+	}
+	inv.record(a, now, "hostname", a.Hostname, name)
+	a.Hostname, a.HostnameSource = name, source
+}
+
+func (inv *Inventory) setOS(a *Asset, guess, source string, now time.Time) {
+	if guess == a.OS || osSources[source] < osSources[a.OSSource] {
+		return
+	}
+	inv.record(a, now, "os", a.OS, guess)
+	a.OS, a.OSSource = guess, source
+}
+
+func (inv *Inventory) addService(a *Asset, service string, now time.Time) {
+	if a.Services[service] {
+		return
+	}
+	a.Services[service] = true
+	inv.record(a, now, "service", "", service)
+}
+
+// guessOS guesses the operating system that sent a SYN or SYN-ACK from
+// its TTL, rounded up to the usual initial values of 64, 128 and 255, and
+// its window size.
+func guessOS(ttl uint8, window uint16) string {
+	switch {
+	case ttl == 0:
+		return ""
+	case ttl > 128:
+		if window == 4128 {
+			return "Cisco IOS"
+		}
+		return "Network device"
+	case ttl > 64:
+		return "Windows"
+	case window == 65535:
+		return "macOS/BSD"
+	case window == 5840 || window == 14600 || window == 29200 || window == 64240 || window == 65160:
+		return "Linux"
+	default:
+		return "Linux/Unix"
+	}
+}
+
+// dhcpVendorOS guesses the operating system from the vendor class a DHCP
+// client sends.
+func dhcpVendorOS(vendorClass string) string {
+	switch {
+	case strings.HasPrefix(vendorClass, "MSFT"):
+		return "Windows"
+	case strings.HasPrefix(vendorClass, "android-dhcp"):
+		return "Android"
+	case strings.HasPrefix(vendorClass, "dhcpcd"), strings.HasPrefix(vendorClass, "udhcp"):
+		return "Linux"
+	default:
+		return ""
+	}
+}
+
+// reverseDNSAddr returns the address a PTR record under in-addr.arpa or
+// ip6.arpa is for, or "" for other names.
+func reverseDNSAddr(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if rest, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		parts := strings.Split(rest, ".")
+		if len(parts) != 4 {
+			return ""
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		if ip := net.ParseIP(strings.Join(parts, ".")); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+	if rest, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(rest, ".")
+		if len(nibbles) != 32 {
+			return ""
+		}
+		var b strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			b.WriteString(nibbles[i])
+			if i%4 == 0 && i > 0 {
+				b.WriteByte(':')
+			}
+		}
+		if ip := net.ParseIP(b.String()); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+const assetUpsertQuery = `
+	INSERT INTO siem.assets (
+		ip, mac, vendor, hostname, hostname_source, os, os_source,
+		services, first_seen, last_seen, sensor
+	) VALUES (
+		$1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''),
+		$8, $9, $10, NULLIF($11, '')
+	)
+	ON CONFLICT (ip) DO UPDATE SET
+		mac = EXCLUDED.mac,
+		vendor = EXCLUDED.vendor,
+		hostname = EXCLUDED.hostname,
+		hostname_source = EXCLUDED.hostname_source,
+		os = EXCLUDED.os,
+		os_source = EXCLUDED.os_source,
+		services = EXCLUDED.services,
+		first_seen = LEAST(siem.assets.first_seen, EXCLUDED.first_seen),
+		last_seen = GREATEST(siem.assets.last_seen, EXCLUDED.last_seen),
+		sensor = COALESCE(EXCLUDED.sensor, siem.assets.sensor)
+`
+
+// Flush writes the assets that changed since the last flush and their
+// history in one transaction. On failure they are kept for the next
+// flush.
+func (inv *Inventory) Flush(ctx context.Context, dbPool *pgxpool.Pool) error {
+	inv.mu.Lock()
+	dirty, changes := inv.dirty, inv.changes
+	inv.dirty, inv.changes = make(map[string]bool), nil
+	batch := &pgx.Batch{}
+	for ip := range dirty {
+		a := inv.assets[ip]
+		if a == nil {
+			continue
+		}
+		services := make([]string, 0, len(a.Services))
+		for s := range a.Services {
+			services = append(services, s)
+		}
+		sort.Strings(services)
+		batch.Queue(assetUpsertQuery,
+			a.IP, a.MAC, a.Vendor, a.Hostname, a.HostnameSource, a.OS, a.OSSource,
+			services, a.FirstSeen, a.LastSeen, a.Sensor,
+		)
+	}
+	inv.mu.Unlock()
+
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	for _, c := range changes {
+		batch.Queue(`
+			INSERT INTO siem.asset_history (asset_id, timestamp, field, old_value, new_value)
+			SELECT id, $2, $3, NULLIF($4, ''), NULLIF($5, '') FROM siem.assets WHERE ip = $1
+		`, c.ip, c.at, c.field, c.oldValue, c.newValue)
+	}
+
+	err := pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		inv.mu.Lock()
+		for ip := range dirty {
+			inv.dirty[ip] = true
+		}
+		inv.changes = append(changes, inv.changes...)
+		inv.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Load reads the stored inventory, so that changes are recorded against
+// what earlier runs learned.
+func (inv *Inventory) Load(ctx context.Context, dbPool *pgxpool.Pool) error {
+	rows, err := dbPool.Query(ctx, `
+		SELECT ip, COALESCE(mac, ''), COALESCE(vendor, ''),
+			COALESCE(hostname, ''), COALESCE(hostname_source, ''),
+			COALESCE(os, ''), COALESCE(os_source, ''),
+			services, first_seen, last_seen, COALESCE(sensor, '')
+		FROM siem.assets
+		ORDER BY last_seen DESC
+		LIMIT $1
+	`, maxAssets)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for rows.Next() {
+		var (
+			a        Asset
+			services []string
+		)
+		if err := rows.Scan(
+			&a.IP, &a.MAC, &a.Vendor, &a.Hostname, &a.HostnameSource, &a.OS, &a.OSSource,
+			&services, &a.FirstSeen, &a.LastSeen, &a.Sensor,
+		); err != nil {
+			return err
+		}
+		a.Services = make(map[string]bool, len(services))
+		for _, s := range services {
+			a.Services[s] = true
+		}
+		inv.assets[a.IP] = &a
+	}
+	return rows.Err()
+}
+
+// Prune deletes assets not seen within the assets retention policy, with
+// their history.
+func (inv *Inventory) Prune(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) error {
+	var days int
+	err := dbPool.QueryRow(ctx,
+		`SELECT retention_days FROM siem.retention_policy WHERE table_name = 'assets'`,
+	).Scan(&days)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.AddDate(0, 0, -days)
+	inv.mu.Lock()
+	for ip, a := range inv.assets {
+		if a.LastSeen.Before(cutoff) && !inv.dirty[ip] {
+			delete(inv.assets, ip)
+		}
+	}
+	inv.mu.Unlock()
+
+	_, err = dbPool.Exec(ctx, `DELETE FROM siem.assets WHERE last_seen < $1`, cutoff)
+	return err
+}
+
+// runAssetFlusher periodically flushes the inventory and prunes assets
+// that have not been seen for too long.
+func runAssetFlusher(ctx context.Context, dbPool *pgxpool.Pool, inv *Inventory, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	flushTicker := time.NewTicker(*assetInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(*partitionInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := inv.Flush(flushCtx, dbPool); err != nil {
+				log.Printf("[Assets] Error flushing assets on shutdown: %v", err)
+			}
+			cancel()
+			return
+		case <-flushTicker.C:
+			if err := inv.Flush(ctx, dbPool); err != nil {
+				log.Printf("[Assets] Error flushing assets: %v", err)
+			}
+		case <-pruneTicker.C:
+			if err := inv.Prune(ctx, dbPool, time.Now().UTC()); err != nil {
+				log.Printf("[Assets] Error pruning assets: %v", err)
+			}
+		}
+	}
+}
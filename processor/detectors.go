@@ -0,0 +1,828 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/segmentio/kafka-go"
+)
+
+// Detector inspects the packet stream for one kind of suspicious activity.
+// Observe is called concurrently from every network worker, so
+// implementations must do their own locking.
+type Detector interface {
+	// Name is the key of the detector's switch in the alert settings.
+	Name() string
+	// Observe returns an alert when the packet completes a detection.
+	// sensitivity multiplies the detector's thresholds.
+	Observe(p *PacketData, sensitivity float64) *Alert
+}
+
+// Detectors runs the enabled detectors over every packet and queues the
+// resulting alerts for insertion.
+type Detectors struct {
+	detectors []Detector
+	alerts    chan Alert
+}
+
+func NewDetectors() *Detectors {
+	return &Detectors{
+		detectors: []Detector{
+			newPortScanDetector(),
+			newBruteForceDetector(),
+			newExfiltrationDetector(),
+			newSuspiciousIPDetector(),
+			newTrafficSpikeDetector(),
+			newFragmentationDetector(),
+			newARPSpoofDetector(),
+			newRogueDHCPDetector(),
+			newMACFloodDetector(),
+		},
+		alerts: make(chan Alert, 1000),
+	}
+}
+
+func (d *Detectors) Observe(p *PacketData) {
+	s := currentSettings().Alerts
+	sensitivity := s.sensitivity()
+
+	for _, det := range d.detectors {
+		if !s.enabled(det.Name()) {
+			continue
+		}
+		if alert := det.Observe(p, sensitivity); alert != nil {
+			select {
+			case d.alerts <- *alert:
+			default:
+				log.Printf("[Detectors] Alert queue full, dropping %s alert", alert.Rule)
+			}
+		}
+	}
+}
+
+// runDetectorAlerts publishes alerts raised by the detectors to the alert
+// topic, so they reach consumeAlerts and the server's live tail like
+// alerts from anywhere else. An alert that cannot be published is
+// inserted directly; either way it goes through insertAlert and is
+// deduplicated, suppressed and correlated the same way.
+func runDetectorAlerts(ctx context.Context, dbPool *pgxpool.Pool, detectors *Detectors, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(*kafkaAddr),
+		Topic:                  *alertTopic,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+	defer writer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-detectors.alerts:
+			value, err := json.Marshal(alert)
+			if err == nil {
+				err = writer.WriteMessages(ctx, kafka.Message{Value: value})
+			}
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+			log.Printf("[Detectors] Error publishing %s alert, inserting it directly: %v", alert.Rule, err)
+			if _, err := insertAlert(ctx, dbPool, alert); err != nil && ctx.Err() == nil {
+				log.Printf("[Detectors] Error inserting %s alert: %v", alert.Rule, err)
+			}
+		}
+	}
+}
+
+// windowTracker keeps per-key state for fixed windows that start at the
+// first packet seen for the key. Expired keys are reset on access and
+// swept once per window so idle keys do not accumulate.
+type windowTracker struct {
+	mu        sync.Mutex
+	window    time.Duration
+	entries   map[string]*windowEntry
+	lastSweep time.Time
+}
+
+type windowEntry struct {
+	start   time.Time
+	members map[string]struct{}
+	count   int64
+	alerted bool
+}
+
+func newWindowTracker(window time.Duration) *windowTracker {
+	return &windowTracker{window: window, entries: make(map[string]*windowEntry)}
+}
+
+// entry returns the live window for key at time now. The caller must hold
+// t.mu.
+func (t *windowTracker) entry(key string, now time.Time) *windowEntry {
+	if now.Sub(t.lastSweep) > t.window {
+		for k, e := range t.entries {
+			if now.Sub(e.start) > t.window {
+				delete(t.entries, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.start) > t.window {
+		e = &windowEntry{start: now}
+		t.entries[key] = e
+	}
+	return e
+}
+
+func isTCPSyn(p *PacketData) bool {
+	return p.Protocol == "TCP" && strings.Contains(p.TCPFlags, "S") && !strings.Contains(p.TCPFlags, "A")
+}
+
+// isUDPRequest reports whether p is a UDP datagram a client may have
+// sent, that is not one from a well-known port to an ephemeral one, the
+// shape of DNS, NTP and QUIC responses.
+func isUDPRequest(p *PacketData) bool {
+	return p.Protocol == "UDP" && !(p.SrcPort != 0 && p.SrcPort < 1024 && p.DstPort >= 1024)
+}
+
+func isInternalIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+}
+
+func isExternalIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// portScanDetector flags a source that probes many distinct host:port
+// pairs (TCP SYNs or UDP requests) within a minute.
+type portScanDetector struct {
+	*windowTracker
+}
+
+const portScanTargets = 100
+
+func newPortScanDetector() *portScanDetector {
+	return &portScanDetector{newWindowTracker(time.Minute)}
+}
+
+func (d *portScanDetector) Name() string { return "portScans" }
+
+func (d *portScanDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if p.SrcIP == "" || p.DstPort == 0 || !(isTCPSyn(p) || isUDPRequest(p)) {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.entry(p.SrcIP, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	if e.members == nil {
+		e.members = make(map[string]struct{})
+	}
+	e.members[net.JoinHostPort(p.DstIP, fmt.Sprint(p.DstPort))] = struct{}{}
+
+	threshold := int(portScanTargets * sensitivity)
+	if len(e.members) < threshold {
+		return nil
+	}
+	e.alerted = true
+	targets := len(e.members)
+	e.members = nil
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "port-scan",
+		Title:       "Port scan detected",
+		Description: fmt.Sprintf("%s probed %d distinct host:port targets within %s", p.SrcIP, targets, d.window),
+		Severity:    "medium",
+		SrcIP:       p.SrcIP,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"targets": fmt.Sprint(targets)},
+	}
+}
+
+// bruteForceDetector flags repeated new connections from one source to a
+// login service on one host, the network-level trace of password guessing.
+type bruteForceDetector struct {
+	*windowTracker
+}
+
+const bruteForceAttempts = 30
+
+var authServices = map[uint16]string{
+	21: "FTP", 22: "SSH", 23: "Telnet", 25: "SMTP", 110: "POP3", 143: "IMAP",
+	389: "LDAP", 445: "SMB", 1433: "MSSQL", 3306: "MySQL", 3389: "RDP",
+	5432: "PostgreSQL", 5900: "VNC",
+}
+
+func newBruteForceDetector() *bruteForceDetector {
+	return &bruteForceDetector{newWindowTracker(time.Minute)}
+}
+
+func (d *bruteForceDetector) Name() string { return "bruteForce" }
+
+func (d *bruteForceDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	service, ok := authServices[p.DstPort]
+	if !ok || !isTCPSyn(p) || p.SrcIP == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.entry(p.SrcIP+"|"+p.DstIP+"|"+fmt.Sprint(p.DstPort), p.Timestamp)
+	e.count++
+	if e.alerted || e.count < int64(bruteForceAttempts*sensitivity) {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "brute-force",
+		Title:       fmt.Sprintf("Possible %s brute force", service),
+		Description: fmt.Sprintf("%s opened %d %s connections to %s within %s", p.SrcIP, e.count, service, p.DstIP, d.window),
+		Severity:    "high",
+		SrcIP:       p.SrcIP,
+		DstIP:       p.DstIP,
+		DstPort:     p.DstPort,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"attempts": fmt.Sprint(e.count), "service": service},
+	}
+}
+
+// exfiltrationDetector flags an internal host sending an unusually large
+// volume of data to external addresses within ten minutes.
+type exfiltrationDetector struct {
+	*windowTracker
+}
+
+const exfiltrationBytes = 500 << 20
+
+func newExfiltrationDetector() *exfiltrationDetector {
+	return &exfiltrationDetector{newWindowTracker(10 * time.Minute)}
+}
+
+func (d *exfiltrationDetector) Name() string { return "dataExfiltration" }
+
+func (d *exfiltrationDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if p.PayloadSize == 0 || p.Fragments > 0 || !isInternalIP(p.SrcIP) || !isExternalIP(p.DstIP) {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.entry(p.SrcIP, p.Timestamp)
+	e.count += int64(p.PayloadSize)
+	if e.alerted || float64(e.count) < exfiltrationBytes*sensitivity {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "data-exfiltration",
+		Title:       "Large outbound transfer",
+		Description: fmt.Sprintf("%s sent %d MiB to external hosts within %s", p.SrcIP, e.count>>20, d.window),
+		Severity:    "high",
+		SrcIP:       p.SrcIP,
+		DstIP:       p.DstIP,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"bytes": fmt.Sprint(e.count)},
+	}
+}
+
+// suspiciousIPDetector raises an alert for traffic the collector flagged
+// as malicious, once per host pair every ten minutes.
+type suspiciousIPDetector struct {
+	*windowTracker
+}
+
+func newSuspiciousIPDetector() *suspiciousIPDetector {
+	return &suspiciousIPDetector{newWindowTracker(10 * time.Minute)}
+}
+
+func (d *suspiciousIPDetector) Name() string { return "suspiciousIPs" }
+
+func (d *suspiciousIPDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if !p.IsMalicious && p.ThreatType == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e := d.entry(p.SrcIP+"|"+p.DstIP, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	e.alerted = true
+
+	threat := p.ThreatType
+	if threat == "" {
+		threat = "malicious"
+	}
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "suspicious-ip",
+		Title:       "Traffic with a suspicious host",
+		Description: fmt.Sprintf("Traffic between %s and %s flagged as %s", p.SrcIP, p.DstIP, threat),
+		Severity:    "high",
+		SrcIP:       p.SrcIP,
+		DstIP:       p.DstIP,
+		SrcPort:     p.SrcPort,
+		DstPort:     p.DstPort,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"threat_type": threat},
+	}
+}
+
+// trafficSpikeDetector compares the packet rate in 10 second buckets to an
+// exponentially weighted baseline and flags sudden surges.
+type trafficSpikeDetector struct {
+	mu        sync.Mutex
+	bucket    time.Time
+	count     int64
+	baseline  float64
+	samples   int
+	lastAlert time.Time
+}
+
+const (
+	spikeBucket   = 10 * time.Second
+	spikeWarmup   = 30
+	spikeFactor   = 3.0
+	spikeMinimum  = 1000
+	spikeCooldown = 5 * time.Minute
+	spikeAlpha    = 0.1
+)
+
+func newTrafficSpikeDetector() *trafficSpikeDetector {
+	return &trafficSpikeDetector{}
+}
+
+func (d *trafficSpikeDetector) Name() string { return "unusualTraffic" }
+
+func (d *trafficSpikeDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if p.Fragments > 0 {
+		return nil
+	}
+	b := p.Timestamp.Truncate(spikeBucket)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !b.After(d.bucket) {
+		d.count++
+		return nil
+	}
+
+	// A later bucket started, so the current one is complete.
+	var alert *Alert
+	count, baseline := d.count, d.baseline
+	if !d.bucket.IsZero() {
+		if d.samples >= spikeWarmup &&
+			count >= spikeMinimum &&
+			float64(count) > baseline*spikeFactor*sensitivity &&
+			d.bucket.Sub(d.lastAlert) > spikeCooldown {
+			d.lastAlert = d.bucket
+			alert = &Alert{
+				Timestamp:   d.bucket,
+				Rule:        "traffic-spike",
+				Title:       "Unusual traffic volume",
+				Description: fmt.Sprintf("%d packets in %s against a baseline of %.0f", count, spikeBucket, baseline),
+				Severity:    "medium",
+				DeviceName:  p.DeviceName,
+				Sensor:      p.Sensor,
+				Metadata:    map[string]string{"packets": fmt.Sprint(count), "baseline": fmt.Sprintf("%.0f", baseline)},
+			}
+		}
+		if d.samples == 0 {
+			d.baseline = float64(count)
+		} else {
+			d.baseline = spikeAlpha*float64(count) + (1-spikeAlpha)*d.baseline
+		}
+		d.samples++
+	}
+
+	d.bucket = b
+	d.count = 1
+	return alert
+}
+
+// fragmentationDetector raises alerts for the fragment anomalies the
+// collector reports and for floods of fragments from one source, the
+// usual ways of evading inspection or exhausting reassembly memory.
+type fragmentationDetector struct {
+	anomalies *windowTracker
+	floods    *windowTracker
+}
+
+const fragmentFloodCount = 10000
+
+func newFragmentationDetector() *fragmentationDetector {
+	return &fragmentationDetector{
+		anomalies: newWindowTracker(10 * time.Minute),
+		floods:    newWindowTracker(time.Minute),
+	}
+}
+
+func (d *fragmentationDetector) Name() string { return "fragmentation" }
+
+func (d *fragmentationDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if !p.IsFragment || p.SrcIP == "" {
+		return nil
+	}
+	if alert := d.flood(p, sensitivity); alert != nil {
+		return alert
+	}
+	if p.FragAnomaly != "" {
+		return d.anomaly(p)
+	}
+	return nil
+}
+
+// flood alerts once a source sent fragmentFloodCount fragments within a
+// minute.
+func (d *fragmentationDetector) flood(p *PacketData, sensitivity float64) *Alert {
+	d.floods.mu.Lock()
+	defer d.floods.mu.Unlock()
+
+	e := d.floods.entry(p.SrcIP, p.Timestamp)
+	e.count++
+	if e.alerted || float64(e.count) < fragmentFloodCount*sensitivity {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "ip-fragment-flood",
+		Title:       "IP fragment flood",
+		Description: fmt.Sprintf("%s sent %d IP fragments within %s", p.SrcIP, e.count, d.floods.window),
+		Severity:    "medium",
+		SrcIP:       p.SrcIP,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"fragments": fmt.Sprint(e.count)},
+	}
+}
+
+// anomaly alerts once per host pair and anomaly every ten minutes.
+func (d *fragmentationDetector) anomaly(p *PacketData) *Alert {
+	alert := &Alert{
+		Timestamp:  p.Timestamp,
+		SrcIP:      p.SrcIP,
+		DstIP:      p.DstIP,
+		Protocol:   p.Protocol,
+		DeviceName: p.DeviceName,
+		Sensor:     p.Sensor,
+		Metadata:   map[string]string{"anomaly": p.FragAnomaly, "fragment_id": fmt.Sprint(p.FragID)},
+	}
+	switch p.FragAnomaly {
+	case "overlap":
+		alert.Rule, alert.Title, alert.Severity = "ip-fragment-overlap", "Overlapping IP fragments", "high"
+		alert.Description = fmt.Sprintf("%s sent fragments to %s that overwrite data of earlier fragments", p.SrcIP, p.DstIP)
+	case "tiny-first":
+		alert.Rule, alert.Title, alert.Severity = "ip-fragment-tiny", "Tiny first IP fragment", "medium"
+		alert.Description = fmt.Sprintf("%s sent %s a first fragment too short to hold the %s header", p.SrcIP, p.DstIP, p.Protocol)
+	case "oversized":
+		alert.Rule, alert.Title, alert.Severity = "ip-fragment-oversized", "Oversized fragmented datagram", "high"
+		alert.Description = fmt.Sprintf("%s sent fragments to %s that reassemble past the largest IP datagram", p.SrcIP, p.DstIP)
+	default:
+		return nil
+	}
+
+	d.anomalies.mu.Lock()
+	defer d.anomalies.mu.Unlock()
+
+	e := d.anomalies.entry(p.SrcIP+"|"+p.DstIP+"|"+p.FragAnomaly, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	e.alerted = true
+	return alert
+}
+
+// arpSpoofDetector flags ARP cache poisoning: an IP address claimed by more
+// than one MAC, a known gateway's address claimed by a MAC not on the
+// allowlist, and storms of gratuitous ARP from one MAC.
+type arpSpoofDetector struct {
+	claims   *windowTracker
+	gateways *windowTracker
+	storms   *windowTracker
+}
+
+const arpGratuitousStorm = 100
+
+func newARPSpoofDetector() *arpSpoofDetector {
+	return &arpSpoofDetector{
+		claims:   newWindowTracker(5 * time.Minute),
+		gateways: newWindowTracker(5 * time.Minute),
+		storms:   newWindowTracker(time.Minute),
+	}
+}
+
+func (d *arpSpoofDetector) Name() string { return "arpSpoofing" }
+
+func (d *arpSpoofDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	var arp arpFields
+	// A sender of 0.0.0.0 is a probe for a free address, claiming nothing.
+	if !appFields(p, "arp", &arp) || arp.SenderMAC == "" || arp.SenderIP == "0.0.0.0" {
+		return nil
+	}
+	if arp.Gratuitous {
+		if alert := d.storm(p, arp, sensitivity); alert != nil {
+			return alert
+		}
+	}
+
+	macs, known := currentSettings().Alerts.gatewayMACs(arp.SenderIP)
+	for _, mac := range macs {
+		if sameMAC(mac, arp.SenderMAC) {
+			return nil
+		}
+	}
+	if known && len(macs) > 0 {
+		return d.gateway(p, arp, macs)
+	}
+	return d.claim(p, arp)
+}
+
+// storm alerts once a MAC sent arpGratuitousStorm gratuitous ARPs within
+// a minute.
+func (d *arpSpoofDetector) storm(p *PacketData, arp arpFields, sensitivity float64) *Alert {
+	d.storms.mu.Lock()
+	defer d.storms.mu.Unlock()
+
+	e := d.storms.entry(arp.SenderMAC, p.Timestamp)
+	e.count++
+	if e.alerted || float64(e.count) < arpGratuitousStorm*sensitivity {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "arp-gratuitous-storm",
+		Title:       "Gratuitous ARP storm",
+		Description: fmt.Sprintf("%s sent %d gratuitous ARPs within %s", arp.SenderMAC, e.count, d.storms.window),
+		Severity:    "medium",
+		SrcIP:       arp.SenderIP,
+		Protocol:    "ARP",
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"mac": arp.SenderMAC, "gratuitous_arps": fmt.Sprint(e.count)},
+	}
+}
+
+// gateway alerts once per MAC every five minutes when a MAC outside the
+// allowlist claims a known gateway's address.
+func (d *arpSpoofDetector) gateway(p *PacketData, arp arpFields, macs []string) *Alert {
+	d.gateways.mu.Lock()
+	defer d.gateways.mu.Unlock()
+
+	e := d.gateways.entry(arp.SenderIP+"|"+arp.SenderMAC, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp: p.Timestamp,
+		Rule:      "arp-gateway-spoofing",
+		Title:     "Gateway ARP spoofing",
+		Description: fmt.Sprintf("%s claims gateway %s, which belongs to %s",
+			arp.SenderMAC, arp.SenderIP, strings.Join(macs, ", ")),
+		Severity:   "critical",
+		SrcIP:      arp.SenderIP,
+		Protocol:   "ARP",
+		DeviceName: p.DeviceName,
+		Sensor:     p.Sensor,
+		Metadata:   map[string]string{"mac": arp.SenderMAC, "gateway_macs": strings.Join(macs, ",")},
+	}
+}
+
+// claim alerts once every five minutes when an IP address is claimed by a
+// second MAC.
+func (d *arpSpoofDetector) claim(p *PacketData, arp arpFields) *Alert {
+	d.claims.mu.Lock()
+	defer d.claims.mu.Unlock()
+
+	e := d.claims.entry(p.Sensor+"|"+arp.SenderIP, p.Timestamp)
+	if e.members == nil {
+		e.members = make(map[string]struct{})
+	}
+	e.members[strings.ToLower(arp.SenderMAC)] = struct{}{}
+	if e.alerted || len(e.members) < 2 {
+		return nil
+	}
+	e.alerted = true
+
+	macs := make([]string, 0, len(e.members))
+	for mac := range e.members {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "arp-spoofing",
+		Title:       "IP address claimed by several MACs",
+		Description: fmt.Sprintf("%s was claimed in ARP by %s within %s", arp.SenderIP, strings.Join(macs, ", "), d.claims.window),
+		Severity:    "high",
+		SrcIP:       arp.SenderIP,
+		Protocol:    "ARP",
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"mac": arp.SenderMAC, "macs": strings.Join(macs, ",")},
+	}
+}
+
+// rogueDHCPDetector flags DHCP servers that should not be answering. With
+// approved servers in the allowlist, any other server is rogue; without,
+// the detector alerts when a second server answers on the same segment.
+type rogueDHCPDetector struct {
+	rogues  *windowTracker
+	servers *windowTracker
+}
+
+func newRogueDHCPDetector() *rogueDHCPDetector {
+	return &rogueDHCPDetector{
+		rogues:  newWindowTracker(time.Hour),
+		servers: newWindowTracker(time.Hour),
+	}
+}
+
+func (d *rogueDHCPDetector) Name() string { return "rogueDHCP" }
+
+func (d *rogueDHCPDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	var dhcp dhcpFields
+	if !appFields(p, "dhcp", &dhcp) {
+		return nil
+	}
+	switch dhcp.MessageType {
+	case "offer", "ack", "nak":
+	default:
+		return nil
+	}
+	// Behind a relay the source is the relay agent; the server identifier
+	// option names the server itself.
+	server := dhcp.ServerID
+	if server == "" {
+		server = p.SrcIP
+	}
+
+	approved, configured := currentSettings().Alerts.dhcpServers(server, p.SrcMAC)
+	if approved {
+		return nil
+	}
+	if configured {
+		return d.rogue(p, dhcp, server)
+	}
+	return d.multiple(p, dhcp, server)
+}
+
+// rogue alerts once per server every hour.
+func (d *rogueDHCPDetector) rogue(p *PacketData, dhcp dhcpFields, server string) *Alert {
+	d.rogues.mu.Lock()
+	defer d.rogues.mu.Unlock()
+
+	e := d.rogues.entry(server+"|"+p.SrcMAC, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	e.alerted = true
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "rogue-dhcp-server",
+		Title:       "Rogue DHCP server",
+		Description: fmt.Sprintf("DHCP server %s (%s), which is not approved, sent a DHCP %s to %s", server, p.SrcMAC, dhcp.MessageType, dhcp.ClientMAC),
+		Severity:    "high",
+		SrcIP:       p.SrcIP,
+		DstIP:       p.DstIP,
+		SrcPort:     p.SrcPort,
+		DstPort:     p.DstPort,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"server_id": server, "mac": p.SrcMAC, "message_type": dhcp.MessageType, "client_mac": dhcp.ClientMAC},
+	}
+}
+
+// multiple alerts once an hour when more than one server answers DHCP on
+// a sensor's interface.
+func (d *rogueDHCPDetector) multiple(p *PacketData, dhcp dhcpFields, server string) *Alert {
+	d.servers.mu.Lock()
+	defer d.servers.mu.Unlock()
+
+	e := d.servers.entry(p.Sensor+"|"+p.DeviceName, p.Timestamp)
+	if e.members == nil {
+		e.members = make(map[string]struct{})
+	}
+	e.members[server] = struct{}{}
+	if e.alerted || len(e.members) < 2 {
+		return nil
+	}
+	e.alerted = true
+
+	servers := make([]string, 0, len(e.members))
+	for s := range e.members {
+		servers = append(servers, s)
+	}
+	sort.Strings(servers)
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "multiple-dhcp-servers",
+		Title:       "Multiple DHCP servers",
+		Description: fmt.Sprintf("DHCP servers %s answered on %s within %s", strings.Join(servers, ", "), p.DeviceName, d.servers.window),
+		Severity:    "medium",
+		SrcIP:       p.SrcIP,
+		DstIP:       p.DstIP,
+		SrcPort:     p.SrcPort,
+		DstPort:     p.DstPort,
+		Protocol:    p.Protocol,
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"server_id": server, "mac": p.SrcMAC, "servers": strings.Join(servers, ",")},
+	}
+}
+
+// macFloodDetector flags floods of frames from many source MACs, which
+// overflow switch CAM tables so the switch sends all traffic to every
+// port.
+type macFloodDetector struct {
+	macs *windowTracker
+}
+
+const macFloodAddresses = 5000
+
+func newMACFloodDetector() *macFloodDetector {
+	return &macFloodDetector{macs: newWindowTracker(time.Minute)}
+}
+
+func (d *macFloodDetector) Name() string { return "macFlooding" }
+
+// Observe alerts once a sensor's interface saw macFloodAddresses distinct
+// source MACs within a minute.
+func (d *macFloodDetector) Observe(p *PacketData, sensitivity float64) *Alert {
+	if p.SrcMAC == "" {
+		return nil
+	}
+
+	d.macs.mu.Lock()
+	defer d.macs.mu.Unlock()
+
+	e := d.macs.entry(p.Sensor+"|"+p.DeviceName, p.Timestamp)
+	if e.alerted {
+		return nil
+	}
+	if e.members == nil {
+		e.members = make(map[string]struct{})
+	}
+	e.members[p.SrcMAC] = struct{}{}
+	if float64(len(e.members)) < macFloodAddresses*sensitivity {
+		return nil
+	}
+	e.alerted = true
+	count := len(e.members)
+	e.members = nil
+
+	return &Alert{
+		Timestamp:   p.Timestamp,
+		Rule:        "mac-flood",
+		Title:       "MAC flooding",
+		Description: fmt.Sprintf("%d source MACs were seen on %s within %s", count, p.DeviceName, d.macs.window),
+		Severity:    "high",
+		DeviceName:  p.DeviceName,
+		Sensor:      p.Sensor,
+		Metadata:    map[string]string{"mac_addresses": fmt.Sprint(count)},
+	}
+}
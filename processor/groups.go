@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// maxLegacyGroups bounds the search for the per-worker consumer groups of
+// earlier versions.
+const maxLegacyGroups = 64
+
+// adoptLegacyOffsets gives group, when it has nothing committed yet, the
+// offsets of the per-worker groups earlier versions used for topic
+// (group-0, group-1, ...). Each of those read the whole topic, so the
+// furthest of their offsets on a partition is where processing stopped.
+// Without this an upgraded processor would start the shared group at the
+// oldest retained message and process everything again.
+func adoptLegacyOffsets(ctx context.Context, topic, group string) error {
+	client := &kafka.Client{Addr: kafka.TCP(*kafkaAddr), Timeout: 10 * time.Second}
+
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return err
+	}
+	var partitions []int
+	for _, t := range meta.Topics {
+		if t.Name != topic || t.Error != nil {
+			continue
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	committed := func(g string) (map[int]int64, error) {
+		resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+			GroupID: g,
+			Topics:  map[string][]int{topic: partitions},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		offsets := make(map[int]int64)
+		for _, p := range resp.Topics[topic] {
+			if p.Error == nil && p.CommittedOffset >= 0 {
+				offsets[p.Partition] = p.CommittedOffset
+			}
+		}
+		return offsets, nil
+	}
+
+	current, err := committed(group)
+	if err != nil || len(current) > 0 {
+		return err
+	}
+
+	adopted := make(map[int]int64)
+	legacy := 0
+	for ; legacy < maxLegacyGroups; legacy++ {
+		offsets, err := committed(fmt.Sprintf("%s-%d", group, legacy))
+		if err != nil {
+			return err
+		}
+		if len(offsets) == 0 {
+			break
+		}
+		for p, offset := range offsets {
+			adopted[p] = max(adopted[p], offset)
+		}
+	}
+	if len(adopted) == 0 {
+		return nil
+	}
+
+	commits := make([]kafka.OffsetCommit, 0, len(adopted))
+	for p, offset := range adopted {
+		commits = append(commits, kafka.OffsetCommit{Partition: p, Offset: offset})
+	}
+	// Generation -1 commits for a group without members.
+	resp, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+	}
+	log.Printf("[Kafka] Started %s on %s where its %d per-worker predecessors stopped", group, topic, legacy)
+	return nil
+}
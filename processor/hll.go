@@ -0,0 +1,52 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// HyperLogLog sketch with 2^12 one-byte registers (~1.6% standard error).
+// The byte layout is shared with siem.hll_merge and the server, so the
+// precision and hash must not change without a migration.
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+)
+
+type hllSketch []byte
+
+func newHLLSketch() hllSketch {
+	return make(hllSketch, hllRegisters)
+}
+
+func (s hllSketch) Add(value string) {
+	h := hllHash(value)
+	idx := h >> (64 - hllPrecision)
+	rho := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rho > s[idx] {
+		s[idx] = rho
+	}
+}
+
+func (s hllSketch) Merge(other hllSketch) {
+	for i := range s {
+		if i < len(other) && other[i] > s[i] {
+			s[i] = other[i]
+		}
+	}
+}
+
+// hllHash is FNV-1a followed by the splitmix64 finalizer, which spreads
+// FNV's weak high bits. It must be stable across processes because
+// sketches from every processor instance are merged together.
+func hllHash(value string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxIncidentArray bounds the rules, hosts and indicators kept on an
+// incident so a scan touching thousands of hosts stays a manageable row.
+const maxIncidentArray = 256
+
+// indicatorKeys are the alert metadata keys whose values are treated as
+// indicators shared between alerts, alongside the source host.
+var indicatorKeys = []string{
+	"domain", "hostname", "url", "email", "user", "username",
+	"hash", "md5", "sha1", "sha256", "ja3",
+}
+
+// indicators returns the values an alert is correlated on, prefixed with
+// their kind so an IP never matches a username with the same text.
+func (a *Alert) indicators() []string {
+	out := []string{}
+	if a.SrcIP != "" {
+		out = append(out, "ip:"+a.SrcIP)
+	}
+	for _, key := range indicatorKeys {
+		if v := a.Metadata[key]; v != "" {
+			out = append(out, key+":"+v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func nonEmpty(values ...string) []string {
+	out := []string{}
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// correlateAlert attaches a newly stored alert to an open incident seen
+// within -incident-window that shares its source host or an indicator, or
+// that already has the same rule firing against the same destination.
+// Otherwise the alert starts a new incident.
+func correlateAlert(ctx context.Context, tx pgx.Tx, alertID int64, alert Alert) error {
+	indicators := alert.indicators()
+	srcIPs := nonEmpty(alert.SrcIP)
+	dstIPs := nonEmpty(alert.DstIP)
+
+	var incidentID int64
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM siem.incidents
+		WHERE status NOT IN ('resolved', 'false-positive')
+			AND last_seen >= $1
+			AND (
+				indicators && $2::text[]
+				OR ($3 = ANY(rules) AND $4 <> '' AND $4 = ANY(dst_ips))
+			)
+		ORDER BY last_seen DESC
+		LIMIT 1
+		FOR UPDATE
+	`, alert.Timestamp.Add(-*incidentWindow), indicators, alert.Rule, alert.DstIP).Scan(&incidentID)
+
+	switch {
+	case err == pgx.ErrNoRows:
+		title := alert.Title
+		if alert.SrcIP != "" {
+			title += " from " + alert.SrcIP
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO siem.incidents (
+				title, severity, first_seen, last_seen, alert_count, event_count,
+				rules, src_ips, dst_ips, indicators
+			) VALUES ($1, $2, $3, $3, 1, 1, $4, $5, $6, $7)
+			RETURNING id
+		`, title, alert.Severity, alert.Timestamp, []string{alert.Rule}, srcIPs, dstIPs, indicators).Scan(&incidentID)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		_, err = tx.Exec(ctx, `
+			UPDATE siem.incidents SET
+				alert_count = alert_count + 1,
+				event_count = event_count + 1,
+				first_seen = LEAST(first_seen, $2),
+				last_seen = GREATEST(last_seen, $2),
+				severity = CASE WHEN siem.severity_rank($3) > siem.severity_rank(severity) THEN $3 ELSE severity END,
+				rules = siem.text_array_union(rules, $4, $8),
+				src_ips = siem.text_array_union(src_ips, $5, $8),
+				dst_ips = siem.text_array_union(dst_ips, $6, $8),
+				indicators = siem.text_array_union(indicators, $7, $8),
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, incidentID, alert.Timestamp, alert.Severity, []string{alert.Rule}, srcIPs, dstIPs, indicators, maxIncidentArray)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE siem.alerts SET incident_id = $1 WHERE id = $2`, incidentID, alertID)
+	return err
+}
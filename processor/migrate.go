@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/h3bzzz/pluto/processor/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runMigrateCommand implements `processor migrate [up|down [n]|status]`.
+func runMigrateCommand(args []string) {
+	ctx := context.Background()
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	pool, err := pgxpool.New(ctx, *migratePg)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer pool.Close()
+
+	switch action {
+	case "up":
+		applied, err := migrations.Up(ctx, pool)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s), schema is at version %d", len(applied), migrations.Latest())
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrations.Down(ctx, pool, steps)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		for _, version := range reverted {
+			log.Printf("Reverted migration %d", version)
+		}
+
+	case "status":
+		statuses, err := migrations.List(ctx, pool)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "usage: processor migrate [up|down [n]|status]\n")
+		os.Exit(2)
+	}
+}
+
+// migrateOnStartup brings the schema up to date before the processor
+// starts consuming. It uses the migration connection string because the
+// processor's own role cannot create roles or other owners' objects.
+func migrateOnStartup(ctx context.Context) error {
+	pool, err := pgxpool.New(ctx, *migratePg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	applied, err := migrations.Up(ctx, pool)
+	if err != nil {
+		return err
+	}
+	for _, version := range applied {
+		log.Printf("Applied migration %d", version)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package migrations applies the versioned SQL files embedded under sql/ to
+// the Pluto database. Files are named NNNN_description.up.sql and
+// NNNN_description.down.sql; each migration runs in its own transaction and
+// is recorded in siem.schema_migrations. A session-level advisory lock
+// serialises concurrent runs from several processor instances.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// advisoryLockKey is an arbitrary constant shared by every process that
+// migrates this database.
+const advisoryLockKey = 7_261_001
+
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// All returns the embedded migrations sorted by version.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		m := fileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(files, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest returns the highest embedded migration version.
+func Latest() int64 {
+	migrations, err := All()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Up applies every pending migration and returns the versions applied.
+func Up(ctx context.Context, pool *pgxpool.Pool) ([]int64, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int64
+	err = withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx,
+					`INSERT INTO siem.schema_migrations (version, name) VALUES ($1, $2)`,
+					mig.Version, mig.Name,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("apply migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig.Version)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recent `steps` applied migrations and returns the
+// versions reverted.
+func Down(ctx context.Context, pool *pgxpool.Pool, steps int) ([]int64, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []int64
+	err = withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down step", mig.Version, mig.Name)
+			}
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM siem.schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("revert migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			reverted = append(reverted, mig.Version)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// List reports every embedded migration and whether it has been applied.
+func List(ctx context.Context, pool *pgxpool.Pool) ([]Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	done, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, mig := range migrations {
+		appliedAt, ok := done[mig.Version]
+		statuses = append(statuses, Status{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockKey)
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func ensureTable(ctx context.Context, conn *pgxpool.Conn) error {
+	_, err := conn.Exec(ctx, `
+		CREATE SCHEMA IF NOT EXISTS siem;
+		CREATE TABLE IF NOT EXISTS siem.schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM siem.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS refresh_network_stats_trigger ON siem.packet_data;
+DROP FUNCTION IF EXISTS siem.refresh_network_stats();
+DROP MATERIALIZED VIEW IF EXISTS siem.network_stats;
+DROP TABLE IF EXISTS siem.log_data;
+DROP TABLE IF EXISTS siem.retention_policy;
+DROP TABLE IF EXISTS siem.packet_data;
+
+REVOKE CREATE ON SCHEMA siem FROM processor_user;
+REVOKE USAGE ON SCHEMA siem FROM collector_user, processor_user, server_user;
+REVOKE ALL ON siem.schema_migrations FROM processor_user, server_user;
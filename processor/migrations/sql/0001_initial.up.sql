@@ -0,0 +1,221 @@
+-- Baseline schema, formerly scripts/init-db.sql. Every statement is
+-- idempotent so this also applies cleanly to databases that were created
+-- by that script.
+CREATE SCHEMA IF NOT EXISTS siem;
+
+-- Application roles
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'collector_user') THEN
+        CREATE USER collector_user WITH PASSWORD 'collector_pass';
+    END IF;
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'processor_user') THEN
+        CREATE USER processor_user WITH PASSWORD 'processor_pass';
+    END IF;
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'server_user') THEN
+        CREATE USER server_user WITH PASSWORD 'server_pass';
+    END IF;
+END
+$$;
+
+-- Databases created by the old init script have an unpartitioned
+-- siem.packet_data. Move it out of the way; its rows are copied into the
+-- partitioned table at the end of this migration.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT FROM pg_class c JOIN pg_namespace n ON c.relnamespace = n.oid
+        WHERE n.nspname = 'siem' AND c.relname = 'packet_data' AND c.relkind = 'r'
+    ) THEN
+        DROP MATERIALIZED VIEW IF EXISTS siem.network_stats;
+        ALTER TABLE siem.packet_data RENAME TO packet_data_legacy;
+        ALTER INDEX IF EXISTS siem.idx_packet_data_timestamp RENAME TO idx_packet_data_legacy_timestamp;
+        ALTER INDEX IF EXISTS siem.idx_packet_data_src_ip RENAME TO idx_packet_data_legacy_src_ip;
+        ALTER INDEX IF EXISTS siem.idx_packet_data_dst_ip RENAME TO idx_packet_data_legacy_dst_ip;
+        ALTER INDEX IF EXISTS siem.idx_packet_data_protocol RENAME TO idx_packet_data_legacy_protocol;
+        ALTER SEQUENCE IF EXISTS siem.packet_data_id_seq RENAME TO packet_data_legacy_id_seq;
+    END IF;
+END
+$$;
+
+-- Network monitoring tables. Packets are range partitioned by day on
+-- timestamp; the processor creates upcoming partitions and drops the ones
+-- older than the configured retention (see siem.retention_policy).
+CREATE TABLE IF NOT EXISTS siem.packet_data (
+    id BIGSERIAL,
+    timestamp TIMESTAMP NOT NULL,
+    device_name TEXT NOT NULL,
+    interface_index INTEGER,
+    direction TEXT,
+
+    -- Ethernet
+    src_mac TEXT,
+    dst_mac TEXT,
+    ether_type TEXT,
+    vlan_id INTEGER,
+    is_multicast BOOLEAN,
+
+    -- Network
+    src_ip TEXT,
+    dst_ip TEXT,
+    ip_version TEXT,
+    ttl INTEGER,
+    protocol TEXT,
+    fragment_id INTEGER,
+    fragment_offset INTEGER,
+    dscp INTEGER,
+    icmp_type INTEGER,
+    icmp_code INTEGER,
+
+    -- Transport
+    src_port INTEGER,
+    dst_port INTEGER,
+    tcp_flags TEXT,
+    sequence_number BIGINT,
+    acknowledgement_number BIGINT,
+    window_size INTEGER,
+    checksum_valid BOOLEAN,
+
+    -- Application
+    dns_id INTEGER,
+    dns_opcode TEXT,
+    dns_query JSONB,
+    http_method TEXT,
+    tls_version TEXT,
+    sni TEXT,
+
+    -- Payload
+    payload_size INTEGER,
+    payload_hash TEXT,
+
+    -- Security & Behavioral
+    is_malicious BOOLEAN DEFAULT FALSE,
+    threat_type TEXT,
+    cve_ids JSONB,
+    src_country TEXT,
+    dst_country TEXT,
+
+    inserted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+-- Catches rows outside every daily partition (clock skew, replays) so
+-- inserts never fail while the processor catches up.
+CREATE TABLE IF NOT EXISTS siem.packet_data_default PARTITION OF siem.packet_data DEFAULT;
+
+CREATE INDEX IF NOT EXISTS idx_packet_data_timestamp ON siem.packet_data(timestamp);
+CREATE INDEX IF NOT EXISTS idx_packet_data_src_ip ON siem.packet_data(src_ip);
+CREATE INDEX IF NOT EXISTS idx_packet_data_dst_ip ON siem.packet_data(dst_ip);
+CREATE INDEX IF NOT EXISTS idx_packet_data_protocol ON siem.packet_data(protocol);
+
+-- Copy legacy rows into daily partitions, creating the partitions first
+-- so nothing lands in the default partition.
+DO $$
+DECLARE
+    day DATE;
+    last_day DATE;
+BEGIN
+    IF to_regclass('siem.packet_data_legacy') IS NULL THEN
+        RETURN;
+    END IF;
+
+    SELECT MIN(timestamp)::date, MAX(timestamp)::date INTO day, last_day FROM siem.packet_data_legacy;
+    WHILE day IS NOT NULL AND day <= last_day LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS siem.%I PARTITION OF siem.packet_data FOR VALUES FROM (%L) TO (%L)',
+            'packet_data_p' || to_char(day, 'YYYYMMDD'), day, day + 1
+        );
+        EXECUTE format(
+            'ALTER TABLE siem.%I OWNER TO processor_user',
+            'packet_data_p' || to_char(day, 'YYYYMMDD')
+        );
+        day := day + 1;
+    END LOOP;
+
+    INSERT INTO siem.packet_data
+    SELECT * FROM siem.packet_data_legacy;
+
+    PERFORM setval('siem.packet_data_id_seq', GREATEST((SELECT MAX(id) FROM siem.packet_data_legacy), 1));
+
+    DROP TABLE siem.packet_data_legacy;
+END
+$$;
+
+-- Retention per table in days, editable through the API
+CREATE TABLE IF NOT EXISTS siem.retention_policy (
+    table_name TEXT PRIMARY KEY,
+    retention_days INTEGER NOT NULL CHECK (retention_days > 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO siem.retention_policy (table_name, retention_days)
+VALUES ('packet_data', 7)
+ON CONFLICT (table_name) DO NOTHING;
+
+-- Log data
+CREATE TABLE IF NOT EXISTS siem.log_data (
+    id SERIAL PRIMARY KEY,
+    timestamp TIMESTAMP NOT NULL,
+    source TEXT NOT NULL,
+    log_level TEXT,
+    message TEXT NOT NULL,
+    metadata JSONB,
+    inserted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_log_data_timestamp ON siem.log_data(timestamp);
+CREATE INDEX IF NOT EXISTS idx_log_data_source ON siem.log_data(source);
+CREATE INDEX IF NOT EXISTS idx_log_data_log_level ON siem.log_data(log_level);
+
+-- Hourly network statistics
+CREATE MATERIALIZED VIEW IF NOT EXISTS siem.network_stats AS
+SELECT
+    date_trunc('hour', timestamp) AS hour,
+    COUNT(*) AS packet_count,
+    COUNT(DISTINCT src_ip) AS unique_src_ips,
+    COUNT(DISTINCT dst_ip) AS unique_dst_ips,
+    SUM(payload_size) AS total_bytes,
+    COUNT(CASE WHEN is_malicious THEN 1 END) AS malicious_packets
+FROM
+    siem.packet_data
+GROUP BY
+    date_trunc('hour', timestamp)
+WITH DATA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_network_stats_hour ON siem.network_stats(hour);
+
+CREATE OR REPLACE FUNCTION siem.refresh_network_stats()
+RETURNS TRIGGER AS $$
+BEGIN
+    REFRESH MATERIALIZED VIEW CONCURRENTLY siem.network_stats;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS refresh_network_stats_trigger ON siem.packet_data;
+CREATE TRIGGER refresh_network_stats_trigger
+AFTER INSERT ON siem.packet_data
+FOR EACH STATEMENT
+EXECUTE FUNCTION siem.refresh_network_stats();
+
+-- Permissions
+GRANT USAGE ON SCHEMA siem TO collector_user, processor_user, server_user;
+GRANT SELECT ON siem.schema_migrations TO processor_user, server_user;
+
+GRANT SELECT, INSERT ON siem.packet_data TO processor_user;
+GRANT USAGE ON SEQUENCE siem.packet_data_id_seq TO processor_user;
+GRANT SELECT ON siem.packet_data TO server_user;
+-- Creating and dropping partitions requires owning the parent table
+ALTER TABLE siem.packet_data OWNER TO processor_user;
+ALTER TABLE siem.packet_data_default OWNER TO processor_user;
+GRANT CREATE ON SCHEMA siem TO processor_user;
+
+GRANT SELECT ON siem.retention_policy TO processor_user;
+GRANT SELECT, UPDATE ON siem.retention_policy TO server_user;
+
+GRANT SELECT ON siem.network_stats TO server_user;
+
+GRANT SELECT, INSERT ON siem.log_data TO processor_user;
+GRANT USAGE ON SEQUENCE siem.log_data_id_seq TO processor_user;
+GRANT SELECT ON siem.log_data TO server_user;
@@ -0,0 +1,37 @@
+DROP TABLE IF EXISTS siem.port_rollup;
+DROP TABLE IF EXISTS siem.host_rollup;
+DROP TABLE IF EXISTS siem.protocol_rollup;
+DROP TABLE IF EXISTS siem.traffic_rollup;
+DROP FUNCTION IF EXISTS siem.hll_merge(BYTEA, BYTEA);
+
+DELETE FROM siem.retention_policy WHERE table_name IN ('rollup_1m', 'rollup_1h');
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS siem.network_stats AS
+SELECT
+    date_trunc('hour', timestamp) AS hour,
+    COUNT(*) AS packet_count,
+    COUNT(DISTINCT src_ip) AS unique_src_ips,
+    COUNT(DISTINCT dst_ip) AS unique_dst_ips,
+    SUM(payload_size) AS total_bytes,
+    COUNT(CASE WHEN is_malicious THEN 1 END) AS malicious_packets
+FROM
+    siem.packet_data
+GROUP BY
+    date_trunc('hour', timestamp)
+WITH DATA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_network_stats_hour ON siem.network_stats(hour);
+GRANT SELECT ON siem.network_stats TO server_user;
+
+CREATE OR REPLACE FUNCTION siem.refresh_network_stats()
+RETURNS TRIGGER AS $$
+BEGIN
+    REFRESH MATERIALIZED VIEW CONCURRENTLY siem.network_stats;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER refresh_network_stats_trigger
+AFTER INSERT ON siem.packet_data
+FOR EACH STATEMENT
+EXECUTE FUNCTION siem.refresh_network_stats();
@@ -0,0 +1,86 @@
+-- Pre-aggregated traffic rollups maintained by the processor. Each table
+-- holds one row per bucket (and dimension) for two resolutions, '1m' and
+-- '1h'. Unique IP counts are HyperLogLog sketches (4096 one-byte
+-- registers) that can be merged across buckets without rescanning
+-- packets.
+
+-- The per-insert REFRESH of siem.network_stats rescanned every packet on
+-- every insert; the rollups below replace it.
+DROP TRIGGER IF EXISTS refresh_network_stats_trigger ON siem.packet_data;
+DROP FUNCTION IF EXISTS siem.refresh_network_stats();
+DROP MATERIALIZED VIEW IF EXISTS siem.network_stats;
+
+-- Register-wise maximum of two sketches, used when upserting a bucket
+CREATE OR REPLACE FUNCTION siem.hll_merge(a BYTEA, b BYTEA)
+RETURNS BYTEA AS $$
+DECLARE
+    result BYTEA;
+    i INTEGER;
+BEGIN
+    IF a IS NULL THEN
+        RETURN b;
+    END IF;
+    IF b IS NULL OR length(a) <> length(b) THEN
+        RETURN a;
+    END IF;
+
+    result := a;
+    FOR i IN 0 .. length(a) - 1 LOOP
+        IF get_byte(b, i) > get_byte(result, i) THEN
+            result := set_byte(result, i, get_byte(b, i));
+        END IF;
+    END LOOP;
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS siem.traffic_rollup (
+    resolution TEXT NOT NULL CHECK (resolution IN ('1m', '1h')),
+    bucket TIMESTAMP NOT NULL,
+    packet_count BIGINT NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    malicious_packets BIGINT NOT NULL DEFAULT 0,
+    src_ip_sketch BYTEA,
+    dst_ip_sketch BYTEA,
+    PRIMARY KEY (resolution, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS siem.protocol_rollup (
+    resolution TEXT NOT NULL CHECK (resolution IN ('1m', '1h')),
+    bucket TIMESTAMP NOT NULL,
+    protocol TEXT NOT NULL,
+    packet_count BIGINT NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (resolution, bucket, protocol)
+);
+
+-- direction is 'src' or 'dst': which side of the packet the host was on
+CREATE TABLE IF NOT EXISTS siem.host_rollup (
+    resolution TEXT NOT NULL CHECK (resolution IN ('1m', '1h')),
+    bucket TIMESTAMP NOT NULL,
+    ip TEXT NOT NULL,
+    direction TEXT NOT NULL CHECK (direction IN ('src', 'dst')),
+    packet_count BIGINT NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (resolution, bucket, ip, direction)
+);
+
+CREATE TABLE IF NOT EXISTS siem.port_rollup (
+    resolution TEXT NOT NULL CHECK (resolution IN ('1m', '1h')),
+    bucket TIMESTAMP NOT NULL,
+    protocol TEXT NOT NULL,
+    port INTEGER NOT NULL,
+    packet_count BIGINT NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (resolution, bucket, protocol, port)
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_rollup_ip ON siem.host_rollup(ip, resolution, bucket);
+
+INSERT INTO siem.retention_policy (table_name, retention_days)
+VALUES ('rollup_1m', 14), ('rollup_1h', 365)
+ON CONFLICT (table_name) DO NOTHING;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.traffic_rollup, siem.protocol_rollup, siem.host_rollup, siem.port_rollup TO processor_user;
+GRANT SELECT ON siem.traffic_rollup, siem.protocol_rollup, siem.host_rollup, siem.port_rollup TO server_user;
+GRANT EXECUTE ON FUNCTION siem.hll_merge(BYTEA, BYTEA) TO processor_user, server_user;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS siem.alert_comments;
+DROP TABLE IF EXISTS siem.alerts;
+DROP FUNCTION IF EXISTS siem.apply_alert_suppression();
+DROP TABLE IF EXISTS siem.alert_suppressions;
@@ -0,0 +1,95 @@
+-- Alerts raised by detectors, with an analyst workflow on top: status,
+-- assignee, comments and time-boxed suppression rules.
+
+CREATE TABLE IF NOT EXISTS siem.alert_suppressions (
+    id BIGSERIAL PRIMARY KEY,
+    -- NULL criteria match anything; a rule with every criterion NULL is
+    -- rejected by the API.
+    rule TEXT,
+    src_ip TEXT,
+    dst_ip TEXT,
+    dst_port INTEGER,
+    reason TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_suppressions_expires_at ON siem.alert_suppressions(expires_at);
+
+CREATE TABLE IF NOT EXISTS siem.alerts (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    timestamp TIMESTAMP NOT NULL,
+
+    rule TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    severity TEXT NOT NULL CHECK (severity IN ('low', 'medium', 'high', 'critical')),
+
+    src_ip TEXT,
+    dst_ip TEXT,
+    src_port INTEGER,
+    dst_port INTEGER,
+    protocol TEXT,
+    device_name TEXT,
+    metadata JSONB,
+
+    status TEXT NOT NULL DEFAULT 'new'
+        CHECK (status IN ('new', 'acknowledged', 'in-progress', 'resolved', 'false-positive')),
+    assignee TEXT,
+    acknowledged_at TIMESTAMP,
+    resolved_at TIMESTAMP,
+    suppressed_by BIGINT REFERENCES siem.alert_suppressions(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_timestamp ON siem.alerts(timestamp);
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON siem.alerts(status);
+CREATE INDEX IF NOT EXISTS idx_alerts_rule ON siem.alerts(rule);
+CREATE INDEX IF NOT EXISTS idx_alerts_src_ip ON siem.alerts(src_ip);
+
+-- Notes left by analysts and an automatic trail of workflow changes
+CREATE TABLE IF NOT EXISTS siem.alert_comments (
+    id BIGSERIAL PRIMARY KEY,
+    alert_id BIGINT NOT NULL REFERENCES siem.alerts(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL DEFAULT 'note' CHECK (kind IN ('note', 'change')),
+    author TEXT,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_comments_alert_id ON siem.alert_comments(alert_id);
+
+-- Mark new alerts that match an active suppression so they are hidden
+-- from the default alert list, whichever service inserted them.
+CREATE OR REPLACE FUNCTION siem.apply_alert_suppression()
+RETURNS TRIGGER AS $$
+BEGIN
+    SELECT s.id INTO NEW.suppressed_by
+    FROM siem.alert_suppressions s
+    WHERE s.expires_at > CURRENT_TIMESTAMP
+        AND (s.rule IS NULL OR s.rule = NEW.rule)
+        AND (s.src_ip IS NULL OR s.src_ip = NEW.src_ip)
+        AND (s.dst_ip IS NULL OR s.dst_ip = NEW.dst_ip)
+        AND (s.dst_port IS NULL OR s.dst_port = NEW.dst_port)
+    ORDER BY s.expires_at DESC
+    LIMIT 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS apply_alert_suppression_trigger ON siem.alerts;
+CREATE TRIGGER apply_alert_suppression_trigger
+BEFORE INSERT ON siem.alerts
+FOR EACH ROW
+EXECUTE FUNCTION siem.apply_alert_suppression();
+
+GRANT SELECT, INSERT ON siem.alerts TO processor_user;
+GRANT USAGE ON SEQUENCE siem.alerts_id_seq TO processor_user;
+GRANT SELECT ON siem.alert_suppressions TO processor_user;
+
+GRANT SELECT, INSERT, UPDATE ON siem.alerts TO server_user;
+GRANT SELECT, INSERT ON siem.alert_comments TO server_user;
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.alert_suppressions TO server_user;
+GRANT USAGE ON SEQUENCE siem.alerts_id_seq, siem.alert_comments_id_seq, siem.alert_suppressions_id_seq TO server_user;
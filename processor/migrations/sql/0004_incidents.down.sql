@@ -0,0 +1,30 @@
+CREATE OR REPLACE FUNCTION siem.apply_alert_suppression()
+RETURNS TRIGGER AS $$
+BEGIN
+    SELECT s.id INTO NEW.suppressed_by
+    FROM siem.alert_suppressions s
+    WHERE s.expires_at > CURRENT_TIMESTAMP
+        AND (s.rule IS NULL OR s.rule = NEW.rule)
+        AND (s.src_ip IS NULL OR s.src_ip = NEW.src_ip)
+        AND (s.dst_ip IS NULL OR s.dst_ip = NEW.dst_ip)
+        AND (s.dst_port IS NULL OR s.dst_port = NEW.dst_port)
+    ORDER BY s.expires_at DESC
+    LIMIT 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS siem.active_alert_suppression(TEXT, TEXT, TEXT, INTEGER);
+
+REVOKE UPDATE ON siem.alerts FROM processor_user;
+
+DROP INDEX IF EXISTS siem.idx_alerts_incident_id;
+DROP INDEX IF EXISTS siem.idx_alerts_fingerprint;
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS incident_id;
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS last_seen;
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS occurrences;
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS fingerprint;
+
+DROP TABLE IF EXISTS siem.incidents;
+DROP FUNCTION IF EXISTS siem.text_array_union(TEXT[], TEXT[], INTEGER);
+DROP FUNCTION IF EXISTS siem.severity_rank(TEXT);
@@ -0,0 +1,90 @@
+-- Deduplication of repeated alerts and correlation of related alerts into
+-- incidents. Both are done by the processor as alerts are ingested.
+
+CREATE OR REPLACE FUNCTION siem.severity_rank(severity TEXT)
+RETURNS INTEGER AS $$
+    SELECT CASE severity
+        WHEN 'critical' THEN 4
+        WHEN 'high' THEN 3
+        WHEN 'medium' THEN 2
+        WHEN 'low' THEN 1
+        ELSE 0
+    END
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Sorted union of two arrays without duplicates, truncated to max_len so
+-- incidents touching thousands of hosts stay a manageable size.
+CREATE OR REPLACE FUNCTION siem.text_array_union(a TEXT[], b TEXT[], max_len INTEGER)
+RETURNS TEXT[] AS $$
+    SELECT COALESCE((array_agg(DISTINCT v ORDER BY v))[1:max_len], '{}')
+    FROM unnest(a || b) AS v
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS siem.incidents (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL,
+    -- Highest severity of any member alert
+    severity TEXT NOT NULL CHECK (severity IN ('low', 'medium', 'high', 'critical')),
+    status TEXT NOT NULL DEFAULT 'new'
+        CHECK (status IN ('new', 'acknowledged', 'in-progress', 'resolved', 'false-positive')),
+    assignee TEXT,
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    -- Distinct member alerts, and total occurrences including duplicates
+    alert_count INTEGER NOT NULL DEFAULT 0,
+    event_count INTEGER NOT NULL DEFAULT 0,
+    rules TEXT[] NOT NULL DEFAULT '{}',
+    src_ips TEXT[] NOT NULL DEFAULT '{}',
+    dst_ips TEXT[] NOT NULL DEFAULT '{}',
+    -- Source hosts plus indicator values from alert metadata (domains,
+    -- hashes, users, ...); alerts sharing any of these are correlated.
+    indicators TEXT[] NOT NULL DEFAULT '{}',
+    resolved_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_incidents_last_seen ON siem.incidents(last_seen);
+CREATE INDEX IF NOT EXISTS idx_incidents_status ON siem.incidents(status);
+CREATE INDEX IF NOT EXISTS idx_incidents_indicators ON siem.incidents USING GIN (indicators);
+
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS fingerprint TEXT;
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS occurrences INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP;
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS incident_id BIGINT REFERENCES siem.incidents(id) ON DELETE SET NULL;
+
+UPDATE siem.alerts SET last_seen = timestamp WHERE last_seen IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_alerts_fingerprint ON siem.alerts(fingerprint, last_seen);
+CREATE INDEX IF NOT EXISTS idx_alerts_incident_id ON siem.alerts(incident_id);
+
+-- Factored out of the suppression trigger so the processor can tell whether
+-- an incoming alert would be muted before deduplicating it.
+CREATE OR REPLACE FUNCTION siem.active_alert_suppression(
+    p_rule TEXT, p_src_ip TEXT, p_dst_ip TEXT, p_dst_port INTEGER
+)
+RETURNS BIGINT AS $$
+    SELECT s.id
+    FROM siem.alert_suppressions s
+    WHERE s.expires_at > CURRENT_TIMESTAMP
+        AND (s.rule IS NULL OR s.rule = p_rule)
+        AND (s.src_ip IS NULL OR s.src_ip = p_src_ip)
+        AND (s.dst_ip IS NULL OR s.dst_ip = p_dst_ip)
+        AND (s.dst_port IS NULL OR s.dst_port = p_dst_port)
+    ORDER BY s.expires_at DESC
+    LIMIT 1
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION siem.apply_alert_suppression()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.suppressed_by := siem.active_alert_suppression(NEW.rule, NEW.src_ip, NEW.dst_ip, NEW.dst_port);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+GRANT UPDATE ON siem.alerts TO processor_user;
+GRANT SELECT, INSERT, UPDATE ON siem.incidents TO processor_user;
+GRANT USAGE ON SEQUENCE siem.incidents_id_seq TO processor_user;
+
+GRANT SELECT, UPDATE ON siem.incidents TO server_user;
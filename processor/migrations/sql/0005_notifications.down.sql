@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS siem.notification_deliveries;
+DROP TABLE IF EXISTS siem.notification_channels;
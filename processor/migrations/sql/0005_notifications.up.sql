@@ -0,0 +1,35 @@
+-- Outbound notification channels for alerts, managed through the server API
+-- and delivered by plutos-space.
+
+CREATE TABLE IF NOT EXISTS siem.notification_channels (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL CHECK (type IN ('webhook', 'smtp', 'slack')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    -- Only alerts at or above this severity are sent
+    min_severity TEXT NOT NULL DEFAULT 'low'
+        CHECK (min_severity IN ('low', 'medium', 'high', 'critical')),
+    -- Go text/template for the message body; empty uses the channel default
+    template TEXT NOT NULL DEFAULT '',
+    -- Channel specific settings (URLs, SMTP server, secrets)
+    config JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per delivery, successful or not, for troubleshooting channels
+CREATE TABLE IF NOT EXISTS siem.notification_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    channel_id BIGINT NOT NULL REFERENCES siem.notification_channels(id) ON DELETE CASCADE,
+    alert_id BIGINT REFERENCES siem.alerts(id) ON DELETE SET NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+    attempts INTEGER NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_channel ON siem.notification_deliveries(channel_id, created_at);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.notification_channels TO server_user;
+GRANT SELECT, INSERT, DELETE ON siem.notification_deliveries TO server_user;
+GRANT USAGE ON SEQUENCE siem.notification_channels_id_seq, siem.notification_deliveries_id_seq TO server_user;
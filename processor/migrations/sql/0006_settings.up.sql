@@ -0,0 +1,15 @@
+-- Dashboard settings. Every save appends a new version; the highest version
+-- is current. The server validates the document, the processor polls it and
+-- the collector receives it on the control topic.
+
+CREATE TABLE IF NOT EXISTS siem.settings (
+    version BIGSERIAL PRIMARY KEY,
+    settings JSONB NOT NULL,
+    changed_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+GRANT SELECT ON siem.settings TO processor_user;
+
+GRANT SELECT, INSERT ON siem.settings TO server_user;
+GRANT USAGE ON SEQUENCE siem.settings_version_seq TO server_user;
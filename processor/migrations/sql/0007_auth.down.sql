@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS siem.api_keys;
+DROP TABLE IF EXISTS siem.sessions;
+DROP TABLE IF EXISTS siem.users;
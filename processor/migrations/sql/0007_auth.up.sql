@@ -0,0 +1,47 @@
+-- Local users and the credentials the server accepts: login sessions for
+-- the dashboard and long-lived API keys for automation. Only hashes of
+-- session tokens and API keys are stored.
+
+CREATE TABLE IF NOT EXISTS siem.users (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    -- bcrypt hash
+    password_hash TEXT NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_login_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS siem.sessions (
+    -- SHA-256 of the session token
+    token_hash BYTEA PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES siem.users(id) ON DELETE CASCADE,
+    user_agent TEXT,
+    remote_addr TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON siem.sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON siem.sessions(expires_at);
+
+CREATE TABLE IF NOT EXISTS siem.api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES siem.users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- First characters of the key, shown so users can tell keys apart
+    prefix TEXT NOT NULL,
+    -- SHA-256 of the key
+    key_hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON siem.api_keys(user_id);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.users, siem.sessions, siem.api_keys TO server_user;
+GRANT USAGE ON SEQUENCE siem.users_id_seq, siem.api_keys_id_seq TO server_user;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS siem.audit_log;
+DROP FUNCTION IF EXISTS siem.audit_log_immutable();
+ALTER TABLE siem.users DROP COLUMN IF EXISTS role;
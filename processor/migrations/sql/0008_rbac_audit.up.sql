@@ -0,0 +1,44 @@
+-- Roles for API users and an append-only audit trail of every mutating API
+-- request.
+
+-- Users created before roles existed had full access, so they become admins.
+ALTER TABLE siem.users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'viewer'
+    CHECK (role IN ('viewer', 'analyst', 'admin'));
+UPDATE siem.users SET role = 'admin';
+
+CREATE TABLE IF NOT EXISTS siem.audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Kept as plain values so entries outlive deleted users
+    actor_id BIGINT,
+    actor TEXT,
+    role TEXT,
+    -- session, api_key or none (failed authentication, login attempts)
+    auth_method TEXT NOT NULL,
+    method TEXT NOT NULL,
+    -- Route template, e.g. /api/alerts/{id}
+    route TEXT NOT NULL,
+    path TEXT NOT NULL,
+    resource_id TEXT,
+    status INTEGER NOT NULL,
+    remote_addr TEXT,
+    user_agent TEXT,
+    details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON siem.audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON siem.audit_log(actor, created_at);
+
+CREATE OR REPLACE FUNCTION siem.audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'siem.audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_immutable ON siem.audit_log;
+CREATE TRIGGER audit_log_immutable
+    BEFORE UPDATE OR DELETE ON siem.audit_log
+    FOR EACH ROW EXECUTE FUNCTION siem.audit_log_immutable();
+
+GRANT SELECT, INSERT ON siem.audit_log TO server_user;
+GRANT USAGE ON SEQUENCE siem.audit_log_id_seq TO server_user;
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS siem.try_inet(TEXT);
@@ -0,0 +1,16 @@
+-- Helpers for the server's search language. IP addresses are stored as
+-- text, so CIDR matches cast them with try_inet, which yields NULL instead
+-- of failing on empty or malformed values.
+
+CREATE OR REPLACE FUNCTION siem.try_inet(addr TEXT) RETURNS INET AS $$
+BEGIN
+    IF addr IS NULL OR addr = '' THEN
+        RETURN NULL;
+    END IF;
+    RETURN addr::inet;
+EXCEPTION WHEN invalid_text_representation THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT;
+
+GRANT EXECUTE ON FUNCTION siem.try_inet(TEXT) TO server_user;
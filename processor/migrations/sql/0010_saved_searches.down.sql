@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS siem.search_reports;
+DROP TABLE IF EXISTS siem.saved_searches;
@@ -0,0 +1,60 @@
+-- Named searches in the server's query language, optionally run on a cron
+-- schedule to raise alerts or write reports.
+
+CREATE TABLE IF NOT EXISTS siem.saved_searches (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    target TEXT NOT NULL CHECK (target IN ('packets', 'logs', 'alerts')),
+    query TEXT NOT NULL,
+    owner_id BIGINT REFERENCES siem.users(id) ON DELETE SET NULL,
+    owner TEXT NOT NULL,
+    -- Visible to every user rather than only the owner and admins
+    shared BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- Five-field cron expression (UTC); NULL for searches that only run on
+    -- demand
+    schedule TEXT,
+    -- alert: raise an alert when more than threshold rows match.
+    -- report: write the matching rows to a file and notify channel_ids.
+    action TEXT CHECK (action IN ('alert', 'report')),
+    -- How far back each run looks, e.g. 1h or 7d
+    time_window TEXT NOT NULL DEFAULT '1h',
+    threshold INTEGER NOT NULL DEFAULT 0,
+    severity TEXT NOT NULL DEFAULT 'medium'
+        CHECK (severity IN ('low', 'medium', 'high', 'critical')),
+    report_format TEXT NOT NULL DEFAULT 'csv' CHECK (report_format IN ('csv', 'json')),
+    channel_ids BIGINT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_status TEXT,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_next_run ON siem.saved_searches(next_run_at) WHERE enabled;
+
+-- Report files written by scheduled searches; the files themselves live in
+-- the server's -reports-dir.
+CREATE TABLE IF NOT EXISTS siem.search_reports (
+    id BIGSERIAL PRIMARY KEY,
+    search_id BIGINT NOT NULL REFERENCES siem.saved_searches(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    range_from TIMESTAMP NOT NULL,
+    range_to TIMESTAMP NOT NULL,
+    format TEXT NOT NULL,
+    row_count INTEGER NOT NULL,
+    -- More rows matched than the report row limit
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    size_bytes BIGINT NOT NULL,
+    path TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_reports_search ON siem.search_reports(search_id, created_at);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.saved_searches TO server_user;
+GRANT SELECT, INSERT, DELETE ON siem.search_reports TO server_user;
+GRANT USAGE ON SEQUENCE siem.saved_searches_id_seq, siem.search_reports_id_seq TO server_user;
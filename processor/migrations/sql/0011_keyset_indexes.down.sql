@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS siem.idx_audit_log_created_at_id;
+DROP INDEX IF EXISTS siem.idx_incidents_last_seen_id;
+DROP INDEX IF EXISTS siem.idx_alerts_timestamp_id;
+DROP INDEX IF EXISTS siem.idx_log_data_timestamp_id;
+DROP INDEX IF EXISTS siem.idx_packet_data_timestamp_id;
@@ -0,0 +1,9 @@
+-- Indexes matching the (timestamp, id) order list endpoints page by, so a
+-- cursor seeks straight to the next page instead of scanning past earlier
+-- ones.
+
+CREATE INDEX IF NOT EXISTS idx_packet_data_timestamp_id ON siem.packet_data(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_log_data_timestamp_id ON siem.log_data(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_alerts_timestamp_id ON siem.alerts(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_incidents_last_seen_id ON siem.incidents(last_seen, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at_id ON siem.audit_log(created_at, id);
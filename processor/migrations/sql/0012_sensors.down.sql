@@ -0,0 +1,2 @@
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS sensor;
+ALTER TABLE siem.packet_data DROP COLUMN IF EXISTS sensor;
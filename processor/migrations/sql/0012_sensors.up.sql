@@ -0,0 +1,6 @@
+-- The collector a packet was captured by, so its raw bytes can be fetched
+-- from that sensor's capture buffer. Alerts raised from packets record it
+-- too.
+
+ALTER TABLE siem.packet_data ADD COLUMN IF NOT EXISTS sensor TEXT;
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS sensor TEXT;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS siem.alert_evidence;
@@ -0,0 +1,35 @@
+-- Raw traffic kept as evidence for alerts. The processor adds a row when it
+-- raises an alert with addresses; the server pins the alert's window in the
+-- sensor's capture buffer, copies the packets to the evidence store once
+-- the window has passed and records where they are.
+
+CREATE TABLE IF NOT EXISTS siem.alert_evidence (
+    alert_id BIGINT PRIMARY KEY REFERENCES siem.alerts(id) ON DELETE CASCADE,
+    sensor TEXT,
+    window_start TIMESTAMP NOT NULL,
+    window_end TIMESTAMP NOT NULL,
+    -- pending: waiting for the window to pass or being copied.
+    -- stored: copied to the evidence store.
+    -- unavailable: the sensor had no packets or none is configured.
+    -- failed: copying failed too often.
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'stored', 'unavailable', 'failed')),
+    -- Pin holding the window in the sensor's capture buffer
+    pin_id TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    -- When a server should next work on the row; claiming a row moves it
+    -- ahead so other servers leave it alone
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    error TEXT,
+    object_key TEXT,
+    packets INTEGER,
+    size_bytes BIGINT,
+    sha256 TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    stored_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_evidence_pending ON siem.alert_evidence(next_attempt_at) WHERE status = 'pending';
+
+GRANT INSERT ON siem.alert_evidence TO processor_user;
+GRANT SELECT, UPDATE ON siem.alert_evidence TO server_user;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS siem.tcp_sessions;
+DELETE FROM siem.retention_policy WHERE table_name = 'tcp_sessions';
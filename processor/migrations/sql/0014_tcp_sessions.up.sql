@@ -0,0 +1,43 @@
+-- TCP sessions reconstructed by collectors running with -tcp-sessions. One
+-- row per connection, written when it closes or goes idle.
+
+CREATE TABLE IF NOT EXISTS siem.tcp_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    sensor TEXT,
+    device_name TEXT NOT NULL,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    client_ip TEXT NOT NULL,
+    server_ip TEXT NOT NULL,
+    client_port INTEGER NOT NULL,
+    server_port INTEGER NOT NULL,
+    -- complete, incomplete, rejected or midstream
+    handshake TEXT NOT NULL,
+    rtt_ms DOUBLE PRECISION,
+    client_bytes BIGINT NOT NULL DEFAULT 0,
+    server_bytes BIGINT NOT NULL DEFAULT 0,
+    client_packets BIGINT NOT NULL DEFAULT 0,
+    server_packets BIGINT NOT NULL DEFAULT 0,
+    retransmissions INTEGER NOT NULL DEFAULT 0,
+    out_of_order INTEGER NOT NULL DEFAULT 0,
+    missing_bytes BIGINT NOT NULL DEFAULT 0,
+    -- fin, reset, timeout or shutdown
+    close_reason TEXT NOT NULL,
+    app_protocol TEXT,
+    -- Protocol details from the stream parsers, e.g. http_host, tls_sni,
+    -- smtp_mail_from
+    app_details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_tcp_sessions_start ON siem.tcp_sessions(start_time);
+CREATE INDEX IF NOT EXISTS idx_tcp_sessions_client ON siem.tcp_sessions(client_ip, start_time);
+CREATE INDEX IF NOT EXISTS idx_tcp_sessions_server ON siem.tcp_sessions(server_ip, server_port, start_time);
+
+INSERT INTO siem.retention_policy (table_name, retention_days)
+VALUES ('tcp_sessions', 30)
+ON CONFLICT (table_name) DO NOTHING;
+
+GRANT SELECT, INSERT, DELETE ON siem.tcp_sessions TO processor_user;
+GRANT USAGE ON SEQUENCE siem.tcp_sessions_id_seq TO processor_user;
+GRANT SELECT ON siem.tcp_sessions TO server_user;
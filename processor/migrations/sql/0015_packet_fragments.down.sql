@@ -0,0 +1,3 @@
+ALTER TABLE siem.packet_data DROP COLUMN IF EXISTS fragment_anomaly;
+ALTER TABLE siem.packet_data DROP COLUMN IF EXISTS fragments;
+ALTER TABLE siem.packet_data DROP COLUMN IF EXISTS is_fragment;
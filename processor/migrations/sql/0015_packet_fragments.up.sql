@@ -0,0 +1,8 @@
+-- Collectors reassemble fragmented datagrams. Fragments are stored as
+-- before and flagged; the reassembled datagram follows them with the
+-- number of fragments it was built from. Anomalies found in a fragment
+-- (overlap, tiny-first, oversized) are recorded on it.
+
+ALTER TABLE siem.packet_data ADD COLUMN IF NOT EXISTS is_fragment BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE siem.packet_data ADD COLUMN IF NOT EXISTS fragments INTEGER;
+ALTER TABLE siem.packet_data ADD COLUMN IF NOT EXISTS fragment_anomaly TEXT;
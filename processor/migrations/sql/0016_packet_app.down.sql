@@ -0,0 +1 @@
+ALTER TABLE siem.packet_data DROP COLUMN IF EXISTS app;
@@ -0,0 +1,5 @@
+-- Collectors decode more protocols (ARP, DHCP, NDP, NTP, SSH, SMB, QUIC,
+-- mDNS, LLMNR, SNMP) than packet_data has columns for. Their fields are
+-- stored by protocol name in app, e.g. {"dhcp": {"hostname": "laptop"}}.
+
+ALTER TABLE siem.packet_data ADD COLUMN IF NOT EXISTS app JSONB;
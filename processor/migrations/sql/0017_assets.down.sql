@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS siem.asset_history;
+DROP TABLE IF EXISTS siem.assets;
+DELETE FROM siem.retention_policy WHERE table_name = 'assets';
@@ -0,0 +1,48 @@
+-- Passive asset inventory built by the processor from the traffic of
+-- internal hosts: one row per address with what was learned about it, and
+-- a history of when each field changed.
+
+CREATE TABLE IF NOT EXISTS siem.assets (
+    id BIGSERIAL PRIMARY KEY,
+    ip TEXT NOT NULL UNIQUE,
+    mac TEXT,
+    -- From the OUI of the MAC address
+    vendor TEXT,
+    hostname TEXT,
+    -- dhcp, mdns or dns
+    hostname_source TEXT,
+    os TEXT,
+    -- dhcp (vendor class) or tcp (TTL and window size of SYNs)
+    os_source TEXT,
+    -- Open ports seen answering a SYN, e.g. tcp/22
+    services TEXT[] NOT NULL DEFAULT '{}',
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    sensor TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_assets_last_seen ON siem.assets(last_seen);
+CREATE INDEX IF NOT EXISTS idx_assets_mac ON siem.assets(mac);
+
+CREATE TABLE IF NOT EXISTS siem.asset_history (
+    id BIGSERIAL PRIMARY KEY,
+    asset_id BIGINT NOT NULL REFERENCES siem.assets(id) ON DELETE CASCADE,
+    timestamp TIMESTAMP NOT NULL,
+    -- ip when the asset was first seen, mac, vendor, hostname, os, or
+    -- service for a newly seen open port
+    field TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_asset_history_asset ON siem.asset_history(asset_id, timestamp);
+
+INSERT INTO siem.retention_policy (table_name, retention_days)
+VALUES ('assets', 90)
+ON CONFLICT (table_name) DO NOTHING;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.assets TO processor_user;
+GRANT USAGE ON SEQUENCE siem.assets_id_seq TO processor_user;
+GRANT SELECT, INSERT ON siem.asset_history TO processor_user;
+GRANT USAGE ON SEQUENCE siem.asset_history_id_seq TO processor_user;
+GRANT SELECT ON siem.assets, siem.asset_history TO server_user;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS siem.idx_alerts_last_seen;
+DROP TABLE IF EXISTS siem.host_risk;
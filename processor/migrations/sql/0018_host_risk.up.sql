@@ -0,0 +1,25 @@
+-- Risk scores the processor computes for hosts involved in recent alerts,
+-- traffic flagged as malicious or anomalous traffic volume. The table is
+-- rewritten every -risk-interval; hosts whose score decayed away are
+-- removed.
+
+CREATE TABLE IF NOT EXISTS siem.host_risk (
+    ip TEXT PRIMARY KEY,
+    -- 0 to 100, from the sum of the components below
+    score DOUBLE PRECISION NOT NULL,
+    alert_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    indicator_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    anomaly_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    -- Alerts still counted into the score, flagged packets and traffic
+    -- anomalies seen since the host was last unscored
+    alerts INTEGER NOT NULL DEFAULT 0,
+    indicator_hits BIGINT NOT NULL DEFAULT 0,
+    anomalies INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_risk_score ON siem.host_risk(score);
+CREATE INDEX IF NOT EXISTS idx_alerts_last_seen ON siem.alerts(last_seen);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON siem.host_risk TO processor_user;
+GRANT SELECT ON siem.host_risk TO server_user;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS siem.idx_alerts_unnotified;
+ALTER TABLE siem.alerts DROP COLUMN IF EXISTS notified_at;
@@ -0,0 +1,7 @@
+-- The server records when it picked up each alert for notification, so
+-- alerts raised while it is down are sent when it starts and several
+-- instances do not send the same alert. Existing alerts count as sent.
+
+ALTER TABLE siem.alerts ADD COLUMN IF NOT EXISTS notified_at TIMESTAMP;
+UPDATE siem.alerts SET notified_at = created_at WHERE notified_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_alerts_unnotified ON siem.alerts(id) WHERE notified_at IS NULL;
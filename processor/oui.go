@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+)
+
+// builtinOUIs names the vendors of virtual and single-board machines,
+// which are common on lab and server networks. Load a full table with
+// -oui-file for everything else; the processor image does so by default.
+var builtinOUIs = map[string]string{
+	"000569": "VMware",
+	"000c29": "VMware",
+	"001c14": "VMware",
+	"005056": "VMware",
+	"080027": "Oracle VirtualBox",
+	"001c42": "Parallels",
+	"00155d": "Microsoft Hyper-V",
+	"00163e": "Xen",
+	"525400": "QEMU/KVM",
+	"b827eb": "Raspberry Pi",
+	"dca632": "Raspberry Pi",
+	"e45f01": "Raspberry Pi",
+}
+
+// ouiTable maps the first three bytes of a MAC address, as six lowercase
+// hex digits, to the vendor they are registered to.
+type ouiTable map[string]string
+
+// loadOUIs returns the built-in vendors plus those in path, which is
+// either the IEEE MA-L registry in CSV (oui.csv) or a Wireshark manuf
+// file. Entries of the file take precedence.
+func loadOUIs(path string) (ouiTable, error) {
+	table := make(ouiTable, len(builtinOUIs))
+	for prefix, vendor := range builtinOUIs {
+		table[prefix] = vendor
+	}
+	if path == "" {
+		return table, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".csv") {
+		err = readIEEEOUIs(f, table)
+	} else {
+		err = readManufOUIs(f, table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// readIEEEOUIs reads rows of Registry,Assignment,Organization Name,...
+func readIEEEOUIs(r io.Reader, table ouiTable) error {
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+	for {
+		rec, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) >= 3 && rec[0] == "MA-L" && len(rec[1]) == 6 {
+			table[strings.ToLower(rec[1])] = strings.TrimSpace(rec[2])
+		}
+	}
+}
+
+// readManufOUIs reads lines of prefix, short name and optionally the long
+// name, separated by tabs. Prefixes longer than 24 bits are skipped.
+func readManufOUIs(r io.Reader, table ouiTable) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || strings.Contains(fields[0], "/") {
+			continue
+		}
+		prefix := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(fields[0]))
+		if len(prefix) != 6 {
+			continue
+		}
+		vendor := fields[1]
+		if len(fields) > 2 && fields[2] != "" {
+			vendor = fields[2]
+		}
+		table[prefix] = strings.TrimSpace(vendor)
+	}
+	return scanner.Err()
+}
+
+// vendor returns the vendor of mac, written as colon-separated hex.
+// Locally administered addresses, such as the random addresses of phones,
+// have none.
+func (t ouiTable) vendor(mac string) string {
+	prefix := strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+	if len(prefix) < 6 {
+		return ""
+	}
+	prefix = prefix[:6]
+	if b := prefix[1]; strings.IndexByte("2367abef", b) >= 0 {
+		return ""
+	}
+	return t[prefix]
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const partitionNameLayout = "20060102"
+
+// runPartitionMaintenance keeps siem.packet_data's daily partitions in
+// shape: partitions for the next few days always exist before packets
+// arrive for them, and partitions entirely older than the retention window
+// are dropped. Expired TCP sessions are pruned along with them. It runs
+// once at startup and then on every tick.
+func runPartitionMaintenance(ctx context.Context, dbPool *pgxpool.Pool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	maintain := func() {
+		if err := ensurePacketPartitions(ctx, dbPool, time.Now().UTC(), *partitionsAhead); err != nil {
+			log.Printf("[Partitions] Error creating partitions: %v", err)
+		}
+
+		retentionDays, err := packetRetentionDays(ctx, dbPool)
+		if err != nil {
+			log.Printf("[Partitions] Error reading retention policy, using %d days: %v", *defaultRetention, err)
+			retentionDays = *defaultRetention
+		}
+
+		if err := dropExpiredPacketPartitions(ctx, dbPool, time.Now().UTC(), retentionDays); err != nil {
+			log.Printf("[Partitions] Error dropping expired partitions: %v", err)
+		}
+
+		if err := pruneSessions(ctx, dbPool, time.Now().UTC()); err != nil {
+			log.Printf("[Partitions] Error pruning TCP sessions: %v", err)
+		}
+	}
+
+	maintain()
+
+	ticker := time.NewTicker(*partitionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			maintain()
+		}
+	}
+}
+
+func packetPartitionName(day time.Time) string {
+	return "packet_data_p" + day.Format(partitionNameLayout)
+}
+
+// ensurePacketPartitions creates the partitions for today and the
+// following `ahead` days if they do not exist yet. A day that fails is
+// reported and the later days are still created.
+func ensurePacketPartitions(ctx context.Context, dbPool *pgxpool.Pool, now time.Time, ahead int) error {
+	today := now.Truncate(24 * time.Hour)
+
+	var errs []error
+	for i := 0; i <= ahead; i++ {
+		from := today.AddDate(0, 0, i)
+		if err := createPacketPartition(ctx, dbPool, from); err != nil {
+			errs = append(errs, fmt.Errorf("create partition %s: %w", packetPartitionName(from), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createPacketPartition creates the partition of day. Postgres refuses to
+// create a partition while the default partition holds rows in its range,
+// e.g. packets from a sensor with a clock running ahead, so those rows are
+// first moved into a standalone table that is then attached.
+func createPacketPartition(ctx context.Context, dbPool *pgxpool.Pool, day time.Time) error {
+	name := pgx.Identifier{"siem", packetPartitionName(day)}.Sanitize()
+	from, to := day.Format("2006-01-02"), day.AddDate(0, 0, 1).Format("2006-01-02")
+
+	var exists, conflicting bool
+	err := dbPool.QueryRow(ctx, fmt.Sprintf(`
+		SELECT to_regclass('%[1]s') IS NOT NULL,
+			EXISTS (SELECT 1 FROM siem.packet_data_default
+				WHERE timestamp >= '%[2]s' AND timestamp < '%[3]s')
+	`, name, from, to)).Scan(&exists, &conflicting)
+	if err != nil || exists {
+		return err
+	}
+
+	if !conflicting {
+		_, err := dbPool.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF siem.packet_data FOR VALUES FROM ('%s') TO ('%s')`,
+			name, from, to,
+		))
+		return err
+	}
+
+	var moved int64
+	err = pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE %s (LIKE siem.packet_data INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`, name,
+		)); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, fmt.Sprintf(`
+			WITH moved AS (
+				DELETE FROM siem.packet_data_default
+				WHERE timestamp >= '%[2]s' AND timestamp < '%[3]s'
+				RETURNING *
+			)
+			INSERT INTO %[1]s SELECT * FROM moved
+		`, name, from, to))
+		if err != nil {
+			return err
+		}
+		moved = tag.RowsAffected()
+		_, err = tx.Exec(ctx, fmt.Sprintf(
+			`ALTER TABLE siem.packet_data ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+			name, from, to,
+		))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("[Partitions] Created partition siem.%s, moving %d rows out of the default partition", packetPartitionName(day), moved)
+	return nil
+}
+
+// dropExpiredPacketPartitions drops every daily partition whose upper bound
+// is older than now minus retentionDays, and deletes the rows older than
+// that from the default partition.
+func dropExpiredPacketPartitions(ctx context.Context, dbPool *pgxpool.Pool, now time.Time, retentionDays int) error {
+	if retentionDays <= 0 {
+		return fmt.Errorf("invalid retention of %d days", retentionDays)
+	}
+
+	partitions, err := listPacketPartitions(ctx, dbPool)
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Truncate(24*time.Hour).AddDate(0, 0, -retentionDays)
+
+	for _, name := range partitions {
+		day, err := time.Parse(partitionNameLayout, strings.TrimPrefix(name, "packet_data_p"))
+		if err != nil {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+
+		query := fmt.Sprintf(`DROP TABLE IF EXISTS siem.%s`, pgx.Identifier{name}.Sanitize())
+		if _, err := dbPool.Exec(ctx, query); err != nil {
+			return fmt.Errorf("drop partition %s: %w", name, err)
+		}
+		log.Printf("[Partitions] Dropped expired partition siem.%s", name)
+	}
+
+	tag, err := dbPool.Exec(ctx, `DELETE FROM siem.packet_data_default WHERE timestamp < $1`, cutoff)
+	if err != nil {
+		return fmt.Errorf("prune default partition: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		log.Printf("[Partitions] Deleted %d expired rows from siem.packet_data_default", tag.RowsAffected())
+	}
+	return nil
+}
+
+func listPacketPartitions(ctx context.Context, dbPool *pgxpool.Pool) ([]string, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON pg_inherits.inhparent = parent.oid
+		JOIN pg_class child ON pg_inherits.inhrelid = child.oid
+		JOIN pg_namespace ns ON parent.relnamespace = ns.oid
+		WHERE ns.nspname = 'siem' AND parent.relname = 'packet_data'
+			AND child.relname LIKE 'packet\_data\_p%'
+		ORDER BY child.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func packetRetentionDays(ctx context.Context, dbPool *pgxpool.Pool) (int, error) {
+	var days int
+	err := dbPool.QueryRow(ctx,
+		`SELECT retention_days FROM siem.retention_policy WHERE table_name = 'packet_data'`,
+	).Scan(&days)
+	if err != nil {
+		return 0, err
+	}
+	return days, nil
+}
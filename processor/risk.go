@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A host's risk is the sum of points from the alerts it was involved in,
+// the traffic it exchanged that the collector flagged as malicious
+// (indicator hits), and surges of its traffic above its own baseline.
+// Points halve every -risk-half-life, and the sum is mapped onto a score
+// from 0 to 100.
+var severityRisk = map[string]float64{"low": 1, "medium": 4, "high": 10, "critical": 25}
+
+const (
+	maxRiskHosts = 65536
+
+	// riskScale is the sum of points at which a host scores 63; the score
+	// approaches 100 as points grow.
+	riskScale = 50
+	// Hosts whose points decayed below riskFloor are dropped.
+	riskFloor = 0.5
+	// Alerts older than riskHorizon half-lives add less than 1%.
+	riskHorizon = 7
+	// The destination of an alert gets this share of its points.
+	alertTargetShare = 0.25
+
+	// indicatorRisk is added for every interval in which a host exchanged
+	// flagged traffic.
+	indicatorRisk = 10
+	// anomalyRisk is added per doubling of an internal host's traffic in
+	// an interval over anomalyFactor times its baseline, an exponentially
+	// weighted average trusted after anomalyWarmup intervals.
+	anomalyRisk     = 5
+	anomalyFactor   = 3
+	anomalyMinBytes = 1 << 20
+	anomalyWarmup   = 30
+	anomalyAlpha    = 0.1
+)
+
+// hostRisk is what the scorer tracks for a host between intervals.
+type hostRisk struct {
+	internal bool
+	riskPoints
+
+	// Traffic of the current interval, and the internal host's baseline.
+	bytes    int64
+	flagged  int64
+	baseline float64
+	samples  int
+}
+
+type riskPoints struct {
+	indicator     float64
+	anomaly       float64
+	indicatorHits int64
+	anomalies     int64
+}
+
+// RiskScorer accumulates indicator hits and traffic volume per host from
+// the network workers, and periodically writes every host's score to
+// siem.host_risk. Alert points are read from siem.alerts when scoring, so
+// suppressed alerts and alerts marked as false positives do not count.
+type RiskScorer struct {
+	mu       sync.Mutex
+	hosts    map[string]*hostRisk
+	halfLife time.Duration
+	scored   time.Time
+}
+
+func NewRiskScorer(halfLife time.Duration) *RiskScorer {
+	return &RiskScorer{hosts: make(map[string]*hostRisk), halfLife: halfLife}
+}
+
+// host returns the state of ip, or nil when the scorer is full. The caller
+// must hold s.mu.
+func (s *RiskScorer) host(ip string) *hostRisk {
+	h, ok := s.hosts[ip]
+	if !ok {
+		if len(s.hosts) >= maxRiskHosts {
+			return nil
+		}
+		h = &hostRisk{internal: isInternalIP(ip)}
+		s.hosts[ip] = h
+	}
+	return h
+}
+
+func (s *RiskScorer) Observe(p *PacketData) {
+	// Reassembled datagrams are already counted by their fragments.
+	if p.Fragments > 0 {
+		return
+	}
+	flagged := p.IsMalicious || p.ThreatType != ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ip := range []string{p.SrcIP, p.DstIP} {
+		// Only internal hosts have baselines; others are tracked once
+		// they exchange flagged traffic.
+		if ip == "" || !flagged && !isInternalIP(ip) {
+			continue
+		}
+		h := s.host(ip)
+		if h == nil {
+			continue
+		}
+		if h.internal {
+			h.bytes += int64(p.PayloadSize)
+		}
+		if flagged {
+			h.flagged++
+		}
+	}
+}
+
+// decay returns the factor points shrink by over d.
+func (s *RiskScorer) decay(d time.Duration) float64 {
+	if d <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(d)/float64(s.halfLife))
+}
+
+// advance closes the interval ending at now: it decays every host's
+// points, adds those earned in the interval and updates the baselines. It
+// returns the points of the hosts that still have any.
+func (s *RiskScorer) advance(now time.Time) map[string]riskPoints {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	decay := 1.0
+	if !s.scored.IsZero() {
+		decay = s.decay(now.Sub(s.scored))
+	}
+	s.scored = now
+
+	points := make(map[string]riskPoints)
+	for ip, h := range s.hosts {
+		h.indicator *= decay
+		h.anomaly *= decay
+
+		if h.flagged > 0 {
+			h.indicator += indicatorRisk
+			h.indicatorHits += h.flagged
+		}
+
+		if h.internal {
+			bytes := float64(h.bytes)
+			if h.samples >= anomalyWarmup && h.bytes >= anomalyMinBytes && bytes > anomalyFactor*h.baseline {
+				h.anomaly += anomalyRisk * math.Log2(bytes/math.Max(h.baseline, 1))
+				h.anomalies++
+			}
+			if h.samples == 0 {
+				h.baseline = bytes
+			} else {
+				h.baseline = anomalyAlpha*bytes + (1-anomalyAlpha)*h.baseline
+			}
+			h.samples++
+		}
+
+		active := h.indicator+h.anomaly >= riskFloor
+		if !active && h.bytes == 0 && h.baseline < 1 {
+			delete(s.hosts, ip)
+			continue
+		}
+		h.bytes, h.flagged = 0, 0
+
+		if active {
+			points[ip] = h.riskPoints
+		} else {
+			h.riskPoints = riskPoints{}
+		}
+	}
+	return points
+}
+
+type alertRisk struct {
+	points float64
+	alerts int
+}
+
+// alertPoints sums the points of the alerts each host was involved in.
+// Repeats of an alert add the logarithm of their number, so a noisy rule
+// does not drown out everything else.
+func (s *RiskScorer) alertPoints(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) (map[string]*alertRisk, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT ip, target, severity, date_trunc('hour', last_seen) AS hour,
+			SUM(1 + ln(occurrences)), COUNT(*)
+		FROM (
+			SELECT src_ip AS ip, false AS target, severity, last_seen, occurrences
+			FROM siem.alerts
+			WHERE last_seen >= $1 AND suppressed_by IS NULL AND status <> 'false-positive'
+			UNION ALL
+			SELECT dst_ip, true, severity, last_seen, occurrences
+			FROM siem.alerts
+			WHERE last_seen >= $1 AND suppressed_by IS NULL AND status <> 'false-positive'
+		) a
+		WHERE ip IS NOT NULL AND ip <> ''
+		GROUP BY ip, target, severity, hour
+	`, now.Add(-riskHorizon*s.halfLife))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	risks := make(map[string]*alertRisk)
+	for rows.Next() {
+		var (
+			ip, severity string
+			target       bool
+			hour         time.Time
+			weight       float64
+			count        int
+		)
+		if err := rows.Scan(&ip, &target, &severity, &hour, &weight, &count); err != nil {
+			return nil, err
+		}
+
+		points := severityRisk[severity] * weight * s.decay(now.Sub(hour))
+		if target {
+			points *= alertTargetShare
+		}
+		r, ok := risks[ip]
+		if !ok {
+			r = &alertRisk{}
+			risks[ip] = r
+		}
+		r.points += points
+		r.alerts += count
+	}
+	return risks, rows.Err()
+}
+
+const hostRiskUpsertQuery = `
+	INSERT INTO siem.host_risk (
+		ip, score, alert_score, indicator_score, anomaly_score,
+		alerts, indicator_hits, anomalies, updated_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (ip) DO UPDATE SET
+		score = EXCLUDED.score,
+		alert_score = EXCLUDED.alert_score,
+		indicator_score = EXCLUDED.indicator_score,
+		anomaly_score = EXCLUDED.anomaly_score,
+		alerts = EXCLUDED.alerts,
+		indicator_hits = EXCLUDED.indicator_hits,
+		anomalies = EXCLUDED.anomalies,
+		updated_at = EXCLUDED.updated_at
+`
+
+// riskScore maps a sum of points onto 0 to 100, to one decimal.
+func riskScore(points float64) float64 {
+	return math.Round(1000*(1-math.Exp(-points/riskScale))) / 10
+}
+
+// Score closes the current interval and rewrites siem.host_risk with the
+// score of every host that has points left.
+func (s *RiskScorer) Score(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) error {
+	points := s.advance(now)
+	alerts, err := s.alertPoints(ctx, dbPool, now)
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	queue := func(ip string, a alertRisk, p riskPoints) {
+		total := a.points + p.indicator + p.anomaly
+		if total < riskFloor {
+			return
+		}
+		batch.Queue(hostRiskUpsertQuery,
+			ip, riskScore(total), a.points, p.indicator, p.anomaly,
+			a.alerts, p.indicatorHits, p.anomalies, now)
+	}
+	for ip, a := range alerts {
+		queue(ip, *a, points[ip])
+	}
+	for ip, p := range points {
+		if alerts[ip] == nil {
+			queue(ip, alertRisk{}, p)
+		}
+	}
+	batch.Queue(`DELETE FROM siem.host_risk WHERE updated_at < $1`, now)
+
+	return pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Load restores the indicator and anomaly points of the last run, decayed
+// to now. Traffic baselines start over.
+func (s *RiskScorer) Load(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) error {
+	rows, err := dbPool.Query(ctx, `
+		SELECT ip, indicator_score, anomaly_score, indicator_hits, anomalies, updated_at
+		FROM siem.host_risk
+		WHERE indicator_score > 0 OR anomaly_score > 0
+		ORDER BY score DESC
+		LIMIT $1
+	`, maxRiskHosts)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for rows.Next() {
+		var (
+			ip      string
+			p       riskPoints
+			updated time.Time
+		)
+		if err := rows.Scan(&ip, &p.indicator, &p.anomaly, &p.indicatorHits, &p.anomalies, &updated); err != nil {
+			return err
+		}
+		decay := s.decay(now.Sub(updated))
+		p.indicator *= decay
+		p.anomaly *= decay
+		s.hosts[ip] = &hostRisk{internal: isInternalIP(ip), riskPoints: p}
+	}
+	s.scored = now
+	return rows.Err()
+}
+
+// runRiskScorer rescores every host each -risk-interval.
+func runRiskScorer(ctx context.Context, dbPool *pgxpool.Pool, scorer *RiskScorer, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(*riskInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := scorer.Score(ctx, dbPool, time.Now().UTC()); err != nil && ctx.Err() == nil {
+				log.Printf("[Risk] Error scoring hosts: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// rollupResolutions are the bucket sizes maintained for every rollup
+// table. The names match the resolution column.
+var rollupResolutions = []struct {
+	name string
+	size time.Duration
+}{
+	{"1m", time.Minute},
+	{"1h", time.Hour},
+}
+
+type rollupBucket struct {
+	resolution string
+	bucket     time.Time
+}
+
+type trafficAgg struct {
+	packets   int64
+	bytes     int64
+	malicious int64
+	srcIPs    hllSketch
+	dstIPs    hllSketch
+}
+
+type counterAgg struct {
+	packets int64
+	bytes   int64
+}
+
+type protocolKey struct {
+	rollupBucket
+	protocol string
+}
+
+type hostKey struct {
+	rollupBucket
+	ip        string
+	direction string
+}
+
+type portKey struct {
+	rollupBucket
+	protocol string
+	port     uint16
+}
+
+// Rollup accumulates packet counters in memory between flushes. It is
+// shared by all network workers, so every method takes the lock.
+type Rollup struct {
+	mu        sync.Mutex
+	traffic   map[rollupBucket]*trafficAgg
+	protocols map[protocolKey]*counterAgg
+	hosts     map[hostKey]*counterAgg
+	ports     map[portKey]*counterAgg
+}
+
+func NewRollup() *Rollup {
+	r := &Rollup{}
+	r.reset()
+	return r
+}
+
+func (r *Rollup) reset() {
+	r.traffic = make(map[rollupBucket]*trafficAgg)
+	r.protocols = make(map[protocolKey]*counterAgg)
+	r.hosts = make(map[hostKey]*counterAgg)
+	r.ports = make(map[portKey]*counterAgg)
+}
+
+func (r *Rollup) Add(packet *PacketData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bytes := int64(packet.PayloadSize)
+
+	for _, res := range rollupResolutions {
+		b := rollupBucket{resolution: res.name, bucket: packet.Timestamp.UTC().Truncate(res.size)}
+
+		t, ok := r.traffic[b]
+		if !ok {
+			t = &trafficAgg{srcIPs: newHLLSketch(), dstIPs: newHLLSketch()}
+			r.traffic[b] = t
+		}
+		t.packets++
+		t.bytes += bytes
+		if packet.IsMalicious {
+			t.malicious++
+		}
+		if packet.SrcIP != "" {
+			t.srcIPs.Add(packet.SrcIP)
+		}
+		if packet.DstIP != "" {
+			t.dstIPs.Add(packet.DstIP)
+		}
+
+		addCounter(r.protocols, protocolKey{b, packet.Protocol}, bytes)
+
+		if packet.SrcIP != "" {
+			addCounter(r.hosts, hostKey{b, packet.SrcIP, "src"}, bytes)
+		}
+		if packet.DstIP != "" {
+			addCounter(r.hosts, hostKey{b, packet.DstIP, "dst"}, bytes)
+		}
+
+		if packet.DstPort != 0 {
+			addCounter(r.ports, portKey{b, packet.Protocol, packet.DstPort}, bytes)
+		}
+	}
+}
+
+func addCounter[K comparable](m map[K]*counterAgg, key K, bytes int64) {
+	c, ok := m[key]
+	if !ok {
+		c = &counterAgg{}
+		m[key] = c
+	}
+	c.packets++
+	c.bytes += bytes
+}
+
+// Flush writes the accumulated counters to Postgres in one transaction,
+// adding to any existing rows for the same buckets. On failure the
+// counters are merged back so the next flush retries them.
+func (r *Rollup) Flush(ctx context.Context, dbPool *pgxpool.Pool) error {
+	r.mu.Lock()
+	traffic, protocols, hosts, ports := r.traffic, r.protocols, r.hosts, r.ports
+	r.reset()
+	r.mu.Unlock()
+
+	if len(traffic) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+
+	for b, t := range traffic {
+		batch.Queue(`
+			INSERT INTO siem.traffic_rollup (
+				resolution, bucket, packet_count, total_bytes, malicious_packets, src_ip_sketch, dst_ip_sketch
+			) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (resolution, bucket) DO UPDATE SET
+				packet_count = siem.traffic_rollup.packet_count + EXCLUDED.packet_count,
+				total_bytes = siem.traffic_rollup.total_bytes + EXCLUDED.total_bytes,
+				malicious_packets = siem.traffic_rollup.malicious_packets + EXCLUDED.malicious_packets,
+				src_ip_sketch = siem.hll_merge(siem.traffic_rollup.src_ip_sketch, EXCLUDED.src_ip_sketch),
+				dst_ip_sketch = siem.hll_merge(siem.traffic_rollup.dst_ip_sketch, EXCLUDED.dst_ip_sketch)
+		`, b.resolution, b.bucket, t.packets, t.bytes, t.malicious, []byte(t.srcIPs), []byte(t.dstIPs))
+	}
+
+	for k, c := range protocols {
+		batch.Queue(`
+			INSERT INTO siem.protocol_rollup (resolution, bucket, protocol, packet_count, total_bytes)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (resolution, bucket, protocol) DO UPDATE SET
+				packet_count = siem.protocol_rollup.packet_count + EXCLUDED.packet_count,
+				total_bytes = siem.protocol_rollup.total_bytes + EXCLUDED.total_bytes
+		`, k.resolution, k.bucket, k.protocol, c.packets, c.bytes)
+	}
+
+	for k, c := range hosts {
+		batch.Queue(`
+			INSERT INTO siem.host_rollup (resolution, bucket, ip, direction, packet_count, total_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (resolution, bucket, ip, direction) DO UPDATE SET
+				packet_count = siem.host_rollup.packet_count + EXCLUDED.packet_count,
+				total_bytes = siem.host_rollup.total_bytes + EXCLUDED.total_bytes
+		`, k.resolution, k.bucket, k.ip, k.direction, c.packets, c.bytes)
+	}
+
+	for k, c := range ports {
+		batch.Queue(`
+			INSERT INTO siem.port_rollup (resolution, bucket, protocol, port, packet_count, total_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (resolution, bucket, protocol, port) DO UPDATE SET
+				packet_count = siem.port_rollup.packet_count + EXCLUDED.packet_count,
+				total_bytes = siem.port_rollup.total_bytes + EXCLUDED.total_bytes
+		`, k.resolution, k.bucket, k.protocol, int(k.port), c.packets, c.bytes)
+	}
+
+	err := pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		r.restore(traffic, protocols, hosts, ports)
+		return err
+	}
+	return nil
+}
+
+func (r *Rollup) restore(traffic map[rollupBucket]*trafficAgg, protocols map[protocolKey]*counterAgg, hosts map[hostKey]*counterAgg, ports map[portKey]*counterAgg) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for b, t := range traffic {
+		if cur, ok := r.traffic[b]; ok {
+			cur.packets += t.packets
+			cur.bytes += t.bytes
+			cur.malicious += t.malicious
+			cur.srcIPs.Merge(t.srcIPs)
+			cur.dstIPs.Merge(t.dstIPs)
+		} else {
+			r.traffic[b] = t
+		}
+	}
+	mergeCounters(r.protocols, protocols)
+	mergeCounters(r.hosts, hosts)
+	mergeCounters(r.ports, ports)
+}
+
+func mergeCounters[K comparable](dst, src map[K]*counterAgg) {
+	for k, c := range src {
+		if cur, ok := dst[k]; ok {
+			cur.packets += c.packets
+			cur.bytes += c.bytes
+		} else {
+			dst[k] = c
+		}
+	}
+}
+
+// runRollupFlusher periodically flushes the rollup and prunes buckets
+// older than the rollup retention policies.
+func runRollupFlusher(ctx context.Context, dbPool *pgxpool.Pool, rollup *Rollup, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	flushTicker := time.NewTicker(*rollupInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(*partitionInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Final flush with a fresh context so counters collected since
+			// the last tick are not lost on shutdown.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := rollup.Flush(flushCtx, dbPool); err != nil {
+				log.Printf("[Rollups] Error flushing rollups on shutdown: %v", err)
+			}
+			cancel()
+			return
+		case <-flushTicker.C:
+			if err := rollup.Flush(ctx, dbPool); err != nil {
+				log.Printf("[Rollups] Error flushing rollups: %v", err)
+			}
+		case <-pruneTicker.C:
+			if err := pruneRollups(ctx, dbPool, time.Now().UTC()); err != nil {
+				log.Printf("[Rollups] Error pruning rollups: %v", err)
+			}
+		}
+	}
+}
+
+func pruneRollups(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) error {
+	for _, res := range rollupResolutions {
+		var days int
+		err := dbPool.QueryRow(ctx,
+			`SELECT retention_days FROM siem.retention_policy WHERE table_name = $1`,
+			"rollup_"+res.name,
+		).Scan(&days)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		cutoff := now.AddDate(0, 0, -days)
+		for _, table := range []string{"traffic_rollup", "protocol_rollup", "host_rollup", "port_rollup"} {
+			query := fmt.Sprintf(`DELETE FROM siem.%s WHERE resolution = $1 AND bucket < $2`, table)
+			if _, err := dbPool.Exec(ctx, query, res.name, cutoff); err != nil {
+				return fmt.Errorf("prune %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
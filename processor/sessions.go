@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/segmentio/kafka-go"
+)
+
+// TCPSession is the message format collectors publish on the session
+// topic, one per reconstructed TCP connection.
+type TCPSession struct {
+	Sensor     string    `json:"sensor,omitempty"`
+	DeviceName string    `json:"device_name"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	DurationMs int64     `json:"duration_ms"`
+
+	ClientIP   string `json:"client_ip"`
+	ServerIP   string `json:"server_ip"`
+	ClientPort uint16 `json:"client_port"`
+	ServerPort uint16 `json:"server_port"`
+
+	Handshake string  `json:"handshake"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+
+	ClientBytes     int64  `json:"client_bytes"`
+	ServerBytes     int64  `json:"server_bytes"`
+	ClientPackets   int64  `json:"client_packets"`
+	ServerPackets   int64  `json:"server_packets"`
+	Retransmissions int    `json:"retransmissions"`
+	OutOfOrder      int    `json:"out_of_order"`
+	MissingBytes    int64  `json:"missing_bytes"`
+	CloseReason     string `json:"close_reason"`
+
+	AppProtocol string `json:"app_protocol,omitempty"`
+	SessionApp
+}
+
+// SessionApp holds what the collector's stream parsers found. It is stored
+// as the session's app_details.
+type SessionApp struct {
+	HTTPMethod    string   `json:"http_method,omitempty"`
+	HTTPHost      string   `json:"http_host,omitempty"`
+	HTTPPath      string   `json:"http_path,omitempty"`
+	HTTPUserAgent string   `json:"http_user_agent,omitempty"`
+	HTTPStatus    int      `json:"http_status,omitempty"`
+	TLSVersion    string   `json:"tls_version,omitempty"`
+	TLSSNI        string   `json:"tls_sni,omitempty"`
+	TLSALPN       []string `json:"tls_alpn,omitempty"`
+	TLSJA3        string   `json:"tls_ja3,omitempty"`
+	SMTPHelo      string   `json:"smtp_helo,omitempty"`
+	SMTPMailFrom  string   `json:"smtp_mail_from,omitempty"`
+	SMTPRcptTo    []string `json:"smtp_rcpt_to,omitempty"`
+	SMTPStartTLS  bool     `json:"smtp_starttls,omitempty"`
+}
+
+const sessionInsertQuery = `
+	INSERT INTO siem.tcp_sessions (
+		sensor, device_name, start_time, end_time, duration_ms,
+		client_ip, server_ip, client_port, server_port,
+		handshake, rtt_ms,
+		client_bytes, server_bytes, client_packets, server_packets,
+		retransmissions, out_of_order, missing_bytes, close_reason,
+		app_protocol, app_details
+	) VALUES (
+		NULLIF($1, ''), $2, $3, $4, $5,
+		$6, $7, $8, $9,
+		$10, NULLIF($11::DOUBLE PRECISION, 0),
+		$12, $13, $14, $15,
+		$16, $17, $18, $19,
+		NULLIF($20, ''), $21
+	)
+`
+
+func consumeSessions(ctx context.Context, dbPool *pgxpool.Pool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{*kafkaAddr},
+		Topic:       *sessionTopic,
+		GroupID:     "session-processor",
+		MinBytes:    1,
+		MaxBytes:    10e6,
+		StartOffset: kafka.FirstOffset,
+		MaxWait:     500 * time.Millisecond,
+	})
+	defer reader.Close()
+
+	log.Printf("[Sessions] Started consuming TCP sessions from Kafka")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			readCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			m, err := reader.ReadMessage(readCtx)
+			cancel()
+
+			if err != nil {
+				if err == context.Canceled || err == context.DeadlineExceeded {
+					continue
+				}
+				log.Printf("[Sessions] Error reading message: %v", err)
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
+			var s TCPSession
+			if err := json.Unmarshal(m.Value, &s); err != nil {
+				log.Printf("[Sessions] Error unmarshaling session: %v", err)
+				continue
+			}
+
+			if err := storeSession(ctx, dbPool, &s); err != nil {
+				log.Printf("[Sessions] Error inserting session: %v", err)
+			}
+		}
+	}
+}
+
+func storeSession(ctx context.Context, dbPool *pgxpool.Pool, s *TCPSession) error {
+	appJSON, err := json.Marshal(s.SessionApp)
+	if err != nil {
+		appJSON = []byte("{}")
+	}
+
+	_, err = dbPool.Exec(ctx, sessionInsertQuery,
+		s.Sensor, s.DeviceName, s.StartTime, s.EndTime, s.DurationMs,
+		s.ClientIP, s.ServerIP, s.ClientPort, s.ServerPort,
+		s.Handshake, s.RTTMs,
+		s.ClientBytes, s.ServerBytes, s.ClientPackets, s.ServerPackets,
+		s.Retransmissions, s.OutOfOrder, s.MissingBytes, s.CloseReason,
+		s.AppProtocol, appJSON,
+	)
+	return err
+}
+
+// pruneSessions deletes sessions that started before the tcp_sessions
+// retention policy.
+func pruneSessions(ctx context.Context, dbPool *pgxpool.Pool, now time.Time) error {
+	var days int
+	err := dbPool.QueryRow(ctx,
+		`SELECT retention_days FROM siem.retention_policy WHERE table_name = 'tcp_sessions'`,
+	).Scan(&days)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = dbPool.Exec(ctx, `DELETE FROM siem.tcp_sessions WHERE start_time < $1`, now.AddDate(0, 0, -days))
+	return err
+}
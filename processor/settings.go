@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runtimeSettings is the part of the dashboard settings document (see
+// plutos-space models.Settings) that the processor acts on. Other fields
+// are ignored.
+type runtimeSettings struct {
+	Version int64            `json:"-"`
+	Network networkSettings  `json:"network"`
+	Alerts  detectorSettings `json:"alerts"`
+}
+
+type networkSettings struct {
+	CapturePackets bool `json:"capturePackets"`
+}
+
+type detectorSettings struct {
+	PortScans        bool           `json:"portScans"`
+	BruteForce       bool           `json:"bruteForce"`
+	DataExfiltration bool           `json:"dataExfiltration"`
+	SuspiciousIPs    bool           `json:"suspiciousIPs"`
+	UnusualTraffic   bool           `json:"unusualTraffic"`
+	Threshold        string         `json:"threshold"`
+	KnownGateways    []knownGateway `json:"knownGateways"`
+}
+
+type knownGateway struct {
+	IP   string `json:"ip"`
+	MAC  string `json:"mac"`
+	DHCP bool   `json:"dhcp"`
+}
+
+// enabled reports whether the detector with the given name is switched on.
+// Detectors without a switch in the settings are always on.
+func (s detectorSettings) enabled(name string) bool {
+	switch name {
+	case "portScans":
+		return s.PortScans
+	case "bruteForce":
+		return s.BruteForce
+	case "dataExfiltration":
+		return s.DataExfiltration
+	case "suspiciousIPs":
+		return s.SuspiciousIPs
+	case "unusualTraffic":
+		return s.UnusualTraffic
+	}
+	return true
+}
+
+// gatewayMACs returns the MACs allowed to claim ip and whether ip is a
+// known gateway at all. A gateway listed without a MAC allows any.
+func (s detectorSettings) gatewayMACs(ip string) (macs []string, known bool) {
+	for _, g := range s.KnownGateways {
+		if g.IP != ip {
+			continue
+		}
+		known = true
+		if g.MAC == "" {
+			return nil, true
+		}
+		macs = append(macs, g.MAC)
+	}
+	return macs, known
+}
+
+// dhcpServers reports whether the allowlist names any DHCP server, and if
+// so whether the server at ip and mac is one of them.
+func (s detectorSettings) dhcpServers(ip, mac string) (approved, configured bool) {
+	for _, g := range s.KnownGateways {
+		if !g.DHCP {
+			continue
+		}
+		configured = true
+		if g.IP == ip && (g.MAC == "" || sameMAC(g.MAC, mac)) {
+			return true, true
+		}
+	}
+	return false, configured
+}
+
+// sameMAC compares MAC addresses regardless of case and separators.
+func sameMAC(a, b string) bool {
+	x, err := net.ParseMAC(a)
+	if err != nil {
+		return false
+	}
+	y, err := net.ParseMAC(b)
+	return err == nil && x.String() == y.String()
+}
+
+// sensitivity scales detector thresholds: a "low" alert threshold halves
+// them (more alerts) and "high" doubles them (fewer alerts).
+func (s detectorSettings) sensitivity() float64 {
+	switch s.Threshold {
+	case "low":
+		return 0.5
+	case "high":
+		return 2
+	}
+	return 1
+}
+
+func defaultRuntimeSettings() *runtimeSettings {
+	return &runtimeSettings{
+		Network: networkSettings{CapturePackets: true},
+		Alerts: detectorSettings{
+			PortScans:        true,
+			BruteForce:       true,
+			DataExfiltration: true,
+			SuspiciousIPs:    true,
+			UnusualTraffic:   true,
+			Threshold:        "medium",
+			KnownGateways:    []knownGateway{},
+		},
+	}
+}
+
+var activeSettings atomic.Pointer[runtimeSettings]
+
+func init() {
+	activeSettings.Store(defaultRuntimeSettings())
+}
+
+// currentSettings returns the settings in effect. Callers must not modify
+// the result.
+func currentSettings() *runtimeSettings {
+	return activeSettings.Load()
+}
+
+// watchSettings polls siem.settings and swaps in each new version, so
+// changes saved from the dashboard apply without a restart.
+func watchSettings(ctx context.Context, dbPool *pgxpool.Pool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(*settingsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := loadSettings(ctx, dbPool); err != nil && ctx.Err() == nil {
+			log.Printf("[Settings] Error loading settings: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func loadSettings(ctx context.Context, dbPool *pgxpool.Pool) error {
+	var version int64
+	var raw []byte
+	err := dbPool.QueryRow(ctx, `
+		SELECT version, settings FROM siem.settings ORDER BY version DESC LIMIT 1
+	`).Scan(&version, &raw)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if version == currentSettings().Version {
+		return nil
+	}
+
+	// Decode over the defaults so fields missing from older versions keep
+	// their default value.
+	s := defaultRuntimeSettings()
+	if err := json.Unmarshal(raw, s); err != nil {
+		return err
+	}
+	s.Version = version
+	activeSettings.Store(s)
+
+	log.Printf("[Settings] Applied settings version %d (store packets: %v, threshold: %s)",
+		version, s.Network.CapturePackets, s.Alerts.Threshold)
+	return nil
+}